@@ -0,0 +1,297 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clientActiveWindow 最近多久内有请求的客户端视为在线
+	clientActiveWindow = 5 * time.Minute
+	// cacheUsageTTL 缓存占用统计的有效期，避免每次刷新管理页都遍历整个缓存目录
+	cacheUsageTTL = time.Minute
+)
+
+var serverStarted = time.Now()
+
+// ClientInfo 最近访问过的客户端
+type ClientInfo struct {
+	Device   string    `json:"device,omitempty"` // 设备名称，没有设备 ID 时为空
+	User     string    `json:"user,omitempty"`   // 登录的账号（-users）
+	IP       string    `json:"ip"`
+	Agent    string    `json:"agent"`
+	Watching string    `json:"watching,omitempty"` // 最近播放的视频（相对路径）
+	Active   int       `json:"active"`             // 进行中的请求数（视频流、事件推送等）
+	LastSeen time.Time `json:"last_seen"`
+}
+
+var (
+	// clients 设备 ID（没有时为 IP）-> 客户端信息
+	clients   = make(map[string]*ClientInfo)
+	clientsMu sync.Mutex
+)
+
+// requestIP 客户端 IP，经过反向代理时取 X-Forwarded-For
+func requestIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
+		ip, _, _ = strings.Cut(ip, ",")
+		return strings.TrimSpace(ip)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// requestDevice 请求携带的设备 ID，没有时返回空（不像 deviceID 那样分配新 ID）
+func requestDevice(r *http.Request) string {
+	if id := r.Header.Get("X-Device-ID"); id != "" {
+		return id
+	}
+	if c, err := r.Cookie(deviceCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// watchingFile 请求正在播放的视频：/play、/video、/remux 的 file 参数，或 HLS 任务的来源
+func watchingFile(r *http.Request, root string) string {
+	switch r.URL.Path {
+	case "/play", "/video", "/remux":
+		return r.URL.Query().Get("file")
+	}
+	key, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/hls/"), "/")
+	if !ok || !strings.HasPrefix(r.URL.Path, "/hls/") {
+		return ""
+	}
+	hlsJobsMu.Lock()
+	job := hlsJobs[key]
+	hlsJobsMu.Unlock()
+	if job == nil {
+		return ""
+	}
+	if rel, err := filepath.Rel(root, job.Source); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return ""
+}
+
+// clientsMiddleware 记录访问过的客户端，供 /admin 显示在线设备
+func (s *Server) clientsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		device, ip := requestDevice(r), requestIP(r)
+		id := device
+		if id == "" {
+			id = ip
+		}
+		watching := watchingFile(r, s.videoDir)
+		user := requestUser(r)
+
+		clientsMu.Lock()
+		c := clients[id]
+		if c == nil {
+			c = &ClientInfo{}
+			clients[id] = c
+		}
+		c.IP, c.Agent, c.LastSeen = ip, r.UserAgent(), time.Now()
+		if user != "" {
+			c.User = user
+		}
+		if watching != "" {
+			c.Watching = watching
+		}
+		c.Active++
+		// 顺带清理很久没有访问的客户端
+		for k, other := range clients {
+			if other.Active == 0 && time.Since(other.LastSeen) > 24*time.Hour {
+				delete(clients, k)
+			}
+		}
+		clientsMu.Unlock()
+
+		defer func() {
+			clientsMu.Lock()
+			c.Active--
+			c.LastSeen = time.Now()
+			clientsMu.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connectedClients 在线的客户端：有进行中的请求，或 clientActiveWindow 内访问过
+func connectedClients() []ClientInfo {
+	clientsMu.Lock()
+	var list []ClientInfo
+	var devices []string
+	for id, c := range clients {
+		if c.Active > 0 || time.Since(c.LastSeen) < clientActiveWindow {
+			list = append(list, *c)
+			devices = append(devices, id)
+		}
+	}
+	clientsMu.Unlock()
+	for i := range list {
+		if devices[i] != list[i].IP {
+			list[i].Device = deviceName(devices[i])
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
+
+var (
+	ffmpegVersions   = make(map[string]string) // ffmpeg 路径 -> 版本
+	ffmpegVersionsMu sync.Mutex
+)
+
+// ffmpegVersion ffmpeg -version 输出的第一行，如 "ffmpeg version 7.1 Copyright ..."，按路径缓存
+func ffmpegVersion() string {
+	if !ffmpegReady() {
+		return ""
+	}
+	path := ffmpegPath()
+	ffmpegVersionsMu.Lock()
+	defer ffmpegVersionsMu.Unlock()
+	if v, ok := ffmpegVersions[path]; ok {
+		return v
+	}
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return ""
+	}
+	v, _, _ := strings.Cut(string(out), "\n")
+	v, _, _ = strings.Cut(v, " Copyright")
+	ffmpegVersions[path] = strings.TrimSpace(v)
+	return ffmpegVersions[path]
+}
+
+// cacheDirUsage 缓存目录下一个子目录的占用
+type cacheDirUsage struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	SizeStr string `json:"size_str"`
+}
+
+var cacheUsage struct {
+	sync.Mutex
+	dirs    []cacheDirUsage
+	updated time.Time
+}
+
+// cacheDiskUsage 统计缓存根目录下各子目录的占用，结果缓存 cacheUsageTTL
+func cacheDiskUsage() []cacheDirUsage {
+	cacheUsage.Lock()
+	defer cacheUsage.Unlock()
+	if cacheUsage.dirs != nil && time.Since(cacheUsage.updated) < cacheUsageTTL {
+		return cacheUsage.dirs
+	}
+	entries, err := os.ReadDir(cacheRoot)
+	if err != nil {
+		return nil
+	}
+	dirs := []cacheDirUsage{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		size := dirSize(filepath.Join(cacheRoot, e.Name()))
+		dirs = append(dirs, cacheDirUsage{Name: e.Name(), Size: size, SizeStr: formatSize(size)})
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Size > dirs[j].Size })
+	cacheUsage.dirs, cacheUsage.updated = dirs, time.Now()
+	return dirs
+}
+
+// adminJob /api/admin 中的转码任务
+type adminJob struct {
+	jobStatus
+	File string `json:"file"` // 相对视频目录的路径
+}
+
+// handleAdmin GET /api/admin 返回管理页所需的服务状态（仅管理员）：
+// 转码任务及进度、在线客户端、缓存占用、ffmpeg 版本。取消转码和删除缓存分别使用
+// DELETE /api/jobs/{key} 和 DELETE /api/cache?key=
+func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+
+	hlsJobsMu.Lock()
+	active := make(map[string]*HLSJob, len(hlsJobs))
+	for key, job := range hlsJobs {
+		active[key] = job
+	}
+	hlsJobsMu.Unlock()
+	jobs := make([]adminJob, 0, len(active))
+	for key, job := range active {
+		file := job.Source
+		if rel, err := filepath.Rel(s.videoDir, job.Source); err == nil {
+			file = filepath.ToSlash(rel)
+		}
+		jobs = append(jobs, adminJob{jobStatus: job.status(key), File: file})
+	}
+	// 进行中的任务在前，其次是排队中的，最后是已结束的
+	rank := map[string]int{"running": 0, "queued": 1}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		ri, ok := rank[jobs[i].State]
+		if !ok {
+			ri = 2
+		}
+		rj, ok := rank[jobs[j].State]
+		if !ok {
+			rj = 2
+		}
+		if ri != rj {
+			return ri < rj
+		}
+		return jobs[i].File < jobs[j].File
+	})
+
+	dirs := cacheDiskUsage()
+	var total int64
+	for _, d := range dirs {
+		total += d.Size
+	}
+	status := ffmpegStatus()
+	status["version"] = ffmpegVersion()
+
+	writeJSON(w, map[string]any{
+		"version": version,
+		"go":      runtime.Version(),
+		"started": serverStarted,
+		"uptime":  time.Since(serverStarted).Round(time.Second).String(),
+		"ffmpeg":  status,
+		"jobs":    jobs,
+		"clients": connectedClients(),
+		"cache": map[string]any{
+			"root":        cacheRoot,
+			"dirs":        dirs,
+			"total":       total,
+			"total_str":   formatSize(total),
+			"hls_max":     cacheMaxSize,
+			"hls_max_str": formatSize(cacheMaxSize),
+		},
+	})
+}
+
+// handleAdminPage 服务器状态管理页面（仅管理员）
+func (s *Server) handleAdminPage(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "admin.html", nil); err != nil {
+		logErrorf("模板渲染错误: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// hlsJSVersion 内嵌的 hls.js 版本，升级 static/hls.min.js 时同步修改
+const hlsJSVersion = "1.6.15"
+
+// version 程序版本，发布时通过 -ldflags "-X main.version=..." 注入
+var version = "dev"
+
+// Asset 内嵌静态资源的版本信息
+type Asset struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`       // 带内容哈希的版本化地址，可永久缓存
+	Integrity string `json:"integrity"` // Subresource Integrity，sha384-...
+	Size      int    `json:"size"`
+}
+
+var (
+	// assetManifest 原始文件名 -> 版本信息
+	assetManifest = buildAssetManifest()
+	// assetByURL 版本化文件名 -> 原始文件名
+	assetByURL = make(map[string]string)
+)
+
+// buildAssetManifest 为 static/ 下的每个文件计算内容哈希，生成 name.<hash>.ext 形式的地址
+// 所有前端资源都从内嵌文件提供，局域网离线也能播放，升级由服务端版本控制
+func buildAssetManifest() map[string]Asset {
+	manifest := make(map[string]Asset)
+	entries, err := fs.ReadDir(staticFS, "static")
+	if err != nil {
+		log.Printf("[资源] 读取内嵌资源失败: %v", err)
+		return manifest
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := staticFS.ReadFile("static/" + e.Name())
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		sri := sha512.Sum384(data)
+
+		ext := path.Ext(e.Name())
+		versioned := strings.TrimSuffix(e.Name(), ext) + "." + hex.EncodeToString(sum[:4]) + ext
+		manifest[e.Name()] = Asset{
+			Name:      e.Name(),
+			URL:       "/assets/" + versioned,
+			Integrity: "sha384-" + base64.StdEncoding.EncodeToString(sri[:]),
+			Size:      len(data),
+		}
+	}
+	return manifest
+}
+
+func init() {
+	for name, a := range assetManifest {
+		assetByURL[path.Base(a.URL)] = name
+	}
+}
+
+// assetURL 模板函数：返回资源的版本化地址
+func assetURL(name string) string {
+	if a, ok := assetManifest[name]; ok {
+		return a.URL
+	}
+	return "/static/" + name
+}
+
+// assetIntegrity 模板函数：返回资源的 SRI 哈希
+func assetIntegrity(name string) string {
+	return assetManifest[name].Integrity
+}
+
+// handleAssets 提供版本化的静态资源，内容不变则地址不变，可设置永久缓存
+func handleAssets(w http.ResponseWriter, r *http.Request) {
+	name, ok := assetByURL[strings.TrimPrefix(r.URL.Path, "/assets/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFileFS(w, r, staticFS, "static/"+name)
+}
+
+// handleVersion 报告程序及前端资源版本，便于确认局域网内各设备加载的是同一套资源
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Version string           `json:"version"`
+		Go      string           `json:"go"`
+		HLSJS   string           `json:"hls_js"`
+		Assets  map[string]Asset `json:"assets"`
+	}{
+		Version: version,
+		Go:      runtime.Version(),
+		HLSJS:   hlsJSVersion,
+		Assets:  assetManifest,
+	})
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie  = "lc_session"
+	sessionMaxAge  = 30 * 24 * time.Hour
+	sessionKeyFile = "session.key"
+)
+
+var (
+	// authPassword 登录密码（-password），为空表示不启用密码登录
+	authPassword string
+	// authToken 访问令牌（-token），供外部播放器和脚本使用：Authorization: Bearer <token> 或 ?token=<token>
+	authToken string
+	// sessionKey 会话签名密钥，由数据目录中的随机密钥和密码派生，修改密码后旧会话失效
+	sessionKey []byte
+)
+
+// authEnabled 是否启用了访问保护
+func authEnabled() bool {
+	return authPassword != "" || authToken != "" || len(accounts) > 0
+}
+
+// InitAuth 设置密码和令牌，加载（或生成）会话签名密钥
+func InitAuth(password, token string) error {
+	authPassword, authToken = password, token
+	// 私密文件夹的解锁 cookie 也用会话密钥签名
+	if !authEnabled() && len(privateFolders) == 0 {
+		return nil
+	}
+	path := filepath.Join(dataDir, sessionKeyFile)
+	secret, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || len(secret) < 32 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, secret); err != nil {
+			return err
+		}
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	mac.Write([]byte{0})
+	mac.Write([]byte(token))
+	sessionKey = mac.Sum(nil)
+	return nil
+}
+
+// signSession 生成会话 cookie 值：过期时间.签名，账号登录时后面再加 .账号名
+func signSession(user string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	value := exp + "." + sessionSig(user, exp)
+	if user != "" {
+		value += "." + url.PathEscape(user)
+	}
+	return value
+}
+
+// sessionSig 会话签名；账号的签名包含该账号的密码，修改密码后只有该账号的会话失效
+func sessionSig(user, exp string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(exp))
+	if a := accounts[user]; a != nil {
+		mac.Write([]byte("|" + user + "|" + a.Password))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSession 校验会话 cookie 的签名和有效期，返回登录的账号名（共用密码登录时为空）
+func validSession(value string) (string, bool) {
+	exp, rest, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	sig, escaped, _ := strings.Cut(rest, ".")
+	user, err := url.PathUnescape(escaped)
+	if err != nil || user != "" && accounts[user] == nil {
+		return "", false
+	}
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > ts {
+		return "", false
+	}
+	return user, hmac.Equal([]byte(sig), []byte(sessionSig(user, exp)))
+}
+
+// signStream 视频原文件地址（/video?file=）的签名，用于 .strm 等长期有效的外部播放链接
+func signStream(file string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("stream|" + file))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// secretEqual 常量时间比较，避免通过响应时间猜测密码
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+const (
+	// authFailInterval 认证失败用完额度后，每个 IP 每隔多久才能再试一次
+	authFailInterval = 10 * time.Second
+	// authFailBurst 每个 IP 可以连续认证失败的次数
+	authFailBurst = 5
+)
+
+var (
+	// authFailures IP -> 认证失败的限速器
+	authFailures   = make(map[string]*rateLimiter)
+	authFailuresMu sync.Mutex
+)
+
+// remoteIP 连接的远端 IP，不信任 X-Forwarded-For（客户端可以随意伪造）
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// authThrottled 该 IP 认证失败次数过多时返回还需等待的时间，期间不再校验
+func authThrottled(r *http.Request) time.Duration {
+	authFailuresMu.Lock()
+	l := authFailures[remoteIP(r)]
+	authFailuresMu.Unlock()
+	if l == nil {
+		return 0
+	}
+	return max(l.wait(), 0)
+}
+
+// recordAuthFailure 记录一次认证失败，顺便清理早已恢复额度的 IP
+func recordAuthFailure(r *http.Request) {
+	authFailuresMu.Lock()
+	defer authFailuresMu.Unlock()
+	for ip, l := range authFailures {
+		if l.wait() < -l.burst {
+			delete(authFailures, ip)
+		}
+	}
+	ip := remoteIP(r)
+	l := authFailures[ip]
+	if l == nil {
+		l = &rateLimiter{rate: 1 / authFailInterval.Seconds(), burst: (authFailBurst - 1) * authFailInterval}
+		authFailures[ip] = l
+	}
+	l.reserve(1)
+}
+
+// rejectThrottled 认证失败次数过多时返回 429 和 Retry-After，返回 true 表示已拒绝
+func rejectThrottled(w http.ResponseWriter, r *http.Request) bool {
+	wait := authThrottled(r)
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(w, "尝试次数过多，请稍后再试", http.StatusTooManyRequests)
+	return true
+}
+
+// secretParams 写入日志和崩溃现场前隐去的查询参数：访问令牌和签名地址的签名、过期时间
+var secretParams = []string{"token", "sig", "exp", "hsig", "hexp"}
+
+// redactQuery 隐去查询字符串中的令牌和签名，其他参数保持原样和原有顺序
+func redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	parts := strings.Split(raw, "&")
+	for i, p := range parts {
+		k, _, _ := strings.Cut(p, "=")
+		if name, err := url.QueryUnescape(k); err == nil && slices.Contains(secretParams, name) {
+			parts[i] = k + "=REDACTED"
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// redactedURI 隐去令牌和签名后的 RequestURI，用于日志和崩溃现场
+func redactedURI(u *url.URL) string {
+	c := *u
+	c.RawQuery = redactQuery(u.RawQuery)
+	return c.RequestURI()
+}
+
+// authorized 请求是否携带有效的会话 cookie 或访问令牌
+func authorized(r *http.Request) bool {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if _, ok := validSession(c.Value); ok {
+			return true
+		}
+	}
+	if sig := r.URL.Query().Get("sig"); sig != "" && r.URL.Path == "/video" &&
+		secretEqual(sig, signStream(r.URL.Query().Get("file"))) {
+		return true
+	}
+	if hlsSigned(r) {
+		return true
+	}
+	return tokenAuthorized(r)
+}
+
+// tokenAuthorized 请求是否携带了有效的访问令牌（-token）
+func tokenAuthorized(r *http.Request) bool {
+	if authToken == "" {
+		return false
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secretEqual(bearer, authToken) {
+		return true
+	}
+	if t := r.URL.Query().Get("token"); t != "" && secretEqual(t, authToken) {
+		return true
+	}
+	return false
+}
+
+// isAdminRequest 管理员请求：本机访问，或用 -admin-users 中的账号登录；
+// 共用密码、令牌（-token）登录、限制了文件夹的账号和儿童模式下都不是管理员
+func isAdminRequest(r *http.Request) bool {
+	user := requestUser(r)
+	if len(userFolders(user)) > 0 || kidsMode(r) {
+		return false
+	}
+	if isLocalRequest(r) {
+		return true
+	}
+	a := accounts[user]
+	return a != nil && a.Admin
+}
+
+// publicPath 无需登录即可访问的路径（登录页及其使用的静态资源）
+func publicPath(path string) bool {
+	return path == "/login" || path == "/logout" ||
+		strings.HasPrefix(path, "/assets/") || strings.HasPrefix(path, "/static/")
+}
+
+// authMiddleware 启用 -password / -token 时保护所有页面和接口
+// 浏览器打开页面时跳转到登录页，其他请求返回 401
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled() || publicPath(r.URL.Path) || authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") && authPassword != "" {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="LocalCinema"`)
+		http.Error(w, "需要登录", http.StatusUnauthorized)
+	})
+}
+
+// safeNext 登录后跳转的地址，只允许站内路径
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// handleLogin GET 显示登录页，POST 校验账号（或共用密码）并设置会话 cookie
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	data := struct {
+		Next     string
+		Error    string
+		Users    bool // 定义了账号，显示账号输入框
+		Shared   bool // 同时设置了共用密码，账号可以留空
+		Username string
+	}{Next: next, Users: len(accounts) > 0, Shared: authPassword != "", Username: r.FormValue("user")}
+
+	if authPassword == "" && len(accounts) == 0 {
+		// 未设置密码和账号（只有令牌或未启用保护）时没有登录页
+		http.Redirect(w, r, next, http.StatusFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if rejectThrottled(w, r) {
+			return
+		}
+		user, password := strings.TrimSpace(r.FormValue("user")), r.FormValue("password")
+		var ok bool
+		if a := accounts[user]; a != nil {
+			ok = secretEqual(password, a.Password)
+		} else {
+			ok = user == "" && authPassword != "" && secretEqual(password, authPassword)
+		}
+		if ok {
+			expires := time.Now().Add(sessionMaxAge)
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    signSession(user, expires),
+				Path:     "/",
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		logWarnf("[登录] 账号或密码错误 (%s) <- %s", user, r.RemoteAddr)
+		recordAuthFailure(r)
+		w.WriteHeader(http.StatusUnauthorized)
+		data.Error = "密码错误"
+		if data.Users {
+			data.Error = "账号或密码错误"
+		}
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "login.html", data); err != nil {
+		log.Printf("模板渲染错误: %v", err)
+	}
+}
+
+// handleLogout 清除会话 cookie
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupDirName = "backups"
+	backupPrefix  = "localcinema-"
+	backupSuffix  = ".tar.gz"
+	// backupMaxFile 单个数据文件的大小上限，恢复时拒绝异常的归档
+	backupMaxFile = 256 << 20
+)
+
+var (
+	// backupInterval 自动备份间隔（-backup-interval），0 表示不自动备份
+	backupInterval = 24 * time.Hour
+	// backupKeep 保留的备份数量（-backup-keep），超出后删除最旧的
+	backupKeep = 7
+)
+
+// backupDir 备份目录，位于数据目录下
+func backupDir() string {
+	return filepath.Join(dataDir, backupDirName)
+}
+
+// userFileInfo 账号目录（users/<账号名>/）中的数据文件，Name 为相对数据目录的路径
+type userFileInfo struct {
+	os.FileInfo
+	rel string
+}
+
+func (f userFileInfo) Name() string { return f.rel }
+
+// backupFiles 需要备份的数据文件：数据目录下的普通文件（播放进度、观看记录、播放列表、配置等）
+// 和各账号的观看状态，跳过备份目录和写入中的临时文件
+func backupFiles() ([]os.FileInfo, error) {
+	files, err := regularFiles(dataDir, "")
+	if err != nil {
+		return nil, err
+	}
+	users, _ := os.ReadDir(filepath.Join(dataDir, usersDir))
+	for _, u := range users {
+		if u.IsDir() && !strings.HasPrefix(u.Name(), ".") {
+			sub, _ := regularFiles(filepath.Join(dataDir, usersDir, u.Name()), usersDir+"/"+u.Name()+"/")
+			files = append(files, sub...)
+		}
+	}
+	return files, nil
+}
+
+// regularFiles 目录下的普通文件，prefix 不为空时文件名加上该前缀
+func regularFiles(dir, prefix string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if prefix != "" {
+			info = userFileInfo{info, prefix + e.Name()}
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// listBackups 已有的备份文件名，从旧到新
+func listBackups() []string {
+	entries, _ := os.ReadDir(backupDir())
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names) // 文件名中的时间戳保证按时间排序
+	return names
+}
+
+// createBackup 把数据文件打包为带时间戳的归档，note 附加在文件名中（如 pre-restore）；
+// 数据自上次备份后没有变化时跳过，返回空文件名。
+// JSON 文件无法解析（如断电损坏）时放弃本次备份，避免损坏的数据把完好的旧备份轮换掉
+func createBackup(note string) (string, error) {
+	files, err := backupFiles()
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	backups := listBackups()
+	if note == "" && len(backups) > 0 {
+		if last, err := os.Stat(filepath.Join(backupDir(), backups[len(backups)-1])); err == nil {
+			changed := false
+			for _, f := range files {
+				if f.ModTime().After(last.ModTime()) {
+					changed = true
+					break
+				}
+			}
+			if !changed {
+				return "", nil
+			}
+		}
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dataDir, f.Name()))
+		if err != nil {
+			return "", err
+		}
+		if !json.Valid(data) {
+			return "", fmt.Errorf("%s 已损坏，跳过本次备份", f.Name())
+		}
+	}
+
+	if err := os.MkdirAll(backupDir(), 0755); err != nil {
+		return "", err
+	}
+	name := backupPrefix + time.Now().Format("20060102-150405")
+	if note != "" {
+		name += "-" + note
+	}
+	name += backupSuffix
+	path := filepath.Join(backupDir(), name)
+	tmp := path + ".tmp"
+	if err := writeBackup(tmp, files); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	pruneBackups()
+	return name, nil
+}
+
+// writeBackup 写入 tar.gz 归档
+func writeBackup(path string, files []os.FileInfo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, info := range files {
+		data, err := os.ReadFile(filepath.Join(dataDir, info.Name()))
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: info.Name(), Mode: int64(info.Mode().Perm()), Size: int64(len(data)), ModTime: info.ModTime()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// pruneBackups 只保留最新的 backupKeep 个备份
+func pruneBackups() {
+	backups := listBackups()
+	for len(backups) > backupKeep && backupKeep > 0 {
+		if err := os.Remove(filepath.Join(backupDir(), backups[0])); err != nil {
+			log.Printf("[备份] 删除旧备份失败: %v", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// StartBackups 启动定时备份：启动 1 分钟后先备份一次，之后每隔 backupInterval 备份
+func StartBackups() {
+	if backupInterval <= 0 {
+		return
+	}
+	go func() {
+		time.Sleep(time.Minute)
+		for {
+			if name, err := createBackup(""); err != nil {
+				log.Printf("[备份] 失败: %v", err)
+			} else if name != "" {
+				log.Printf("[备份] 已保存 %s", name)
+			}
+			time.Sleep(backupInterval)
+		}
+	}()
+}
+
+// resolveBackup 解析 -restore 参数：latest 为最新的备份，否则为备份文件名或归档路径
+func resolveBackup(arg string) (string, error) {
+	if arg == "latest" {
+		backups := listBackups()
+		if len(backups) == 0 {
+			return "", fmt.Errorf("%s 中没有备份", backupDir())
+		}
+		return filepath.Join(backupDir(), backups[len(backups)-1]), nil
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return arg, nil
+	}
+	path := filepath.Join(backupDir(), filepath.Base(arg))
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("找不到备份 %s", arg)
+	}
+	return path, nil
+}
+
+// restoreBackup 用归档中的文件覆盖数据目录中的同名文件；恢复前先备份当前数据（pre-restore），
+// 以便恢复错了还能回退。需要在服务未运行时执行
+func restoreBackup(arg string) (string, []string, error) {
+	path, err := resolveBackup(arg)
+	if err != nil {
+		return "", nil, err
+	}
+	// 先完整读取并校验归档，避免恢复到一半失败
+	files, err := readBackup(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("读取 %s 失败: %w", filepath.Base(path), err)
+	}
+	if _, err := createBackup("pre-restore"); err != nil {
+		log.Printf("[备份] 恢复前备份当前数据失败: %v", err)
+	}
+	var names []string
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dataDir, name)), 0755); err != nil {
+			return path, names, err
+		}
+		if err := writeFileAtomic(filepath.Join(dataDir, name), data); err != nil {
+			return path, names, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return path, names, nil
+}
+
+// readBackup 读取归档中的文件，只接受数据目录下的普通文件名和 users/<账号名>/ 下的文件
+func readBackup(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !backupFileName(hdr.Name) || hdr.Size > backupMaxFile {
+			return nil, fmt.Errorf("无效的归档内容: %s", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(hdr.Name, ".json") && !json.Valid(data) {
+			return nil, fmt.Errorf("%s 已损坏", hdr.Name)
+		}
+		files[hdr.Name] = data
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("归档为空")
+	}
+	return files, nil
+}
+
+// backupFileName 归档中的文件名是否有效：数据目录下的文件，或 users/<账号名>/ 下的文件
+func backupFileName(name string) bool {
+	parts := strings.Split(name, "/")
+	if len(parts) == 3 && parts[0] == usersDir {
+		parts = parts[1:]
+	} else if len(parts) != 1 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || strings.HasPrefix(part, ".") || !filepath.IsLocal(part) || strings.Contains(part, `\`) {
+			return false
+		}
+	}
+	return true
+}
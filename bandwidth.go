@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// bandwidthBurst 限速器允许积攒的额度（按时间计），空闲后恢复传输时可以短暂超出限速
+	bandwidthBurst = 250 * time.Millisecond
+	// bandwidthChunk 每次按限速等待的最大写入量，避免一次大块写入后长时间停顿
+	bandwidthChunk = 32 << 10
+)
+
+var (
+	// globalLimiter 所有视频流共享的总带宽限速（-max-bandwidth total=），nil 表示不限
+	globalLimiter *rateLimiter
+	// connBandwidth 单个连接的带宽限速（字节/秒，-max-bandwidth conn=），0 表示不限
+	connBandwidth int64
+)
+
+// parseBandwidth 解析 -max-bandwidth，如 "40M"（总带宽）或 "total=40M,conn=8M"，单位为字节/秒
+func parseBandwidth(spec string) (total, conn int64, err error) {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			key, value = "total", part
+		}
+		n, err := parseByteSize(value)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch strings.TrimSpace(key) {
+		case "total":
+			total = n
+		case "conn":
+			conn = n
+		default:
+			return 0, 0, fmt.Errorf("未知的限速项 %q（可用 total、conn）", key)
+		}
+	}
+	return total, conn, nil
+}
+
+// newRateLimiter 按字节计的限速器（rateLimiter 的额度单位为字节），允许积攒 bandwidthBurst 的额度
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSec), burst: bandwidthBurst}
+}
+
+type connLimiterKey struct{}
+
+// bandwidthConnContext 供 http.Server.ConnContext 使用，启用单连接限速时为每个连接创建限速器，
+// 同一连接上先后请求的 HLS 分片共用额度
+func bandwidthConnContext(ctx context.Context, _ net.Conn) context.Context {
+	if connBandwidth <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connLimiterKey{}, newRateLimiter(connBandwidth))
+}
+
+// throttledWriter 按总带宽和单连接限速分块写入响应
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*rateLimiter
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), bandwidthChunk)]
+		var wait time.Duration
+		for _, l := range w.limiters {
+			wait = max(wait, l.reserve(len(chunk)))
+		}
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-w.ctx.Done():
+				t.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 让 http.ResponseController 能穿过限速包装
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bandwidthMiddleware 视频流接口（/video、/download、/remux、/hls/、/dash/）的带宽限制，
+// 避免一个客户端全速下载大文件时占满上行带宽，其他人无法播放
+func bandwidthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var limiters []*rateLimiter
+		if globalLimiter != nil {
+			limiters = append(limiters, globalLimiter)
+		}
+		if l, ok := r.Context().Value(connLimiterKey{}).(*rateLimiter); ok {
+			limiters = append(limiters, l)
+		}
+		if len(limiters) == 0 {
+			next(w, r)
+			return
+		}
+		next(&throttledWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}, r)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FolderEntry 目录浏览中的子目录
+type FolderEntry struct {
+	Name    string
+	RelPath string
+	Stats   FolderStats // 含子目录的视频统计（由 attachFolderStats 填充）
+}
+
+// Crumb 面包屑导航中的一级
+type Crumb struct {
+	Name string
+	Path string
+}
+
+// ListDir 列出 root 下相对目录 rel 中的子目录和视频（只列一层，不递归）
+func ListDir(root, rel string) ([]FolderEntry, []VideoFile, error) {
+	dir := filepath.Join(root, rel)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var folders []FolderEntry
+	var videos []VideoFile
+	subsByDir := make(map[string][]string)
+	for _, e := range entries {
+		if skipName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || skipEntry(e.Name(), info) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		relPath, _ := filepath.Rel(root, path)
+		if e.IsDir() {
+			folders = append(folders, FolderEntry{Name: e.Name(), RelPath: relPath})
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if subtitleExts[ext] {
+			subsByDir[filepath.Dir(relPath)] = append(subsByDir[filepath.Dir(relPath)], relPath)
+			continue
+		}
+		if !videoExts[ext] || partialVideo(path, info) {
+			continue
+		}
+		videos = append(videos, newVideoFile(root, path, info))
+	}
+
+	attachSidecars(videos, subsByDir)
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	sort.Slice(videos, func(i, j int) bool { return videos[i].Name < videos[j].Name })
+	return folders, videos, nil
+}
+
+// breadcrumbs 生成从根目录到 rel 的导航路径
+func breadcrumbs(rel string) []Crumb {
+	crumbs := []Crumb{{Name: "全部文件夹", Path: ""}}
+	if rel == "" {
+		return crumbs
+	}
+	var cur string
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		cur = filepath.Join(cur, part)
+		crumbs = append(crumbs, Crumb{Name: part, Path: cur})
+	}
+	return crumbs
+}
+
+// cleanDirParam 校验并规范化目录参数，空字符串表示根目录
+func (s *Server) cleanDirParam(rel string) (string, bool) {
+	rel = filepath.Clean(rel)
+	if rel == "." || rel == "" {
+		return "", true
+	}
+	if !s.isSafeRelPath(rel) {
+		return "", false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if skipName(part) {
+			return "", false
+		}
+	}
+	info, err := os.Stat(filepath.Join(s.videoDir, rel))
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	return rel, true
+}
+
+// handleBrowse 按目录浏览（JSON）
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	rel, ok := s.cleanDirParam(r.URL.Query().Get("path"))
+	if !ok {
+		http.Error(w, "无效的目录", http.StatusForbidden)
+		return
+	}
+
+	folders, videos, err := ListDir(s.videoDir, rel)
+	if err != nil {
+		http.Error(w, "读取目录失败", http.StatusInternalServerError)
+		return
+	}
+	user := requestUser(r)
+	folders = visibleFolders(r, folders)
+	videos = visibleVideos(r, videos)
+	markWatched(user, videos)
+	attachFolderStats(user, folders)
+	facets, err := facetExpr(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if facets != "" {
+		videos, _ = FilterVideos(videos, facets)
+	}
+
+	writeJSON(w, struct {
+		Path        string
+		Breadcrumbs []Crumb
+		Folders     []FolderEntry
+		Videos      []VideoFile
+	}{
+		Path:        filepath.ToSlash(rel),
+		Breadcrumbs: breadcrumbs(rel),
+		Folders:     folders,
+		Videos:      videos,
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fontExts 视为字体的附件扩展名（libass 可加载的格式）
+var fontExts = map[string]bool{
+	".ttf": true,
+	".otf": true,
+	".ttc": true,
+}
+
+// fontAttachment Matroska 中的字体附件
+type fontAttachment struct {
+	Index int    // 流的绝对序号，对应 -dump_attachment:N
+	Ext   string // .ttf / .otf / .ttc
+}
+
+// probeFontAttachments 列出视频附带的字体（MKV 特效字幕通常把所用字体作为附件封装）
+func probeFontAttachments(filePath string) ([]fontAttachment, error) {
+	out, err := exec.Command(ffprobePath(),
+		"-v", "quiet",
+		"-select_streams", "t",
+		"-show_entries", "stream=index:stream_tags=filename,mimetype",
+		"-print_format", "json",
+		filePath,
+	).Output()
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Streams []struct {
+			Index int `json:"index"`
+			Tags  struct {
+				Filename string `json:"filename"`
+				Mimetype string `json:"mimetype"`
+			} `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, err
+	}
+	var fonts []fontAttachment
+	for _, st := range result.Streams {
+		ext := strings.ToLower(filepath.Ext(st.Tags.Filename))
+		if !fontExts[ext] {
+			// 没有扩展名时按 MIME 类型判断，如 application/x-truetype-font、font/otf
+			switch mime := strings.ToLower(st.Tags.Mimetype); {
+			case strings.Contains(mime, "opentype") || strings.HasSuffix(mime, "otf"):
+				ext = ".otf"
+			case strings.Contains(mime, "truetype") || strings.HasSuffix(mime, "ttf"):
+				ext = ".ttf"
+			default:
+				continue
+			}
+		}
+		fonts = append(fonts, fontAttachment{Index: st.Index, Ext: ext})
+	}
+	return fonts, nil
+}
+
+// fontsCacheDir 视频字体附件的缓存目录，文件变化后自动失效
+func fontsCacheDir(filePath string) string {
+	return filepath.Join(subsCacheDir, "fonts", mediaCacheKey(filePath))
+}
+
+// extractFonts 把视频的字体附件导出到缓存目录，没有字体附件时返回空字符串
+// 附件按序号命名，不使用 MKV 中记录的文件名，避免路径穿越
+func extractFonts(filePath string) (string, error) {
+	dir := fontsCacheDir(filePath)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	v, err, _ := probeGroup.Do("fonts|"+filePath, func() (any, error) {
+		fonts, err := probeFontAttachments(filePath)
+		if err != nil || len(fonts) == 0 {
+			return "", err
+		}
+		tmp := dir + ".tmp"
+		os.RemoveAll(tmp)
+		if err := os.MkdirAll(tmp, 0755); err != nil {
+			return "", err
+		}
+		args := []string{"-loglevel", "error"}
+		for _, f := range fonts {
+			args = append(args, fmt.Sprintf("-dump_attachment:%d", f.Index), filepath.Join(tmp, fmt.Sprintf("%d%s", f.Index, f.Ext)))
+		}
+		// 只导出附件、没有输出文件时 ffmpeg 总是以错误退出，以实际导出的文件为准
+		args = append(args, "-y", "-i", filePath)
+		out, _ := exec.Command(ffmpegPath(), args...).CombinedOutput()
+		if entries, _ := os.ReadDir(tmp); len(entries) == 0 {
+			os.RemoveAll(tmp)
+			return "", fmt.Errorf("导出字体附件失败: %s", strings.TrimSpace(string(out)))
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			os.RemoveAll(tmp)
+			return "", err
+		}
+		log.Printf("[字幕] %s: 导出 %d 个字体附件", filepath.Base(filePath), len(fonts))
+		return dir, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// filterPath 转义滤镜参数中的文件路径：先按选项值转义，再按滤镜图转义
+func filterPath(p string) string {
+	p = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(p)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(p)
+}
+
+// burnSubtitleFilter 把第 track 条内嵌字幕烧录到画面的 subtitles 滤镜，ASS 字幕使用视频附带的字体渲染
+func burnSubtitleFilter(filePath string, track int) string {
+	filter := fmt.Sprintf("subtitles=filename=%s:si=%d", filterPath(filePath), track)
+	fonts, err := extractFonts(filePath)
+	if err != nil {
+		log.Printf("[字幕] %s: %v，使用系统字体", filepath.Base(filePath), err)
+	}
+	if fonts != "" {
+		filter += ":fontsdir=" + filterPath(fonts)
+	}
+	return filter
+}
+
+// burnable 可以连同字体附件烧录到画面的字幕（特效字幕 ASS/SSA，转成 WebVTT 会丢失样式）
+func burnable(t SubtitleTrack) bool {
+	return t.Index >= 0 && (t.Codec == "ass" || t.Codec == "ssa")
+}
+
+// burnTrack 解析 ?burn=N，返回 HLSOptions.Burn；不是可烧录的内嵌字幕时返回 0
+func burnTrack(r *http.Request, tracks []SubtitleTrack) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("burn"))
+	if err != nil {
+		return 0
+	}
+	for _, t := range tracks {
+		if t.Index == n && burnable(t) {
+			return n + 1
+		}
+	}
+	return 0
+}
+
+// withVideoFilter 在编码参数的 -vf 前插入滤镜（字幕需要在 format/hwupload 之前叠加到软件帧上）
+func withVideoFilter(args []string, filter string) []string {
+	out := append([]string{}, args...)
+	for i := 0; i+1 < len(out); i++ {
+		if out[i] == "-vf" {
+			out[i+1] = filter + "," + out[i+1]
+			return out
+		}
+	}
+	return append([]string{"-vf", filter}, out...)
+}
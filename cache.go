@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	cacheRoot    string // 缓存根目录，默认 ~/.cache/localcinema
+	cacheMaxSize int64  // HLS 缓存上限（字节），0 表示不限制
+
+	evictMu sync.Mutex
+)
+
+// InitCacheRoot 设置缓存根目录，dir 为空时使用 ~/.cache/localcinema
+func InitCacheRoot(dir string) error {
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(home, ".cache", "localcinema")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	cacheRoot = abs
+	return os.MkdirAll(cacheRoot, 0755)
+}
+
+// parseByteSize 解析 "500M"、"20G"、"1.5T" 等容量，纯数字按字节计算
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	if s == "" {
+		return 0, nil
+	}
+	units := map[byte]float64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	mult := 1.0
+	if u, ok := units[s[len(s)-1]]; ok {
+		mult = u
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的容量: %q", s)
+	}
+	return int64(n * mult), nil
+}
+
+// dirSize 统计目录下所有文件的总大小
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// cacheManifestName 缓存目录中记录来源信息的文件，不通过 /hls/ 对外提供
+const cacheManifestName = "manifest.json"
+
+// cacheManifest 转码缓存的来源信息
+type cacheManifest struct {
+	Source      string    `json:"source"` // 源视频完整路径
+	Audio       int       `json:"audio"`
+	HEVC        bool      `json:"hevc,omitempty"`
+	FMP4        bool      `json:"fmp4,omitempty"`
+	DASH        bool      `json:"dash,omitempty"`
+	Precise     bool      `json:"precise,omitempty"`
+	Fallback    bool      `json:"fallback,omitempty"`
+	Burn        int       `json:"burn,omitempty"` // 见 HLSOptions.Burn
+	Flat        bool      `json:"flat,omitempty"`
+	Passthrough bool      `json:"passthrough,omitempty"`
+	Encoder     string    `json:"encoder"` // 编码设置，见 encoderSettings
+	Version     string    `json:"version"` // 生成缓存的程序版本
+	Created     time.Time `json:"created"`
+}
+
+// writeCacheManifest 在缓存目录中记录来源视频，供 /api/cache 展示
+func writeCacheManifest(dir string, m cacheManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, cacheManifestName), data, 0644)
+}
+
+// options 还原生成该缓存时的输出选项（与 hlsJobKey 对应）
+// manifest 中的 FMP4 是实际使用的分片格式，DASH 总是 fMP4，不计入 key
+func (m cacheManifest) options() HLSOptions {
+	return HLSOptions{Audio: m.Audio, HEVC: m.HEVC, FMP4: m.FMP4 && !m.DASH, DASH: m.DASH, Precise: m.Precise, Fallback: m.Fallback, Burn: m.Burn, Flat: m.Flat, Passthrough: m.Passthrough}
+}
+
+// readCacheManifest 读取来源信息，旧版本缓存没有该文件时返回零值
+func readCacheManifest(dir string) cacheManifest {
+	var m cacheManifest
+	if data, err := os.ReadFile(filepath.Join(dir, cacheManifestName)); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+// hlsCacheEntry 一个 HLS 缓存目录
+type hlsCacheEntry struct {
+	Key        string
+	Source     string // 源视频完整路径，旧缓存为空
+	Encoder    string // 编码设置
+	Version    string // 生成缓存的程序版本
+	Size       int64
+	Created    time.Time
+	LastAccess time.Time
+	Complete   bool // 转码已完成
+	Active     bool // 正在转码中
+}
+
+// scanHLSCache 列出所有 HLS 缓存目录
+func scanHLSCache() []hlsCacheEntry {
+	active := make(map[string]bool)
+	lastAccess := make(map[string]int64)
+	hlsJobsMu.Lock()
+	for key, job := range hlsJobs {
+		if !job.Cached {
+			active[key] = true
+		}
+		lastAccess[key] = atomic.LoadInt64(&job.lastAccess)
+	}
+	hlsJobsMu.Unlock()
+
+	dirs, err := os.ReadDir(hlsCacheDir)
+	if err != nil {
+		return nil
+	}
+	var entries []hlsCacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(hlsCacheDir, d.Name())
+		m := readCacheManifest(dir)
+		// 目录修改时间在每次命中缓存时刷新，内存中的访问时间更精确
+		last := info.ModTime()
+		if ts := lastAccess[d.Name()]; ts > last.Unix() {
+			last = time.Unix(ts, 0)
+		}
+		entries = append(entries, hlsCacheEntry{
+			Key:        d.Name(),
+			Source:     m.Source,
+			Encoder:    m.Encoder,
+			Version:    m.Version,
+			Size:       dirSize(dir),
+			Created:    m.Created,
+			LastAccess: last,
+			Complete:   !active[d.Name()] && isCacheComplete(dir),
+			Active:     active[d.Name()],
+		})
+	}
+	return entries
+}
+
+// removeHLSCache 停止任务并删除缓存目录
+func removeHLSCache(key string) error {
+	StopHLS(key)
+	if err := os.RemoveAll(filepath.Join(hlsCacheDir, key)); err != nil {
+		return err
+	}
+	bus.Publish("cache.evicted", map[string]string{"key": key})
+	return nil
+}
+
+// touchCacheDir 记录缓存目录的访问时间，供 LRU 淘汰使用
+func touchCacheDir(dir string) {
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+}
+
+// EvictHLSCache 超出 -cache-max-size 时按最近访问时间从旧到新删除已完成的转码缓存，固定缓存的视频除外
+func EvictHLSCache() {
+	if cacheMaxSize <= 0 {
+		return
+	}
+	evictMu.Lock()
+	defer evictMu.Unlock()
+
+	var total int64
+	var entries []hlsCacheEntry
+	for _, e := range scanHLSCache() {
+		total += e.Size
+		// 只淘汰已完成的转码，进行中的任务和固定缓存的视频不受影响
+		if e.Complete && !pinnedSource(e.Source) {
+			entries = append(entries, e)
+		}
+	}
+	if total <= cacheMaxSize {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastAccess.Before(entries[j].LastAccess) })
+	for _, e := range entries {
+		if total <= cacheMaxSize {
+			break
+		}
+		if err := removeHLSCache(e.Key); err != nil {
+			log.Printf("[缓存] 淘汰 %s 失败: %v", e.Key, err)
+			continue
+		}
+		total -= e.Size
+		log.Printf("[缓存] 淘汰 %s (%s)，当前 %s / %s", e.Key, formatSize(e.Size), formatSize(total), formatSize(cacheMaxSize))
+	}
+	if total > cacheMaxSize {
+		log.Printf("[缓存] 固定缓存的视频和进行中的转码共占用 %s，超出上限 %s", formatSize(total), formatSize(cacheMaxSize))
+	}
+}
+
+// isLocalRequest 请求是否来自本机，本机访问视为管理员
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleCache GET 列出转码缓存，DELETE ?key= 删除单个缓存（正在转码的任务会被停止），仅管理员
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		type entry struct {
+			Key        string    `json:"key"`
+			File       string    `json:"file"` // 相对视频目录的路径，未知时为空
+			Encoder    string    `json:"encoder"`
+			Version    string    `json:"version"`
+			Size       int64     `json:"size"`
+			SizeStr    string    `json:"size_str"`
+			Created    time.Time `json:"created,omitzero"`
+			LastAccess time.Time `json:"last_access"`
+			Complete   bool      `json:"complete"`
+			Active     bool      `json:"active"`
+			Pinned     bool      `json:"pinned,omitempty"` // 来源视频固定了缓存，不会被淘汰
+		}
+		entries := scanHLSCache()
+		sort.Slice(entries, func(i, j int) bool { return entries[i].LastAccess.After(entries[j].LastAccess) })
+		list := make([]entry, 0, len(entries))
+		var total int64
+		for _, e := range entries {
+			file := e.Source
+			if rel, err := filepath.Rel(s.videoDir, e.Source); err == nil && e.Source != "" && !strings.HasPrefix(rel, "..") {
+				file = filepath.ToSlash(rel)
+			}
+			total += e.Size
+			list = append(list, entry{
+				Key:        e.Key,
+				File:       file,
+				Encoder:    e.Encoder,
+				Version:    e.Version,
+				Size:       e.Size,
+				SizeStr:    formatSize(e.Size),
+				Created:    e.Created,
+				LastAccess: e.LastAccess,
+				Complete:   e.Complete,
+				Active:     e.Active,
+				Pinned:     pinnedSource(e.Source),
+			})
+		}
+		writeJSON(w, map[string]any{
+			"entries":  list,
+			"total":    total,
+			"max_size": cacheMaxSize,
+		})
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		if !isHexKey(key) {
+			http.Error(w, "无效的 key", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(hlsCacheDir, key)); err != nil {
+			http.Error(w, "缓存不存在", http.StatusNotFound)
+			return
+		}
+		if err := removeHLSCache(key); err != nil {
+			http.Error(w, "删除缓存失败", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[缓存] 手动删除 %s", key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const capabilitiesFile = "capabilities.json"
+
+// capabilityCodecs 客户端可以上报的编码，其余值忽略
+var capabilityCodecs = map[string]bool{
+	"h264": true, "hevc": true, "vp8": true, "vp9": true, "av1": true,
+	"aac": true, "mp3": true, "opus": true, "vorbis": true, "flac": true, "ac3": true, "eac3": true,
+}
+
+// capabilityContainers 客户端可以上报的容器（扩展名）
+var capabilityContainers = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".webm": true, ".mkv": true}
+
+// Capabilities 播放页用 canPlayType / MediaSource.isTypeSupported 检测到的解码能力，按设备保存
+type Capabilities struct {
+	// Containers 能直接播放的容器，如 [".mp4", ".webm"]
+	Containers []string `json:"containers"`
+	// Video / Audio 能解码的视频和音频编码，如 ["h264", "hevc"]、["aac", "ac3"]
+	Video []string `json:"video"`
+	Audio []string `json:"audio"`
+	// HLS 是否原生支持 HLS（Safari），否则通过 hls.js 播放
+	HLS     bool  `json:"hls"`
+	Updated int64 `json:"updated"`
+}
+
+var (
+	// deviceCapabilities 设备 ID -> 上报的解码能力
+	deviceCapabilities   = make(map[string]Capabilities)
+	deviceCapabilitiesMu sync.Mutex
+)
+
+// InitCapabilities 从数据目录加载各设备上报的解码能力
+func InitCapabilities() error {
+	deviceCapabilitiesMu.Lock()
+	defer deviceCapabilitiesMu.Unlock()
+	return loadJSON(capabilitiesFile, &deviceCapabilities)
+}
+
+// capabilitiesOf 请求设备上报过的解码能力
+func capabilitiesOf(r *http.Request) (Capabilities, bool) {
+	id := requestDevice(r)
+	if id == "" {
+		return Capabilities{}, false
+	}
+	deviceCapabilitiesMu.Lock()
+	defer deviceCapabilitiesMu.Unlock()
+	c, ok := deviceCapabilities[id]
+	return c, ok
+}
+
+// normalize 去掉未知的编码和容器并排序，便于比较是否有变化
+func (c Capabilities) normalize() Capabilities {
+	filter := func(values []string, known map[string]bool) []string {
+		var result []string
+		for _, v := range values {
+			v = strings.ToLower(strings.TrimSpace(v))
+			if known[v] && !slices.Contains(result, v) {
+				result = append(result, v)
+			}
+		}
+		slices.Sort(result)
+		return result
+	}
+	return Capabilities{
+		Containers: filter(c.Containers, capabilityContainers),
+		Video:      filter(c.Video, capabilityCodecs),
+		Audio:      filter(c.Audio, capabilityCodecs),
+		HLS:        c.HLS,
+	}
+}
+
+// equal 两次上报的能力是否相同（不比较上报时间）
+func (c Capabilities) equal(o Capabilities) bool {
+	return slices.Equal(c.Containers, o.Containers) && slices.Equal(c.Video, o.Video) &&
+		slices.Equal(c.Audio, o.Audio) && c.HLS == o.HLS
+}
+
+// apply 用上报的能力替换设备类型能力表中的直接播放组合，音轨和字幕语言偏好保留
+func (c Capabilities) apply(p DeviceProfile) DeviceProfile {
+	p.Direct = make(map[string][]string, len(c.Containers))
+	for _, ext := range c.Containers {
+		p.Direct[ext] = c.Video
+	}
+	p.Audio = c.Audio
+	p.Stream = nil
+	// 实时封装转换输出 fMP4，能播放 MP4 的客户端可以直接收到它能解码的任何视频编码
+	if slices.Contains(c.Containers, ".mp4") {
+		p.Stream = c.Video
+	}
+	p.Reported = true
+	return p
+}
+
+// handleCapabilities 客户端解码能力：
+//
+//	GET  /api/capabilities  当前设备上报过的能力，没有时为 null
+//	POST /api/capabilities[?file=xxx]  上报能力 {"containers": [...], "video": [...], "audio": [...], "hls": true}，
+//	     带 file 时返回该视频按新能力的播放方式 {"mode": "direct|stream|hls|transcode", "changed": 能力是否有变化}
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c, ok := capabilitiesOf(r)
+		if !ok {
+			writeJSON(w, nil)
+			return
+		}
+		writeJSON(w, c)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+	var req Capabilities
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求", http.StatusBadRequest)
+		return
+	}
+	caps := req.normalize()
+	id := deviceID(w, r)
+
+	deviceCapabilitiesMu.Lock()
+	old, ok := deviceCapabilities[id]
+	changed := !ok || !old.equal(caps)
+	if changed {
+		caps.Updated = time.Now().Unix()
+		deviceCapabilities[id] = caps
+		if err := saveJSON(capabilitiesFile, deviceCapabilities); err != nil {
+			logErrorf("[设备] 保存解码能力失败: %v", err)
+		}
+	}
+	deviceCapabilitiesMu.Unlock()
+	if changed {
+		logInfof("[设备] %s 解码能力: 容器 %v 视频 %v 音频 %v", deviceName(id), caps.Containers, caps.Video, caps.Audio)
+	}
+
+	resp := map[string]any{"changed": changed}
+	if file := r.URL.Query().Get("file"); file != "" && s.isValidPath(file) {
+		// 刚分配设备 ID 时请求里还没有 cookie，直接按本次上报的能力判断
+		p := caps.apply(lookupProfile(profileNameFor(r)))
+		fullPath := filepath.Join(s.videoDir, file)
+		d := decidePlayback(fullPath, selectAudio(r, fullPath), p, slices.Contains(caps.Video, "hevc"))
+		resp["mode"] = playModeName(d.Mode)
+	}
+	writeJSON(w, resp)
+}
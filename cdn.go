@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// segmentMaxAge 已完成转码的分片缓存时间：缓存 key 包含源文件和转码参数，内容不会再变
+	segmentMaxAge = 365 * 24 * time.Hour
+	// completedPlaylistMaxAge 已完成转码的播放列表缓存时间
+	completedPlaylistMaxAge = 5 * time.Minute
+	// segmentURLWindow 子播放列表和分片地址签名的过期时间按此对齐：同一时段内签发的地址相同，
+	// CDN 可以按完整地址缓存；签发后至少有效一个时段，足够看完一部电影（包括中途暂停）
+	segmentURLWindow = 6 * time.Hour
+)
+
+var (
+	// cdnMode 在 CDN / nginx 缓存后面提供服务（-cdn）：已完成的分片返回长期不变的缓存头，
+	// 播放页使用短期有效的签名播放列表地址
+	cdnMode bool
+	// playlistURLTTL 签名播放列表地址的有效期（-cdn-playlist-ttl）
+	playlistURLTTL = 10 * time.Minute
+)
+
+// playlistURIAttr 播放列表标签中的 URI 属性，如 #EXT-X-MAP:URI="init.mp4"
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// signHLSKey 转码任务的签名，附加在播放列表中的子播放列表和分片地址上，exp 后失效
+func signHLSKey(key string, exp int64) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("hls|" + key + "|" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// hlsSegmentQuery 子播放列表和分片地址附加的签名参数（?hexp=&hsig=），过期时间对齐到 segmentURLWindow，
+// 同一时段内对所有用户相同，有效 1~2 个时段
+func hlsSegmentQuery(key string) string {
+	window := int64(segmentURLWindow.Seconds())
+	exp := (time.Now().Unix()/window + 2) * window
+	return "hexp=" + strconv.FormatInt(exp, 10) + "&hsig=" + signHLSKey(key, exp)
+}
+
+// signPlaylist 主播放列表地址的短期签名
+func signPlaylist(key string, exp int64) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("playlist|" + key + "|" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// hlsPlaylistURL 播放页使用的主播放列表地址；-cdn 且启用访问保护时带上 playlistURLTTL 内有效的签名，
+// 经过不转发 cookie 的 CDN 也能访问
+func hlsPlaylistURL(key string) string {
+	u := "/hls/" + key + "/master.m3u8"
+	if !cdnMode || !authEnabled() {
+		return u
+	}
+	exp := time.Now().Add(playlistURLTTL).Unix()
+	return u + "?exp=" + strconv.FormatInt(exp, 10) + "&sig=" + signPlaylist(key, exp)
+}
+
+// hlsSigned /hls/ 请求是否带有未过期的有效签名：主播放列表的短期签名，或播放列表中附加的任务签名
+func hlsSigned(r *http.Request) bool {
+	key, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/hls/"), "/")
+	if !ok || !strings.HasPrefix(r.URL.Path, "/hls/") || !isHexKey(key) {
+		return false
+	}
+	q := r.URL.Query()
+	if sig := q.Get("hsig"); sig != "" {
+		ts, ok := signatureExpiry(q.Get("hexp"))
+		return ok && secretEqual(sig, signHLSKey(key, ts))
+	}
+	sig := q.Get("sig")
+	ts, ok := signatureExpiry(q.Get("exp"))
+	return sig != "" && ok && secretEqual(sig, signPlaylist(key, ts))
+}
+
+// signatureExpiry 解析签名地址中的过期时间（unix 秒），已过期或无效时返回 false
+func signatureExpiry(exp string) (int64, bool) {
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > ts {
+		return 0, false
+	}
+	return ts, true
+}
+
+// setHLSCacheHeaders 设置 HLS / DASH 输出的缓存头。未开启 -cdn 时只有播放列表禁止缓存；
+// 开启后已完成转码的分片可以长期缓存，播放列表短期缓存，转码中的输出每次都回源校验
+func setHLSCacheHeaders(w http.ResponseWriter, dir string, playlist bool) {
+	switch {
+	case !cdnMode:
+		if playlist {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+	case !isCacheComplete(dir):
+		w.Header().Set("Cache-Control", "no-cache")
+	case playlist:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(completedPlaylistMaxAge.Seconds())))
+	default:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(segmentMaxAge.Seconds()))+", immutable")
+	}
+}
+
+// fileETag 由文件大小和修改时间（纳秒）生成的 ETag，文件重新生成后随之改变
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// serveCachedFile 提供缓存中生成的文件（封面、预览图、分片等），带上 ETag 和 Last-Modified：
+// 缓存过期后客户端带 If-None-Match / If-Modified-Since 再次请求，文件没变时返回 304，不必重新下载
+func serveCachedFile(w http.ResponseWriter, r *http.Request, path string) {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		w.Header().Set("ETag", fileETag(info))
+	}
+	http.ServeFile(w, r, path)
+}
+
+// servePlaylist 提供播放列表；-cdn 且启用访问保护时为其中的子播放列表和分片地址附加有时效的任务签名
+func servePlaylist(w http.ResponseWriter, r *http.Request, key, path string) {
+	if !cdnMode || !authEnabled() {
+		serveCachedFile(w, r, path)
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data = signPlaylistURIs(data, hlsSegmentQuery(key))
+	w.Header().Set("ETag", fileETag(info))
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// signPlaylistURIs 给播放列表中的地址（非注释行和 URI 属性）附加查询参数
+func signPlaylistURIs(data []byte, query string) []byte {
+	withQuery := func(uri string) string {
+		if strings.Contains(uri, "?") {
+			return uri + "&" + query
+		}
+		return uri + "?" + query
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			lines[i] = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := strings.TrimSuffix(strings.TrimPrefix(attr, `URI="`), `"`)
+				return `URI="` + withQuery(uri) + `"`
+			})
+		default:
+			lines[i] = withQuery(trimmed)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
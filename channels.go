@@ -0,0 +1,519 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	channelsFile = "channels.json"
+	// channelWindow 直播播放列表中保留的分片数，所有观众都从最后几个分片开始播放
+	channelWindow = 6
+	// channelPrestart 开播前多久开始转码第一个节目，节目结束前多久开始转码下一个
+	channelPrestart = 10 * time.Minute
+	// channelKeep 播完的频道保留多久后自动删除
+	channelKeep = 24 * time.Hour
+	// channelSegmentSeconds 还没有转码时估算分片数用的分片时长，与 startHLS 的 -hls_time 一致
+	channelSegmentSeconds = 6
+)
+
+// Channel 定时播放的频道：在 Start 时刻开始按顺序播放播放列表，所有观众看到同一个画面，像电视直播一样
+type Channel struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Playlist  string    `json:"playlist"`  // 来源播放列表 ID
+	Items     []string  `json:"items"`     // 创建时的节目单（视频相对路径），之后修改播放列表不影响已排好的频道
+	Durations []float64 `json:"durations"` // 各节目的时长（秒）
+	Start     time.Time `json:"start"`
+}
+
+var (
+	channels   []Channel
+	channelsMu sync.Mutex
+)
+
+// InitChannels 从数据目录加载频道
+func InitChannels() error {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	return loadJSON(channelsFile, &channels)
+}
+
+// saveChannelsLocked 保存频道（调用方持有 channelsMu）
+func saveChannelsLocked() {
+	if err := saveJSON(channelsFile, channels); err != nil {
+		logErrorf("[频道] 保存失败: %v", err)
+	}
+}
+
+// listChannels 返回所有频道
+func listChannels() []Channel {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	return append([]Channel(nil), channels...)
+}
+
+// findChannel 按 ID 查找频道
+func findChannel(id string) (Channel, bool) {
+	for _, c := range listChannels() {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Channel{}, false
+}
+
+// End 频道播完的时刻
+func (c Channel) End() time.Time {
+	total := 0.0
+	for _, d := range c.Durations {
+		total += d
+	}
+	return c.Start.Add(time.Duration(total * float64(time.Second)))
+}
+
+// at t 时刻正在播放的节目序号和节目内的位置（秒）；未开播时序号为 -1，播完时为 len(Items)
+func (c Channel) at(t time.Time) (int, float64) {
+	pos := t.Sub(c.Start).Seconds()
+	if pos < 0 {
+		return -1, 0
+	}
+	for i, d := range c.Durations {
+		if pos < d {
+			return i, pos
+		}
+		pos -= d
+	}
+	return len(c.Items), 0
+}
+
+// ChannelStatus 频道及当前的播放状态
+type ChannelStatus struct {
+	Channel
+	End      time.Time `json:"end"`
+	State    string    `json:"state"`    // upcoming 未开播 / live 直播中 / ended 已播完
+	Current  int       `json:"current"`  // 正在播放的节目序号，未在直播时为 -1
+	Position float64   `json:"position"` // 正在播放的节目内的位置（秒）
+	Title    string    `json:"title"`    // 正在播放的节目名称
+	Next     string    `json:"next"`     // 下一个节目名称
+}
+
+// StartsAt 首页显示的开播时间：今天只显示时刻
+func (s ChannelStatus) StartsAt() string {
+	if s.Start.Format("2006-01-02") == time.Now().Format("2006-01-02") {
+		return s.Start.Format("15:04")
+	}
+	return s.Start.Format("01-02 15:04")
+}
+
+// channelStatus 频道在 now 时刻的状态
+func channelStatus(c Channel, now time.Time) ChannelStatus {
+	st := ChannelStatus{Channel: c, End: c.End(), Current: -1}
+	i, pos := c.at(now)
+	switch {
+	case i < 0:
+		st.State = "upcoming"
+		st.Next = channelItemTitle(c.Items[0])
+	case i >= len(c.Items):
+		st.State = "ended"
+	default:
+		st.State = "live"
+		st.Current, st.Position = i, pos
+		st.Title = channelItemTitle(c.Items[i])
+		if i+1 < len(c.Items) {
+			st.Next = channelItemTitle(c.Items[i+1])
+		}
+	}
+	return st
+}
+
+// channelItemTitle 节目显示的名称：去掉目录和扩展名的文件名
+func channelItemTitle(rel string) string {
+	name := filepath.Base(rel)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// visibleChannel 请求者能否观看频道：节目单中的视频都要能访问
+func visibleChannel(v viewer, c Channel) bool {
+	if !v.restricted() {
+		return true
+	}
+	for _, item := range c.Items {
+		if !v.canAccess(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// visibleChannels 请求者能观看的、还没播完的频道，首页显示为虚拟文件夹
+func visibleChannels(r *http.Request) []ChannelStatus {
+	v := viewerOf(r)
+	now := time.Now()
+	var result []ChannelStatus
+	for _, c := range listChannels() {
+		if st := channelStatus(c, now); st.State != "ended" && visibleChannel(v, c) {
+			result = append(result, st)
+		}
+	}
+	return result
+}
+
+// parseChannelStart 解析开播时间：RFC 3339，或本地时间的 2006-01-02T15:04 / 2006-01-02 15:04 / 15:04（今天）
+func parseChannelStart(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local), nil
+	}
+	return time.Time{}, fmt.Errorf("无效的开播时间: %q", s)
+}
+
+type channelRequest struct {
+	Name     string `json:"name"`
+	Playlist string `json:"playlist"`
+	Start    string `json:"start"`
+}
+
+// handleChannels 定时播放的频道：
+//
+//	GET    /api/channels      所有频道及当前状态，?id= 返回单个
+//	POST   /api/channels      用播放列表排一个频道，请求体 {"playlist": "播放列表 ID", "start": "2024-06-01T20:00", "name": "..."}
+//	DELETE /api/channels?id=  删除
+func (s *Server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		v := viewerOf(r)
+		now := time.Now()
+		if id != "" {
+			c, ok := findChannel(id)
+			if !ok || !visibleChannel(v, c) {
+				http.Error(w, "频道不存在", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, channelStatus(c, now))
+			return
+		}
+		result := []ChannelStatus{}
+		for _, c := range listChannels() {
+			if visibleChannel(v, c) {
+				result = append(result, channelStatus(c, now))
+			}
+		}
+		writeJSON(w, result)
+		return
+	case http.MethodPost, http.MethodDelete:
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员安排频道", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		channelsMu.Lock()
+		for i, c := range channels {
+			if c.ID == id {
+				channels = append(channels[:i], channels[i+1:]...)
+				break
+			}
+		}
+		saveChannelsLocked()
+		channelsMu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求", http.StatusBadRequest)
+		return
+	}
+	p, ok := findPlaylist(req.Playlist)
+	if !ok {
+		http.Error(w, "播放列表不存在", http.StatusNotFound)
+		return
+	}
+	start, err := parseChannelStart(req.Start)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = p.Name
+	}
+	if utf8.RuneCountInString(name) > 64 {
+		http.Error(w, "名称最多 64 个字", http.StatusBadRequest)
+		return
+	}
+
+	// 跳过已删除、不能转码或读不到时长的视频
+	c := Channel{Name: name, Playlist: p.ID, Start: start}
+	for _, item := range p.Items {
+		if !s.isValidPath(item) {
+			continue
+		}
+		fullPath := filepath.Join(s.videoDir, item)
+		if _, err := os.Stat(fullPath); err != nil || hlsDecision(fullPath, false).Blocked != "" {
+			continue
+		}
+		d := durationSeconds(getDuration(fullPath))
+		if d <= 0 {
+			continue
+		}
+		c.Items = append(c.Items, item)
+		c.Durations = append(c.Durations, float64(d))
+	}
+	if len(c.Items) == 0 {
+		http.Error(w, "播放列表中没有可以播放的视频", http.StatusBadRequest)
+		return
+	}
+	if !c.End().After(time.Now()) {
+		http.Error(w, "开播时间太早，节目已经播完", http.StatusBadRequest)
+		return
+	}
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	c.ID = hex.EncodeToString(buf)
+
+	channelsMu.Lock()
+	channels = append(channels, c)
+	saveChannelsLocked()
+	channelsMu.Unlock()
+	logInfof("[频道] %s: %s 开播，共 %d 个节目", c.Name, c.Start.Format("2006-01-02 15:04"), len(c.Items))
+	writeJSON(w, channelStatus(c, time.Now()))
+}
+
+// ChannelItem 节目单中的一项
+type ChannelItem struct {
+	Title string
+	Start time.Time
+}
+
+// Schedule 节目单及各节目的开播时间
+func (c Channel) Schedule() []ChannelItem {
+	items := make([]ChannelItem, len(c.Items))
+	t := c.Start
+	for i, item := range c.Items {
+		items[i] = ChannelItem{Title: channelItemTitle(item), Start: t}
+		t = t.Add(time.Duration(c.Durations[i] * float64(time.Second)))
+	}
+	return items
+}
+
+// channelJob 节目的转码任务，所有观众共用；总是使用 MPEG-TS 分片和默认音轨，保证各节目可以连续播放
+func channelJob(root string, c Channel, i int) (string, error) {
+	job, err := getOrStartHLS(filepath.Join(root, c.Items[i]), HLSOptions{}, "channel:"+c.ID)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.Base(job.Dir)
+	TouchHLS(key)
+	return key, nil
+}
+
+// liveSegment 直播播放列表中的一个分片
+type liveSegment struct {
+	item     int
+	key      string
+	name     string
+	duration float64
+}
+
+// readSegments 读取转码输出的媒体播放列表中的分片（文件名和时长）
+func readSegments(key string, item int) []liveSegment {
+	data, err := os.ReadFile(filepath.Join(hlsCacheDir, key, "stream.m3u8"))
+	if err != nil {
+		return nil
+	}
+	var segs []liveSegment
+	duration := 0.0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			rest, _, _ = strings.Cut(rest, ",")
+			duration, _ = strconv.ParseFloat(rest, 64)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		segs = append(segs, liveSegment{item: item, key: key, name: line, duration: duration})
+	}
+	return segs
+}
+
+// firstSequence 第 i 个节目第一个分片的媒体序号：之前各节目的分片数之和。
+// 之前的节目已经播完，转码通常已完成，读取实际的分片数；否则按时长估算
+func firstSequence(root string, c Channel, i int) int {
+	seq := 0
+	for j := 0; j < i; j++ {
+		key := hlsJobKey(filepath.Join(root, c.Items[j]), HLSOptions{})
+		if isCacheComplete(filepath.Join(hlsCacheDir, key)) {
+			seq += len(readSegments(key, j))
+		} else {
+			seq += int(math.Ceil(c.Durations[j] / channelSegmentSeconds))
+		}
+	}
+	return seq
+}
+
+// livePlaylist 频道在 now 时刻的直播播放列表：以正在播放的分片结尾的最近 channelWindow 个分片，
+// 节目切换处加 #EXT-X-DISCONTINUITY。所有观众拿到同样的窗口，播放位置一致
+func livePlaylist(root string, c Channel, now time.Time) (string, int, error) {
+	i, pos := c.at(now)
+	if i < 0 {
+		return "", http.StatusServiceUnavailable, fmt.Errorf("频道还没有开播")
+	}
+	if i >= len(c.Items) {
+		return "", http.StatusGone, fmt.Errorf("频道已播完")
+	}
+	key, err := channelJob(root, c, i)
+	if err != nil {
+		return "", http.StatusServiceUnavailable, fmt.Errorf("节目转码失败: %v", err)
+	}
+	// 节目快结束时提前转码下一个
+	if i+1 < len(c.Items) && c.Durations[i]-pos < channelPrestart.Seconds() {
+		channelJob(root, c, i+1)
+	}
+
+	// 只保留开始时间不晚于当前位置的分片，转码跟不上时到已生成的最后一个分片为止
+	segs := readSegments(key, i)
+	n, elapsed := 0, 0.0
+	for n < len(segs) && elapsed <= pos {
+		elapsed += segs[n].duration
+		n++
+	}
+	segs = segs[:n]
+	seq := firstSequence(root, c, i)
+	if len(segs) >= channelWindow {
+		seq += len(segs) - channelWindow
+		segs = segs[len(segs)-channelWindow:]
+	} else if i > 0 {
+		// 节目刚开始时用上一个节目的结尾补足窗口
+		prev := readSegments(hlsJobKey(filepath.Join(root, c.Items[i-1]), HLSOptions{}), i-1)
+		keep := min(len(prev), channelWindow-len(segs))
+		seq -= keep
+		segs = append(prev[len(prev)-keep:], segs...)
+	}
+	if len(segs) == 0 {
+		return "", http.StatusServiceUnavailable, fmt.Errorf("节目准备中")
+	}
+
+	target := channelSegmentSeconds
+	for _, seg := range segs {
+		target = max(target, int(math.Ceil(seg.duration)))
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", segs[0].item)
+	for k, seg := range segs {
+		if k > 0 && seg.item != segs[k-1].item {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		uri := "/hls/" + seg.key + "/" + seg.name
+		if cdnMode && authEnabled() {
+			uri += "?" + hlsSegmentQuery(seg.key)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.duration, uri)
+	}
+	return b.String(), http.StatusOK, nil
+}
+
+// handleLive /live/{id} 频道的播放页，/live/{id}/stream.m3u8 直播播放列表
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	id, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/live/"), "/")
+	c, ok := findChannel(id)
+	if !ok || !visibleChannel(viewerOf(r), c) {
+		http.NotFound(w, r)
+		return
+	}
+	switch file {
+	case "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := templates.ExecuteTemplate(w, "live.html", channelStatus(c, time.Now())); err != nil {
+			logErrorf("模板渲染错误: %v", err)
+		}
+	case "stream.m3u8":
+		playlist, status, err := livePlaylist(s.videoDir, c, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(playlist))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// StartChannels 每 30 秒检查一次频道：开播前 channelPrestart 开始转码第一个节目，
+// 直播中保持当前节目的转码任务不被回收（没有人在看也继续，随时加入都能跟上），
+// 节目快结束时转码下一个；播完超过 channelKeep 的频道自动删除
+func StartChannels(root string) {
+	go func() {
+		for {
+			now := time.Now()
+			expired := false
+			for _, c := range listChannels() {
+				if !c.End().After(now.Add(-channelKeep)) {
+					expired = true
+					continue
+				}
+				// 当前节目，以及 channelPrestart 后要播放的节目
+				cur, _ := c.at(now)
+				soon, _ := c.at(now.Add(channelPrestart))
+				for _, i := range []int{cur, soon} {
+					if i < 0 || i >= len(c.Items) {
+						continue
+					}
+					if _, err := channelJob(root, c, i); err != nil {
+						logErrorf("[频道] %s: 转码 %s 失败: %v", c.Name, c.Items[i], err)
+					}
+					if soon == cur {
+						break
+					}
+				}
+			}
+			if expired {
+				channelsMu.Lock()
+				kept := channels[:0]
+				for _, c := range channels {
+					if c.End().After(now.Add(-channelKeep)) {
+						kept = append(kept, c)
+					}
+				}
+				channels = kept
+				saveChannelsLocked()
+				channelsMu.Unlock()
+			}
+			time.Sleep(30 * time.Second)
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize 小于这个大小（已知 Content-Length 时）的响应不压缩，省下的流量抵不过压缩开销
+const compressMinSize = 1024
+
+// compressibleTypes 压缩的响应类型：页面、接口 JSON、播放列表和字幕等文本。
+// 视频、分片和图片本身已经压缩过，不再压缩
+var compressibleTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/vtt",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/vnd.apple.mpegurl",
+	"application/x-mpegurl",
+	"application/dash+xml",
+	"image/svg+xml",
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// compressible 响应类型是否需要压缩
+func compressible(contentType string) bool {
+	ct, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	ct = strings.TrimSpace(ct)
+	for _, t := range compressibleTypes {
+		if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter 在写出响应头时按类型和大小决定是否压缩
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	size, err := strconv.Atoi(h.Get("Content-Length"))
+	known := err == nil
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) &&
+		(!known || size >= compressMinSize) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		// 压缩后的内容与原文件字节不同，强 ETag 改为弱 ETag，If-None-Match 仍然可以匹配
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 访问底层连接（如上传时延长读取期限）
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close 写完压缩流的结尾并归还 gzip.Writer
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(io.Discard)
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// compressMiddleware 客户端支持 gzip 时压缩文本响应（首页、接口 JSON、m3u8 播放列表等），
+// 长电影的播放列表有上千行，压缩后不到原来的十分之一。Range 请求和 SSE 事件流不压缩
+func compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			!strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") ||
+			strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
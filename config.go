@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName 配置文件名，默认位于用户数据目录（~/.config/localcinema/）
+const configFileName = "config.yaml"
+
+// loadConfigFile 读取 YAML 配置文件，把其中的值应用到命令行中没有显式指定的参数上（命令行优先）
+// 键名与参数名相同（如 cache-max-size，也可以写作 cache_max_size），嵌套的键以 - 连接，
+// 例如 cache: {dir: ..., max-size: 20G} 对应 -cache-dir 和 -cache-max-size；列表值以逗号拼接
+// path 为空时使用默认位置，默认位置的文件不存在时忽略
+func loadConfigFile(fset *flag.FlagSet, path string) (string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = filepath.Join(defaultDataDir(), configFileName)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return "", fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+	values := make(map[string]string)
+	if err := flattenConfig(fset, "", raw, values); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	set := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, value := range values {
+		if set[name] {
+			continue
+		}
+		if err := fset.Set(name, value); err != nil {
+			return "", fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return path, nil
+}
+
+// flattenConfig 把配置项展开为 参数名 -> 值
+func flattenConfig(fset *flag.FlagSet, prefix string, m map[string]any, out map[string]string) error {
+	for key, value := range m {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+		if name == "config" {
+			return fmt.Errorf("配置文件中不能指定 config")
+		}
+		if fset.Lookup(name) != nil {
+			out[name] = configValue(value)
+			continue
+		}
+		if sub, ok := value.(map[string]any); ok {
+			if err := flattenConfig(fset, name, sub, out); err != nil {
+				return err
+			}
+			continue
+		}
+		return fmt.Errorf("未知的配置项: %s", name)
+	}
+	return nil
+}
+
+// configValue 把 YAML 值转换为参数字符串：列表以逗号拼接，映射转为 k=v 列表（如 ext-rules）
+func configValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = configValue(item)
+		}
+		return strings.Join(parts, ",")
+	case map[string]any:
+		parts := make([]string, 0, len(v))
+		for k, item := range v {
+			parts = append(parts, k+"="+configValue(item))
+		}
+		sort.Strings(parts)
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// convertCheckInterval 扫描监视目录的间隔；文件大小和修改时间在两次扫描之间没有变化才视为已复制完成
+	convertCheckInterval = 30 * time.Second
+	// convertKeepJobs 保留的已结束转换任务数量
+	convertKeepJobs = 50
+)
+
+// convertPreset 转换预设：Height 为输出的最大高度（不放大），0 表示只封装转换（视频 copy）
+type convertPreset struct {
+	Height  int
+	Bitrate string
+}
+
+var convertPresets = map[string]convertPreset{
+	"1080p": {Height: 1080, Bitrate: "4M"},
+	"720p":  {Height: 720, Bitrate: "2500k"},
+	"480p":  {Height: 480, Bitrate: "1200k"},
+	"remux": {},
+}
+
+// ConvertRule 自动转换规则：放入 Src 的视频转换为 Preset 后移到 Dst（均为视频目录下的相对路径）
+type ConvertRule struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Preset string `json:"preset"`
+}
+
+// ConvertJob 一个自动转换任务
+type ConvertJob struct {
+	ID       string
+	Source   string // 源视频相对路径
+	Output   string // 转换后的视频相对路径
+	Preset   string
+	State    string // queued / running / done / failed / canceled
+	Error    string
+	Created  time.Time
+	Finished time.Time
+
+	rule     ConvertRule
+	stamp    fileStamp
+	progress jobProgress
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// convertJobStatus /api/convert 返回的任务状态
+type convertJobStatus struct {
+	ID       string    `json:"id"`
+	Source   string    `json:"source"`
+	Output   string    `json:"output,omitempty"`
+	Preset   string    `json:"preset"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Queue    int       `json:"queue_position,omitempty"`
+	Percent  float64   `json:"percent"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// fileStamp 文件大小和修改时间，用于判断复制是否完成、源文件是否变化
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+var (
+	// convertRules 自动转换规则（-convert）
+	convertRules []ConvertRule
+
+	convertMu   sync.Mutex
+	convertRoot string
+	convertJobs []*ConvertJob // 按加入顺序
+	// convertSeen 上一次扫描时监视目录中的视频，完整路径 -> 大小和修改时间
+	convertSeen = make(map[string]fileStamp)
+	// convertHandled 已转换或转换失败、但源文件仍留在原处的视频，源文件不变时不再重试
+	convertHandled = make(map[string]fileStamp)
+	convertWake    = make(chan struct{}, 1)
+)
+
+// parseConvertRules 解析 -convert：逗号分隔的规则，每条为 源目录=目标目录[@预设]，
+// 如 Incoming/phone=Movies/家庭录像@1080p，预设为 1080p / 720p / 480p / remux，默认 1080p
+func parseConvertRules(spec string) error {
+	var rules []ConvertRule
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		src, dst, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("无效的规则 %q，应为 源目录=目标目录[@预设]", item)
+		}
+		preset := "1080p"
+		if d, p, ok := strings.Cut(dst, "@"); ok {
+			dst, preset = d, strings.ToLower(strings.TrimSpace(p))
+		}
+		if _, ok := convertPresets[preset]; !ok {
+			return fmt.Errorf("规则 %q：未知的预设 %q（可选 1080p / 720p / 480p / remux）", item, preset)
+		}
+		src = filepath.Clean(filepath.FromSlash(strings.TrimSpace(src)))
+		dst = filepath.Clean(filepath.FromSlash(strings.TrimSpace(dst)))
+		if !filepath.IsLocal(src) || !filepath.IsLocal(dst) || inTrash(src) || inTrash(dst) {
+			return fmt.Errorf("规则 %q：目录应为视频目录下的相对路径", item)
+		}
+		// 目标目录在源目录内时，转换结果会被再次转换
+		if src == dst || strings.HasPrefix(dst, src+string(filepath.Separator)) {
+			return fmt.Errorf("规则 %q：目标目录不能位于源目录内", item)
+		}
+		rules = append(rules, ConvertRule{Src: filepath.ToSlash(src), Dst: filepath.ToSlash(dst), Preset: preset})
+	}
+	convertRules = rules
+	return nil
+}
+
+// StartConvertWatch 定期扫描各规则的源目录，把复制完成的视频加入转换队列，由后台任务依次转换
+func StartConvertWatch(root string) {
+	if len(convertRules) == 0 {
+		return
+	}
+	convertMu.Lock()
+	convertRoot = root
+	convertMu.Unlock()
+	for _, rule := range convertRules {
+		if err := os.MkdirAll(filepath.Join(root, rule.Src), 0755); err != nil {
+			log.Printf("[转换] 创建监视目录 %s 失败: %v", rule.Src, err)
+		}
+		log.Printf("[转换] 监视 %s：转换为 %s 后移到 %s", rule.Src, rule.Preset, rule.Dst)
+	}
+	go func() {
+		for {
+			scanConvertDirs(root)
+			time.Sleep(convertCheckInterval)
+		}
+	}()
+	go convertWorker(root)
+}
+
+// scanConvertDirs 扫描一次监视目录，把两次扫描之间没有变化的视频加入队列
+func scanConvertDirs(root string) {
+	seen := make(map[string]fileStamp)
+	var ready []*ConvertJob
+	for _, rule := range convertRules {
+		srcDir := filepath.Join(root, rule.Src)
+		filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != srcDir && skipName(d.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if skipName(d.Name()) || !videoExts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || partialVideo(path, info) {
+				return nil
+			}
+			stamp := fileStamp{size: info.Size(), modTime: info.ModTime()}
+			seen[path] = stamp
+			if prev, ok := convertSeen[path]; ok && prev == stamp {
+				ready = append(ready, newConvertJob(root, rule, path, stamp))
+			}
+			return nil
+		})
+	}
+	convertSeen = seen
+
+	convertMu.Lock()
+	defer convertMu.Unlock()
+	for path, stamp := range convertHandled {
+		if s, ok := seen[path]; !ok || s != stamp {
+			delete(convertHandled, path)
+		}
+	}
+	added := 0
+	for _, job := range ready {
+		path := filepath.Join(root, filepath.FromSlash(job.Source))
+		if _, ok := convertHandled[path]; ok || convertPendingLocked(job.Source) {
+			continue
+		}
+		convertJobs = append(convertJobs, job)
+		added++
+		log.Printf("[转换] %s 已加入转换队列（%s）", job.Source, job.Preset)
+	}
+	if added > 0 {
+		select {
+		case convertWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func newConvertJob(root string, rule ConvertRule, path string, stamp fileStamp) *ConvertJob {
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	rel, _ := filepath.Rel(root, path)
+	return &ConvertJob{
+		ID:      hex.EncodeToString(buf),
+		Source:  filepath.ToSlash(rel),
+		Preset:  rule.Preset,
+		State:   "queued",
+		Created: time.Now(),
+		rule:    rule,
+		stamp:   stamp,
+		stop:    make(chan struct{}),
+	}
+}
+
+// convertPendingLocked 源视频是否已在队列中或正在转换
+func convertPendingLocked(source string) bool {
+	for _, j := range convertJobs {
+		if j.Source == source && (j.State == "queued" || j.State == "running") {
+			return true
+		}
+	}
+	return false
+}
+
+// nextConvertJob 取出队列中最早的任务并标记为转换中
+func nextConvertJob() *ConvertJob {
+	convertMu.Lock()
+	defer convertMu.Unlock()
+	for _, j := range convertJobs {
+		if j.State == "queued" {
+			j.State = "running"
+			return j
+		}
+	}
+	return nil
+}
+
+// convertWorker 依次执行转换任务，每个任务占用一个转码名额（-max-transcodes），与播放时的转码一起排队
+func convertWorker(root string) {
+	for {
+		job := nextConvertJob()
+		if job == nil {
+			<-convertWake
+			continue
+		}
+		waitQuietHours("自动转换")
+		err := errConvertCanceled
+		if scheduler.acquire(job, job.Source, job.stop) {
+			err = runConvertJob(root, job)
+			scheduler.release()
+		}
+		finishConvertJob(root, job, err)
+	}
+}
+
+var errConvertCanceled = fmt.Errorf("已取消")
+
+// finishConvertJob 记录任务结果，只保留最近 convertKeepJobs 个已结束的任务
+func finishConvertJob(root string, job *ConvertJob, err error) {
+	convertMu.Lock()
+	defer convertMu.Unlock()
+	job.Finished = time.Now()
+	switch {
+	case err == errConvertCanceled:
+		job.State = "canceled"
+		log.Printf("[转换] %s 已取消", job.Source)
+	case err != nil:
+		job.State, job.Error = "failed", err.Error()
+		log.Printf("[转换] %s 转换失败: %v", job.Source, err)
+	default:
+		job.State = "done"
+		log.Printf("[转换] %s 已转换为 %s", job.Source, job.Output)
+	}
+	// 源文件仍在原处（转换失败、取消或无法移到回收站）时，在它变化之前不再重试
+	path := filepath.Join(root, filepath.FromSlash(job.Source))
+	if _, statErr := os.Stat(path); statErr == nil {
+		convertHandled[path] = job.stamp
+	}
+
+	finished := 0
+	for i := len(convertJobs) - 1; i >= 0; i-- {
+		if s := convertJobs[i].State; s == "queued" || s == "running" {
+			continue
+		}
+		if finished++; finished > convertKeepJobs {
+			convertJobs = append(convertJobs[:i], convertJobs[i+1:]...)
+		}
+	}
+}
+
+// convertOutputPath 转换结果的路径：目标目录下保持源目录内的子目录结构，扩展名改为 .mp4，
+// 已有同名文件时加上序号
+func convertOutputPath(root string, job *ConvertJob) string {
+	rel, _ := filepath.Rel(filepath.FromSlash(job.rule.Src), filepath.FromSlash(job.Source))
+	base := filepath.Join(root, filepath.FromSlash(job.rule.Dst), strings.TrimSuffix(rel, filepath.Ext(rel)))
+	return uniquePath(base+".mp4", ".part")
+}
+
+// convertArgs 按预设生成 ffmpeg 参数：缩小到预设高度后用当前编码器（含硬件编码）编码为 H.264，
+// 音频转为 AAC；remux 只把视频和音频封装为 MP4
+func convertArgs(src, out string, preset convertPreset) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-progress", "pipe:1", "-nostats"}
+	if preset.Height == 0 {
+		args = append(args, "-i", src, "-map", "0:v:0", "-map", "0:a?", "-c", "copy")
+	} else {
+		enc := currentEncoder()
+		encodeArgs := withVideoFilter(enc.EncodeArgs, fmt.Sprintf("scale=-2:trunc(min(%d\\,ih)/2)*2", preset.Height))
+		for i := 0; i+1 < len(encodeArgs); i++ {
+			if encodeArgs[i] == "-b:v" {
+				encodeArgs[i+1] = preset.Bitrate
+			}
+		}
+		args = append(args, enc.InputArgs...)
+		args = append(args, "-i", src, "-map", "0:v:0", "-map", "0:a?")
+		args = append(args, encodeArgs...)
+		args = append(args, "-c:a", "aac", "-b:a", "192k")
+	}
+	return append(args, "-movflags", "+faststart", "-f", "mp4", "-y", out)
+}
+
+// runConvertJob 转换视频：先写入目标目录下的 .part 临时文件，完成后改名，
+// 外挂字幕随之移到目标目录，源视频移到回收站
+func runConvertJob(root string, job *ConvertJob) error {
+	src := filepath.Join(root, filepath.FromSlash(job.Source))
+	if info, err := os.Stat(src); err != nil {
+		return err
+	} else if (fileStamp{size: info.Size(), modTime: info.ModTime()}) != job.stamp {
+		return fmt.Errorf("源文件在排队期间发生了变化")
+	}
+	out := convertOutputPath(root, job)
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	outRel, _ := filepath.Rel(root, out)
+	convertMu.Lock()
+	job.Output = filepath.ToSlash(outRel)
+	convertMu.Unlock()
+
+	tmp := out + ".part"
+	cmd := exec.Command(ffmpegPath(), convertArgs(src, tmp, convertPresets[job.Preset])...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	job.progress.mu.Lock()
+	job.progress.duration = float64(durationSeconds(getDuration(src)))
+	job.progress.mu.Unlock()
+	job.progress.start()
+	log.Printf("[转换] 开始转换 %s -> %s", job.Source, job.Output)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-job.stop:
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	job.progress.parse(stdout)
+	err = cmd.Wait()
+	close(done)
+	select {
+	case <-job.stop:
+		os.Remove(tmp)
+		return errConvertCanceled
+	default:
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	videoBase := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	outBase := strings.TrimSuffix(out, filepath.Ext(out))
+	for _, sub := range videoSidecars(src) {
+		target := outBase + strings.TrimPrefix(filepath.Base(sub), videoBase)
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		if err := os.Rename(sub, target); err != nil {
+			log.Printf("[转换] 移动字幕 %s 失败: %v", filepath.Base(sub), err)
+		}
+	}
+	if info, err := os.Stat(out); err == nil {
+		folderStatsAdd(out, info.Size())
+	}
+	if _, err := moveToTrash(root, filepath.FromSlash(job.Source)); err != nil {
+		log.Printf("[转换] 源视频 %s 无法移到回收站，保留在原处: %v", job.Source, err)
+		bus.Publish("library.changed", nil)
+	}
+	return nil
+}
+
+// status 任务当前状态，调用方需持有 convertMu
+func (job *ConvertJob) status() convertJobStatus {
+	view := convertJobStatus{
+		ID: job.ID, Source: job.Source, Output: job.Output, Preset: job.Preset,
+		State: job.State, Error: job.Error, Created: job.Created, Finished: job.Finished,
+	}
+	if pos := scheduler.position(job); pos > 0 {
+		view.State, view.Queue = "queued", pos // 已轮到，等待空闲的转码名额
+		return view
+	}
+	switch job.State {
+	case "running":
+		job.progress.mu.Lock()
+		if job.progress.duration > 0 {
+			view.Percent = math.Min(99.9, math.Round(job.progress.outTime/job.progress.duration*1000)/10)
+		}
+		job.progress.mu.Unlock()
+	case "done":
+		view.Percent = 100
+	}
+	return view
+}
+
+// cancelConvertJob 取消排队中或正在转换的任务
+func cancelConvertJob(id string) bool {
+	convertMu.Lock()
+	defer convertMu.Unlock()
+	for _, j := range convertJobs {
+		if j.ID != id {
+			continue
+		}
+		switch j.State {
+		case "queued":
+			// 还没有交给转换任务，直接标记为已取消
+			j.State, j.Finished = "canceled", time.Now()
+			convertHandled[filepath.Join(convertRoot, filepath.FromSlash(j.Source))] = j.stamp
+		case "running":
+			j.stopOnce.Do(func() { close(j.stop) })
+		default:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// handleConvert 自动转换（仅限管理员）：
+//
+//	GET /api/convert            转换规则和最近的任务（最新的在前）
+//	DELETE /api/convert?id=     取消排队中或正在转换的任务
+func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "只有管理员可以管理自动转换", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		convertMu.Lock()
+		jobs := make([]convertJobStatus, 0, len(convertJobs))
+		for i := len(convertJobs) - 1; i >= 0; i-- {
+			jobs = append(jobs, convertJobs[i].status())
+		}
+		convertMu.Unlock()
+		rules := convertRules
+		if rules == nil {
+			rules = []ConvertRule{}
+		}
+		writeJSON(w, map[string]any{"rules": rules, "jobs": jobs})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if !cancelConvertJob(id) {
+			http.Error(w, "任务不存在或已结束", http.StatusNotFound)
+			return
+		}
+		log.Printf("[转换] 手动取消任务 %s", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxCrashDumps = 50
+
+// crashDumps 是否把 panic 的现场写入缓存目录下的 crashes/（-crash-dumps）
+var crashDumps bool
+
+// recoverMiddleware 捕获处理函数中的 panic：记录堆栈和请求信息，返回 500，不影响其他请求
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// 客户端断开时 net/http 用于中止响应的 panic，按原样抛出
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			stack := debug.Stack()
+			log.Printf("[崩溃] %s %s <- %s: panic: %v\n%s", r.Method, redactedURI(r.URL), r.RemoteAddr, v, stack)
+			if crashDumps {
+				if path, err := writeCrashDump(r, v, stack); err != nil {
+					log.Printf("[崩溃] 写入现场失败: %v", err)
+				} else {
+					log.Printf("[崩溃] 现场已保存: %s", path)
+				}
+			}
+			// 响应已开始写出时无法再改状态码，连接由客户端自行处理
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeCrashDump 把 panic 信息、请求和堆栈写入 crashes/ 目录，只保留最近的若干份
+func writeCrashDump(r *http.Request, v any, stack []byte) (string, error) {
+	dir := filepath.Join(cacheRoot, "crashes")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "time: %s\nversion: %s\npanic: %v\n\n", now.Format(time.RFC3339), version, v)
+	fmt.Fprintf(&b, "%s %s %s\nremote: %s\n", r.Method, redactedURI(r.URL), r.Proto, r.RemoteAddr)
+	for name, values := range r.Header {
+		// 不记录登录凭据，地址中的令牌和签名也隐去
+		if name == "Cookie" || name == "Authorization" {
+			continue
+		}
+		if name == "Referer" {
+			if u, err := url.Parse(values[0]); err == nil {
+				u.RawQuery = redactQuery(u.RawQuery)
+				values = []string{u.String()}
+			}
+		}
+		fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(values, ", "))
+	}
+	fmt.Fprintf(&b, "\n%s", stack)
+
+	path := filepath.Join(dir, now.Format("20060102-150405.000")+".txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return "", err
+	}
+	pruneCrashDumps(dir)
+	return path, nil
+}
+
+// pruneCrashDumps 删除较早的现场文件（文件名按时间排序）
+func pruneCrashDumps(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) <= maxCrashDumps {
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-maxCrashDumps] {
+		os.Remove(filepath.Join(dir, name))
+	}
+}
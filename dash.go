@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dashManifestName DASH 输出的清单文件
+const dashManifestName = "manifest.mpd"
+
+// dashArgs ffmpeg DASH 输出参数：6 秒 fMP4 分片，清单使用 SegmentTemplate + SegmentTimeline
+func dashArgs() []string {
+	return []string{
+		"-f", "dash",
+		"-seg_duration", "6",
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "seg-$RepresentationID$-$Number%05d$.m4s",
+	}
+}
+
+// handleDASH 提供 MPEG-DASH 输出，与 HLS 共用转码任务、缓存 key 和清理逻辑
+//
+//	/dash/?file=xxx[&audio=N]   启动（或复用）转码任务，重定向到清单地址
+//	/dash/{key}/manifest.mpd     清单，转码进行中为 dynamic，完成后为 static
+//	/dash/{key}/{segment}.m4s    分片
+func (s *Server) handleDASH(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/dash/")
+	if path == "" {
+		s.startDASH(w, r)
+		return
+	}
+
+	key, fileName, ok := strings.Cut(path, "/")
+	if !ok || !isHexKey(key) || strings.Contains(fileName, "/") || strings.Contains(fileName, "..") {
+		http.NotFound(w, r)
+		return
+	}
+	ext := filepath.Ext(fileName)
+	if fileName != dashManifestName && ext != ".m4s" {
+		http.NotFound(w, r)
+		return
+	}
+
+	dir, ok := jobDir(w, r, key)
+	if !ok {
+		return
+	}
+	filePath := filepath.Join(dir, fileName)
+	// 清单在第一个分片完成后才写出，分片可能还在写入
+	timeout := 30 * time.Second
+	if fileName == dashManifestName {
+		timeout = 15 * time.Second
+	}
+	if !waitForFile(filePath, timeout) {
+		http.Error(w, "DASH 输出尚未就绪", http.StatusServiceUnavailable)
+		return
+	}
+	setHLSCacheHeaders(w, dir, fileName == dashManifestName)
+	w.Header().Set("Content-Type", hlsContentTypes[ext])
+	serveCachedFile(w, r, filePath)
+}
+
+// startDASH 为视频启动 DASH 转码，重定向到清单地址
+func (s *Server) startDASH(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+
+	fullPath := filepath.Join(s.videoDir, file)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusNotFound)
+		return
+	}
+	audio := selectAudio(r, fullPath)
+
+	decision := hlsDecision(fullPath, acceptsHEVC(r))
+	if decision.Blocked != "" {
+		http.Error(w, decision.Blocked, http.StatusForbidden)
+		return
+	}
+	opts := HLSOptions{Audio: audio, HEVC: outputHEVC(r, decision), DASH: true, Passthrough: audioPassthrough(r, fullPath, audio)}
+	key := hlsJobKey(fullPath, opts)
+	if _, err := getOrStartHLS(fullPath, opts, deviceID(w, r)); err != nil {
+		log.Printf("[DASH] 启动失败: %v", err)
+		http.Error(w, "转码启动失败", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/dash/"+url.PathEscape(key)+"/"+dashManifestName, http.StatusFound)
+}
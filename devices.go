@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	devicesFile       = "devices.json"
+	deviceNameMaxLen  = 32
+	deviceIDShortName = 6
+)
+
+var (
+	// deviceNames 设备 ID -> 用户设置的名称（如「客厅电视」）
+	deviceNames   = make(map[string]string)
+	deviceNamesMu sync.Mutex
+)
+
+// InitDevices 从数据目录加载设备名称
+func InitDevices() error {
+	deviceNamesMu.Lock()
+	defer deviceNamesMu.Unlock()
+	return loadJSON(devicesFile, &deviceNames)
+}
+
+// deviceName 设备的显示名称，未命名时显示为「设备 + ID 前几位」
+func deviceName(id string) string {
+	deviceNamesMu.Lock()
+	name := deviceNames[id]
+	deviceNamesMu.Unlock()
+	if name != "" {
+		return name
+	}
+	if len(id) > deviceIDShortName {
+		id = id[:deviceIDShortName]
+	}
+	return "设备 " + id
+}
+
+// setDeviceName 设置设备名称，name 为空时恢复默认
+func setDeviceName(id, name string) {
+	deviceNamesMu.Lock()
+	defer deviceNamesMu.Unlock()
+	if name == "" {
+		delete(deviceNames, id)
+	} else {
+		deviceNames[id] = name
+	}
+	if err := saveJSON(devicesFile, deviceNames); err != nil {
+		log.Printf("[设备] 保存失败: %v", err)
+	}
+}
+
+// handleDevice GET 返回当前设备的 ID 和名称，POST {"name":"客厅电视"} 为当前设备命名
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	id := deviceID(w, r)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if utf8.RuneCountInString(name) > deviceNameMaxLen || strings.ContainsAny(name, "\r\n") {
+			http.Error(w, "设备名称过长或包含换行", http.StatusBadRequest)
+			return
+		}
+		setDeviceName(id, name)
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]string{"id": id, "name": deviceName(id)})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxErrorReports = 200
+
+// 同一转码任务在 fallbackWindow 内出现 fallbackErrors 次解码错误后改用兼容模式重新转码
+const (
+	fallbackErrors = 3
+	fallbackWindow = 2 * time.Minute
+)
+
+// PlaybackError 播放器上报的播放错误，附带服务端对应转码任务的状态和 ffmpeg 输出
+type PlaybackError struct {
+	Time       time.Time  `json:"time"`
+	Device     string     `json:"device"`
+	DeviceName string     `json:"device_name"`
+	File       string     `json:"file"`
+	Key        string     `json:"key,omitempty"`    // HLS 任务 key，直接播放时为空
+	Code       int        `json:"code"`             // MediaError.code：1 中止 2 网络 3 解码 4 不支持
+	Message    string     `json:"message"`          // MediaError.message 或 hls.js 的错误详情
+	URL        string     `json:"url,omitempty"`    // 出错的分片/播放列表地址
+	Position   float64    `json:"position"`         // 出错时的播放位置（秒）
+	Fatal      bool       `json:"fatal"`            // 是否导致播放中断
+	Job        *jobStatus `json:"job,omitempty"`    // 出错时转码任务的状态
+	FFmpeg     string     `json:"ffmpeg,omitempty"` // 转码任务最近的 ffmpeg 错误输出
+	Agent      string     `json:"user_agent"`
+	Fallback   string     `json:"fallback,omitempty"` // 因此改用兼容模式重新转码的任务 key
+}
+
+var (
+	errorReports   []PlaybackError // 最近的错误，新的在后
+	errorReportsMu sync.Mutex
+	decodeErrors   = map[string][]time.Time{} // 转码任务 key -> 最近的解码错误时间
+)
+
+// recordPlaybackError 关联转码任务信息后保存错误报告
+func recordPlaybackError(e PlaybackError) PlaybackError {
+	if e.Key != "" {
+		hlsJobsMu.Lock()
+		job, ok := hlsJobs[e.Key]
+		hlsJobsMu.Unlock()
+		if ok {
+			st := job.status(e.Key)
+			e.Job = &st
+			e.FFmpeg = job.stderr.String()
+		}
+	}
+	log.Printf("[播放错误] %s @%.0fs code=%d %s %s", e.File, e.Position, e.Code, e.Message, e.URL)
+
+	errorReportsMu.Lock()
+	errorReports = append(errorReports, e)
+	if len(errorReports) > maxErrorReports {
+		errorReports = errorReports[len(errorReports)-maxErrorReports:]
+	}
+	errorReportsMu.Unlock()
+	return e
+}
+
+// isDecodeError 解码类错误：MediaError 3，或 hls.js 的 mediaError（分片解析、追加到缓冲区失败等）
+func isDecodeError(e PlaybackError) bool {
+	return e.Code == 3 || strings.HasPrefix(e.Message, "mediaError")
+}
+
+// fallbackJob 同一转码任务反复出现解码错误时（如硬件编码器输出的码流有问题），
+// 改用兼容模式（H.264 软编码、Main profile）重新转码，返回新任务的 key；无需回退时返回空
+func fallbackJob(e PlaybackError) string {
+	if e.Key == "" {
+		return ""
+	}
+	// 输出校验未通过的任务已经在用兼容模式重新转码，播放器请求失败时直接切换过去
+	hlsJobsMu.Lock()
+	job, ok := hlsJobs[e.Key]
+	hlsJobsMu.Unlock()
+	if ok {
+		select {
+		case <-job.Done:
+			if job.failed.Load() && job.fallback != "" {
+				return job.fallback
+			}
+		default:
+		}
+	}
+	if !isDecodeError(e) || transcodePolicy != PolicyFull {
+		return ""
+	}
+	now := time.Now()
+	errorReportsMu.Lock()
+	recent := []time.Time{now}
+	for _, t := range decodeErrors[e.Key] {
+		if now.Sub(t) < fallbackWindow {
+			recent = append(recent, t)
+		}
+	}
+	decodeErrors[e.Key] = recent
+	errorReportsMu.Unlock()
+	if len(recent) < fallbackErrors {
+		return ""
+	}
+
+	m := readCacheManifest(filepath.Join(hlsCacheDir, e.Key))
+	if m.Source == "" || m.Fallback || m.DASH {
+		return ""
+	}
+	opts := m.options()
+	opts.Fallback, opts.HEVC = true, false
+	if _, err := getOrStartHLS(m.Source, opts, e.Device); err != nil {
+		log.Printf("[播放错误] %s: 兼容模式转码启动失败: %v", filepath.Base(m.Source), err)
+		return ""
+	}
+	key := hlsJobKey(m.Source, opts)
+	log.Printf("[播放错误] %s: 任务 %s 反复解码出错，改用兼容模式重新转码 (%s)", filepath.Base(m.Source), e.Key, key)
+	return key
+}
+
+// recentPlaybackErrors 返回最近的错误报告，新的在前
+func recentPlaybackErrors() []PlaybackError {
+	errorReportsMu.Lock()
+	defer errorReportsMu.Unlock()
+	list := make([]PlaybackError, len(errorReports))
+	for i, e := range errorReports {
+		list[len(errorReports)-1-i] = e
+	}
+	return list
+}
+
+// handleErrors POST 上报播放错误，GET 返回最近的错误报告（仅管理员）
+func (s *Server) handleErrors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !isAdminRequest(r) {
+			http.Error(w, "仅限管理员访问", http.StatusForbidden)
+			return
+		}
+		writeJSON(w, recentPlaybackErrors())
+	case http.MethodPost:
+		var e PlaybackError
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&e); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		if reason := quotaExceeded(quotaRulesFor(w, r)); reason != "" {
+			// 配额用完导致的中断不是播放错误，播放页收到 429 后刷新为提示页
+			http.Error(w, reason, http.StatusTooManyRequests)
+			return
+		}
+		if e.Key != "" && !isHexKey(e.Key) {
+			e.Key = ""
+		}
+		e.Time = time.Now()
+		e.Device = deviceID(w, r)
+		e.DeviceName = deviceName(e.Device)
+		e.Agent = r.UserAgent()
+		e.Job, e.FFmpeg = nil, ""
+		e.Fallback = fallbackJob(e)
+		recordPlaybackError(e)
+		if e.Fallback != "" {
+			writeJSON(w, map[string]string{"key": e.Fallback, "url": "/hls/" + e.Fallback + "/master.m3u8"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleErrorsPage 播放错误排查页面（仅管理员）
+func (s *Server) handleErrorsPage(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "errors.html", recentPlaybackErrors()); err != nil {
+		log.Printf("模板渲染错误: %v", err)
+	}
+}
+
+// tailBuffer 只保留最后 max 字节的输出，用于记录 ffmpeg 错误输出
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
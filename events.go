@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event 进程内事件，通过 EventBus 广播给订阅者（如 SSE 连接）
+type Event struct {
+	Type string    `json:"type"` // 如 "ffmpeg.progress"
+	Data any       `json:"data,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// EventBus 简单的发布/订阅，订阅者处理不过来时丢弃事件，不阻塞发布方
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+var bus = &EventBus{subs: make(map[chan Event]struct{})}
+
+// Publish 广播一条事件
+func (b *EventBus) Publish(typ string, data any) {
+	ev := Event{Type: typ, Data: data, Time: time.Now()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe 订阅所有事件，调用返回的函数取消订阅
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// serveEvents 以 Server-Sent Events 推送类型以任一 prefix 开头的事件，直到客户端断开；
+// allow 不为 nil 时只推送它允许的事件
+func serveEvents(w http.ResponseWriter, r *http.Request, allow func(Event) bool, prefixes ...string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := bus.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case ev := <-events:
+			if !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(ev.Type, p) }) {
+				continue
+			}
+			if allow != nil && !allow(ev) {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleEvents /events 推送页面实时更新所需的事件，页面无需轮询：
+//
+//	library.changed     媒体库有变化（新增、删除、下载完成等）
+//	video.missing       视频源文件已被删除或移动
+//	video.duration      后台探测到视频时长（列表中先留空，见 listDuration）
+//	transcode.progress  转码进度（内容同 /api/hls/{key}/status）
+//	cache.evicted       转码缓存被淘汰或删除
+//
+// 受限的请求者（账号限制的文件夹、私密文件夹、儿童模式）只收到能访问的视频的事件
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var allow func(Event) bool
+	if v := viewerOf(r); v.restricted() {
+		allow = func(ev Event) bool {
+			rel, ok := s.eventSource(ev)
+			return !ok || rel != "" && v.canAccess(rel)
+		}
+	}
+	serveEvents(w, r, allow, "library.", "video.", "transcode.", "cache.")
+}
+
+// eventSource 事件涉及的视频（相对视频目录，/ 分隔）；ok 为 false 表示事件不涉及具体视频，
+// 涉及的视频无法确定时 rel 为空
+func (s *Server) eventSource(ev Event) (rel string, ok bool) {
+	switch ev.Type {
+	case "video.duration":
+		m, _ := ev.Data.(map[string]string)
+		return m["file"], true
+	case "video.missing":
+		m, _ := ev.Data.(map[string]string)
+		return s.videoRel(m["file"]), true
+	case "transcode.progress":
+		st, _ := ev.Data.(jobStatus)
+		return s.jobSourceRel(st.Key), true
+	}
+	return "", false
+}
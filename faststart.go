@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// moov 在尾部的大 MP4：后台用 ffmpeg -c copy -movflags +faststart 重新封装一次并缓存，
+// 之后直接以 Range 方式提供重新封装后的文件，无需切 HLS 分片
+var faststartCacheDir string
+
+// InitFaststartCache 初始化 faststart 重新封装缓存目录
+func InitFaststartCache() error {
+	faststartCacheDir = filepath.Join(cacheRoot, "faststart")
+	return os.MkdirAll(faststartCacheDir, 0755)
+}
+
+// faststartPath 重新封装后的缓存路径，与封面使用相同的缓存 key
+func faststartPath(videoPath string) string {
+	return filepath.Join(faststartCacheDir, mediaCacheKey(videoPath)+".mp4")
+}
+
+// faststartReady 返回重新封装后的文件是否可用；不可用时在后台启动重新封装（同一视频只执行一次），
+// 本次播放仍直接提供原文件（浏览器通过 Range 读取尾部的 moov）
+func faststartReady(videoPath string) bool {
+	if !needsStreamingMp4(videoPath) {
+		return false
+	}
+	cached := faststartPath(videoPath)
+	if _, err := os.Stat(cached); err == nil {
+		return true
+	}
+	if transcodePolicy == PolicyNone || !ffmpegReady() {
+		return false
+	}
+	go probeGroup.Do(cached, func() (any, error) {
+		if _, err := os.Stat(cached); err == nil {
+			return nil, nil
+		}
+		return nil, remuxFaststart(videoPath, cached)
+	})
+	return false
+}
+
+// remuxFaststart 不重新编码，只把 moov 移到文件头部；先写临时文件再重命名
+func remuxFaststart(videoPath, cachePath string) error {
+	outPath := strings.TrimSuffix(cachePath, ".mp4") + ".tmp.mp4"
+	defer os.Remove(outPath)
+
+	log.Printf("[faststart] 开始重新封装: %s", filepath.Base(videoPath))
+	out, err := exec.Command(ffmpegPath(),
+		"-i", videoPath, "-map", "0", "-c", "copy",
+		"-movflags", "+faststart", "-y", outPath).CombinedOutput()
+	if err == nil {
+		if info, statErr := os.Stat(outPath); statErr == nil && info.Size() > 0 {
+			log.Printf("[faststart] 完成: %s", filepath.Base(videoPath))
+			return os.Rename(outPath, cachePath)
+		}
+		err = fmt.Errorf("ffmpeg 未输出视频")
+	}
+	log.Printf("[faststart] 重新封装失败 %s: %v\n%s", filepath.Base(videoPath), err, string(out))
+	return err
+}
@@ -2,23 +2,81 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"time"
 )
 
 var (
-	ffmpegBin  string
-	ffprobeBin string
+	// ffmpegBin / ffprobeBin 找到或下载好的可执行文件，为空时使用 PATH 中的命令；
+	// 后台下载完成时会修改，读写都要持有 ffmpegBinMu
+	ffmpegBin   string
+	ffprobeBin  string
+	ffmpegBinMu sync.RWMutex
+
+	// ffmpegMu 保证同一时间只有一个 EnsureFFmpeg 在执行（启动重试和手动安装可能同时触发）
+	ffmpegMu sync.Mutex
+
+	// downloadProxy 下载 ffmpeg 使用的代理（-ffmpeg-proxy），为空时使用 HTTP_PROXY / HTTPS_PROXY 环境变量
+	downloadProxy *url.URL
+	// downloadLimit 下载限速（字节/秒，-ffmpeg-download-limit），0 表示不限，避免下载时占满带宽影响正在播放的视频
+	downloadLimit int64
 )
 
+// ffmpegDownload 进行中的下载（启动时、后台重试或手动安装），可通过 DELETE /api/ffmpeg 取消
+var ffmpegDownload struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+// errDownloadCancelled 下载被用户取消
+var errDownloadCancelled = errors.New("下载已取消")
+
+// parseDownloadProxy 解析 -ffmpeg-proxy，如 http://127.0.0.1:7890、socks5://127.0.0.1:1080
+func parseDownloadProxy(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("无效的代理地址: %s", spec)
+	}
+	downloadProxy = u
+	return nil
+}
+
+// ffmpegHTTPClient 下载 ffmpeg 使用的 HTTP 客户端，代理优先使用 -ffmpeg-proxy
+func ffmpegHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if downloadProxy != nil {
+		transport.Proxy = http.ProxyURL(downloadProxy)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// cancelFFmpegDownload 取消进行中的下载，没有下载时返回 false
+func cancelFFmpegDownload() bool {
+	ffmpegDownload.Lock()
+	defer ffmpegDownload.Unlock()
+	if ffmpegDownload.cancel == nil {
+		return false
+	}
+	ffmpegDownload.cancel()
+	return true
+}
+
 func binCacheDir() string {
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".cache", "localcinema", "bin")
+	return filepath.Join(cacheRoot, "bin")
 }
 
 func exeSuffix() string {
@@ -28,31 +86,55 @@ func exeSuffix() string {
 	return ""
 }
 
+// ffmpegBins 当前找到的 ffmpeg 和 ffprobe，未找到的为空
+func ffmpegBins() (ffmpeg, ffprobe string) {
+	ffmpegBinMu.RLock()
+	defer ffmpegBinMu.RUnlock()
+	return ffmpegBin, ffprobeBin
+}
+
+// setFFmpegBins 更新找到的 ffmpeg 和 ffprobe
+func setFFmpegBins(ffmpeg, ffprobe string) {
+	ffmpegBinMu.Lock()
+	defer ffmpegBinMu.Unlock()
+	ffmpegBin, ffprobeBin = ffmpeg, ffprobe
+}
+
 func ffmpegPath() string {
-	if ffmpegBin != "" {
-		return ffmpegBin
+	if bin, _ := ffmpegBins(); bin != "" {
+		return bin
 	}
 	return "ffmpeg" + exeSuffix()
 }
 
 func ffprobePath() string {
-	if ffprobeBin != "" {
-		return ffprobeBin
+	if _, bin := ffmpegBins(); bin != "" {
+		return bin
 	}
 	return "ffprobe" + exeSuffix()
 }
 
+// ffmpegReady ffmpeg 和 ffprobe 是否都已就绪
+func ffmpegReady() bool {
+	ffmpeg, ffprobe := ffmpegBins()
+	return ffmpeg != "" && ffprobe != ""
+}
+
 // EnsureFFmpeg locates or downloads ffmpeg and ffprobe.
 func EnsureFFmpeg() error {
+	ffmpegMu.Lock()
+	defer ffmpegMu.Unlock()
+
 	dir := binCacheDir()
 
 	// Check local cache first, then system PATH
+	ffmpeg, ffprobe := ffmpegBins()
 	for _, tool := range []struct {
 		name string
 		ptr  *string
 	}{
-		{"ffmpeg", &ffmpegBin},
-		{"ffprobe", &ffprobeBin},
+		{"ffmpeg", &ffmpeg},
+		{"ffprobe", &ffprobe},
 	} {
 		local := filepath.Join(dir, tool.name+exeSuffix())
 		if _, err := os.Stat(local); err == nil {
@@ -64,8 +146,10 @@ func EnsureFFmpeg() error {
 		}
 	}
 
+	setFFmpegBins(ffmpeg, ffprobe)
+
 	// If both resolved, done
-	if ffmpegBin != "" && ffprobeBin != "" {
+	if ffmpeg != "" && ffprobe != "" {
 		return nil
 	}
 
@@ -79,6 +163,17 @@ func EnsureFFmpeg() error {
 		return fmt.Errorf("创建目录失败: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	ffmpegDownload.Lock()
+	ffmpegDownload.cancel = cancel
+	ffmpegDownload.Unlock()
+	defer func() {
+		ffmpegDownload.Lock()
+		ffmpegDownload.cancel = nil
+		ffmpegDownload.Unlock()
+		cancel()
+	}()
+
 	if runtime.GOOS == "windows" {
 		// Windows: gyan.dev 提供单个 zip 包含 ffmpeg.exe 和 ffprobe.exe
 		ffmpegDest := filepath.Join(dir, "ffmpeg.exe")
@@ -87,11 +182,11 @@ func EnsureFFmpeg() error {
 		_, errP := os.Stat(ffprobeDest)
 		if errF != nil || errP != nil {
 			url := "https://www.gyan.dev/ffmpeg/builds/ffmpeg-release-essentials.zip"
-			fmt.Println("正在下载 ffmpeg (Windows) ...")
-			if err := downloadAndExtractMultiple(url, dir, []string{"ffmpeg.exe", "ffprobe.exe"}); err != nil {
+			log.Printf("[ffmpeg] 正在下载 ffmpeg (Windows) ...")
+			if err := downloadAndExtractMultiple(ctx, url, dir, []string{"ffmpeg.exe", "ffprobe.exe"}); err != nil {
 				return fmt.Errorf("下载 ffmpeg 失败: %w", err)
 			}
-			fmt.Println("ffmpeg 下载完成")
+			log.Printf("[ffmpeg] ffmpeg 下载完成")
 		}
 	} else {
 		for _, tool := range []string{"ffmpeg", "ffprobe"} {
@@ -101,20 +196,188 @@ func EnsureFFmpeg() error {
 			}
 
 			url := fmt.Sprintf("https://ffmpeg.martin-riedl.de/redirect/latest/%s/%s/release/%s.zip", osName, arch, tool)
-			fmt.Printf("正在下载 %s ...\n", tool)
+			log.Printf("[ffmpeg] 正在下载 %s ...", tool)
 
-			if err := downloadAndExtract(url, tool, dest); err != nil {
+			if err := downloadAndExtract(ctx, url, tool, dest); err != nil {
 				return fmt.Errorf("下载 %s 失败: %w", tool, err)
 			}
-			fmt.Printf("%s 下载完成\n", tool)
+			log.Printf("[ffmpeg] %s 下载完成", tool)
 		}
 	}
 
-	ffmpegBin = filepath.Join(dir, "ffmpeg"+exeSuffix())
-	ffprobeBin = filepath.Join(dir, "ffprobe"+exeSuffix())
+	setFFmpegBins(filepath.Join(dir, "ffmpeg"+exeSuffix()), filepath.Join(dir, "ffprobe"+exeSuffix()))
 	return nil
 }
 
+const (
+	ffmpegRetryMin = 1 * time.Minute
+	ffmpegRetryMax = 30 * time.Minute
+)
+
+// StartFFmpegRetry 启动时 ffmpeg 未就绪（如离线无法下载）时在后台定期重试，
+// 成功后补全缺失的视频时长和封面，无需重启服务
+func StartFFmpegRetry(videoDir string) {
+	go func() {
+		delay := ffmpegRetryMin
+		for {
+			time.Sleep(delay)
+			if ffmpegReady() {
+				return // 已通过 /api/ffmpeg 手动安装
+			}
+			if err := EnsureFFmpeg(); errors.Is(err, errDownloadCancelled) {
+				log.Printf("[ffmpeg] 下载已取消，不再自动重试（可在首页手动安装）")
+				return
+			} else if err != nil {
+				log.Printf("[ffmpeg] 重试失败: %v，%s 后再试", err, delay*2)
+				delay *= 2
+				if delay > ffmpegRetryMax {
+					delay = ffmpegRetryMax
+				}
+				continue
+			}
+			log.Printf("[ffmpeg] 已就绪: %s", ffmpegPath())
+			backfillMedia(videoDir)
+			return
+		}
+	}()
+}
+
+// ffmpegInstaller 运行中的服务上手动触发的安装任务
+var ffmpegInstaller struct {
+	sync.Mutex
+	running bool
+	err     string
+}
+
+// startFFmpegInstall 在后台执行 EnsureFFmpeg，进度和结果通过事件总线广播，
+// 成功后补全封面和时长；已在安装中时直接返回
+func startFFmpegInstall(videoDir string) {
+	ffmpegInstaller.Lock()
+	if ffmpegInstaller.running {
+		ffmpegInstaller.Unlock()
+		return
+	}
+	ffmpegInstaller.running = true
+	ffmpegInstaller.err = ""
+	ffmpegInstaller.Unlock()
+
+	go func() {
+		bus.Publish("ffmpeg.start", nil)
+		err := EnsureFFmpeg()
+
+		ffmpegInstaller.Lock()
+		ffmpegInstaller.running = false
+		if err != nil {
+			ffmpegInstaller.err = err.Error()
+		}
+		ffmpegInstaller.Unlock()
+
+		if errors.Is(err, errDownloadCancelled) {
+			log.Printf("[ffmpeg] 安装已取消")
+			bus.Publish("ffmpeg.cancelled", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("[ffmpeg] 安装失败: %v", err)
+			bus.Publish("ffmpeg.error", map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("[ffmpeg] 已就绪: %s", ffmpegPath())
+		bus.Publish("ffmpeg.ready", ffmpegStatus())
+		backfillMedia(videoDir)
+	}()
+}
+
+// ffmpegStatus ffmpeg 当前状态，供 /api/ffmpeg 返回
+func ffmpegStatus() map[string]any {
+	ffmpegDownload.Lock()
+	downloading := ffmpegDownload.cancel != nil
+	ffmpegDownload.Unlock()
+	ffmpeg, ffprobe := ffmpegBins()
+	ffmpegInstaller.Lock()
+	defer ffmpegInstaller.Unlock()
+	return map[string]any{
+		"ready":       ffmpegReady(),
+		"installing":  ffmpegInstaller.running,
+		"downloading": downloading,
+		"error":       ffmpegInstaller.err,
+		"ffmpeg":      ffmpeg,
+		"ffprobe":     ffprobe,
+	}
+}
+
+// handleFFmpeg GET 查询 ffmpeg 状态，POST 在运行中的服务上下载安装（无需重启），DELETE 取消进行中的下载，
+// 安装进度通过 /api/ffmpeg/events 以 SSE 推送；安装和取消仅管理员
+func (s *Server) handleFFmpeg(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, ffmpegStatus())
+	case http.MethodPost:
+		if !ffmpegReady() {
+			startFFmpegInstall(s.videoDir)
+		}
+		writeJSON(w, ffmpegStatus())
+	case http.MethodDelete:
+		if !cancelFFmpegDownload() {
+			http.Error(w, "没有进行中的下载", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, ffmpegStatus())
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleFFmpegEvents 推送 ffmpeg 安装进度事件
+func (s *Server) handleFFmpegEvents(w http.ResponseWriter, r *http.Request) {
+	serveEvents(w, r, nil, "ffmpeg.")
+}
+
+// downloadProgress 下载进度广播到事件总线（按 500ms 节流），同时每隔几秒写一行日志
+type downloadProgress struct {
+	name      string
+	total     int64 // 未知时为 -1
+	started   time.Time
+	lastEvent time.Time
+	lastLog   time.Time
+}
+
+func newDownloadProgress(name string, total int64) *downloadProgress {
+	now := time.Now()
+	return &downloadProgress{name: name, total: total, started: now, lastLog: now}
+}
+
+func (p *downloadProgress) report(downloaded int64) {
+	done := downloaded == p.total
+	if time.Since(p.lastEvent) < 500*time.Millisecond && !done {
+		return
+	}
+	p.lastEvent = time.Now()
+	var speed int64 // 字节/秒
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		speed = int64(float64(downloaded) / elapsed)
+	}
+	bus.Publish("ffmpeg.progress", map[string]any{
+		"name":       p.name,
+		"downloaded": downloaded,
+		"total":      p.total,
+		"speed":      speed,
+	})
+	if time.Since(p.lastLog) >= 5*time.Second || done {
+		p.lastLog = time.Now()
+		pct := ""
+		if p.total > 0 {
+			pct = fmt.Sprintf(" (%d%%)", downloaded*100/p.total)
+		}
+		log.Printf("[ffmpeg] %s 已下载 %.1f MB%s，%.1f MB/s", p.name,
+			float64(downloaded)/(1024*1024), pct, float64(speed)/(1024*1024))
+	}
+}
+
 func platformInfo() (osName, arch string, err error) {
 	switch runtime.GOOS {
 	case "darwin":
@@ -139,8 +402,8 @@ func platformInfo() (osName, arch string, err error) {
 }
 
 // downloadAndExtractMultiple 下载 zip 并提取多个二进制到 dir（用于 Windows gyan.dev 包）
-func downloadAndExtractMultiple(url, dir string, binaries []string) error {
-	tmp, err := downloadToTemp(url, "ffmpeg")
+func downloadAndExtractMultiple(ctx context.Context, url, dir string, binaries []string) error {
+	tmp, err := downloadToTemp(ctx, url, "ffmpeg")
 	if err != nil {
 		return err
 	}
@@ -191,12 +454,19 @@ func downloadAndExtractMultiple(url, dir string, binaries []string) error {
 	return nil
 }
 
-// downloadToTemp 下载 URL 到临时文件，返回路径
-func downloadToTemp(url, prefix string) (string, error) {
-	resp, err := http.Get(url)
+// downloadToTemp 下载 URL 到临时文件，返回路径；ctx 取消时中断下载并删除临时文件
+func downloadToTemp(ctx context.Context, rawURL, prefix string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return "", err
 	}
+	resp, err := ffmpegHTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", errDownloadCancelled
+		}
+		return "", err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
@@ -208,76 +478,67 @@ func downloadToTemp(url, prefix string) (string, error) {
 		return "", err
 	}
 	tmpPath := tmp.Name()
+	fail := func(err error) (string, error) {
+		tmp.Close()
+		os.Remove(tmpPath)
+		if ctx.Err() != nil {
+			return "", errDownloadCancelled
+		}
+		return "", err
+	}
 
+	progress := newDownloadProgress(prefix, resp.ContentLength)
 	var downloaded int64
 	buf := make([]byte, 256*1024)
 	for {
 		n, readErr := resp.Body.Read(buf)
 		if n > 0 {
 			if _, err := tmp.Write(buf[:n]); err != nil {
-				tmp.Close()
-				os.Remove(tmpPath)
-				return "", err
+				return fail(err)
 			}
 			downloaded += int64(n)
-			fmt.Printf("\r  已下载: %.1f MB", float64(downloaded)/(1024*1024))
+			progress.report(downloaded)
+			if err := throttleDownload(ctx, progress.started, downloaded); err != nil {
+				return fail(err)
+			}
 		}
 		if readErr == io.EOF {
 			break
 		}
 		if readErr != nil {
-			tmp.Close()
-			os.Remove(tmpPath)
-			return "", readErr
+			return fail(readErr)
 		}
 	}
-	fmt.Println()
 	tmp.Close()
 	return tmpPath, nil
 }
 
-func downloadAndExtract(url, binaryName, dest string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+// throttleDownload 启用 -ffmpeg-download-limit 时，下载速度超过限速就等待到平均速度回落
+func throttleDownload(ctx context.Context, started time.Time, downloaded int64) error {
+	if downloadLimit <= 0 {
+		return nil
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	due := started.Add(time.Duration(float64(downloaded) / float64(downloadLimit) * float64(time.Second)))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
 	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
 
-	// Write to temp file (needed for zip random access)
-	tmp, err := os.CreateTemp("", binaryName+"-*.zip")
+func downloadAndExtract(ctx context.Context, rawURL, binaryName, dest string) error {
+	// 先下载到临时文件（zip 需要随机访问）
+	tmpPath, err := downloadToTemp(ctx, rawURL, binaryName)
 	if err != nil {
 		return err
 	}
-	tmpPath := tmp.Name()
 	defer os.Remove(tmpPath)
 
-	var downloaded int64
-	buf := make([]byte, 256*1024)
-	for {
-		n, readErr := resp.Body.Read(buf)
-		if n > 0 {
-			if _, err := tmp.Write(buf[:n]); err != nil {
-				tmp.Close()
-				return err
-			}
-			downloaded += int64(n)
-			fmt.Printf("\r  已下载: %.1f MB", float64(downloaded)/(1024*1024))
-		}
-		if readErr == io.EOF {
-			break
-		}
-		if readErr != nil {
-			tmp.Close()
-			return readErr
-		}
-	}
-	fmt.Println()
-	tmp.Close()
-
 	// Extract binary from zip
 	zr, err := zip.OpenReader(tmpPath)
 	if err != nil {
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FolderStats 目录（含所有子目录）的视频统计
+type FolderStats struct {
+	Count     int // 视频数量
+	Size      int64
+	SizeStr   string
+	Unwatched int // 未看完的数量
+}
+
+// folderTotal 目录的视频数量和总大小（含子目录）
+type folderTotal struct {
+	count int
+	size  int64
+}
+
+var (
+	folderStatsMu sync.Mutex
+	folderRoot    string
+	// folderFiles 视频相对路径 -> 大小，nil 表示尚未统计
+	folderFiles map[string]int64
+	// folderTotals 目录相对路径（根目录为 "."）-> 汇总，随 folderFiles 增量维护
+	folderTotals map[string]*folderTotal
+)
+
+// InitFolderStats 设置统计的视频目录，首次浏览目录时统计
+func InitFolderStats(root string) {
+	folderStatsMu.Lock()
+	defer folderStatsMu.Unlock()
+	folderRoot = root
+	folderFiles = nil
+}
+
+// forEachAncestor 依次对文件所在目录及其所有上级目录调用 fn（直到根目录 "."）
+func forEachAncestor(rel string, fn func(dir string)) {
+	dir := filepath.Dir(rel)
+	for {
+		fn(dir)
+		if dir == "." {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// setFolderFilesLocked 用完整的文件列表重建统计
+func setFolderFilesLocked(files map[string]int64) {
+	folderFiles = files
+	folderTotals = make(map[string]*folderTotal)
+	for rel, size := range files {
+		addTotalsLocked(rel, 1, size)
+	}
+}
+
+func addTotalsLocked(rel string, count int, size int64) {
+	forEachAncestor(rel, func(dir string) {
+		t := folderTotals[dir]
+		if t == nil {
+			t = &folderTotal{}
+			folderTotals[dir] = t
+		}
+		t.count += count
+		t.size += size
+	})
+}
+
+// ensureFolderStatsLocked 尚未统计时遍历一次视频目录（只读文件大小，不探测时长）
+func ensureFolderStatsLocked() {
+	if folderFiles != nil || folderRoot == "" {
+		return
+	}
+	files := make(map[string]int64)
+	walkVideos(folderRoot, func(rel string, info os.FileInfo) {
+		files[rel] = info.Size()
+	})
+	setFolderFilesLocked(files)
+}
+
+// updateFolderStats 用一次完整扫描的结果刷新统计（ScanVideos 完成后调用，无需额外遍历）
+func updateFolderStats(root string, videos []VideoFile) {
+	folderStatsMu.Lock()
+	defer folderStatsMu.Unlock()
+	if root != folderRoot {
+		return
+	}
+	files := make(map[string]int64, len(videos))
+	for _, v := range videos {
+		files[v.RelPath] = v.Size
+	}
+	setFolderFilesLocked(files)
+}
+
+// folderStatsAdd 新增或修改了视频文件（完整路径），增量更新所在目录的统计
+func folderStatsAdd(path string, size int64) {
+	folderStatsMu.Lock()
+	defer folderStatsMu.Unlock()
+	rel, err := filepath.Rel(folderRoot, path)
+	if folderFiles == nil || err != nil {
+		return
+	}
+	if old, ok := folderFiles[rel]; ok {
+		addTotalsLocked(rel, -1, -old)
+	}
+	folderFiles[rel] = size
+	addTotalsLocked(rel, 1, size)
+}
+
+// folderStatsRemove 视频文件（完整路径）被删除或移走，增量更新所在目录的统计
+func folderStatsRemove(path string) {
+	folderStatsMu.Lock()
+	defer folderStatsMu.Unlock()
+	rel, err := filepath.Rel(folderRoot, path)
+	if folderFiles == nil || err != nil {
+		return
+	}
+	if size, ok := folderFiles[rel]; ok {
+		addTotalsLocked(rel, -1, -size)
+		delete(folderFiles, rel)
+	}
+}
+
+// attachFolderStats 为子目录填充统计；未看数量按账号的观看记录计算
+func attachFolderStats(user string, folders []FolderEntry) {
+	if len(folders) == 0 {
+		return
+	}
+	watched := watchedFiles(user)
+
+	folderStatsMu.Lock()
+	defer folderStatsMu.Unlock()
+	ensureFolderStatsLocked()
+
+	watchedIn := make(map[string]int)
+	for _, rel := range watched {
+		if _, ok := folderFiles[rel]; ok {
+			forEachAncestor(rel, func(dir string) { watchedIn[dir]++ })
+		}
+	}
+	for i := range folders {
+		f := &folders[i]
+		t := folderTotals[f.RelPath]
+		if t == nil {
+			f.Stats = FolderStats{SizeStr: formatSize(0)}
+			continue
+		}
+		f.Stats = FolderStats{
+			Count:     t.count,
+			Size:      t.size,
+			SizeStr:   formatSize(t.size),
+			Unwatched: t.count - watchedIn[f.RelPath],
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultFrameRate 探测不到帧率时按 25 帧计算
+const defaultFrameRate = 25
+
+// videoFrameRate 视频第一条视频流的平均帧率
+func videoFrameRate(filePath string) float64 {
+	video, err := probeVideoStream(filePath)
+	if err != nil {
+		return defaultFrameRate
+	}
+	if fps := parseFrameRate(video.FrameRate); fps > 0 {
+		return fps
+	}
+	return defaultFrameRate
+}
+
+// frameTime 取 t 所在的帧再前进 step 帧，返回目标帧的起始时间（秒）
+func frameTime(t, fps float64, step int) float64 {
+	n := max(int(math.Floor(t*fps+1e-3))+step, 0)
+	return float64(n) / fps
+}
+
+// handleFrame 逐帧查看：/api/frame?file=xxx&t=秒[&step=±N]
+// 返回目标帧的 JPEG（解码到精确时间点，不受关键帧间隔影响），X-Frame-Time / X-Frame-Rate 头给出帧时间和帧率
+func (s *Server) handleFrame(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	file := q.Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+	t, err := strconv.ParseFloat(q.Get("t"), 64)
+	if err != nil || t < 0 {
+		http.Error(w, "无效的 t 参数", http.StatusBadRequest)
+		return
+	}
+	step := 0
+	if v := q.Get("step"); v != "" {
+		if step, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "无效的 step 参数", http.StatusBadRequest)
+			return
+		}
+	}
+	fullPath := filepath.Join(s.videoDir, file)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusNotFound)
+		return
+	}
+	if !ffmpegReady() {
+		http.Error(w, "ffmpeg 未就绪", http.StatusServiceUnavailable)
+		return
+	}
+
+	fps := videoFrameRate(fullPath)
+	target := frameTime(t, fps, step)
+	ts := strconv.FormatFloat(target, 'f', 3, 64)
+	// -ss 在 -i 之前且默认 accurate_seek：从前一个关键帧解码到目标时间，输出的是精确的那一帧
+	var stderr bytes.Buffer
+	cmd := exec.Command(ffmpegPath(), "-loglevel", "error",
+		"-ss", ts, "-i", fullPath,
+		"-frames:v", "1", "-q:v", "2", "-f", "image2pipe", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil || len(out) == 0 {
+		log.Printf("[逐帧] %s @%s 截取失败: %v\n%s", filepath.Base(fullPath), ts, err, stderr.String())
+		http.Error(w, "截取画面失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Frame-Time", ts)
+	w.Header().Set("X-Frame-Rate", strconv.FormatFloat(fps, 'f', 3, 64))
+	w.Write(out)
+}
@@ -1,3 +1,11 @@
 module github.com/raojinlin/localcinema
 
 go 1.24.6
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/sync v0.19.0
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
+)
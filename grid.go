@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// gridSnapshotTTL 列表快照在最后一次访问后保留多久
+	gridSnapshotTTL = 10 * time.Minute
+	// gridMaxSnapshots 同时保留的快照数量，超出时丢弃最久未访问的
+	gridMaxSnapshots = 32
+	// gridDefaultLimit / gridMaxLimit 每次返回的卡片数量
+	gridDefaultLimit = 60
+	gridMaxLimit     = 200
+)
+
+// GridCard 虚拟滚动列表中的一张卡片，只包含渲染卡片所需的字段
+type GridCard struct {
+	ID       string `json:"id"` // 相对路径，用于 /play?file=
+	Name     string `json:"name"`
+	Thumb    string `json:"thumb"`
+	Poster   string `json:"poster,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Quality  string `json:"quality,omitempty"`
+	Year     int    `json:"year,omitempty"`
+	Watched  bool   `json:"watched,omitempty"`
+	Favorite bool   `json:"favorite,omitempty"`
+	Stars    int    `json:"stars,omitempty"`
+	Blocked  bool   `json:"blocked,omitempty"`
+}
+
+// gridSnapshot 一次列表请求的完整结果，翻页时按快照返回，媒体库变化不会让已加载的卡片错位
+type gridSnapshot struct {
+	user     string // 生成快照的账号，其他账号不能使用
+	cards    []GridCard
+	accessed time.Time
+}
+
+var (
+	gridSnapshots   = make(map[string]*gridSnapshot)
+	gridSnapshotsMu sync.Mutex
+)
+
+// newGridCard 从列表项生成卡片
+func newGridCard(v VideoFile) GridCard {
+	return GridCard{
+		ID:       v.RelPath,
+		Name:     v.Name,
+		Thumb:    "/thumb?file=" + url.QueryEscape(v.RelPath),
+		Poster:   v.Poster,
+		Duration: v.Duration,
+		Quality:  v.Quality,
+		Year:     v.Year,
+		Watched:  v.Watched,
+		Favorite: v.Favorite,
+		Stars:    v.Stars,
+		Blocked:  v.Blocked != "",
+	}
+}
+
+// findGridSnapshot 按令牌查找快照并刷新访问时间，不存在或已过期时返回 nil
+func findGridSnapshot(token, user string) []GridCard {
+	gridSnapshotsMu.Lock()
+	defer gridSnapshotsMu.Unlock()
+	snap := gridSnapshots[token]
+	if snap == nil || snap.user != user || time.Since(snap.accessed) > gridSnapshotTTL {
+		return nil
+	}
+	snap.accessed = time.Now()
+	return snap.cards
+}
+
+// addGridSnapshot 保存快照并返回令牌，顺带清理过期和超出数量的快照
+func addGridSnapshot(cards []GridCard, user string) string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	gridSnapshotsMu.Lock()
+	defer gridSnapshotsMu.Unlock()
+	for k, snap := range gridSnapshots {
+		if time.Since(snap.accessed) > gridSnapshotTTL {
+			delete(gridSnapshots, k)
+		}
+	}
+	for len(gridSnapshots) >= gridMaxSnapshots {
+		var oldest string
+		for k, snap := range gridSnapshots {
+			if oldest == "" || snap.accessed.Before(gridSnapshots[oldest].accessed) {
+				oldest = k
+			}
+		}
+		delete(gridSnapshots, oldest)
+	}
+	gridSnapshots[token] = &gridSnapshot{user: user, cards: cards, accessed: time.Now()}
+	return token
+}
+
+// handleGrid GET /api/grid 供虚拟滚动列表按窗口加载卡片：
+//
+//	?offset=&limit=          窗口位置和大小（默认 60，最多 200）
+//	?token=                  上一次响应中的令牌，按同一份快照返回，保证顺序稳定
+//	path / smart / filter / q / sort 和各筛选项与首页相同
+//
+// 令牌不存在或已过期（10 分钟未访问）时重新生成列表并返回新令牌，客户端发现令牌变化时应从头加载
+func (s *Server) handleGrid(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = gridDefaultLimit
+	}
+	limit = min(limit, gridMaxLimit)
+
+	token := q.Get("token")
+	user := requestUser(r)
+	cards := findGridSnapshot(token, user)
+	if cards == nil {
+		l, status, err := s.listVideos(r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		cards = make([]GridCard, len(l.videos))
+		for i, v := range l.videos {
+			cards[i] = newGridCard(v)
+		}
+		token = addGridSnapshot(cards, user)
+	}
+
+	start := min(offset, len(cards))
+	end := min(start+limit, len(cards))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, struct {
+		Token  string     `json:"token"`
+		Total  int        `json:"total"`
+		Offset int        `json:"offset"`
+		Items  []GridCard `json:"items"`
+	}{token, len(cards), start, cards[start:end]})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// HiddenPolicy 隐藏文件和系统目录的处理方式
+type HiddenPolicy int
+
+const (
+	HiddenSkip HiddenPolicy = iota // 跳过隐藏文件（点开头、Windows 隐藏属性）和系统目录
+	HiddenShow                     // 显示隐藏文件，仍跳过系统目录（适合把视频放在点目录下的 NAS）
+	HiddenAll                      // 不跳过任何文件
+)
+
+var hiddenPolicy = HiddenSkip
+
+// systemNames 操作系统和 NAS 生成的目录/文件，不会包含正常的视频
+var systemNames = map[string]bool{
+	"@eaDir":                    true, // 群晖缩略图索引
+	"#recycle":                  true, // 群晖回收站
+	"#snapshot":                 true,
+	"$RECYCLE.BIN":              true,
+	"System Volume Information": true,
+	"lost+found":                true,
+	".Trashes":                  true,
+	".Spotlight-V100":           true,
+	".fseventsd":                true,
+	".TemporaryItems":           true,
+	".DocumentRevisions-V100":   true,
+	".AppleDouble":              true,
+}
+
+// parseHiddenPolicy 解析 -hidden 参数
+func parseHiddenPolicy(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "skip":
+		hiddenPolicy = HiddenSkip
+	case "show":
+		hiddenPolicy = HiddenShow
+	case "all":
+		hiddenPolicy = HiddenAll
+	default:
+		return fmt.Errorf("未知的隐藏文件策略 %q（可选 skip / show / all）", s)
+	}
+	return nil
+}
+
+// isSystemName 是否为系统生成的目录或文件（含 macOS 在非 HFS 磁盘上生成的 ._ 资源文件）
+func isSystemName(name string) bool {
+	return systemNames[name] || strings.HasPrefix(name, "._") || strings.HasPrefix(name, ".Trash-")
+}
+
+// skipName 按名称判断扫描时是否跳过该文件或目录（不访问文件系统）
+func skipName(name string) bool {
+	if name == trashDirName {
+		return true
+	}
+	if hiddenPolicy == HiddenAll {
+		return false
+	}
+	if isSystemName(name) {
+		return true
+	}
+	return hiddenPolicy == HiddenSkip && strings.HasPrefix(name, ".")
+}
+
+// skipEntry 在 skipName 的基础上检查 Windows 隐藏/系统属性，info 为 nil 时只按名称判断
+func skipEntry(name string, info fs.FileInfo) bool {
+	if skipName(name) {
+		return true
+	}
+	if hiddenPolicy == HiddenAll || info == nil {
+		return false
+	}
+	hidden, system := fileAttributes(info)
+	return system || hidden && hiddenPolicy == HiddenSkip
+}
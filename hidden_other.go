@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "io/fs"
+
+// fileAttributes 非 Windows 系统没有隐藏/系统属性，只按文件名判断
+func fileAttributes(info fs.FileInfo) (hidden, system bool) {
+	return false, false
+}
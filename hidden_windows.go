@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileAttributes 读取 Windows 文件的隐藏和系统属性
+func fileAttributes(info fs.FileInfo) (hidden, system bool) {
+	d, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return false, false
+	}
+	return d.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0, d.FileAttributes&syscall.FILE_ATTRIBUTE_SYSTEM != 0
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	historyFile = "history.json"
+	recentLimit = 8 // 首页"最近观看"显示数量
+)
+
+// HistoryEntry 视频的观看记录
+type HistoryEntry struct {
+	LastPlayed int64 `json:"last_played"` // unix 秒
+	PlayCount  int   `json:"play_count"`
+	Watched    bool  `json:"watched"`              // 已看完（播放到结尾或手动标记）
+	WatchedAt  int64 `json:"watched_at,omitempty"` // 最近一次看完或标记已看/未看的时间（unix 秒），用于多实例同步
+}
+
+var (
+	// history 视频相对路径 -> 观看记录，未登录账号（共用密码、令牌或未启用访问保护）时使用
+	history = make(map[string]HistoryEntry)
+	// userHistory 账号名 -> 该账号的观看记录（-users）
+	userHistory = make(map[string]map[string]HistoryEntry)
+	historyMu   sync.Mutex
+)
+
+// InitHistory 从数据目录加载观看记录
+func InitHistory() error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return loadJSON(historyFile, &history)
+}
+
+// historyOfLocked 账号的观看记录，账号名为空时为共享的观看记录
+func historyOfLocked(user string) map[string]HistoryEntry {
+	if user == "" {
+		return history
+	}
+	if userHistory[user] == nil {
+		userHistory[user] = make(map[string]HistoryEntry)
+	}
+	return userHistory[user]
+}
+
+func saveHistoryLocked(user string) {
+	if err := saveJSON(userDataFile(user, historyFile), historyOfLocked(user)); err != nil {
+		log.Printf("[历史] 保存失败: %v", err)
+	}
+}
+
+// RecordPlay 记录一次播放
+func RecordPlay(user, file string) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	h := historyOfLocked(user)
+	e := h[file]
+	e.LastPlayed = time.Now().Unix()
+	e.PlayCount++
+	h[file] = e
+	saveHistoryLocked(user)
+}
+
+// SetWatched 标记视频为已看/未看；重新看完已看的视频时只更新时间
+func SetWatched(user, file string, watched bool) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	h := historyOfLocked(user)
+	e := h[file]
+	if e.Watched == watched && !watched {
+		return
+	}
+	e.Watched = watched
+	e.WatchedAt = time.Now().Unix()
+	h[file] = e
+	saveHistoryLocked(user)
+}
+
+// isWatched 视频是否已看完
+func isWatched(user, file string) bool {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return historyOfLocked(user)[file].Watched
+}
+
+// watchedFiles 返回所有已看完视频的相对路径
+func watchedFiles(user string) []string {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	var files []string
+	for file, e := range historyOfLocked(user) {
+		if e.Watched {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// recentlyWatched 从 videos 中挑出播放过的，按最近播放时间倒序
+func recentlyWatched(user string, videos []VideoFile, limit int) []VideoFile {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	history := historyOfLocked(user)
+	var recent []VideoFile
+	for _, v := range videos {
+		if history[v.RelPath].LastPlayed > 0 {
+			recent = append(recent, v)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return history[recent[i].RelPath].LastPlayed > history[recent[j].RelPath].LastPlayed
+	})
+	if limit > 0 && len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent
+}
+
+// unwatchedVideos 从 videos 中挑出未看完的
+func unwatchedVideos(user string, videos []VideoFile) []VideoFile {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	history := historyOfLocked(user)
+	var result []VideoFile
+	for _, v := range videos {
+		if !history[v.RelPath].Watched {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// handleHistory GET 查询最近观看/未看列表，POST 标记已看/未看
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		videos, err := ScanVideos(s.videoDir)
+		if err != nil {
+			http.Error(w, "扫描视频目录失败", http.StatusInternalServerError)
+			return
+		}
+		user := requestUser(r)
+		videos = visibleVideos(r, videos)
+		markWatched(user, videos)
+		writeJSON(w, struct {
+			Recent    []VideoFile
+			Unwatched []VideoFile
+		}{
+			Recent:    recentlyWatched(user, videos, 0),
+			Unwatched: unwatchedVideos(user, videos),
+		})
+
+	case http.MethodPost:
+		var req struct {
+			File    string `json:"file"`
+			Watched bool   `json:"watched"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		if !s.isValidPath(req.File) {
+			http.Error(w, "无效的文件路径", http.StatusForbidden)
+			return
+		}
+		SetWatched(requestUser(r), req.File, req.Watched)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// markWatched 填充列表中每个视频的已看状态，以及收藏、评分和标签
+func markWatched(user string, videos []VideoFile) {
+	historyMu.Lock()
+	history := historyOfLocked(user)
+	for i := range videos {
+		videos[i].Watched = history[videos[i].RelPath].Watched
+	}
+	historyMu.Unlock()
+	markRatings(user, videos)
+	markTags(videos)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// videoEncoder 转码使用的视频编码器
+type videoEncoder struct {
+	Name       string   // -hwaccel 中使用的名称
+	Codec      string   // ffmpeg 编码器
+	Label      string   // 日志中的说明
+	InputArgs  []string // 放在 -i 之前的参数（如硬件设备）
+	EncodeArgs []string // 放在 -i 之后的参数（滤镜、编码器、码率）
+}
+
+// hwBackend 一种硬件加速后端，分别提供 H.264 和 HEVC 编码器
+type hwBackend struct {
+	H264 videoEncoder
+	HEVC videoEncoder
+}
+
+var (
+	softwareBackend = hwBackend{
+		H264: videoEncoder{
+			Name:       "none",
+			Codec:      "libx264",
+			Label:      "软编码",
+			EncodeArgs: []string{"-c:v", "libx264", "-preset", "fast", "-b:v", "4M"},
+		},
+		HEVC: videoEncoder{
+			Name:       "none",
+			Codec:      "libx265",
+			Label:      "软编码",
+			EncodeArgs: []string{"-c:v", "libx265", "-preset", "fast", "-b:v", "2M"},
+		},
+	}
+
+	// hwBackends 支持的硬件加速后端，自动检测时按此顺序尝试
+	hwBackends = []hwBackend{
+		{
+			H264: videoEncoder{
+				Name:       "videotoolbox",
+				Codec:      "h264_videotoolbox",
+				Label:      "VideoToolbox 硬件加速",
+				EncodeArgs: []string{"-c:v", "h264_videotoolbox", "-b:v", "4M"},
+			},
+			HEVC: videoEncoder{
+				Name:       "videotoolbox",
+				Codec:      "hevc_videotoolbox",
+				Label:      "VideoToolbox 硬件加速",
+				EncodeArgs: []string{"-c:v", "hevc_videotoolbox", "-b:v", "2M"},
+			},
+		},
+		{
+			H264: videoEncoder{
+				Name:       "nvenc",
+				Codec:      "h264_nvenc",
+				Label:      "NVENC 硬件加速",
+				EncodeArgs: []string{"-c:v", "h264_nvenc", "-preset", "p4", "-b:v", "4M"},
+			},
+			HEVC: videoEncoder{
+				Name:       "nvenc",
+				Codec:      "hevc_nvenc",
+				Label:      "NVENC 硬件加速",
+				EncodeArgs: []string{"-c:v", "hevc_nvenc", "-preset", "p4", "-b:v", "2M"},
+			},
+		},
+		{
+			H264: videoEncoder{
+				Name:       "qsv",
+				Codec:      "h264_qsv",
+				Label:      "Quick Sync 硬件加速",
+				EncodeArgs: []string{"-vf", "format=nv12", "-c:v", "h264_qsv", "-b:v", "4M"},
+			},
+			HEVC: videoEncoder{
+				Name:       "qsv",
+				Codec:      "hevc_qsv",
+				Label:      "Quick Sync 硬件加速",
+				EncodeArgs: []string{"-vf", "format=nv12", "-c:v", "hevc_qsv", "-b:v", "2M"},
+			},
+		},
+		{
+			H264: videoEncoder{
+				Name:       "vaapi",
+				Codec:      "h264_vaapi",
+				Label:      "VAAPI 硬件加速",
+				InputArgs:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+				EncodeArgs: []string{"-vf", "format=nv12,hwupload", "-c:v", "h264_vaapi", "-b:v", "4M"},
+			},
+			HEVC: videoEncoder{
+				Name:       "vaapi",
+				Codec:      "hevc_vaapi",
+				Label:      "VAAPI 硬件加速",
+				InputArgs:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+				EncodeArgs: []string{"-vf", "format=nv12,hwupload", "-c:v", "hevc_vaapi", "-b:v", "2M"},
+			},
+		},
+	}
+
+	// hwaccelMode -hwaccel 参数：auto 自动检测、none 禁用，或指定后端名称
+	hwaccelMode = "auto"
+
+	encoderOnce     sync.Once
+	encoder         videoEncoder
+	hevcEncoderOnce sync.Once
+	hevcEncoder     videoEncoder
+)
+
+// parseHWAccel 校验 -hwaccel 参数
+func parseHWAccel(mode string) error {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = "auto"
+	}
+	if mode == "auto" || mode == "none" {
+		hwaccelMode = mode
+		return nil
+	}
+	for _, b := range hwBackends {
+		if b.H264.Name == mode {
+			hwaccelMode = mode
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的硬件加速后端: %s（可选 auto/none/videotoolbox/nvenc/qsv/vaapi）", mode)
+}
+
+// currentEncoder 返回 H.264 转码使用的编码器，首次调用时检测（ffmpeg 可能在启动后才安装）
+func currentEncoder() videoEncoder {
+	encoderOnce.Do(func() {
+		encoder = detectEncoder(func(b hwBackend) videoEncoder { return b.H264 })
+		log.Printf("[转码] 视频编码器: %s (%s)", encoder.Codec, encoder.Label)
+	})
+	return encoder
+}
+
+// currentHEVCEncoder 返回 HEVC 转码使用的编码器
+func currentHEVCEncoder() videoEncoder {
+	hevcEncoderOnce.Do(func() {
+		hevcEncoder = detectEncoder(func(b hwBackend) videoEncoder { return b.HEVC })
+		log.Printf("[转码] HEVC 编码器: %s (%s)", hevcEncoder.Codec, hevcEncoder.Label)
+	})
+	return hevcEncoder
+}
+
+// jobEncoder 转码任务使用的编码器，兼容模式总是使用 H.264 软编码
+func jobEncoder(hevc, fallback bool) videoEncoder {
+	switch {
+	case fallback:
+		return softwareBackend.H264
+	case hevc:
+		return currentHEVCEncoder()
+	}
+	return currentEncoder()
+}
+
+// detectEncoder 按 -hwaccel 选择编码器；auto 时逐个检测硬件编码器是否真正可用
+func detectEncoder(pick func(hwBackend) videoEncoder) videoEncoder {
+	switch hwaccelMode {
+	case "none":
+		return pick(softwareBackend)
+	case "auto":
+	default:
+		// 指定后端时直接使用，不做检测
+		for _, b := range hwBackends {
+			if enc := pick(b); enc.Name == hwaccelMode {
+				return enc
+			}
+		}
+	}
+
+	out, err := exec.Command(ffmpegPath(), "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return pick(softwareBackend)
+	}
+	for _, b := range hwBackends {
+		enc := pick(b)
+		if enc.Name == "videotoolbox" && runtime.GOOS != "darwin" {
+			continue
+		}
+		if !strings.Contains(string(out), " "+enc.Codec+" ") {
+			continue
+		}
+		// ffmpeg 编译了该编码器不代表有可用的硬件/驱动，试编码一帧确认
+		if err := testEncoder(enc); err != nil {
+			log.Printf("[转码] %s 不可用: %v", enc.Codec, err)
+			continue
+		}
+		return enc
+	}
+	return pick(softwareBackend)
+}
+
+// testEncoder 用一帧测试画面试运行编码器
+func testEncoder(enc videoEncoder) error {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	args = append(args, enc.InputArgs...)
+	args = append(args, "-f", "lavfi", "-i", "color=black:s=256x144:d=0.1")
+	args = append(args, enc.EncodeArgs...)
+	args = append(args, "-frames:v", "1", "-f", "null", "-")
+	if out, err := exec.Command(ffmpegPath(), args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
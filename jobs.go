@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handleJobs DELETE /api/jobs/{key} 取消转码任务（仅限发起的设备或管理员），
+// ?keep=1 保留已生成的分片
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	if !isHexKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	hlsJobsMu.Lock()
+	job, ok := hlsJobs[key]
+	hlsJobsMu.Unlock()
+	if !ok {
+		http.Error(w, "转码任务不存在或已结束", http.StatusNotFound)
+		return
+	}
+	if !ownsJob(r, job) && !isAdminRequest(r) {
+		http.Error(w, "只能取消自己发起的转码任务", http.StatusForbidden)
+		return
+	}
+
+	keep := r.URL.Query().Get("keep") == "1"
+	log.Printf("[HLS] 手动取消转码任务: %s (保留分片: %v)", key, keep)
+	stopHLSJob(key, keep)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownsJob 请求者是否为发起转码的设备。只认设备 cookie，
+// 不采信客户端可随意设置的 X-Device-ID 请求头
+func ownsJob(r *http.Request, job *HLSJob) bool {
+	c, err := r.Cookie(deviceCookieName)
+	return err == nil && c.Value != "" && c.Value == job.Owner
+}
+
+// jobProgress 转码进度，由 ffmpeg -progress 输出更新
+type jobProgress struct {
+	mu       sync.Mutex
+	duration float64 // 源视频总时长（秒），未知时为 0
+	outTime  float64 // 已输出的时长（秒），之前的部分可以安全拖动
+	offset   float64 // 继续转码时已有分片的时长，ffmpeg 输出的 out_time 从 0 开始计算
+	speed    float64 // 相对实时的倍速
+	started  time.Time
+}
+
+func (p *jobProgress) start() {
+	p.mu.Lock()
+	p.started = time.Now()
+	p.mu.Unlock()
+}
+
+// parse 解析 ffmpeg -progress 输出（每行 key=value），直到 ffmpeg 关闭 stdout
+func (p *jobProgress) parse(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.mu.Lock()
+				p.outTime = p.offset + float64(us)/1e6
+				p.mu.Unlock()
+			}
+		case "speed":
+			if x, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64); err == nil {
+				p.mu.Lock()
+				p.speed = x
+				p.mu.Unlock()
+			}
+		}
+	}
+	io.Copy(io.Discard, r)
+}
+
+// progressReportInterval 转码进度事件的广播间隔
+const progressReportInterval = 2 * time.Second
+
+// reportProgress 转码期间（含排队）定期广播 transcode.progress 事件，返回停止函数
+func reportProgress(job *HLSJob, key string) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				bus.Publish("transcode.progress", job.status(key))
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// jobStatus /api/hls/{key}/status 的响应，也作为 transcode.progress 事件的内容
+type jobStatus struct {
+	Key      string  `json:"key"`
+	State    string  `json:"state"`           // queued / running / done / failed / gone
+	Owner    string  `json:"owner,omitempty"` // 发起转码的设备名称
+	Queue    int     `json:"queue_position,omitempty"`
+	Percent  float64 `json:"percent"`
+	Seekable float64 `json:"seekable"` // 已转码、可安全拖动到的位置（秒）
+	Duration float64 `json:"duration"`
+	Speed    float64 `json:"speed"`
+	ETA      float64 `json:"eta"`                // 预计剩余秒数，未知时为 -1
+	Fallback string  `json:"fallback,omitempty"` // 输出校验未通过，改用兼容模式重新转码的任务 key
+}
+
+// status 汇总任务当前状态
+func (job *HLSJob) status(key string) jobStatus {
+	st := jobStatus{Key: key, ETA: -1}
+	if job.Owner != "" {
+		st.Owner = deviceName(job.Owner)
+	}
+	job.progress.mu.Lock()
+	st.Duration = job.progress.duration
+	st.Seekable = job.progress.outTime
+	st.Speed = job.progress.speed
+	job.progress.mu.Unlock()
+
+	select {
+	case <-job.Done:
+		switch {
+		case job.gone.Load():
+			st.State = "gone"
+		case job.failed.Load():
+			st.State = "failed"
+			st.Fallback = job.fallback
+		default:
+			st.State = "done"
+			st.Percent = 100
+			st.Seekable = st.Duration
+			st.ETA = 0
+		}
+		return st
+	default:
+	}
+
+	if job.Cached {
+		st.State, st.Percent, st.Seekable, st.ETA = "done", 100, st.Duration, 0
+		return st
+	}
+	if pos := scheduler.position(job); pos > 0 {
+		st.State = "queued"
+		st.Queue = pos
+		return st
+	}
+	st.State = "running"
+	if st.Duration > 0 {
+		st.Percent = math.Min(99.9, math.Round(st.Seekable/st.Duration*1000)/10)
+		if st.Speed > 0 {
+			st.ETA = math.Round((st.Duration - st.Seekable) / st.Speed)
+		}
+	}
+	return st
+}
+
+// handleHLSStatus GET /api/hls/{key}/status 返回转码进度、速度和预计剩余时间
+func (s *Server) handleHLSStatus(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/status")
+	if !ok || !isHexKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	hlsJobsMu.Lock()
+	job, ok := hlsJobs[key]
+	hlsJobsMu.Unlock()
+	if ok {
+		writeJSON(w, job.status(key))
+		return
+	}
+	// 内存中没有任务记录，但磁盘缓存已完成
+	if isCacheComplete(filepath.Join(hlsCacheDir, key)) {
+		writeJSON(w, jobStatus{Key: key, State: "done", Percent: 100})
+		return
+	}
+	http.Error(w, "转码任务不存在或已结束", http.StatusNotFound)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const kidsFile = "kids.json"
+
+var (
+	// kidsFolders 儿童模式下能访问的文件夹（-kids-folders，相对视频目录，/ 分隔）
+	kidsFolders []string
+	// kidsTags 儿童模式下能访问的标签（-kids-tags），有其中任一标签的视频都能看
+	kidsTags []string
+	// kidsPIN 退出儿童模式的 PIN（-kids-pin），为空时不能开启儿童模式
+	kidsPIN string
+
+	// kidsProfiles 开启了儿童模式的账号（user:<账号名>）、设备（device:<设备 ID>）或 IP（ip:<地址>）
+	kidsProfiles   = make(map[string]bool)
+	kidsProfilesMu sync.Mutex
+)
+
+// parseKidsFolders 解析 -kids-folders，如 动画,儿童电影
+func parseKidsFolders(spec string) ([]string, error) {
+	var dirs []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		dir := strings.Trim(filepath.ToSlash(filepath.Clean(item)), "/")
+		if dir == "" || dir == "." || !filepath.IsLocal(dir) {
+			return nil, fmt.Errorf("无效的文件夹: %q", item)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// InitKids 从数据目录加载开启了儿童模式的账号和设备
+func InitKids() error {
+	kidsProfilesMu.Lock()
+	defer kidsProfilesMu.Unlock()
+	return loadJSON(kidsFile, &kidsProfiles)
+}
+
+// kidsAvailable 是否可以开启儿童模式：设置了退出用的 PIN 和允许观看的文件夹或标签
+func kidsAvailable() bool {
+	return kidsPIN != "" && (len(kidsFolders) > 0 || len(kidsTags) > 0)
+}
+
+// kidsKeys 儿童模式记在哪里：登录了账号时记在账号上，换设备或清除 cookie 也不会退出；
+// 否则同时记在设备和连接的 IP 上，清除 cookie 换成新设备 ID 后仍按 IP 保持儿童模式
+func kidsKeys(r *http.Request) []string {
+	if user := requestUser(r); user != "" {
+		return []string{"user:" + user}
+	}
+	var keys []string
+	if id := requestDevice(r); id != "" {
+		keys = append(keys, "device:"+id)
+	}
+	return append(keys, "ip:"+remoteIP(r))
+}
+
+// kidsMode 请求者是否处于儿童模式
+func kidsMode(r *http.Request) bool {
+	if !kidsAvailable() {
+		return false
+	}
+	kidsProfilesMu.Lock()
+	defer kidsProfilesMu.Unlock()
+	for _, key := range kidsKeys(r) {
+		if kidsProfiles[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// kidsAllowed 儿童模式下能否访问视频或文件夹 rel：在允许的文件夹中，或有允许的标签
+func kidsAllowed(rel string) bool {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	for _, dir := range kidsFolders {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	for _, t := range tagsOf(rel) {
+		if containsFold(kidsTags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// kidsBrowsable 儿童模式下能否进入文件夹 dir：允许的文件夹及其子文件夹，以及通往它们的上级文件夹
+func kidsBrowsable(dir string) bool {
+	if kidsAllowed(dir) {
+		return true
+	}
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." || dir == "" {
+		return true
+	}
+	for _, f := range kidsFolders {
+		if strings.HasPrefix(f, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// kidsTagCounts 儿童模式下标签栏只显示允许的标签
+func kidsTagCounts(tags []TagCount) []TagCount {
+	var result []TagCount
+	for _, t := range tags {
+		if containsFold(kidsTags, t.Name) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// handleKids 儿童模式：
+//
+//	GET  /api/kids  {"available": 能否开启, "enabled": 是否已开启}
+//	POST /api/kids  开启 {"enabled": true}，退出 {"enabled": false, "pin": "..."}
+func (s *Server) handleKids(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]bool{"available": kidsAvailable(), "enabled": kidsMode(r)})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+	if !kidsAvailable() {
+		http.Error(w, "未启用儿童模式（需要设置 -kids-pin 和 -kids-folders / -kids-tags）", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Enabled bool   `json:"enabled"`
+		PIN     string `json:"pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求", http.StatusBadRequest)
+		return
+	}
+	keys := kidsKeys(r)
+	if requestUser(r) == "" && requestDevice(r) == "" {
+		// 没有设备 ID 时给设备分配一个，之后的请求都带上
+		keys = append([]string{"device:" + deviceID(w, r)}, keys...)
+	}
+	profile := strings.Join(keys, " ")
+	if !req.Enabled && kidsMode(r) {
+		if rejectThrottled(w, r) {
+			return
+		}
+		if !secretEqual(req.PIN, kidsPIN) {
+			logWarnf("[儿童模式] %s 退出 PIN 错误 <- %s", profile, r.RemoteAddr)
+			recordAuthFailure(r)
+			http.Error(w, "PIN 错误", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// 退出时清除该请求对应的所有记录（设备和 IP），只有输入 PIN 才能走到这里
+	kidsProfilesMu.Lock()
+	for _, key := range keys {
+		if req.Enabled {
+			kidsProfiles[key] = true
+		} else {
+			delete(kidsProfiles, key)
+		}
+	}
+	if err := saveJSON(kidsFile, kidsProfiles); err != nil {
+		logErrorf("[儿童模式] 保存失败: %v", err)
+	}
+	kidsProfilesMu.Unlock()
+	if req.Enabled {
+		logInfof("[儿童模式] %s 开启", profile)
+	} else {
+		logInfof("[儿童模式] %s 退出", profile)
+	}
+	writeJSON(w, map[string]bool{"available": true, "enabled": req.Enabled})
+}
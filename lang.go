@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	// audioLangs 默认优先的音轨语言（-audio-lang），设备类型未配置时使用
+	audioLangs []string
+	// subtitleLangs 默认优先的字幕语言（-subtitle-lang）
+	subtitleLangs []string
+)
+
+// langAliases ISO 639-2（ffprobe 常见）和字幕文件名中常见的写法 -> ISO 639-1
+var langAliases = map[string]string{
+	"chi": "zh", "zho": "zh", "cmn": "zh", "chs": "zh", "cht": "zh", "sc": "zh", "tc": "zh", "gb": "zh", "big5": "zh", "chinese": "zh",
+	"yue": "zh",
+	"eng": "en", "english": "en",
+	"jpn": "ja", "jp": "ja", "japanese": "ja",
+	"kor": "ko", "kr": "ko", "korean": "ko",
+	"fre": "fr", "fra": "fr",
+	"ger": "de", "deu": "de",
+	"spa": "es",
+	"ita": "it",
+	"por": "pt",
+	"rus": "ru",
+	"tha": "th",
+}
+
+// normalizeLang 统一语言代码：zh-CN、chi、chs 都视为 zh
+func normalizeLang(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.IndexAny(s, "-_"); i > 0 {
+		s = s[:i]
+	}
+	if alias, ok := langAliases[s]; ok {
+		return alias
+	}
+	return s
+}
+
+// parseLangList 解析逗号分隔的语言列表，如 "zh,en"
+func parseLangList(s string) []string {
+	var langs []string
+	for _, part := range strings.Split(s, ",") {
+		if lang := normalizeLang(part); lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// preferredLangs 请求对应设备类型的音轨和字幕语言偏好，未配置时使用全局默认
+func preferredLangs(r *http.Request) (audio, subs []string) {
+	profile := profileFor(r)
+	audio, subs = profile.AudioLangs, profile.SubtitleLangs
+	if len(audio) == 0 {
+		audio = audioLangs
+	}
+	if len(subs) == 0 {
+		subs = subtitleLangs
+	}
+	return audio, subs
+}
+
+// pickLang 按偏好顺序选择第一个语言匹配的轨道，返回 langs 中的序号，没有匹配时返回 -1
+func pickLang(prefs []string, langs []string) int {
+	for _, want := range prefs {
+		for i, lang := range langs {
+			if normalizeLang(lang) == normalizeLang(want) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// selectAudio 决定播放的音轨：URL 指定 audio 时使用指定值，否则按语言偏好自动选择，默认第一条
+// 禁用转码时只能直接播放（只有第一条音轨），不自动选择
+func selectAudio(r *http.Request, filePath string) int {
+	if v := r.URL.Query().Get("audio"); v != "" {
+		audio, _ := strconv.Atoi(v)
+		return max(audio, 0)
+	}
+	prefs, _ := preferredLangs(r)
+	if len(prefs) == 0 || transcodePolicy == PolicyNone {
+		return 0
+	}
+	streams, err := probeStreams(filePath, "a")
+	if err != nil {
+		return 0
+	}
+	langs := make([]string, len(streams))
+	for i, st := range streams {
+		langs[i] = st.Tags.Language
+	}
+	return max(pickLang(prefs, langs), 0)
+}
+
+// markDefaultSubtitle 把最符合语言偏好的字幕标记为默认显示
+func markDefaultSubtitle(r *http.Request, tracks []SubtitleTrack) {
+	_, prefs := preferredLangs(r)
+	langs := make([]string, len(tracks))
+	for i, t := range tracks {
+		langs[i] = t.Lang
+	}
+	if i := pickLang(prefs, langs); i >= 0 {
+		tracks[i].Default = true
+	}
+}
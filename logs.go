@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxLogEntries = 1000
+
+// LogEntry 一行日志。通过 logInfof 等函数输出的日志带有明确的级别，
+// 直接调用 log 包的日志从内容推断级别（如 "[HLS] xxx 失败" -> error）；标签取自开头的 "[HLS]"
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"` // debug / info / warn / error
+	Tag     string    `json:"tag,omitempty"`
+	Message string    `json:"message"`
+}
+
+var (
+	logEntries []LogEntry
+	logMu      sync.Mutex
+	// logPrefix log 包默认输出的 "2006/01/02 15:04:05 " 前缀
+	logPrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+	logTag    = regexp.MustCompile(`^\[([^\]]+)\]`)
+
+	// 日志输出设置（-log-level、-log-format、-log-file）
+	logOut       io.Writer = os.Stderr
+	logOutMu     sync.Mutex
+	logJSON      bool
+	logMinLevel  = "info"
+	logTagLevels map[string]string // 标签（小写）-> 该标签的最低级别
+)
+
+// parseLogLevel 解析 -log-level：全局最低级别，可按标签单独设置，如 "info,http=warn,hls=debug"
+func parseLogLevel(spec string) (string, map[string]string, error) {
+	level := "info"
+	tags := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		tag, lv, ok := strings.Cut(part, "=")
+		if !ok {
+			tag, lv = "", part
+		}
+		lv = strings.ToLower(strings.TrimSpace(lv))
+		if _, known := logLevelRank[lv]; !known {
+			return "", nil, fmt.Errorf("未知的日志级别 %q（可用 debug、info、warn、error）", lv)
+		}
+		if tag == "" {
+			level = lv
+		} else {
+			tags[strings.ToLower(strings.TrimSpace(tag))] = lv
+		}
+	}
+	return level, tags, nil
+}
+
+// InitLogOutput 设置日志级别、格式（text / json）和输出文件，file 为空时输出到终端
+func InitLogOutput(level, format, file string) error {
+	minLevel, tags, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("未知的日志格式 %q（可用 text、json）", format)
+	}
+	var out io.Writer = os.Stderr
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	logOutMu.Lock()
+	defer logOutMu.Unlock()
+	logOut, logJSON, logMinLevel, logTagLevels = out, format == "json", minLevel, tags
+	return nil
+}
+
+// logEnabled 该级别和标签的日志是否需要输出
+func logEnabled(level, tag string) bool {
+	enabled, _ := logSettings(level, tag)
+	return enabled
+}
+
+// logSettings 同 logEnabled，同时返回是否输出为 JSON；这些设置由 InitLogOutput 修改，需持有 logOutMu 读取
+func logSettings(level, tag string) (enabled, jsonFormat bool) {
+	logOutMu.Lock()
+	defer logOutMu.Unlock()
+	min := logMinLevel
+	if lv, ok := logTagLevels[strings.ToLower(tag)]; ok {
+		min = lv
+	}
+	return logLevelRank[level] >= logLevelRank[min], logJSON
+}
+
+// emitLog 输出一条日志：低于设置级别的丢弃，其余写到终端或日志文件，
+// 保留在最近的日志中并通过事件总线广播（log.debug / log.info / log.warn / log.error）
+func emitLog(level, msg string) {
+	e := LogEntry{Time: time.Now(), Level: level, Message: msg}
+	if m := logTag.FindStringSubmatch(msg); m != nil {
+		e.Tag = m[1]
+	}
+	enabled, jsonFormat := logSettings(e.Level, e.Tag)
+	if !enabled {
+		return
+	}
+
+	var line bytes.Buffer
+	if jsonFormat {
+		enc := json.NewEncoder(&line)
+		enc.SetEscapeHTML(false)
+		enc.Encode(e)
+	} else {
+		line.WriteString(e.Time.Format("2006/01/02 15:04:05 ") + msg + "\n")
+	}
+	logOutMu.Lock()
+	logOut.Write(line.Bytes())
+	logOutMu.Unlock()
+
+	logMu.Lock()
+	logEntries = append(logEntries, e)
+	if len(logEntries) > maxLogEntries {
+		logEntries = logEntries[len(logEntries)-maxLogEntries:]
+	}
+	logMu.Unlock()
+	bus.Publish("log."+e.Level, e)
+}
+
+// logDebugf 等按级别输出日志，消息以 "[标签] " 开头时可以按标签调整级别
+func logDebugf(format string, args ...any) { emitLog("debug", fmt.Sprintf(format, args...)) }
+func logInfof(format string, args ...any)  { emitLog("info", fmt.Sprintf(format, args...)) }
+func logWarnf(format string, args ...any)  { emitLog("warn", fmt.Sprintf(format, args...)) }
+func logErrorf(format string, args ...any) { emitLog("error", fmt.Sprintf(format, args...)) }
+
+// logSink 接收 log 包的输出，按内容推断级别后交给 emitLog
+type logSink struct{}
+
+func (logSink) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		msg := logPrefix.ReplaceAllString(line, "")
+		if msg == "" {
+			continue
+		}
+		emitLog(logLevel(msg), msg)
+	}
+	return len(p), nil
+}
+
+// logLevel 根据日志内容推断级别
+func logLevel(msg string) string {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "失败") || strings.Contains(msg, "错误") || strings.Contains(lower, "error") ||
+		strings.Contains(lower, "panic"):
+		return "error"
+	case strings.Contains(msg, "警告") || strings.Contains(msg, "超时") || strings.Contains(lower, "warn"):
+		return "warn"
+	}
+	return "info"
+}
+
+// InitLogStream 把 log 包的输出交给 emitLog，在输出到终端的同时收集日志，供 /logs 页面实时查看
+func InitLogStream() {
+	log.SetFlags(0)
+	log.SetOutput(logSink{})
+}
+
+// logLevelRank 级别从低到高排序，用于按最低级别筛选
+var logLevelRank = map[string]int{"debug": -1, "info": 0, "warn": 1, "error": 2}
+
+// recentLogs 返回最近的日志，只保留不低于 minLevel 的
+func recentLogs(minLevel string) []LogEntry {
+	logMu.Lock()
+	defer logMu.Unlock()
+	min := logLevelRank[minLevel]
+	list := make([]LogEntry, 0, len(logEntries))
+	for _, e := range logEntries {
+		if logLevelRank[e.Level] >= min {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// handleLogs GET /api/logs?level=warn 返回最近的日志（仅管理员）
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	writeJSON(w, recentLogs(r.URL.Query().Get("level")))
+}
+
+// handleLogEvents 通过 SSE 推送新日志（仅管理员）
+func (s *Server) handleLogEvents(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	serveEvents(w, r, nil, "log.")
+}
+
+// handleLogsPage 实时日志页面
+func (s *Server) handleLogsPage(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "logs.html", nil); err != nil {
+		log.Printf("模板渲染错误: %v", err)
+	}
+}
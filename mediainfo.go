@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo /api/videos/{id}/probe 的返回内容，id 为视频的相对路径
+type MediaInfo struct {
+	File      string          `json:"file"`
+	Container string          `json:"container"` // ffprobe 的 format_name，如 "matroska,webm"
+	Duration  float64         `json:"duration"`  // 秒
+	Size      int64           `json:"size"`
+	BitRate   int64           `json:"bit_rate"` // 总码率（bit/s）
+	Video     *VideoInfo      `json:"video,omitempty"`
+	Audio     []AudioInfo     `json:"audio"`
+	Subtitles []SubtitleInfo  `json:"subtitles"`
+	Playback  PlaybackSummary `json:"playback"`
+}
+
+// VideoInfo 第一条视频流
+type VideoInfo struct {
+	Codec     string  `json:"codec"`
+	Profile   string  `json:"profile,omitempty"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	FrameRate float64 `json:"frame_rate"`
+	BitRate   int64   `json:"bit_rate,omitempty"`
+	PixFmt    string  `json:"pix_fmt,omitempty"`
+	BitDepth  int     `json:"bit_depth,omitempty"`
+	HDR       string  `json:"hdr,omitempty"` // HDR10 / HLG / Dolby Vision，SDR 为空
+	// Stereo 3D 排列：sbs 左右 / ou 上下，2D 为空
+	Stereo string `json:"stereo,omitempty"`
+	// Projection 全景投影：360 / 180，普通画面为空
+	Projection string `json:"projection,omitempty"`
+}
+
+// AudioInfo 音轨，Index 对应 ?audio=N
+type AudioInfo struct {
+	Index    int    `json:"index"`
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels"`
+	Lang     string `json:"lang,omitempty"`
+	Title    string `json:"title,omitempty"`
+	BitRate  int64  `json:"bit_rate,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+}
+
+// SubtitleInfo 内嵌字幕轨，Text 表示可转换为 WebVTT 显示（图形字幕为 false）
+type SubtitleInfo struct {
+	Index   int    `json:"index"`
+	Codec   string `json:"codec"`
+	Lang    string `json:"lang,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Text    bool   `json:"text"`
+	Default bool   `json:"default,omitempty"`
+	Forced  bool   `json:"forced,omitempty"`
+}
+
+// PlaybackSummary 服务端对当前客户端的播放决策
+type PlaybackSummary struct {
+	Mode    string `json:"mode"` // direct / stream / hls / transcode
+	Blocked string `json:"blocked,omitempty"`
+}
+
+// ffprobeOutput ffprobe -show_format -show_streams 的 JSON 输出（只解析用到的字段）
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType        string `json:"codec_type"`
+		CodecName        string `json:"codec_name"`
+		CodecTag         string `json:"codec_tag_string"`
+		Profile          string `json:"profile"`
+		Width            int    `json:"width"`
+		Height           int    `json:"height"`
+		AvgFrameRate     string `json:"avg_frame_rate"`
+		BitRate          string `json:"bit_rate"`
+		PixFmt           string `json:"pix_fmt"`
+		BitsPerRawSample string `json:"bits_per_raw_sample"`
+		ColorTransfer    string `json:"color_transfer"`
+		Channels         int    `json:"channels"`
+		Disposition      struct {
+			Default int `json:"default"`
+			Forced  int `json:"forced"`
+		} `json:"disposition"`
+		Tags struct {
+			Language   string `json:"language"`
+			Title      string `json:"title"`
+			StereoMode string `json:"stereo_mode"` // MKV 的 3D 排列，如 left_right
+		} `json:"tags"`
+		SideDataList []struct {
+			SideDataType string `json:"side_data_type"`
+			Type         string `json:"type"`       // Stereo 3D：side by side / top and bottom
+			Projection   string `json:"projection"` // Spherical Mapping：equirectangular 等
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// mediaInfoCachePath 完整探测结果的缓存路径，与封面使用相同的缓存 key
+func mediaInfoCachePath(videoPath string) string {
+	return filepath.Join(thumbCacheDir, mediaCacheKey(videoPath)+".probe.json")
+}
+
+// cachedMediaInfo 只读取已缓存的探测结果，不运行 ffprobe（扫描媒体库时使用）
+func cachedMediaInfo(videoPath string) (ffprobeOutput, bool) {
+	var result ffprobeOutput
+	data, err := os.ReadFile(mediaInfoCachePath(videoPath))
+	if err != nil || json.Unmarshal(data, &result) != nil {
+		return result, false
+	}
+	return result, true
+}
+
+// probeMediaInfo 读取（或运行 ffprobe 生成）完整探测结果
+func probeMediaInfo(videoPath string) (ffprobeOutput, error) {
+	if result, ok := cachedMediaInfo(videoPath); ok {
+		return result, nil
+	}
+	var result ffprobeOutput
+	cached := mediaInfoCachePath(videoPath)
+	v, err, _ := probeGroup.Do(cached, func() (any, error) {
+		out, err := exec.Command(ffprobePath(),
+			"-v", "quiet", "-show_format", "-show_streams", "-print_format", "json",
+			videoPath).Output()
+		if err != nil {
+			return nil, err
+		}
+		writeFileAtomic(cached, out)
+		return out, nil
+	})
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(v.([]byte), &result)
+	return result, err
+}
+
+// applyStreamInfo 从已缓存的探测结果填充分辨率、编码和清晰度标签；
+// 没有缓存时留空，由封面生成或后台补全时探测，之后的列表中显示
+func applyStreamInfo(v *VideoFile, path string) {
+	p, ok := cachedMediaInfo(path)
+	if !ok {
+		return
+	}
+	if video := buildMediaInfo(v.RelPath, p).Video; video != nil {
+		v.Width, v.Height, v.Codec = video.Width, video.Height, video.Codec
+		v.Quality = qualityLabel(video.Width, video.Height)
+		v.Stereo, v.Projection = video.Stereo, video.Projection
+	}
+}
+
+// qualityLabel 按宽或高判断清晰度，宽银幕（如 1920x800）和竖屏视频也能归到正确的档位
+func qualityLabel(width, height int) string {
+	switch {
+	case width >= 3200 || height >= 1800:
+		return "4K"
+	case width >= 1800 || height >= 1000:
+		return "1080p"
+	case width >= 1200 || height >= 700:
+		return "720p"
+	case width > 0:
+		return "SD"
+	}
+	return ""
+}
+
+// qualityRank 清晰度标签的高低顺序，用于 res>=1080p 之类的筛选；无法识别时返回 0
+func qualityRank(s string) int {
+	switch strings.ToLower(s) {
+	case "sd", "480p", "576p":
+		return 1
+	case "720p", "720", "hd":
+		return 2
+	case "1080p", "1080", "fhd":
+		return 3
+	case "4k", "2160p", "2160", "uhd":
+		return 4
+	}
+	return 0
+}
+
+// hdrFormat 根据传输特性和 Dolby Vision 配置判断 HDR 类型
+func hdrFormat(transfer, tag string, sideData []string) string {
+	for _, t := range sideData {
+		if strings.Contains(t, "DOVI") {
+			return "Dolby Vision"
+		}
+	}
+	switch {
+	case tag == "dvh1" || tag == "dvhe":
+		return "Dolby Vision"
+	case transfer == "smpte2084":
+		return "HDR10"
+	case transfer == "arib-std-b67":
+		return "HLG"
+	}
+	return ""
+}
+
+// buildMediaInfo 把 ffprobe 输出整理为接口返回的结构
+func buildMediaInfo(file string, p ffprobeOutput) MediaInfo {
+	info := MediaInfo{
+		File:      file,
+		Container: p.Format.FormatName,
+		Audio:     []AudioInfo{},
+		Subtitles: []SubtitleInfo{},
+	}
+	info.Duration, _ = strconv.ParseFloat(p.Format.Duration, 64)
+	info.Size, _ = strconv.ParseInt(p.Format.Size, 10, 64)
+	info.BitRate, _ = strconv.ParseInt(p.Format.BitRate, 10, 64)
+
+	for _, st := range p.Streams {
+		bitRate, _ := strconv.ParseInt(st.BitRate, 10, 64)
+		switch st.CodecType {
+		case "video":
+			if info.Video != nil || st.Width == 0 {
+				continue // 只取第一条视频流，跳过内嵌封面图
+			}
+			var sideData []string
+			var stereoType, projectionType string
+			for _, sd := range st.SideDataList {
+				sideData = append(sideData, sd.SideDataType)
+				switch sd.SideDataType {
+				case "Stereo 3D":
+					stereoType = sd.Type
+				case "Spherical Mapping":
+					projectionType = sd.Projection
+				}
+			}
+			depth, _ := strconv.Atoi(st.BitsPerRawSample)
+			if depth == 0 && strings.Contains(st.PixFmt, "10") {
+				depth = 10
+			}
+			info.Video = &VideoInfo{
+				Codec:     st.CodecName,
+				Profile:   st.Profile,
+				Width:     st.Width,
+				Height:    st.Height,
+				FrameRate: parseFrameRate(st.AvgFrameRate),
+				BitRate:   bitRate,
+				PixFmt:    st.PixFmt,
+				BitDepth:  depth,
+				HDR:       hdrFormat(st.ColorTransfer, st.CodecTag, sideData),
+			}
+			info.Video.Stereo, info.Video.Projection = stereoFromSideData(stereoType, projectionType, st.Tags.StereoMode)
+		case "audio":
+			info.Audio = append(info.Audio, AudioInfo{
+				Index:    len(info.Audio),
+				Codec:    st.CodecName,
+				Channels: st.Channels,
+				Lang:     st.Tags.Language,
+				Title:    st.Tags.Title,
+				BitRate:  bitRate,
+				Default:  st.Disposition.Default == 1,
+			})
+		case "subtitle":
+			info.Subtitles = append(info.Subtitles, SubtitleInfo{
+				Index:   len(info.Subtitles),
+				Codec:   st.CodecName,
+				Lang:    st.Tags.Language,
+				Title:   st.Tags.Title,
+				Text:    textSubtitleCodecs[st.CodecName],
+				Default: st.Disposition.Default == 1,
+				Forced:  st.Disposition.Forced == 1,
+			})
+		}
+	}
+	return info
+}
+
+// playModeName 播放方式在接口中的名称
+func playModeName(mode PlayMode) string {
+	switch mode {
+	case PlayDirect:
+		return "direct"
+	case PlayStream:
+		return "stream"
+	case PlayRemux:
+		return "hls"
+	default:
+		return "transcode"
+	}
+}
+
+// handleVideoAPI 单个视频的接口：/api/videos/{id}/probe、rating、tags、pin、rename、move，id 为相对路径（可整体 URL 编码）
+func (s *Server) handleVideoAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), "/api/videos/")
+	// DELETE /api/videos/{id} 移到回收站
+	if file, err := url.PathUnescape(rest); err == nil && r.Method == http.MethodDelete && s.isValidPath(file) {
+		s.handleDeleteVideo(w, r, file)
+		return
+	}
+	id, action, ok := cutLast(rest, "/")
+	if !ok || action != "probe" && action != "rating" && action != "tags" && action != "pin" && action != "rename" && action != "move" {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := url.PathUnescape(id)
+	if err != nil || file == "" {
+		http.Error(w, "无效的视频 id", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+	switch action {
+	case "rating":
+		s.handleRating(w, r, file)
+		return
+	case "tags":
+		s.handleVideoTags(w, r, file)
+		return
+	case "pin":
+		s.handlePin(w, r, file)
+		return
+	case "rename", "move":
+		s.handleRelocate(w, r, file, action)
+		return
+	}
+	fullPath := filepath.Join(s.videoDir, file)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusNotFound)
+		return
+	}
+	p, err := probeMediaInfo(fullPath)
+	if err != nil {
+		http.Error(w, "ffprobe 探测失败", http.StatusServiceUnavailable)
+		return
+	}
+
+	info := buildMediaInfo(file, p)
+	decision := decidePlayback(fullPath, 0, profileFor(r), acceptsHEVC(r))
+	info.Playback = PlaybackSummary{Mode: playModeName(decision.Mode), Blocked: decision.Blocked}
+	writeJSON(w, info)
+}
+
+// cutLast 按最后一个 sep 切分
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	metadataFile    = "metadata.json"
+	metaTitleMaxLen = 200
+	metaDescMaxLen  = 4000
+	metaYearMin     = 1880
+	metaYearMax     = 2100
+)
+
+// VideoMeta 用户编辑的视频信息，优先于文件名显示
+type VideoMeta struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Year        int    `json:"year,omitempty"`
+}
+
+func (m VideoMeta) empty() bool {
+	return m == VideoMeta{}
+}
+
+var (
+	// metadata 视频相对路径 -> 用户编辑的信息
+	metadata   = make(map[string]VideoMeta)
+	metadataMu sync.Mutex
+)
+
+// InitMetadata 从数据目录加载用户编辑的视频信息
+func InitMetadata() error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return loadJSON(metadataFile, &metadata)
+}
+
+// videoMeta 查询视频的自定义信息
+func videoMeta(rel string) VideoMeta {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return metadata[rel]
+}
+
+// applyMeta 用自定义信息覆盖列表项的显示名称，没有自定义的部分使用刮削结果
+func applyMeta(v *VideoFile) {
+	m := videoMeta(v.RelPath)
+	if m.Title != "" {
+		v.Name = m.Title
+	}
+	v.Year = m.Year
+	v.Description = m.Description
+	applyScraped(v, m)
+}
+
+// setVideoMeta 保存视频的自定义信息，全部为空时删除记录（恢复显示文件名）
+func setVideoMeta(rel string, m VideoMeta) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	if m.empty() {
+		delete(metadata, rel)
+	} else {
+		metadata[rel] = m
+	}
+	if err := saveJSON(metadataFile, metadata); err != nil {
+		log.Printf("[信息] 保存失败: %v", err)
+	}
+}
+
+// handleMetadata GET 查询 / POST 修改 / DELETE 清除视频的自定义标题、简介和年份
+//
+//	POST /api/metadata?file=xxx  {"title":"...","description":"...","year":2010}
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var m VideoMeta
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&m); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		m.Title = strings.TrimSpace(m.Title)
+		m.Description = strings.TrimSpace(m.Description)
+		switch {
+		case utf8.RuneCountInString(m.Title) > metaTitleMaxLen || strings.ContainsAny(m.Title, "\r\n"):
+			http.Error(w, "标题过长或包含换行", http.StatusBadRequest)
+			return
+		case utf8.RuneCountInString(m.Description) > metaDescMaxLen:
+			http.Error(w, "简介过长", http.StatusBadRequest)
+			return
+		case m.Year != 0 && (m.Year < metaYearMin || m.Year > metaYearMax):
+			http.Error(w, "无效的年份", http.StatusBadRequest)
+			return
+		}
+		setVideoMeta(file, m)
+	case http.MethodDelete:
+		setVideoMeta(file, VideoMeta{})
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, videoMeta(file))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// partialCheckInterval 检查未完成下载是否已完成的间隔
+	partialCheckInterval = 30 * time.Second
+	// partialStaleAfter 超过该时间没有变化的未完成下载视为下载失败，可在 /api/downloads 清理
+	partialStaleAfter = 7 * 24 * time.Hour
+)
+
+// partialSuffixes 浏览器和下载工具写入中的临时文件后缀（去掉后缀即为最终文件名）
+var partialSuffixes = []string{
+	".part",       // Firefox、Transmission、yt-dlp
+	".partial",    // IE/Edge 旧版
+	".crdownload", // Chrome
+	".download",   // Safari
+	".!qb",        // qBittorrent
+	".!ut",        // uTorrent
+	".bc!",        // BitComet
+}
+
+// PartialDownload 未完成的下载：File 为完成后的视频路径，Artifact 为当前磁盘上的临时文件
+type PartialDownload struct {
+	File     string    `json:"file"`
+	Artifact string    `json:"artifact"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modified"`
+	Stale    bool      `json:"stale"` // 长时间没有变化，可能已下载失败
+}
+
+var (
+	partialMu   sync.Mutex
+	partialRoot string
+	// partialDownloads 最近一次扫描发现的未完成下载，视频相对路径 -> 记录
+	partialDownloads = make(map[string]PartialDownload)
+)
+
+// partialArtifact 文件名是否为下载中的视频临时文件（如 movie.mkv.part），返回完成后的文件名
+func partialArtifact(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	for _, suffix := range partialSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			final := name[:len(name)-len(suffix)]
+			return final, videoExts[strings.ToLower(filepath.Ext(final))]
+		}
+	}
+	return "", false
+}
+
+// partialVideo 视频文件本身是否还没下载完：空文件，或 aria2 的控制文件（.aria2）仍然存在
+func partialVideo(path string, info fs.FileInfo) bool {
+	if info.Size() == 0 {
+		return true
+	}
+	_, err := os.Stat(path + ".aria2")
+	return err == nil
+}
+
+// newPartialDownload 根据临时文件信息构造记录
+func newPartialDownload(file, artifact string, info fs.FileInfo) PartialDownload {
+	return PartialDownload{
+		File:     file,
+		Artifact: artifact,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Stale:    time.Since(info.ModTime()) > partialStaleAfter,
+	}
+}
+
+// setPartialDownloads 用一次完整扫描的结果替换未完成下载列表
+func setPartialDownloads(root string, partials map[string]PartialDownload) {
+	partialMu.Lock()
+	defer partialMu.Unlock()
+	partialRoot = root
+	partialDownloads = partials
+}
+
+// partialComplete 下载是否已完成：临时文件已消失，且最终文件存在、非空、没有 aria2 控制文件
+func partialComplete(root string, p PartialDownload) bool {
+	if p.Artifact != p.File {
+		if _, err := os.Stat(filepath.Join(root, p.Artifact)); err == nil {
+			return false
+		}
+	}
+	path := filepath.Join(root, p.File)
+	info, err := os.Stat(path)
+	return err == nil && !partialVideo(path, info)
+}
+
+// partialGone 临时文件和最终文件都不存在（下载被取消或临时文件被删除）
+func partialGone(root string, p PartialDownload) bool {
+	_, errA := os.Stat(filepath.Join(root, p.Artifact))
+	_, errF := os.Stat(filepath.Join(root, p.File))
+	return errors.Is(errA, fs.ErrNotExist) && errors.Is(errF, fs.ErrNotExist)
+}
+
+// checkPartialDownloads 检查未完成的下载，完成后加入媒体库并通知客户端刷新
+func checkPartialDownloads() {
+	partialMu.Lock()
+	root := partialRoot
+	pending := make([]PartialDownload, 0, len(partialDownloads))
+	for _, p := range partialDownloads {
+		pending = append(pending, p)
+	}
+	partialMu.Unlock()
+
+	changed := false
+	for _, p := range pending {
+		complete := partialComplete(root, p)
+		if !complete && !partialGone(root, p) {
+			continue
+		}
+		partialMu.Lock()
+		delete(partialDownloads, p.File)
+		partialMu.Unlock()
+		if complete {
+			log.Printf("[下载] 已完成，加入媒体库: %s", p.File)
+			changed = true
+		}
+	}
+	if changed {
+		InitFolderStats(root)
+		bus.Publish("library.changed", nil)
+	}
+}
+
+// StartPartialWatch 定期检查扫描时发现的未完成下载
+func StartPartialWatch() {
+	go func() {
+		for range time.Tick(partialCheckInterval) {
+			checkPartialDownloads()
+		}
+	}()
+}
+
+// handleDownloads GET 列出未完成的下载；DELETE ?file=<临时文件> 清理长时间没有变化的失败下载（仅管理员）
+func (s *Server) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "仅限管理员", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		partialMu.Lock()
+		list := make([]PartialDownload, 0, len(partialDownloads))
+		for _, p := range partialDownloads {
+			list = append(list, p)
+		}
+		partialMu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].File < list[j].File })
+		writeJSON(w, list)
+
+	case http.MethodDelete:
+		artifact := r.URL.Query().Get("file")
+		partialMu.Lock()
+		var found *PartialDownload
+		for _, p := range partialDownloads {
+			if p.Artifact == artifact {
+				found = &p
+				break
+			}
+		}
+		partialMu.Unlock()
+		if found == nil || !s.isSafeRelPath(artifact) {
+			http.Error(w, "未找到该未完成的下载", http.StatusNotFound)
+			return
+		}
+		if !found.Stale {
+			http.Error(w, "下载仍可能在进行中，只能清理长时间没有变化的文件", http.StatusConflict)
+			return
+		}
+		path := filepath.Join(s.videoDir, artifact)
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "删除失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		os.Remove(path + ".aria2")
+		partialMu.Lock()
+		delete(partialDownloads, found.File)
+		partialMu.Unlock()
+		log.Printf("[下载] 已清理失败的下载: %s", artifact)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// audioDownmix 总是把音频转为 AAC 立体声（-audio-downmix），即使设备上报能解码 AC-3 / E-AC-3
+var audioDownmix bool
+
+// passthroughCodecs 可以原样输出的环绕声编码 -> 主播放列表 CODECS 中的名称
+var passthroughCodecs = map[string]string{"ac3": "ac-3", "eac3": "ec-3"}
+
+// passthroughAudioBitrate 原样输出时主播放列表中按此估算音频码率（AC-3 / E-AC-3 常见的最高码率）
+const passthroughAudioBitrate = 640000
+
+// audioPassthrough 音轨 audio 能否原样输出，保留 5.1 / 7.1 声道：音轨为 AC-3 / E-AC-3，
+// 设备上报过能解码该编码（/api/capabilities），且没有设置 -audio-downmix
+func audioPassthrough(r *http.Request, filePath string, audio int) bool {
+	if audioDownmix {
+		return false
+	}
+	caps, ok := capabilitiesOf(r)
+	if !ok {
+		return false
+	}
+	codec := passthroughCodec(filePath, audio)
+	return codec != "" && slices.Contains(caps.Audio, codec)
+}
+
+// passthroughCodec 音轨 audio 的编码，不是可以原样输出的编码时返回空
+func passthroughCodec(filePath string, audio int) string {
+	tracks := probeAudioTracks(filePath, audio)
+	if audio >= len(tracks) || passthroughCodecs[tracks[audio].Codec] == "" {
+		return ""
+	}
+	return tracks[audio].Codec
+}
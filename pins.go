@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const pinsFile = "pins.json"
+
+var (
+	// pins 固定缓存的视频：相对路径 -> 固定时间
+	pins     = make(map[string]time.Time)
+	pinsMu   sync.Mutex
+	pinsRoot string // 视频目录，用于把转码缓存的来源路径换算成相对路径
+)
+
+// InitPins 从数据目录加载固定缓存的视频
+func InitPins(root string) error {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pinsRoot = root
+	return loadJSON(pinsFile, &pins)
+}
+
+// videoPinned 视频是否固定了缓存
+func videoPinned(rel string) bool {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	_, ok := pins[rel]
+	return ok
+}
+
+// pinnedSource 转码缓存的来源视频（完整路径）是否固定了缓存
+func pinnedSource(path string) bool {
+	if path == "" {
+		return false
+	}
+	pinsMu.Lock()
+	root := pinsRoot
+	pinsMu.Unlock()
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	return videoPinned(filepath.ToSlash(rel))
+}
+
+// setVideoPinned 固定或取消固定视频的缓存
+func setVideoPinned(rel string, pinned bool) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	if _, ok := pins[rel]; ok == pinned {
+		return
+	}
+	if pinned {
+		pins[rel] = time.Now()
+	} else {
+		delete(pins, rel)
+	}
+	if err := saveJSON(pinsFile, pins); err != nil {
+		log.Printf("[固定] 保存失败: %v", err)
+	}
+}
+
+// handlePin 固定视频的缓存，已完成的转码缓存不会被 -cache-max-size 淘汰：
+//
+//	GET    /api/videos/{id}/pin  查询 {"pinned":true}
+//	PUT    /api/videos/{id}/pin  固定，并在后台生成拖动预览图
+//	DELETE /api/videos/{id}/pin  取消固定
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request, file string) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		setVideoPinned(file, true)
+		if ffmpegReady() {
+			fullPath := filepath.Join(s.videoDir, file)
+			go func() {
+				if secs := durationSeconds(getDuration(fullPath)); secs > 0 {
+					if _, err := ensureSprite(fullPath, secs); err != nil {
+						log.Printf("[固定] 生成 %s 的预览图失败: %v", file, err)
+					}
+				}
+			}()
+		}
+	case http.MethodDelete:
+		setVideoPinned(file, false)
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]bool{"pinned": videoPinned(file)})
+}
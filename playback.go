@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TranscodePolicy 转码策略，决定允许使用哪些 ffmpeg 处理方式
+type TranscodePolicy int
+
+const (
+	PolicyFull      TranscodePolicy = iota // 允许封装转换和重新编码
+	PolicyRemuxOnly                        // 只允许视频 copy 的封装转换（-remux-only）
+	PolicyNone                             // 禁用所有转码，只播放原文件（-no-transcode）
+)
+
+var transcodePolicy = PolicyFull
+
+// PlayMode 播放方式
+type PlayMode int
+
+const (
+	PlayDirect    PlayMode = iota // 浏览器直接播放原文件
+	PlayRemux                     // HLS 封装转换，视频流 copy
+	PlayTranscode                 // HLS 重新编码视频
+	PlayStream                    // 实时封装为 fMP4 直接输出（/remux），视频流 copy，不落盘
+)
+
+// ExtRule 按扩展名指定的处理方式
+type ExtRule int
+
+const (
+	ExtHLS       ExtRule = iota // 走 HLS，H.264 视频 copy，其他编码重新编码
+	ExtDirect                   // 浏览器直接播放原文件
+	ExtTranscode                // 走 HLS 并强制重新编码（即使是 H.264）
+)
+
+// extRules 用户配置的扩展名规则（-ext-rules），优先于设备能力表
+var extRules = map[string]ExtRule{}
+
+// extRuleFor 返回用户为该扩展名配置的处理方式
+func extRuleFor(filePath string) (ExtRule, bool) {
+	rule, ok := extRules[strings.ToLower(filepath.Ext(filePath))]
+	return rule, ok
+}
+
+// canDirectPlay 文件能否在该设备上直接播放，扩展名规则优先。
+// 按探测到的容器、视频编码和第一条音轨的编码判断：H.264/AAC 的 MKV 在支持的浏览器上可以直接播放，
+// HEVC 编码的 MP4、AC-3 音轨的 MP4（直接播放会黑屏或没有声音）不再直接播放；探测失败时按扩展名判断
+func canDirectPlay(filePath string, profile DeviceProfile) bool {
+	if rule, ok := extRuleFor(filePath); ok {
+		return rule == ExtDirect
+	}
+	c, ok := probeCodecs(filePath)
+	if !ok {
+		return profile.canDirect(filepath.Ext(filePath), "")
+	}
+	if !profile.canDirect(c.Container, c.Video) {
+		return false
+	}
+	return c.Audio == "" || profile.canDirectAudio(c.Audio)
+}
+
+// mediaCodecs 判断能否直接播放用到的容器和编码
+type mediaCodecs struct {
+	Container string // 按实际封装格式归一化的扩展名，如 .mp4 / .webm / .mkv，与能力表的键对应
+	Video     string
+	Audio     string // 第一条音轨（直接播放时浏览器使用的音轨），没有音轨时为空
+}
+
+// webmCodecs WebM 规范允许的编码，只包含这些编码的 Matroska 文件按 WebM 判断
+var webmCodecs = map[string]bool{"vp8": true, "vp9": true, "av1": true, "opus": true, "vorbis": true}
+
+// probeCodecs 从完整探测结果（缓存在 thumbs/ 中）取出容器和编码，不依赖文件扩展名
+func probeCodecs(filePath string) (mediaCodecs, bool) {
+	p, err := probeMediaInfo(filePath)
+	if err != nil {
+		return mediaCodecs{}, false
+	}
+	info := buildMediaInfo("", p)
+	var c mediaCodecs
+	if info.Video != nil {
+		c.Video = info.Video.Codec
+	}
+	if len(info.Audio) > 0 {
+		c.Audio = info.Audio[0].Codec
+	}
+	c.Container = normalizeContainer(info.Container, filePath, c)
+	return c, true
+}
+
+// normalizeContainer 把 ffprobe 的 format_name 换成能力表中的扩展名：
+// MP4 家族保留 .m4v / .mov 扩展名，其余按 .mp4；Matroska 只含 WebM 编码时按 .webm，否则按 .mkv；
+// 其他格式（如 mpegts、avi）使用文件扩展名
+func normalizeContainer(formatName, filePath string, c mediaCodecs) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	formats := strings.Split(formatName, ",")
+	switch {
+	case slices.Contains(formats, "mp4") || slices.Contains(formats, "mov"):
+		if ext == ".m4v" || ext == ".mov" {
+			return ext
+		}
+		return ".mp4"
+	case slices.Contains(formats, "matroska"):
+		if webmCodecs[c.Video] && (c.Audio == "" || webmCodecs[c.Audio]) {
+			return ".webm"
+		}
+		return ".mkv"
+	}
+	return ext
+}
+
+// parseExtRules 解析形如 ".webm=transcode,.m2ts=direct" 的扩展名规则
+// 配置中出现的新扩展名同时加入视频扫描列表
+func parseExtRules(spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ext, mode, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("无效的扩展名规则: %s", item)
+		}
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		var rule ExtRule
+		switch strings.TrimSpace(mode) {
+		case "direct":
+			rule = ExtDirect
+		case "hls":
+			rule = ExtHLS
+		case "transcode":
+			rule = ExtTranscode
+		default:
+			return fmt.Errorf("未知的处理方式 %q（可选 direct / hls / transcode）", mode)
+		}
+		extRules[ext] = rule
+		videoExts[ext] = true
+	}
+	return nil
+}
+
+// PlaybackDecision 播放决策结果
+type PlaybackDecision struct {
+	Mode    PlayMode
+	Codec   string // 视频编码（直接播放时不探测，为空）
+	Blocked string // 当前策略下无法播放的原因，为空表示可以播放
+}
+
+// decidePlayback 根据设备能力、文件格式、视频编码和转码策略决定播放方式
+// audio > 0 表示选择了非默认音轨，原文件直接播放无法切换音轨，需走 HLS；hevc 表示客户端能解码 HEVC
+func decidePlayback(filePath string, audio int, profile DeviceProfile, hevc bool) PlaybackDecision {
+	if canDirectPlay(filePath, profile) && audio == 0 {
+		// moov 在尾部的大 MP4 也直接提供：优先使用 faststart 重新封装后的缓存，未就绪时浏览器通过 Range 读取原文件
+		return PlaybackDecision{Mode: PlayDirect}
+	}
+	// 视频编码兼容（如 H.264 MKV、选择了其他音轨的 MP4）时实时封装转换，无需等待 HLS 切片
+	if d, ok := streamDecision(filePath, profile); ok {
+		return d
+	}
+	return hlsDecision(filePath, hevc)
+}
+
+// hlsDecision 需要走 HLS 时，决定视频 copy 还是重新编码
+// 客户端能解码 HEVC 时（hevc），HEVC 源可直接 copy 到 fMP4 分片，无需重新编码
+func hlsDecision(filePath string, hevc bool) PlaybackDecision {
+	if transcodePolicy == PolicyNone {
+		return PlaybackDecision{Mode: PlayTranscode, Blocked: "需要转码，当前已禁用"}
+	}
+
+	codec := cachedVideoCodec(filePath)
+	if rule, _ := extRuleFor(filePath); (canBrowserPlayCodec(codec) || hevc && codec == "hevc") && rule != ExtTranscode {
+		return PlaybackDecision{Mode: PlayRemux, Codec: codec}
+	}
+	d := PlaybackDecision{Mode: PlayTranscode, Codec: codec}
+	if transcodePolicy == PolicyRemuxOnly {
+		d.Blocked = "需要重新编码，当前仅允许封装转换"
+		if codec != "" {
+			d.Blocked = fmt.Sprintf("%s 编码需要重新编码，当前仅允许封装转换", strings.ToUpper(codec))
+		}
+	}
+	return d
+}
+
+// preciseDecision 精确定位模式：可以 copy 的视频也重新编码，以缩短关键帧间隔
+func preciseDecision(d PlaybackDecision) PlaybackDecision {
+	return forceTranscode(d, "精确定位")
+}
+
+// burnDecision 烧录字幕模式总是重新编码
+func burnDecision(d PlaybackDecision) PlaybackDecision {
+	return forceTranscode(d, "烧录字幕")
+}
+
+// flatDecision 3D / 全景视频转为 2D 时总是重新编码
+func flatDecision(d PlaybackDecision) PlaybackDecision {
+	return forceTranscode(d, "转为 2D 画面")
+}
+
+// forceTranscode 改为重新编码，仅允许封装转换时阻止播放
+func forceTranscode(d PlaybackDecision, what string) PlaybackDecision {
+	if d.Blocked != "" {
+		return d
+	}
+	d.Mode = PlayTranscode
+	if transcodePolicy == PolicyRemuxOnly {
+		d.Blocked = what + "需要重新编码，当前仅允许封装转换"
+	}
+	return d
+}
+
+// playbackBlockReason 列表中显示的无法播放原因，为空表示可以播放或还不确定。
+// 扫描媒体库时调用，只使用已缓存的探测结果，不等待 ffprobe；没有缓存时留空，打开播放页时再按探测结果决定
+func playbackBlockReason(filePath string) string {
+	// 全功能模式下所有格式都可以播放，无需探测编码
+	if transcodePolicy == PolicyFull {
+		return ""
+	}
+	if _, ok := cachedMediaInfo(filePath); !ok {
+		return ""
+	}
+	return decidePlayback(filePath, 0, lookupProfile("default"), false).Blocked
+}
+
+// policyNotice 首页展示的转码策略说明
+func policyNotice() string {
+	switch transcodePolicy {
+	case PolicyNone:
+		return "安全模式：已禁用转码，只能播放浏览器原生支持的文件"
+	case PolicyRemuxOnly:
+		return "仅封装模式：H.264 视频可转封装播放，其他编码需要重新编码，当前无法播放"
+	}
+	return ""
+}
+
+// cachedVideoCodec 获取视频编码，优先读缓存（列表页每个文件都要判断，避免重复 ffprobe），
+// 已有完整探测结果时从中取出
+func cachedVideoCodec(videoPath string) string {
+	cached := codecCachePath(videoPath)
+	if data, err := os.ReadFile(cached); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	if p, ok := cachedMediaInfo(videoPath); ok {
+		if info := buildMediaInfo("", p); info.Video != nil {
+			return info.Video.Codec
+		}
+		return ""
+	}
+	v, _, _ := probeGroup.Do(cached, func() (any, error) {
+		codec := probeVideoCodec(videoPath)
+		if codec != "" {
+			os.MkdirAll(filepath.Dir(cached), 0755)
+			writeFileAtomic(cached, []byte(codec))
+		}
+		return codec, nil
+	})
+	return v.(string)
+}
+
+func codecCachePath(videoPath string) string {
+	info, _ := os.Stat(videoPath)
+	var mtime int64
+	if info != nil {
+		mtime = info.ModTime().UnixNano()
+	}
+	h := md5.Sum([]byte(fmt.Sprintf("%s|%d", videoPath, mtime)))
+	return filepath.Join(thumbCacheDir, fmt.Sprintf("%x.codec", h[:8]))
+}
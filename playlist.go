@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	transcodeVideoBitrate = 4000000 // 重新编码的视频码率（与 -b:v 4M 对应）
+	hevcVideoBitrate      = 2000000 // HEVC 同等画质约为 H.264 的一半（与 -b:v 2M 对应）
+	hlsAudioBitrate       = 128000  // 音频统一 AAC 128k
+	hlsAudioCodec         = "mp4a.40.2"
+)
+
+// hlsContentTypes HLS 相关文件的 MIME 类型，Safari/AVPlayer 对此较为严格
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4", // fMP4 初始化分片
+	".mpd":  "application/dash+xml",
+	".vtt":  "text/vtt; charset=utf-8",
+}
+
+// avcProfileIDs ffprobe profile 名称 -> H.264 profile_idc 与约束标志
+var avcProfileIDs = map[string]string{
+	"Constrained Baseline":  "42e0",
+	"Baseline":              "4200",
+	"Main":                  "4d00",
+	"Extended":              "5800",
+	"High":                  "6400",
+	"High 10":               "6e00",
+	"High 4:2:2":            "7a00",
+	"High 4:4:4 Predictive": "f400",
+}
+
+// avcCodecString 生成 RFC 6381 编码字符串，如 High@4.1 -> avc1.640029
+func avcCodecString(profile string, level int) string {
+	id, ok := avcProfileIDs[profile]
+	if !ok {
+		id = "6400"
+	}
+	if level <= 0 {
+		level = 41
+	}
+	return fmt.Sprintf("avc1.%s%02x", id, level)
+}
+
+// hevcCodecString 生成 HEVC Main profile 的编码字符串，level 沿用 H.264 的表示（41 表示 4.1）
+// HEVC 的 general_level_idc 为 level × 30，如 4.1 -> hvc1.1.6.L123.B0
+func hevcCodecString(level int) string {
+	if level <= 0 {
+		level = 41
+	}
+	return fmt.Sprintf("hvc1.1.6.L%d.B0", level*3)
+}
+
+// hevcSourceCodecString 生成 HEVC 源视频（copy）的编码字符串，level 为 ffprobe 报告的 general_level_idc
+func hevcSourceCodecString(profile string, level int) string {
+	if level <= 0 {
+		level = 123
+	}
+	if profile == "Main 10" {
+		return fmt.Sprintf("hvc1.2.4.L%d.B0", level)
+	}
+	return fmt.Sprintf("hvc1.1.6.L%d.B0", level)
+}
+
+// transcodeLevel 根据输出分辨率和帧率选择 H.264 level（level_idc，如 41 表示 4.1）
+func transcodeLevel(width, height int, fps float64) int {
+	pixels := width * height
+	switch {
+	case pixels <= 1280*720:
+		if fps > 30 {
+			return 32
+		}
+		return 31
+	case pixels <= 1920*1088:
+		if fps > 30 {
+			return 42
+		}
+		return 41
+	default:
+		return 51
+	}
+}
+
+// parseFrameRate 解析 ffprobe 的 "24000/1001" 形式帧率
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	n, _ := strconv.ParseFloat(num, 64)
+	d, _ := strconv.ParseFloat(den, 64)
+	if d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// writeMasterPlaylist 写入带 CODECS/RESOLUTION/FRAME-RATE 属性的主播放列表
+// 部分播放器（Safari、AVPlayer）在缺少这些属性时会拒绝播放或选错解码器；passthrough 为原样输出的音频编码，为空表示 AAC
+func writeMasterPlaylist(dir string, video StreamInfo, transcode, hevc, fmp4 bool, level int, avcProfile, passthrough string) error {
+	fps := parseFrameRate(video.FrameRate)
+
+	var codecs string
+	var bandwidth int
+	switch {
+	case transcode && hevc:
+		codecs = hevcCodecString(level)
+		bandwidth = hevcVideoBitrate
+	case transcode:
+		codecs = avcCodecString(avcProfile, level)
+		bandwidth = transcodeVideoBitrate
+	default:
+		if hevc {
+			codecs = hevcSourceCodecString(video.Profile, video.Level)
+		} else {
+			codecs = avcCodecString(video.Profile, video.Level)
+		}
+		bandwidth, _ = strconv.Atoi(video.BitRate)
+		if bandwidth <= 0 {
+			bandwidth = transcodeVideoBitrate
+		}
+	}
+	audioCodec, audioBitrate := hlsAudioCodec, hlsAudioBitrate
+	if c, ok := passthroughCodecs[passthrough]; ok {
+		audioCodec, audioBitrate = c, passthroughAudioBitrate
+	}
+	bandwidth += audioBitrate
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if fmp4 {
+		b.WriteString("#EXT-X-VERSION:7\n") // fMP4 分片（EXT-X-MAP）
+	} else {
+		b.WriteString("#EXT-X-VERSION:3\n")
+	}
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	// BANDWIDTH 为峰值码率，按平均码率的 1.5 倍估算
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s,%s\"",
+		bandwidth*3/2, bandwidth, codecs, audioCodec)
+	if video.Width > 0 && video.Height > 0 {
+		fmt.Fprintf(&b, ",RESOLUTION=%dx%d", video.Width, video.Height)
+	}
+	if fps > 0 {
+		fmt.Fprintf(&b, ",FRAME-RATE=%.3f", fps)
+	}
+	b.WriteString("\nstream.m3u8\n")
+
+	return os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(b.String()), 0644)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const playlistsFile = "playlists.json"
+
+// Playlist 播放列表，按顺序连续播放其中的视频
+type Playlist struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Items   []string  `json:"items"` // 视频相对路径，可以重复
+	Updated time.Time `json:"updated"`
+}
+
+var (
+	playlists   []Playlist
+	playlistsMu sync.Mutex
+)
+
+// InitPlaylists 从数据目录加载播放列表
+func InitPlaylists() error {
+	playlistsMu.Lock()
+	defer playlistsMu.Unlock()
+	return loadJSON(playlistsFile, &playlists)
+}
+
+// listPlaylists 返回所有播放列表
+func listPlaylists() []Playlist {
+	playlistsMu.Lock()
+	defer playlistsMu.Unlock()
+	return append([]Playlist(nil), playlists...)
+}
+
+// visiblePlaylists 所有播放列表，只保留请求者能访问的视频（账号限制的文件夹、私密文件夹、儿童模式）
+func visiblePlaylists(r *http.Request) []Playlist {
+	list := listPlaylists()
+	v := viewerOf(r)
+	if !v.restricted() {
+		return list
+	}
+	for i, p := range list {
+		items := []string{}
+		for _, item := range p.Items {
+			if v.canAccess(item) {
+				items = append(items, item)
+			}
+		}
+		list[i].Items = items
+	}
+	return list
+}
+
+// findPlaylist 按 ID 查找播放列表
+func findPlaylist(id string) (Playlist, bool) {
+	for _, p := range listPlaylists() {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Playlist{}, false
+}
+
+// savePlaylistsLocked 保存播放列表（调用方持有 playlistsMu）
+func savePlaylistsLocked() {
+	if err := saveJSON(playlistsFile, playlists); err != nil {
+		log.Printf("[播放列表] 保存失败: %v", err)
+	}
+}
+
+// playlistRequest POST / PUT /api/playlists 的请求体
+type playlistRequest struct {
+	Name  *string  `json:"name"`
+	Items []string `json:"items"`
+}
+
+// validItems 校验视频路径，有无效路径时返回 false
+func (s *Server) validItems(items []string) bool {
+	for _, item := range items {
+		if !s.isValidPath(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// handlePlaylists 播放列表的增删改查：
+//
+//	GET    /api/playlists           所有播放列表，?id= 返回单个
+//	POST   /api/playlists           新建，请求体 {"name": "...", "items": [...]}
+//	POST   /api/playlists?id=       把 items 追加到末尾
+//	PUT    /api/playlists?id=       修改名称和（或）整体替换 items，用于删除和调整顺序
+//	DELETE /api/playlists?id=       删除
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if r.Method == http.MethodGet {
+		list := visiblePlaylists(r)
+		if id == "" {
+			writeJSON(w, list)
+			return
+		}
+		for _, p := range list {
+			if p.ID == id {
+				writeJSON(w, p)
+				return
+			}
+		}
+		http.Error(w, "播放列表不存在", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		playlistsMu.Lock()
+		for i, p := range playlists {
+			if p.ID == id {
+				playlists = append(playlists[:i], playlists[i+1:]...)
+				break
+			}
+		}
+		savePlaylistsLocked()
+		playlistsMu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req playlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求", http.StatusBadRequest)
+		return
+	}
+	var name string
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" || utf8.RuneCountInString(name) > 64 {
+			http.Error(w, "名称不能为空，最多 64 个字", http.StatusBadRequest)
+			return
+		}
+	}
+	if !s.validItems(req.Items) {
+		http.Error(w, "无效的文件路径", http.StatusBadRequest)
+		return
+	}
+
+	playlistsMu.Lock()
+	defer playlistsMu.Unlock()
+	if id == "" {
+		if r.Method == http.MethodPut || name == "" {
+			http.Error(w, "名称不能为空", http.StatusBadRequest)
+			return
+		}
+		buf := make([]byte, 4)
+		rand.Read(buf)
+		p := Playlist{ID: hex.EncodeToString(buf), Name: name, Items: append([]string{}, req.Items...), Updated: time.Now()}
+		playlists = append(playlists, p)
+		savePlaylistsLocked()
+		writeJSON(w, p)
+		return
+	}
+	for i := range playlists {
+		p := &playlists[i]
+		if p.ID != id {
+			continue
+		}
+		if name != "" {
+			p.Name = name
+		}
+		if r.Method == http.MethodPost {
+			p.Items = append(p.Items, req.Items...)
+		} else if req.Items != nil {
+			p.Items = append([]string{}, req.Items...)
+		}
+		p.Updated = time.Now()
+		savePlaylistsLocked()
+		writeJSON(w, p)
+		return
+	}
+	http.Error(w, "播放列表不存在", http.StatusNotFound)
+}
+
+// PlaylistView 播放页的播放列表模式：当前播放的是列表中的第 Index 项
+type PlaylistView struct {
+	ID    string
+	Name  string
+	Index int
+	Items []VideoFile // 列表中的视频，已不存在的文件跳过
+	Queue bool        // 随机播放队列（?queue=），不是保存的播放列表
+}
+
+// playlistView 播放页的 ?playlist=ID&i=N 或 ?queue=ID&i=N；同一视频在列表中出现多次时 i 指明是第几项，
+// 缺省为该视频第一次出现的位置
+func playlistView(r *http.Request, file string, videos []VideoFile) *PlaylistView {
+	var view *PlaylistView
+	var items []string
+	if p, ok := findPlaylist(r.URL.Query().Get("playlist")); ok {
+		view, items = &PlaylistView{ID: p.ID, Name: p.Name}, p.Items
+	} else if id := r.URL.Query().Get("queue"); id != "" {
+		if items = findQueue(id); items == nil {
+			return nil
+		}
+		view = &PlaylistView{ID: id, Name: "随机播放", Queue: true}
+	} else {
+		return nil
+	}
+	byPath := make(map[string]VideoFile, len(videos))
+	for _, v := range videos {
+		byPath[v.RelPath] = v
+	}
+	view.Index = -1
+	want, err := strconv.Atoi(r.URL.Query().Get("i"))
+	for _, item := range items {
+		v, ok := byPath[item]
+		if !ok {
+			continue
+		}
+		if item == file && (view.Index < 0 || err == nil && len(view.Items) == want) {
+			view.Index = len(view.Items)
+		}
+		view.Items = append(view.Items, v)
+	}
+	if view.Index < 0 {
+		return nil
+	}
+	return view
+}
+
+// ItemURL 播放列表中第 i 项的播放地址
+func (p *PlaylistView) ItemURL(i int) string {
+	key := "playlist"
+	if p.Queue {
+		key = "queue"
+	}
+	return "/play?" + url.Values{
+		"file": {p.Items[i].RelPath},
+		key:    {p.ID},
+		"i":    {strconv.Itoa(i)},
+	}.Encode()
+}
+
+// Next 下一项的序号，已是最后一项时返回 -1
+func (p *PlaylistView) Next() int {
+	if p.Index+1 < len(p.Items) {
+		return p.Index + 1
+	}
+	return -1
+}
+
+// handlePlaylistStart /play?playlist=ID 没有指定视频时从第一个存在的视频开始播放
+func (s *Server) handlePlaylistStart(w http.ResponseWriter, r *http.Request) bool {
+	id := r.URL.Query().Get("playlist")
+	if id == "" || r.URL.Query().Get("file") != "" {
+		return false
+	}
+	p, _ := findPlaylist(id)
+	v := viewerOf(r)
+	for _, item := range p.Items {
+		if !v.canAccess(item) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.videoDir, item)); err == nil {
+			http.Redirect(w, r, "/play?"+url.Values{"file": {item}, "playlist": {id}, "i": {"0"}}.Encode(), http.StatusFound)
+			return true
+		}
+	}
+	http.Error(w, "播放列表不存在或为空", http.StatusNotFound)
+	return true
+}
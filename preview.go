@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 首页悬停预览：从视频不同位置截取几段短片拼成无声 MP4 循环播放
+const (
+	previewSamples   = 3
+	previewSampleLen = 2 // 每段秒数
+	previewWidth     = 320
+)
+
+var (
+	// previewsEnabled 是否启用悬停预览（-previews），生成需要重新编码，默认关闭
+	previewsEnabled bool
+	previewCacheDir string
+)
+
+// InitPreviewCache 初始化预览短片缓存目录
+func InitPreviewCache() error {
+	previewCacheDir = filepath.Join(cacheRoot, "previews")
+	return os.MkdirAll(previewCacheDir, 0755)
+}
+
+// previewPath 预览短片缓存路径，与封面使用相同的缓存 key
+func previewPath(videoPath string) string {
+	return filepath.Join(previewCacheDir, mediaCacheKey(videoPath)+".mp4")
+}
+
+// previewOffsets 截取位置：均匀分布在视频的 1/4、1/2、3/4 处；视频太短时从头截取一段
+func previewOffsets(secs int) []int {
+	if secs < previewSamples*previewSampleLen*2 {
+		return []int{0}
+	}
+	offsets := make([]int, previewSamples)
+	for i := range offsets {
+		offsets[i] = secs * (i + 1) / (previewSamples + 1)
+	}
+	return offsets
+}
+
+// ensurePreview 确保预览短片已生成，同一视频的并发请求只生成一次
+func ensurePreview(videoPath string) (string, error) {
+	cached := previewPath(videoPath)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	_, err, _ := probeGroup.Do(cached, func() (any, error) {
+		if _, err := os.Stat(cached); err == nil {
+			return nil, nil
+		}
+		return nil, generatePreview(videoPath, cached)
+	})
+	return cached, err
+}
+
+// generatePreview 每段作为一个输入（-ss 快速定位），缩放后用 concat 拼接，先写临时文件再重命名
+func generatePreview(videoPath, cachePath string) error {
+	secs := durationSeconds(getDuration(videoPath))
+	if secs <= 0 {
+		return fmt.Errorf("无法获取视频时长")
+	}
+	offsets := previewOffsets(secs)
+	sampleLen := previewSampleLen
+	if len(offsets) == 1 {
+		sampleLen = min(secs, previewSamples*previewSampleLen)
+	}
+
+	var args []string
+	var filter, inputs strings.Builder
+	for i, off := range offsets {
+		args = append(args, "-ss", strconv.Itoa(off), "-t", strconv.Itoa(sampleLen), "-i", videoPath)
+		fmt.Fprintf(&filter, "[%d:v]scale=%d:-2,setsar=1,fps=24[v%d];", i, previewWidth, i)
+		fmt.Fprintf(&inputs, "[v%d]", i)
+	}
+	fmt.Fprintf(&filter, "%sconcat=n=%d:v=1:a=0[out]", inputs.String(), len(offsets))
+
+	outPath := strings.TrimSuffix(cachePath, ".mp4") + ".tmp.mp4"
+	defer os.Remove(outPath)
+	args = append(args,
+		"-filter_complex", filter.String(), "-map", "[out]", "-an",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "30", "-pix_fmt", "yuv420p",
+		"-movflags", "+faststart", "-y", outPath)
+	out, err := exec.Command(ffmpegPath(), args...).CombinedOutput()
+	if err == nil {
+		if info, statErr := os.Stat(outPath); statErr == nil && info.Size() > 0 {
+			return os.Rename(outPath, cachePath)
+		}
+		err = fmt.Errorf("ffmpeg 未输出视频")
+	}
+	log.Printf("[预览] 生成失败 %s: %v\n%s", filepath.Base(videoPath), err, string(out))
+	return err
+}
+
+// handlePreview 提供悬停预览短片 /preview?file=xxx
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if !previewsEnabled {
+		http.Error(w, "未启用悬停预览（-previews）", http.StatusNotFound)
+		return
+	}
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+	fullPath := filepath.Join(s.videoDir, file)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusNotFound)
+		return
+	}
+	if !ffmpegReady() {
+		http.Error(w, "ffmpeg 未就绪", http.StatusServiceUnavailable)
+		return
+	}
+	cached, err := ensurePreview(fullPath)
+	if err != nil {
+		http.Error(w, "预览生成失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	serveCachedFile(w, r, cached)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unlockCookie = "lc_unlock"
+	// unlockMaxAge 输入 PIN 解锁私密文件夹后多久内有效
+	unlockMaxAge = 4 * time.Hour
+)
+
+// privateFolders 私密文件夹（-private-folders）：相对视频目录的路径（/ 分隔）-> PIN，
+// PIN 为空表示只有管理员能访问。启动时设置后只读
+var privateFolders = make(map[string]string)
+
+// parsePrivateFolders 解析 -private-folders，如 private,Adult=1234：
+// 只写文件夹表示只有管理员能访问，folder=PIN 表示输入 PIN 后也能访问
+func parsePrivateFolders(spec string) (map[string]string, error) {
+	folders := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		dir, pin, _ := strings.Cut(item, "=")
+		dir = strings.Trim(filepath.ToSlash(filepath.Clean(strings.TrimSpace(dir))), "/")
+		if dir == "" || dir == "." || !filepath.IsLocal(dir) {
+			return nil, fmt.Errorf("无效的文件夹: %q", item)
+		}
+		folders[dir] = strings.TrimSpace(pin)
+	}
+	return folders, nil
+}
+
+// privateFoldersOf rel 所在的私密文件夹（可能有多层），不在私密文件夹中时返回空
+func privateFoldersOf(rel string) []string {
+	if len(privateFolders) == 0 {
+		return nil
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	var dirs []string
+	for dir := range privateFolders {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// unlockSig 解锁 cookie 的签名，包含各文件夹当前的 PIN，修改 PIN 后已有的解锁失效
+func unlockSig(exp string, dirs []string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("unlock|" + exp))
+	for _, dir := range dirs {
+		mac.Write([]byte("|" + dir + "=" + privateFolders[dir]))
+	}
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// unlockedFolders 请求的 cookie 中已用 PIN 解锁的私密文件夹
+func unlockedFolders(r *http.Request) map[string]bool {
+	c, err := r.Cookie(unlockCookie)
+	if err != nil {
+		return nil
+	}
+	parts := strings.SplitN(c.Value, ".", 3)
+	if len(parts) != 3 {
+		return nil
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > ts {
+		return nil
+	}
+	joined, err := url.QueryUnescape(parts[2])
+	if err != nil {
+		return nil
+	}
+	dirs := strings.Split(joined, "|")
+	if !hmac.Equal([]byte(parts[1]), []byte(unlockSig(parts[0], dirs))) {
+		return nil
+	}
+	unlocked := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		if pin, ok := privateFolders[dir]; ok && pin != "" {
+			unlocked[dir] = true
+		}
+	}
+	return unlocked
+}
+
+// setUnlockCookie 记录解锁的私密文件夹（与 cookie 中仍有效的合并）
+func setUnlockCookie(w http.ResponseWriter, r *http.Request, dir string) {
+	set := unlockedFolders(r)
+	if set == nil {
+		set = make(map[string]bool)
+	}
+	set[dir] = true
+	dirs := make([]string, 0, len(set))
+	for d := range set {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	expires := time.Now().Add(unlockMaxAge)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookie,
+		Value:    exp + "." + unlockSig(exp, dirs) + "." + url.QueryEscape(strings.Join(dirs, "|")),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// viewer 请求者能访问的范围：账号限制的文件夹（-user-folders）、私密文件夹（-private-folders）和儿童模式
+type viewer struct {
+	user     string
+	admin    bool
+	unlocked map[string]bool
+	kids     bool
+}
+
+// viewerOf 请求者的访问范围
+func viewerOf(r *http.Request) viewer {
+	v := viewer{user: requestUser(r), kids: kidsMode(r)}
+	if len(privateFolders) > 0 {
+		v.admin = isAdminRequest(r)
+		v.unlocked = unlockedFolders(r)
+	}
+	return v
+}
+
+// restricted 是否有不能访问的视频
+func (v viewer) restricted() bool {
+	return len(userFolders(v.user)) > 0 || len(privateFolders) > 0 && !v.admin || v.kids
+}
+
+// lockedFolder rel 所在的、当前请求不能访问的私密文件夹，能访问时返回空
+func (v viewer) lockedFolder(rel string) string {
+	if v.admin {
+		return ""
+	}
+	for _, dir := range privateFoldersOf(rel) {
+		if !v.unlocked[dir] {
+			return dir
+		}
+	}
+	return ""
+}
+
+// canAccess 能否访问文件或文件夹 rel
+func (v viewer) canAccess(rel string) bool {
+	return canAccess(v.user, rel) && v.lockedFolder(rel) == "" && (!v.kids || kidsAllowed(rel))
+}
+
+// canBrowse 能否进入文件夹 dir
+func (v viewer) canBrowse(dir string) bool {
+	return canBrowse(v.user, dir) && v.lockedFolder(dir) == "" && (!v.kids || kidsBrowsable(dir))
+}
+
+// handleUnlock GET 显示输入 PIN 的页面，POST 校验 PIN 后解锁私密文件夹：/unlock?folder=&next=
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	dir := strings.Trim(filepath.ToSlash(filepath.Clean(r.FormValue("folder"))), "/")
+	pin, ok := privateFolders[dir]
+	if !ok || pin == "" {
+		http.Error(w, "文件夹不存在或不能用 PIN 解锁", http.StatusNotFound)
+		return
+	}
+	next := safeNext(r.FormValue("next"))
+	if r.FormValue("next") == "" {
+		next = "/?path=" + url.QueryEscape(dir)
+	}
+	data := struct {
+		Folder string
+		Next   string
+		Error  string
+	}{Folder: dir, Next: next}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if rejectThrottled(w, r) {
+			return
+		}
+		if secretEqual(r.FormValue("pin"), pin) {
+			setUnlockCookie(w, r, dir)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		logWarnf("[私密文件夹] %s PIN 错误 <- %s", dir, r.RemoteAddr)
+		recordAuthFailure(r)
+		w.WriteHeader(http.StatusUnauthorized)
+		data.Error = "PIN 错误"
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "unlock.html", data); err != nil {
+		logErrorf("模板渲染错误: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// probeGroup 合并同一文件的并发探测和生成任务，N 个并发请求只启动一个 ffprobe/ffmpeg
+var probeGroup singleflight.Group
+
+// StreamInfo ffprobe 输出的单条流信息
+type StreamInfo struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Profile   string `json:"profile"`
+	Level     int    `json:"level"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	FrameRate string `json:"avg_frame_rate"` // 如 "24000/1001"
+	BitRate   string `json:"bit_rate"`
+	Channels  int    `json:"channels"`
+	Tags      struct {
+		Language string `json:"language"`
+		Title    string `json:"title"`
+	} `json:"tags"`
+}
+
+// probeStreams 列出指定类型的流（"v" 视频、"a" 音频、"s" 字幕），顺序与 0:s:N 中的 N 一致
+func probeStreams(filePath, streamType string) ([]StreamInfo, error) {
+	v, err, _ := probeGroup.Do("streams|"+streamType+"|"+filePath, func() (any, error) {
+		return runProbeStreams(filePath, streamType)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]StreamInfo), nil
+}
+
+func runProbeStreams(filePath, streamType string) ([]StreamInfo, error) {
+	cmd := exec.Command(ffprobePath(),
+		"-v", "quiet",
+		"-select_streams", streamType,
+		"-show_entries", "stream=index,codec_type,codec_name,profile,level,width,height,avg_frame_rate,bit_rate,channels:stream_tags=language,title",
+		"-print_format", "json",
+		filePath,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Streams []StreamInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, err
+	}
+	return result.Streams, nil
+}
+
+// probeVideoStream 探测第一条视频流
+func probeVideoStream(filePath string) (StreamInfo, error) {
+	streams, err := probeStreams(filePath, "v:0")
+	if err != nil {
+		return StreamInfo{}, err
+	}
+	if len(streams) == 0 {
+		return StreamInfo{}, fmt.Errorf("没有视频流")
+	}
+	return streams[0], nil
+}
+
+// AudioTrack 可选择的音轨
+type AudioTrack struct {
+	Index    int    // 音频流序号（对应 -map 0:a:N 中的 N）
+	Lang     string // 语言代码，如 chi / eng
+	Label    string // 显示名称
+	Codec    string
+	Channels int
+	Selected bool
+}
+
+// probeAudioTracks 列出视频中的所有音轨，selected 为当前选择的序号
+func probeAudioTracks(filePath string, selected int) []AudioTrack {
+	streams, err := probeStreams(filePath, "a")
+	if err != nil {
+		return nil
+	}
+
+	tracks := make([]AudioTrack, 0, len(streams))
+	for i, st := range streams {
+		label := st.Tags.Title
+		if label == "" {
+			label = st.Tags.Language
+		}
+		if label == "" {
+			label = fmt.Sprintf("音轨 %d", i+1)
+		}
+		if st.Channels > 2 {
+			label = fmt.Sprintf("%s (%d 声道)", label, st.Channels)
+		}
+		tracks = append(tracks, AudioTrack{
+			Index:    i,
+			Lang:     st.Tags.Language,
+			Label:    label,
+			Codec:    st.CodecName,
+			Channels: st.Channels,
+			Selected: i == selected,
+		})
+	}
+	return tracks
+}
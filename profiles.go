@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DeviceProfile 某类客户端可直接播放的 容器+编码 组合
+type DeviceProfile struct {
+	// Direct 可直接播放的容器（扩展名）-> 支持的视频编码
+	Direct map[string][]string `json:"direct"`
+	// AudioLangs / SubtitleLangs 优先选择的音轨和字幕语言（如 ["zh", "en"]），为空时使用 -audio-lang / -subtitle-lang
+	AudioLangs    []string `json:"audio_langs,omitempty"`
+	SubtitleLangs []string `json:"subtitle_langs,omitempty"`
+	// Audio 可直接播放的音频编码，为空时使用能力表的 direct_audio
+	Audio []string `json:"audio,omitempty"`
+	// Stream 可实时封装为 fMP4 播放的视频编码，为空时只有 H.264
+	Stream []string `json:"stream,omitempty"`
+	// Reported 来自客户端上报的解码能力（/api/capabilities）
+	Reported bool `json:"-"`
+}
+
+// PlaybackTable 播放能力表，可通过 -playback-table 指定 JSON 文件覆盖
+type PlaybackTable struct {
+	// HLSCopy 可直接 copy 到 HLS（MPEG-TS 分片）的视频编码，其余编码需重新编码
+	HLSCopy []string `json:"hls_copy"`
+	// DirectAudio 设备类型没有配置 audio 时，直接播放允许的音频编码
+	DirectAudio []string `json:"direct_audio"`
+	// Profiles 设备类型 -> 能力，"default" 用于列表页和无法识别的客户端
+	Profiles map[string]DeviceProfile `json:"profiles"`
+}
+
+// playbackTable 默认能力表，只列出各浏览器稳定支持的组合
+var playbackTable = PlaybackTable{
+	HLSCopy:     []string{"h264"},
+	DirectAudio: []string{"aac", "mp3", "opus", "vorbis", "flac"},
+	Profiles: map[string]DeviceProfile{
+		"default": {Direct: map[string][]string{
+			".mp4": {"h264"},
+			".m4v": {"h264"},
+		}},
+		"safari": {Direct: map[string][]string{
+			".mp4": {"h264", "hevc"},
+			".m4v": {"h264", "hevc"},
+			".mov": {"h264", "hevc"},
+		}},
+		"chrome": {Direct: map[string][]string{
+			".mp4":  {"h264", "vp9", "av1"},
+			".m4v":  {"h264", "vp9", "av1"},
+			".webm": {"vp8", "vp9", "av1"},
+			".mkv":  {"h264", "vp8", "vp9", "av1"},
+		}},
+		"firefox": {Direct: map[string][]string{
+			".mp4":  {"h264", "vp9", "av1"},
+			".m4v":  {"h264", "vp9", "av1"},
+			".webm": {"vp8", "vp9", "av1"},
+		}},
+	},
+}
+
+// LoadPlaybackTable 从 JSON 文件加载能力表，文件中出现的项覆盖默认值
+func LoadPlaybackTable(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var t PlaybackTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", filepath.Base(path), err)
+	}
+	if len(t.HLSCopy) > 0 {
+		playbackTable.HLSCopy = t.HLSCopy
+	}
+	if len(t.DirectAudio) > 0 {
+		playbackTable.DirectAudio = t.DirectAudio
+	}
+	for name, p := range t.Profiles {
+		playbackTable.Profiles[name] = p
+	}
+	return nil
+}
+
+// profileNameFor 根据 User-Agent 识别客户端类型
+func profileNameFor(r *http.Request) string {
+	ua := r.UserAgent()
+	switch {
+	case strings.Contains(ua, "Firefox/"):
+		return "firefox"
+	case strings.Contains(ua, "Chrome/") || strings.Contains(ua, "Chromium/") || strings.Contains(ua, "Edg/"):
+		return "chrome"
+	case strings.Contains(ua, "Safari/") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad"):
+		return "safari"
+	}
+	return "default"
+}
+
+// profileFor 返回请求对应的设备能力：设备上报过解码能力时以上报的为准，
+// 否则按 User-Agent 识别的类型查能力表，未配置的类型回退到 default
+func profileFor(r *http.Request) DeviceProfile {
+	p := lookupProfile(profileNameFor(r))
+	if caps, ok := capabilitiesOf(r); ok {
+		p = caps.apply(p)
+	}
+	return p
+}
+
+func lookupProfile(name string) DeviceProfile {
+	if p, ok := playbackTable.Profiles[name]; ok {
+		return p
+	}
+	return playbackTable.Profiles["default"]
+}
+
+// canDirect 容器是否可直接播放；codec 为空表示未探测，仅按容器判断
+func (p DeviceProfile) canDirect(ext, codec string) bool {
+	codecs, ok := p.Direct[strings.ToLower(ext)]
+	if !ok {
+		return false
+	}
+	return codec == "" || slices.Contains(codecs, codec)
+}
+
+// canDirectAudio 音频编码能否随原文件直接播放
+func (p DeviceProfile) canDirectAudio(codec string) bool {
+	if len(p.Audio) > 0 {
+		return slices.Contains(p.Audio, codec)
+	}
+	return slices.Contains(playbackTable.DirectAudio, codec)
+}
+
+// streamCodecs 可实时封装为 fMP4 播放的视频编码
+func (p DeviceProfile) streamCodecs() []string {
+	if p.Reported || len(p.Stream) > 0 {
+		return p.Stream
+	}
+	return []string{"h264"}
+}
+
+// canBrowserPlayCodec 视频编码能否直接 copy 到 HLS 分片
+func canBrowserPlayCodec(codec string) bool {
+	return slices.Contains(playbackTable.HLSCopy, codec)
+}
+
+// acceptsHEVC 客户端能否解码 HEVC：?codec=hevc / ?codec=h264 优先，其次是设备上报的解码能力
+func acceptsHEVC(r *http.Request) bool {
+	switch r.URL.Query().Get("codec") {
+	case "hevc":
+		return true
+	case "h264":
+		return false
+	}
+	caps, ok := capabilitiesOf(r)
+	return ok && slices.Contains(caps.Video, "hevc")
+}
+
+// wantsHEVC 客户端是否接收 HEVC 转码输出：?codec=hevc 强制启用，
+// 否则只在客户端能解码且有 HEVC 硬件编码器时自动启用（libx265 软编码太慢）
+func wantsHEVC(r *http.Request) bool {
+	if r.URL.Query().Get("codec") == "hevc" {
+		return true
+	}
+	return acceptsHEVC(r) && currentHEVCEncoder().Name != "none"
+}
+
+// outputHEVC 是否输出 HEVC：HEVC 源直接 copy，或需要重新编码时编码为 HEVC
+func outputHEVC(r *http.Request, decision PlaybackDecision) bool {
+	if decision.Mode == PlayRemux {
+		return decision.Codec == "hevc"
+	}
+	return decision.Mode == PlayTranscode && wantsHEVC(r)
+}
+
+// wantsFMP4 客户端是否使用 fMP4 分片：?segment=fmp4 / ?segment=ts 优先，否则按 -hls-segment 配置
+func wantsFMP4(r *http.Request) bool {
+	switch r.URL.Query().Get("segment") {
+	case "fmp4":
+		return true
+	case "ts":
+		return false
+	}
+	return hlsFMP4
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	progressFile     = "progress.json"
+	deviceCookieName = "lc_device"
+)
+
+// ProgressEntry 某个设备在某个视频上的播放位置
+type ProgressEntry struct {
+	Position  float64 `json:"position"` // 秒
+	Duration  float64 `json:"duration"` // 秒
+	Device    string  `json:"device"`
+	UpdatedAt int64   `json:"updated_at"` // unix 秒
+}
+
+var (
+	// progress 视频相对路径 -> 设备 ID -> 播放位置，未登录账号时使用
+	progress = make(map[string]map[string]ProgressEntry)
+	// userProgress 账号名 -> 该账号的播放进度（-users）
+	userProgress = make(map[string]map[string]map[string]ProgressEntry)
+	progressMu   sync.Mutex
+)
+
+// InitProgress 从数据目录加载播放进度
+func InitProgress() error {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	return loadJSON(progressFile, &progress)
+}
+
+// deviceID 读取或分配客户端设备 ID（Cookie），也可通过 X-Device-ID 头显式指定
+func deviceID(w http.ResponseWriter, r *http.Request) string {
+	if id := r.Header.Get("X-Device-ID"); id != "" {
+		return id
+	}
+	if c, err := r.Cookie(deviceCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   365 * 24 * 3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// progressOfLocked 账号的播放进度，账号名为空时为共享的播放进度
+func progressOfLocked(user string) map[string]map[string]ProgressEntry {
+	if user == "" {
+		return progress
+	}
+	if userProgress[user] == nil {
+		userProgress[user] = make(map[string]map[string]ProgressEntry)
+	}
+	return userProgress[user]
+}
+
+func saveProgressLocked(user string) {
+	if err := saveJSON(userDataFile(user, progressFile), progressOfLocked(user)); err != nil {
+		logErrorf("[进度] 保存失败: %v", err)
+	}
+}
+
+// SaveProgress 记录播放位置，接近结尾时视为看完并清除记录
+func SaveProgress(user, file, device string, position, duration float64) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	progress := progressOfLocked(user)
+	if duration > 0 && duration-position < 3 {
+		delete(progress, file)
+	} else {
+		if progress[file] == nil {
+			progress[file] = make(map[string]ProgressEntry)
+		}
+		progress[file][device] = ProgressEntry{
+			Position:  position,
+			Duration:  duration,
+			Device:    device,
+			UpdatedAt: time.Now().Unix(),
+		}
+	}
+
+	saveProgressLocked(user)
+}
+
+// LatestProgress 返回视频在所有设备中最近一次的播放位置，便于换设备继续观看
+func LatestProgress(user, file string) (ProgressEntry, bool) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	progress := progressOfLocked(user)
+	var latest ProgressEntry
+	found := false
+	for _, e := range progress[file] {
+		if !found || e.UpdatedAt > latest.UpdatedAt {
+			latest = e
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// handleProgress GET 查询 / POST 保存播放进度
+func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	device := deviceID(w, r)
+
+	switch r.Method {
+	case http.MethodGet:
+		file := r.URL.Query().Get("file")
+		if !s.isValidPath(file) {
+			http.Error(w, "无效的文件路径", http.StatusForbidden)
+			return
+		}
+		entry, _ := LatestProgress(requestUser(r), file)
+		writeJSON(w, entry)
+
+	case http.MethodPost:
+		var req struct {
+			File     string  `json:"file"`
+			Position float64 `json:"position"`
+			Duration float64 `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		if !s.isValidPath(req.File) {
+			http.Error(w, "无效的文件路径", http.StatusForbidden)
+			return
+		}
+		user := requestUser(r)
+		SaveProgress(user, req.File, device, req.Position, req.Duration)
+		if req.Duration > 0 && req.Duration-req.Position < 3 {
+			SetWatched(user, req.File, true)
+		}
+		// 播放中每隔几秒上报一次进度，同时累计观看时长；配额用完时播放页刷新为提示页
+		rules := quotaRulesFor(w, r)
+		creditWatchTime(rules, device)
+		if reason := quotaExceeded(rules); reason != "" {
+			http.Error(w, reason, http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mrand "math/rand/v2"
+)
+
+const (
+	// queueTTL 随机播放队列的保留时间，只在内存中保存，重启后失效
+	queueTTL = 24 * time.Hour
+	// queueMaxItems 单个队列的最大长度
+	queueMaxItems = 500
+	// queueDefaultItems 未指定 limit 时的队列长度
+	queueDefaultItems = 100
+)
+
+// playQueue 随机生成的连续播放队列
+type playQueue struct {
+	items   []string
+	created time.Time
+}
+
+var (
+	queues   = make(map[string]*playQueue)
+	queuesMu sync.Mutex
+)
+
+// findQueue 返回队列中的视频，队列不存在或已过期时返回 nil
+func findQueue(id string) []string {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	q := queues[id]
+	if q == nil || time.Since(q.created) > queueTTL {
+		return nil
+	}
+	return q.items
+}
+
+// addQueue 保存队列并返回 ID，顺带清理过期的队列
+func addQueue(items []string) string {
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	for k, q := range queues {
+		if time.Since(q.created) > queueTTL {
+			delete(queues, k)
+		}
+	}
+	queues[id] = &playQueue{items: items, created: time.Now()}
+	return id
+}
+
+// handleRandomQueue GET /api/queue/random 随机打乱视频生成连续播放队列，返回第一项的播放地址：
+// ?path= 只包含该文件夹（含子文件夹）中的视频，?limit= 队列长度，?unwatched=1 只包含未看完的视频
+func (s *Server) handleRandomQueue(w http.ResponseWriter, r *http.Request) {
+	dir, ok := s.cleanDirParam(r.URL.Query().Get("path"))
+	if !ok {
+		http.Error(w, "无效的目录", http.StatusForbidden)
+		return
+	}
+	limit := queueDefaultItems
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "无效的 limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, queueMaxItems)
+	}
+	unwatched := r.URL.Query().Get("unwatched") == "1"
+
+	videos, err := ScanVideos(s.videoDir)
+	if err != nil {
+		http.Error(w, "扫描视频失败", http.StatusInternalServerError)
+		return
+	}
+	user := requestUser(r)
+	videos = visibleVideos(r, videos)
+	markWatched(user, videos)
+	prefix := ""
+	if dir != "" {
+		prefix = filepath.ToSlash(dir) + "/"
+	}
+	var items []string
+	for _, v := range videos {
+		if v.Blocked != "" || (unwatched && v.Watched) || !strings.HasPrefix(filepath.ToSlash(v.RelPath), prefix) {
+			continue
+		}
+		items = append(items, v.RelPath)
+	}
+	if len(items) == 0 {
+		http.Error(w, "没有可播放的视频", http.StatusNotFound)
+		return
+	}
+	mrand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	id := addQueue(items)
+	writeJSON(w, struct {
+		ID    string   `json:"id"`
+		Items []string `json:"items"`
+		URL   string   `json:"url"` // 从第一项开始播放的地址
+	}{
+		ID:    id,
+		Items: items,
+		URL:   "/play?" + url.Values{"file": {items[0]}, "queue": {id}, "i": {"0"}}.Encode(),
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// quietWindow 安静时段，期间暂停后台任务（补全封面和时长、预转码等），不影响正在播放的视频
+type quietWindow struct {
+	enabled    bool
+	start, end int // 一天中的分钟数，end < start 表示跨过午夜
+}
+
+var quietHours quietWindow
+
+// parseQuietHours 解析 -quiet-hours 参数，如 "23:00-07:00"，空字符串表示不启用
+func parseQuietHours(spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		quietHours = quietWindow{}
+		return nil
+	}
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return fmt.Errorf("格式应为 HH:MM-HH:MM: %s", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("开始和结束时间相同: %s", spec)
+	}
+	quietHours = quietWindow{enabled: true, start: start, end: end}
+	return nil
+}
+
+// parseClock 解析 "HH:MM"，返回一天中的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("无效的时间 %q（格式 HH:MM）", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains 时间 t 是否在安静时段内
+func (q quietWindow) contains(t time.Time) bool {
+	if !q.enabled {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+// remaining 距离安静时段结束还有多久
+func (q quietWindow) remaining(t time.Time) time.Duration {
+	m := t.Hour()*60 + t.Minute()
+	left := q.end - m
+	if left <= 0 {
+		left += 24 * 60
+	}
+	return time.Duration(left)*time.Minute - time.Duration(t.Second())*time.Second
+}
+
+// waitQuietHours 在安静时段内阻塞到时段结束，后台任务在处理每一项前调用
+func waitQuietHours(task string) {
+	now := time.Now()
+	if !quietHours.contains(now) {
+		return
+	}
+	wait := quietHours.remaining(now)
+	log.Printf("[安静时段] %s 暂停，%s 后继续", task, wait.Round(time.Minute))
+	time.Sleep(wait)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	quotaUsageFile    = "quota_usage.json"
+	quotaSaveInterval = 10 * time.Second
+	// quotaMaxCredit 两次进度上报之间最多计入的观看时长，暂停、切到后台等间隔不计入
+	quotaMaxCredit = 30 * time.Second
+	// quotaFlushBytes 响应每写出这么多流量才计入一次配额，避免每次写入都争抢 quotaMu
+	quotaFlushBytes = 1 << 20
+)
+
+// QuotaRule 每日用量配额（-quota），匹配同一条件的请求共享当日用量，每天 0 点清零
+type QuotaRule struct {
+	Match string        // user:小明 / ip:192.168.1.0/24 / device:<设备 ID> / token
+	Bytes int64         // 每日流量上限，0 表示不限
+	Watch time.Duration // 每日观看时长上限，0 表示不限
+	ipnet *net.IPNet
+}
+
+// quotaUsage 某条规则当日的用量
+type quotaUsage struct {
+	Date    string  `json:"date"` // 2006-01-02
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+}
+
+var (
+	quotaRules []*QuotaRule
+	// quotaUsages 规则 Match -> 当日用量，定期保存到数据目录，重启后继续累计
+	quotaUsages = make(map[string]*quotaUsage)
+	// quotaLastReport 设备 ID -> 上次进度上报时间，用于计算观看时长
+	quotaLastReport = make(map[string]time.Time)
+	quotaSaved      time.Time
+	quotaMu         sync.Mutex
+)
+
+// errQuotaExceeded 传输过程中用完流量配额，中断响应
+var errQuotaExceeded = errors.New("今日配额已用完")
+
+// parseQuotas 解析 -quota 参数，如 "user:小明=2h,device:<设备 ID>=5G,ip:192.168.1.0/24=20G,token=50G"
+// 限额是时长（h/m/s，注意小写 m 为分钟）时限制观看时长，否则为流量（K/M/G/T）；同一条件写多次时合并
+func parseQuotas(spec string) error {
+	byMatch := make(map[string]*QuotaRule)
+	var rules []*QuotaRule
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		match, limit, ok := cutLast(item, "=")
+		if !ok {
+			return fmt.Errorf("无效的配额: %s", item)
+		}
+		match, limit = strings.TrimSpace(match), strings.TrimSpace(limit)
+
+		rule := byMatch[match]
+		if rule == nil {
+			rule = &QuotaRule{Match: match}
+			kind, value, _ := strings.Cut(match, ":")
+			switch {
+			case match == "token":
+			case kind == "user" && value != "":
+			case kind == "device" && value != "":
+			case kind == "ip":
+				if !strings.Contains(value, "/") {
+					if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+						value += "/32"
+					} else {
+						value += "/128"
+					}
+				}
+				_, ipnet, err := net.ParseCIDR(value)
+				if err != nil {
+					return fmt.Errorf("无效的 IP 或网段: %s", match)
+				}
+				rule.ipnet = ipnet
+			default:
+				return fmt.Errorf("未知的配额对象 %q（可选 user:账号 / ip:地址或网段 / device:设备 ID / token）", match)
+			}
+			byMatch[match] = rule
+			rules = append(rules, rule)
+		}
+
+		if d, err := time.ParseDuration(limit); err == nil && d > 0 {
+			rule.Watch = d
+		} else if n, err := parseByteSize(limit); err == nil && n > 0 {
+			rule.Bytes = n
+		} else {
+			return fmt.Errorf("无效的限额 %q（如 2h、90m、5G）", limit)
+		}
+	}
+	quotaRules = rules
+	return nil
+}
+
+// InitQuotas 加载当日已用的配额
+func InitQuotas() error {
+	if len(quotaRules) == 0 {
+		return nil
+	}
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	return loadJSON(quotaUsageFile, &quotaUsages)
+}
+
+// matches 请求是否命中规则；账号取登录会话，IP 取连接的远端地址，不信任 X-Forwarded-For。
+// 设备只按 ID 匹配：设备名称任何人都能通过 /api/device 修改。设备 ID 同样由客户端提供，
+// device: 规则只能约束不刻意绕过的客户端
+func (q *QuotaRule) matches(r *http.Request, device string) bool {
+	kind, value, _ := strings.Cut(q.Match, ":")
+	switch kind {
+	case "token":
+		return tokenAuthorized(r)
+	case "user":
+		return requestUser(r) == value
+	case "device":
+		return device == value
+	case "ip":
+		ip := net.ParseIP(remoteIP(r))
+		return ip != nil && q.ipnet.Contains(ip)
+	}
+	return false
+}
+
+// quotaRulesFor 请求命中的配额规则，未配置配额时为空
+func quotaRulesFor(w http.ResponseWriter, r *http.Request) []*QuotaRule {
+	if len(quotaRules) == 0 {
+		return nil
+	}
+	device := deviceID(w, r)
+	var rules []*QuotaRule
+	for _, q := range quotaRules {
+		if q.matches(r, device) {
+			rules = append(rules, q)
+		}
+	}
+	return rules
+}
+
+// usageLocked 规则当日的用量，跨天时清零（调用方持有 quotaMu）
+func usageLocked(q *QuotaRule) *quotaUsage {
+	today := time.Now().Format("2006-01-02")
+	u := quotaUsages[q.Match]
+	if u == nil || u.Date != today {
+		u = &quotaUsage{Date: today}
+		quotaUsages[q.Match] = u
+	}
+	return u
+}
+
+// quotaExceeded 返回已用完的配额说明，为空表示还有余量
+func quotaExceeded(rules []*QuotaRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	for _, q := range rules {
+		u := usageLocked(q)
+		if q.Watch > 0 && u.Seconds >= q.Watch.Seconds() {
+			return fmt.Sprintf("今天的观看时长（%.0f 分钟）已用完", q.Watch.Minutes())
+		}
+		if q.Bytes > 0 && u.Bytes >= q.Bytes {
+			return fmt.Sprintf("今天的流量（%s）已用完", formatSize(q.Bytes))
+		}
+	}
+	return ""
+}
+
+// addQuotaBytes 累计流量
+func addQuotaBytes(rules []*QuotaRule, n int64) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	for _, q := range rules {
+		usageLocked(q).Bytes += n
+	}
+	saveQuotaUsageLocked()
+}
+
+// creditWatchTime 播放进度上报时累计观看时长：计入距上次上报的时间，最多 quotaMaxCredit
+func creditWatchTime(rules []*QuotaRule, device string) {
+	if len(rules) == 0 {
+		return
+	}
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	now := time.Now()
+	last := quotaLastReport[device]
+	quotaLastReport[device] = now
+	if last.IsZero() {
+		return
+	}
+	credit := min(now.Sub(last), quotaMaxCredit).Seconds()
+	for _, q := range rules {
+		usageLocked(q).Seconds += credit
+	}
+	saveQuotaUsageLocked()
+}
+
+// saveQuotaUsageLocked 保存用量，最多每 quotaSaveInterval 写一次（调用方持有 quotaMu）
+func saveQuotaUsageLocked() {
+	if time.Since(quotaSaved) < quotaSaveInterval {
+		return
+	}
+	quotaSaved = time.Now()
+	if err := saveJSON(quotaUsageFile, quotaUsages); err != nil {
+		log.Printf("[配额] 保存用量失败: %v", err)
+	}
+}
+
+// quotaWriter 统计响应流量，每累计 quotaFlushBytes 计入一次配额，用完配额时中断传输
+type quotaWriter struct {
+	http.ResponseWriter
+	rules   []*QuotaRule
+	pending int64 // 已写出但尚未计入配额的流量
+}
+
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.pending += int64(n)
+	if w.pending >= quotaFlushBytes {
+		w.flushUsage()
+		if err == nil && quotaExceeded(w.rules) != "" {
+			err = errQuotaExceeded
+		}
+	}
+	return n, err
+}
+
+// flushUsage 把尚未计入的流量计入配额，响应结束时也会调用
+func (w *quotaWriter) flushUsage() {
+	if w.pending > 0 {
+		addQuotaBytes(w.rules, w.pending)
+		w.pending = 0
+	}
+}
+
+func (w *quotaWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 让 http.ResponseController 能穿过配额统计的包装
+func (w *quotaWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// quotaMiddleware 视频流接口（/video、/download、/remux、/hls/、/dash/）的配额检查和流量统计
+func quotaMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules := quotaRulesFor(w, r)
+		if len(rules) == 0 {
+			next(w, r)
+			return
+		}
+		if reason := quotaExceeded(rules); reason != "" {
+			http.Error(w, reason, http.StatusTooManyRequests)
+			return
+		}
+		qw := &quotaWriter{ResponseWriter: w, rules: rules}
+		defer qw.flushUsage()
+		next(qw, r)
+	}
+}
+
+// serveQuotaPage 播放页在配额用完时显示的提示页
+func serveQuotaPage(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := templates.ExecuteTemplate(w, "quota.html", reason); err != nil {
+		log.Printf("模板渲染错误: %v", err)
+	}
+}
+
+// QuotaStatus /api/quota 返回的当前客户端配额用量
+type QuotaStatus struct {
+	Match      string  `json:"match"`
+	BytesLimit int64   `json:"bytes_limit,omitempty"`
+	BytesUsed  int64   `json:"bytes_used"`
+	WatchLimit float64 `json:"watch_limit,omitempty"` // 秒
+	WatchUsed  float64 `json:"watch_used"`            // 秒
+	Exceeded   bool    `json:"exceeded"`
+}
+
+// handleQuota GET 当前客户端命中的配额及当日用量
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	rules := quotaRulesFor(w, r)
+	status := []QuotaStatus{}
+	quotaMu.Lock()
+	for _, q := range rules {
+		u := usageLocked(q)
+		status = append(status, QuotaStatus{
+			Match:      q.Match,
+			BytesLimit: q.Bytes,
+			BytesUsed:  u.Bytes,
+			WatchLimit: q.Watch.Seconds(),
+			WatchUsed:  u.Seconds,
+			Exceeded:   q.Bytes > 0 && u.Bytes >= q.Bytes || q.Watch > 0 && u.Seconds >= q.Watch.Seconds(),
+		})
+	}
+	quotaMu.Unlock()
+	writeJSON(w, status)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter 令牌桶：记录已分配额度用到的时间点，按该时间点计算需要等待多久
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  float64       // 每秒恢复的额度
+	burst time.Duration // 允许积攒的额度（按时间计）
+	next  time.Time
+}
+
+// reserve 预留 n 份额度，返回需要等待的时间
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if earliest := now.Add(-l.burst); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	return l.next.Sub(now)
+}
+
+// wait 额度已用完时需要等待的时间，不预留额度
+func (l *rateLimiter) wait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Until(l.next)
+}
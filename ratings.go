@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ratingsFile = "ratings.json"
+	maxStars    = 5
+)
+
+// VideoRating 用户对视频的收藏和星级评分
+type VideoRating struct {
+	Favorite  bool  `json:"favorite,omitempty"`
+	Stars     int   `json:"rating,omitempty"`     // 1–5 星，0 表示未评分
+	UpdatedAt int64 `json:"updated_at,omitempty"` // unix 秒，用于多实例同步
+}
+
+var (
+	// ratings 视频相对路径 -> 收藏和评分，未登录账号时使用
+	ratings = make(map[string]VideoRating)
+	// userRatings 账号名 -> 该账号的收藏和评分（-users）
+	userRatings = make(map[string]map[string]VideoRating)
+	ratingsMu   sync.Mutex
+)
+
+// InitRatings 从数据目录加载收藏和评分
+func InitRatings() error {
+	ratingsMu.Lock()
+	defer ratingsMu.Unlock()
+	return loadJSON(ratingsFile, &ratings)
+}
+
+// ratingsOfLocked 账号的收藏和评分，账号名为空时为共享的收藏和评分
+func ratingsOfLocked(user string) map[string]VideoRating {
+	if user == "" {
+		return ratings
+	}
+	if userRatings[user] == nil {
+		userRatings[user] = make(map[string]VideoRating)
+	}
+	return userRatings[user]
+}
+
+func saveRatingsLocked(user string) {
+	if err := saveJSON(userDataFile(user, ratingsFile), ratingsOfLocked(user)); err != nil {
+		logErrorf("[评分] 保存失败: %v", err)
+	}
+}
+
+// videoRating 查询视频的收藏和评分
+func videoRating(user, rel string) VideoRating {
+	ratingsMu.Lock()
+	defer ratingsMu.Unlock()
+	return ratingsOfLocked(user)[rel]
+}
+
+// updateVideoRating 修改视频的收藏和评分，nil 表示保持不变。
+// 取消收藏和评分后保留只有修改时间的记录，同步时据此覆盖其他实例上的旧评分
+func updateVideoRating(user, rel string, favorite *bool, stars *int) VideoRating {
+	ratingsMu.Lock()
+	defer ratingsMu.Unlock()
+	ratings := ratingsOfLocked(user)
+	v := ratings[rel]
+	if favorite != nil {
+		v.Favorite = *favorite
+	}
+	if stars != nil {
+		v.Stars = *stars
+	}
+	v.UpdatedAt = time.Now().Unix()
+	ratings[rel] = v
+	saveRatingsLocked(user)
+	return v
+}
+
+// markRatings 填充列表中每个视频的收藏和评分
+func markRatings(user string, videos []VideoFile) {
+	ratingsMu.Lock()
+	defer ratingsMu.Unlock()
+	ratings := ratingsOfLocked(user)
+	for i := range videos {
+		r := ratings[videos[i].RelPath]
+		videos[i].Favorite, videos[i].Stars = r.Favorite, r.Stars
+	}
+}
+
+// favoriteVideos 只保留收藏的视频
+func favoriteVideos(videos []VideoFile) []VideoFile {
+	var result []VideoFile
+	for _, v := range videos {
+		if v.Favorite {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// handleRating GET 查询 / PUT 修改 / DELETE 清除视频的收藏和评分：
+//
+//	PUT /api/videos/{id}/rating  {"favorite":true,"rating":4}，省略的字段保持不变，rating 为 0 表示取消评分
+func (s *Server) handleRating(w http.ResponseWriter, r *http.Request, file string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, videoRating(requestUser(r), file))
+
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			Favorite *bool `json:"favorite"`
+			Stars    *int  `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		if req.Stars != nil && (*req.Stars < 0 || *req.Stars > maxStars) {
+			http.Error(w, "评分应为 0–5", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, updateVideoRating(requestUser(r), file, req.Favorite, req.Stars))
+
+	case http.MethodDelete:
+		updateVideoRating(requestUser(r), file, new(bool), new(int))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// recommendSeeds 参考最近播放过的多少个视频
+	recommendSeeds = 10
+	// recommendRowLimit 首页「猜你想看」显示数量
+	recommendRowLimit = 8
+	// recommendMaxLimit /api/recommendations 的 limit 上限
+	recommendMaxLimit = 50
+)
+
+// 各项相似度的得分，乘以参考视频的权重后累加
+const (
+	scoreSameSeries    = 4.0
+	scoreSameFolder    = 2.0
+	scoreSharedTag     = 1.5
+	scoreSharedGenre   = 1.0
+	scoreSimilarLength = 0.5
+)
+
+// Recommendation 推荐的视频及推荐理由
+type Recommendation struct {
+	VideoFile
+	Score   float64
+	Reasons []string // 如「同一剧集」「同一文件夹」「#纪录片」「时长相近」
+}
+
+// recommendSeedsFor 账号最近播放过的视频（按最近播放时间倒序），作为推荐的参考；
+// 同时返回所有播放过的视频，这些不再推荐
+func recommendSeedsFor(user string, videos []VideoFile) ([]VideoFile, map[string]int64) {
+	historyMu.Lock()
+	history := historyOfLocked(user)
+	played := make(map[string]int64)
+	for _, v := range videos {
+		if t := history[v.RelPath].LastPlayed; t > 0 {
+			played[v.RelPath] = t
+		}
+	}
+	historyMu.Unlock()
+
+	var seeds []VideoFile
+	for _, v := range videos {
+		if played[v.RelPath] > 0 {
+			seeds = append(seeds, v)
+		}
+	}
+	sort.Slice(seeds, func(i, j int) bool { return played[seeds[i].RelPath] > played[seeds[j].RelPath] })
+	if len(seeds) > recommendSeeds {
+		seeds = seeds[:recommendSeeds]
+	}
+	return seeds, played
+}
+
+// recommendVideos 根据账号的观看记录和标签推荐没播放过的视频：与最近播放的视频属于同一剧集、同一文件夹、
+// 有相同的标签或类型、时长相近的得分更高；越近播放的、收藏或评分高的参考视频权重越大。
+// videos 需已填充观看状态和标签（markWatched），没有观看记录时返回空
+func recommendVideos(user string, videos []VideoFile, limit int) []Recommendation {
+	seeds, played := recommendSeedsFor(user, videos)
+	if len(seeds) == 0 {
+		return nil
+	}
+	type seedInfo struct {
+		weight   float64
+		dir      string
+		series   string
+		tags     map[string]bool
+		genres   map[string]bool
+		duration int
+	}
+	infos := make([]seedInfo, len(seeds))
+	for i, s := range seeds {
+		info := seedInfo{
+			weight:   1 / float64(i+1),
+			dir:      filepath.Dir(s.RelPath),
+			tags:     make(map[string]bool),
+			genres:   make(map[string]bool),
+			duration: durationSeconds(s.Duration),
+		}
+		if s.Favorite || s.Stars >= 4 {
+			info.weight *= 2
+		}
+		if name, _, _, ok := parseEpisode(s.RelPath); ok {
+			info.series = strings.ToLower(name)
+		}
+		for _, t := range s.Tags {
+			info.tags[t] = true
+		}
+		for _, g := range s.Genres {
+			info.genres[g] = true
+		}
+		infos[i] = info
+	}
+
+	var result []Recommendation
+	for _, v := range videos {
+		if v.Watched || v.Blocked != "" || played[v.RelPath] > 0 {
+			continue
+		}
+
+		rec := Recommendation{VideoFile: v}
+		reasons := make(map[string]bool)
+		addReason := func(reason string) {
+			if !reasons[reason] {
+				reasons[reason] = true
+				rec.Reasons = append(rec.Reasons, reason)
+			}
+		}
+		series := ""
+		if name, _, _, ok := parseEpisode(v.RelPath); ok {
+			series = strings.ToLower(name)
+		}
+		duration := durationSeconds(v.Duration)
+		for _, s := range infos {
+			score := 0.0
+			if series != "" && series == s.series {
+				score += scoreSameSeries
+				addReason("同一剧集")
+			}
+			if dir := filepath.Dir(v.RelPath); dir != "." && dir == s.dir {
+				score += scoreSameFolder
+				addReason("同一文件夹")
+			}
+			for _, t := range v.Tags {
+				if s.tags[t] {
+					score += scoreSharedTag
+					addReason("#" + t)
+				}
+			}
+			for _, g := range v.Genres {
+				if s.genres[g] {
+					score += scoreSharedGenre
+					addReason(g)
+				}
+			}
+			// 时长相近只在已有其他相似之处时加分，避免把所有同样长度的视频都推荐出来
+			if score > 0 && duration > 0 && s.duration > 0 &&
+				float64(duration) >= float64(s.duration)*0.75 && float64(duration) <= float64(s.duration)*1.33 {
+				score += scoreSimilarLength
+				addReason("时长相近")
+			}
+			rec.Score += score * s.weight
+		}
+		if rec.Score > 0 {
+			result = append(result, rec)
+		}
+	}
+	// 得分相同时按路径排列，剧集从前往后推荐
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].RelPath < result[j].RelPath
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// handleRecommendations GET /api/recommendations 当前账号的「猜你想看」，?limit= 数量（默认 8，最多 50）
+func (s *Server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	limit := recommendRowLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "无效的 limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, recommendMaxLimit)
+	}
+	videos, err := ScanVideos(s.videoDir)
+	if err != nil {
+		http.Error(w, "扫描视频目录失败", http.StatusInternalServerError)
+		return
+	}
+	user := requestUser(r)
+	videos = visibleVideos(r, videos)
+	markWatched(user, videos)
+	recs := recommendVideos(user, videos, limit)
+	if recs == nil {
+		recs = []Recommendation{}
+	}
+	writeJSON(w, recs)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// streamRemux 是否对视频编码兼容的文件（如 H.264 MKV）实时封装为 fMP4 直接输出（-stream-remux），不走 HLS、不落盘
+var streamRemux = true
+
+// streamAudioCopy 可以原样 copy 到 MP4 的音频编码，其余编码实时转为 AAC（开销很小）
+var streamAudioCopy = map[string]bool{"aac": true, "mp3": true}
+
+// mp4AudioCodecs 可以封装到 MP4 的其他音频编码，设备上报能解码且没有设置 -audio-downmix 时也原样 copy
+var mp4AudioCodecs = map[string]bool{"ac3": true, "eac3": true, "opus": true, "flac": true}
+
+// streamDecision 文件能否实时封装转换后播放：视频编码是设备能播放的（默认只有 H.264），
+// 且用户没有用扩展名规则指定 HLS/转码
+func streamDecision(filePath string, profile DeviceProfile) (PlaybackDecision, bool) {
+	if !streamRemux || transcodePolicy == PolicyNone {
+		return PlaybackDecision{}, false
+	}
+	if rule, ok := extRuleFor(filePath); ok && rule != ExtDirect {
+		return PlaybackDecision{}, false
+	}
+	codec := cachedVideoCodec(filePath)
+	if codec == "" || !slices.Contains(profile.streamCodecs(), codec) {
+		return PlaybackDecision{}, false
+	}
+	return PlaybackDecision{Mode: PlayStream, Codec: codec}, true
+}
+
+// streamCopyAudio 实时封装转换时音频能否原样 copy
+func streamCopyAudio(codec string, clientAudio []string) bool {
+	return streamAudioCopy[codec] || !audioDownmix && mp4AudioCodecs[codec] && slices.Contains(clientAudio, codec)
+}
+
+// remuxArgs 实时封装转换的 ffmpeg 参数：视频 copy，输出 fragmented MP4 到 stdout
+// start > 0 时从该位置（之前最近的关键帧）开始输出；clientAudio 中的音频编码（设备上报能解码的）也原样 copy
+func remuxArgs(filePath string, audio int, start float64, clientAudio []string) []string {
+	args := []string{"-loglevel", "error"}
+	if start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	args = append(args, "-i", filePath,
+		"-map", "0:v:0",
+		"-map", fmt.Sprintf("0:a:%d?", audio),
+		"-c:v", "copy")
+	if tracks := probeAudioTracks(filePath, audio); audio < len(tracks) && streamCopyAudio(tracks[audio].Codec, clientAudio) {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-ac", "2", "-b:a", "128k")
+	}
+	return append(args,
+		"-f", "mp4", "-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"pipe:1")
+}
+
+// handleRemux 实时封装转换 /remux?file=xxx[&audio=N][&t=秒]
+// 输出不支持 Range，跳转时播放页带上 t 重新请求；客户端断开后 ffmpeg 随请求上下文结束
+func (s *Server) handleRemux(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+	fullPath := filepath.Join(s.videoDir, file)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusGone)
+		return
+	}
+	if transcodePolicy == PolicyNone {
+		http.Error(w, "转码已禁用", http.StatusForbidden)
+		return
+	}
+	if !ffmpegReady() {
+		http.Error(w, "ffmpeg 未就绪", http.StatusServiceUnavailable)
+		return
+	}
+	start, _ := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+	audio := selectAudio(r, fullPath)
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	stderr := &tailBuffer{max: 4 << 10}
+	cmd := exec.CommandContext(r.Context(), ffmpegPath(), remuxArgs(fullPath, audio, start, profileFor(r).Audio)...)
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		log.Printf("[实时封装] %s: ffmpeg 退出: %v\n%s", filepath.Base(fullPath), err, stderr.String())
+	}
+}
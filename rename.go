@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errTargetExists = errors.New("目标位置已有同名文件")
+	errTranscoding  = errors.New("视频正在转码，请稍后再试")
+)
+
+// relocateVideo 在视频目录内重命名或移动视频（from、to 为相对路径），同名的外挂字幕一起改名；
+// 按路径和修改时间计算 key 的封面、预览、转码和字幕缓存，以及按相对路径保存的观看数据都迁移到新路径。
+// 只在同一文件系统内重命名，不会复制大文件
+func relocateVideo(root, from, to string) error {
+	src, dst := filepath.Join(root, from), filepath.Join(root, to)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return errTargetExists
+	}
+	if transcodingSource(src) {
+		return errTranscoding
+	}
+
+	// 改名前记下旧 key（改名不改变修改时间，新 key 只随路径变化）
+	oldMedia := mediaCacheKey(src)
+	oldSubs := hlsJobKey(src, HLSOptions{})
+	type hlsCache struct {
+		key  string
+		opts HLSOptions
+	}
+	var hlsCaches []hlsCache
+	for _, e := range scanHLSCache() {
+		if e.Source == src {
+			hlsCaches = append(hlsCaches, hlsCache{e.Key, readCacheManifest(filepath.Join(hlsCacheDir, e.Key)).options()})
+		}
+	}
+
+	subs := videoSidecars(src)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	srcBase := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	dstBase := strings.TrimSuffix(dst, filepath.Ext(dst))
+	for _, sub := range subs {
+		target := dstBase + strings.TrimPrefix(filepath.Base(sub), srcBase)
+		if _, err := os.Lstat(target); err == nil {
+			logWarnf("[改名] 目标位置已有字幕 %s，保留原字幕", filepath.Base(target))
+			continue
+		}
+		if err := os.Rename(sub, target); err != nil {
+			logWarnf("[改名] 移动字幕 %s 失败: %v", filepath.Base(sub), err)
+		}
+	}
+
+	// 派生文件缓存
+	mediaKeysMu.Lock()
+	delete(mediaKeys, src)
+	mediaKeysMu.Unlock()
+	newFiles := mediaCacheFiles(mediaCacheKey(dst))
+	for i, f := range mediaCacheFiles(oldMedia) {
+		if err := os.Rename(f, newFiles[i]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logWarnf("[改名] 迁移缓存 %s 失败: %v", filepath.Base(f), err)
+		}
+	}
+
+	// 转码缓存：内存中只剩已完成缓存的记录，删除后按新 key 重新加载
+	for _, c := range hlsCaches {
+		hlsJobsMu.Lock()
+		delete(hlsJobs, c.key)
+		hlsJobsMu.Unlock()
+		newKey := hlsJobKey(dst, c.opts)
+		newDir := filepath.Join(hlsCacheDir, newKey)
+		if err := os.Rename(filepath.Join(hlsCacheDir, c.key), newDir); err != nil {
+			logWarnf("[改名] 迁移转码缓存 %s 失败: %v", c.key, err)
+			continue
+		}
+		m := readCacheManifest(newDir)
+		m.Source = dst
+		writeCacheManifest(newDir, m)
+	}
+
+	// 内嵌字幕缓存
+	subsSourcesMu.Lock()
+	delete(subsSources, oldSubs)
+	subsSourcesMu.Unlock()
+	if err := os.Rename(filepath.Join(subsCacheDir, oldSubs), filepath.Join(subsCacheDir, hlsJobKey(dst, HLSOptions{}))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logWarnf("[改名] 迁移字幕缓存失败: %v", err)
+	}
+
+	folderStatsRemove(src)
+	folderStatsAdd(dst, info.Size())
+	moveVideoData(filepath.ToSlash(from), filepath.ToSlash(to), filepath.Base(from) == filepath.Base(to))
+	moveUploadOwner(filepath.ToSlash(from), filepath.ToSlash(to))
+	bus.Publish("library.changed", nil)
+	return nil
+}
+
+// transcodingSource 视频是否有正在进行或排队中的转码任务
+func transcodingSource(path string) bool {
+	hlsJobsMu.Lock()
+	defer hlsJobsMu.Unlock()
+	for _, job := range hlsJobs {
+		if job.Source != path || job.Cached {
+			continue
+		}
+		select {
+		case <-job.Done:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// renameKey 把 map 中 from 的记录移到 to，返回是否有记录
+func renameKey[V any](m map[string]V, from, to string) bool {
+	v, ok := m[from]
+	if !ok {
+		return false
+	}
+	delete(m, from)
+	m[to] = v
+	return true
+}
+
+// moveVideoData 把按相对路径保存的播放进度、观看记录、收藏评分、标签、固定缓存、自定义信息和播放列表条目
+// 迁移到新路径；文件名变化时丢弃刮削结果，按新文件名重新刮削
+func moveVideoData(from, to string, keepScraped bool) {
+	// 共享的和每个账号的观看状态都要迁移
+	for _, user := range append([]string{""}, accountNames()...) {
+		progressMu.Lock()
+		if renameKey(progressOfLocked(user), from, to) {
+			saveProgressLocked(user)
+		}
+		progressMu.Unlock()
+
+		historyMu.Lock()
+		if renameKey(historyOfLocked(user), from, to) {
+			saveHistoryLocked(user)
+		}
+		historyMu.Unlock()
+
+		ratingsMu.Lock()
+		if renameKey(ratingsOfLocked(user), from, to) {
+			saveRatingsLocked(user)
+		}
+		ratingsMu.Unlock()
+	}
+
+	videoTagsMu.Lock()
+	if renameKey(videoTags, from, to) {
+		saveTagsLocked()
+	}
+	videoTagsMu.Unlock()
+
+	pinsMu.Lock()
+	if renameKey(pins, from, to) {
+		if err := saveJSON(pinsFile, pins); err != nil {
+			logErrorf("[固定] 保存失败: %v", err)
+		}
+	}
+	pinsMu.Unlock()
+
+	metadataMu.Lock()
+	if renameKey(metadata, from, to) {
+		if err := saveJSON(metadataFile, metadata); err != nil {
+			logErrorf("[信息] 保存失败: %v", err)
+		}
+	}
+	metadataMu.Unlock()
+
+	scrapedMu.Lock()
+	changed := renameKey(scraped, from, to)
+	if changed && !keepScraped {
+		delete(scraped, to)
+	}
+	if changed {
+		if err := saveJSON(scrapedFile, scraped); err != nil {
+			logErrorf("[刮削] 保存失败: %v", err)
+		}
+	}
+	scrapedMu.Unlock()
+
+	playlistsMu.Lock()
+	changed = false
+	for i := range playlists {
+		for j, item := range playlists[i].Items {
+			if item == from {
+				playlists[i].Items[j] = to
+				changed = true
+			}
+		}
+	}
+	if changed {
+		savePlaylistsLocked()
+	}
+	playlistsMu.Unlock()
+}
+
+// renameTarget 校验新文件名（不含目录），扩展名与原视频不同时追加原扩展名
+func renameTarget(file, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || skipName(name) {
+		return "", fmt.Errorf("无效的文件名")
+	}
+	ext := filepath.Ext(file)
+	if !strings.EqualFold(filepath.Ext(name), ext) {
+		name += ext
+	}
+	return filepath.Join(filepath.Dir(file), name), nil
+}
+
+// moveTarget 校验目标目录（视频目录下的相对路径，空表示根目录），目录中不能有隐藏或系统目录
+func moveTarget(file, dir string) (string, error) {
+	dir = filepath.Clean(filepath.FromSlash(strings.TrimSpace(dir)))
+	if dir != "." {
+		if !filepath.IsLocal(dir) || inTrash(dir) {
+			return "", fmt.Errorf("无效的目录")
+		}
+		for _, part := range strings.Split(dir, string(filepath.Separator)) {
+			if skipName(part) {
+				return "", fmt.Errorf("不能移到隐藏目录或系统目录")
+			}
+		}
+	}
+	return filepath.Join(dir, filepath.Base(file)), nil
+}
+
+// handleRelocate 重命名或移动视频（仅限管理员）：
+//
+//	POST /api/videos/{id}/rename  {"name":"新文件名.mkv"}  省略扩展名时保留原扩展名
+//	POST /api/videos/{id}/move    {"dir":"Movies/科幻"}     目录不存在时自动创建，空表示根目录
+func (s *Server) handleRelocate(w http.ResponseWriter, r *http.Request, file, action string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdminRequest(r) {
+		http.Error(w, "只有管理员可以重命名和移动视频", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+		Dir  string `json:"dir"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "无效的请求", http.StatusBadRequest)
+		return
+	}
+	file = filepath.Clean(filepath.FromSlash(file))
+	var target string
+	var err error
+	if action == "rename" {
+		target, err = renameTarget(file, req.Name)
+	} else {
+		target, err = moveTarget(file, req.Dir)
+	}
+	if err == nil && !s.isValidPath(target) {
+		err = fmt.Errorf("无效的目标路径")
+	}
+	if err == nil && target == file {
+		err = fmt.Errorf("新路径与原路径相同")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err := relocateVideo(s.videoDir, file, target); {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "视频不存在", http.StatusNotFound)
+	case errors.Is(err, errTargetExists), errors.Is(err, errTranscoding):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		logErrorf("[改名] %s -> %s 失败: %v", file, target, err)
+		http.Error(w, "重命名失败（目标目录可能位于其他磁盘）", http.StatusInternalServerError)
+	default:
+		logInfof("[改名] %s -> %s", filepath.ToSlash(file), filepath.ToSlash(target))
+		writeJSON(w, map[string]string{"file": filepath.ToSlash(target)})
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
-	"crypto/md5"
 	"fmt"
 	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 var videoExts = map[string]bool{
@@ -24,49 +28,206 @@ var videoExts = map[string]bool{
 }
 
 type VideoFile struct {
-	Name     string
-	RelPath  string
-	Size     int64
-	SizeStr  string
-	Duration string // "1:23:45" 格式
+	Name        string
+	RelPath     string
+	Size        int64
+	SizeStr     string
+	Duration    string   // "1:23:45" 格式
+	Subtitles   []string // 同目录下的外挂字幕（相对路径）
+	Blocked     string   // 无法播放的原因（如转码已禁用），为空表示可播放
+	Watched     bool     // 已看完（由观看记录填充）
+	Favorite    bool     // 已收藏
+	Stars       int      // 用户评分 1–5 星，0 表示未评分
+	Tags        []string // 用户添加的标签
+	Year        int      // 年份（自定义或刮削），0 表示未知
+	Description string   // 简介（自定义或刮削）
+	Poster      string   // 海报地址（TMDB 刮削），为空表示没有
+	Rating      float64  // TMDB 评分
+	Genres      []string // 类型，如 剧情 / 科幻
+	Width       int      // 分辨率，来自已缓存的探测结果，未探测时为 0
+	Height      int
+	Codec       string // 视频编码，如 h264 / hevc
+	Quality     string // 清晰度标签：4K / 1080p / 720p / SD
+	Stereo      string // 3D 排列：sbs 左右 / ou 上下，2D 为空
+	Projection  string // VR 全景投影：360 / 180，普通画面为空
+	ModTime     time.Time
 }
 
+// scanDirWorkers 同时读取的目录数：NAS / SMB 上每次读目录和取文件信息都有网络往返，并发读取快得多
+const scanDirWorkers = 8
+
+var (
+	// probeSlots 限制后台同时运行的时长探测（ffprobe）数（-scan-workers）
+	probeSlots = make(chan struct{}, 4)
+
+	// durationProbes 已排队或正在后台探测时长的视频
+	durationProbes   = make(map[string]bool)
+	durationProbesMu sync.Mutex
+)
+
+// ScanVideos 递归扫描 root 中的视频：并发遍历目录，按 scanDirWorkers 并发构造列表项。
+// 不等待 ffprobe：没有缓存的时长留空，在后台探测（见 listDuration）
 func ScanVideos(root string) ([]VideoFile, error) {
-	var videos []VideoFile
+	type found struct {
+		path string
+		info os.FileInfo
+	}
+	var (
+		mu        sync.Mutex
+		files     []found
+		subsByDir = make(map[string][]string) // 目录 -> 外挂字幕相对路径
+		partials  = make(map[string]PartialDownload)
+	)
+
+	err := walkParallel(root, func(path string, info os.FileInfo) {
+		rel, _ := filepath.Rel(root, path)
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		mu.Lock()
+		defer mu.Unlock()
+		if subtitleExts[ext] {
+			subsByDir[filepath.Dir(rel)] = append(subsByDir[filepath.Dir(rel)], rel)
+			return
+		}
+		if videoExts[ext] {
+			if partialVideo(path, info) {
+				partials[rel] = newPartialDownload(rel, rel, info)
+				return
+			}
+			files = append(files, found{path, info})
+		} else if final, ok := partialArtifact(info.Name()); ok {
+			file := filepath.Join(filepath.Dir(rel), final)
+			partials[file] = newPartialDownload(file, rel, info)
+		}
+	})
+	// 视频目录读不到（如网络盘掉线）时不能当成空目录，否则文件夹统计和未完成的下载会被清空
+	if err != nil {
+		return nil, err
+	}
+
+	videos := make([]VideoFile, len(files))
+	var g errgroup.Group
+	g.SetLimit(scanDirWorkers)
+	for i, f := range files {
+		g.Go(func() error {
+			videos[i] = newVideoFile(root, f.path, f.info)
+			return nil
+		})
+	}
+	g.Wait()
+
+	attachSidecars(videos, subsByDir)
+
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].Name < videos[j].Name
+	})
+
+	updateFolderStats(root, videos)
+	setPartialDownloads(root, partials)
+	return videos, nil
+}
+
+// walkParallel 遍历 root 下的文件（不跟随符号链接，跳过 skipEntry 的文件和目录），
+// 最多同时读取 scanDirWorkers 个目录。fn 会被并发调用，调用顺序不固定。
+// 读不到 root 时返回错误，读不到的子目录跳过
+func walkParallel(root string, fn func(path string, info os.FileInfo)) error {
+	sem := make(chan struct{}, scanDirWorkers)
+	var wg sync.WaitGroup
+	var rootErr error // 只由遍历 root 的 goroutine 写入，wg.Wait 之后读取
+	var walk func(dir string)
+	walk = func(dir string) {
+		defer wg.Done()
+		type entry struct {
+			path string
+			info os.FileInfo
+		}
+		var dirs []string
+		var entries []entry
+		// 读目录和取文件信息都占用一个名额，调用 fn 和遍历子目录时释放
+		sem <- struct{}{}
+		list, err := os.ReadDir(dir)
+		for _, e := range list {
+			info, err := e.Info()
+			if err != nil || skipEntry(e.Name(), info) {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if info.IsDir() {
+				dirs = append(dirs, path)
+			} else {
+				entries = append(entries, entry{path, info})
+			}
+		}
+		<-sem
+		if err != nil {
+			if dir == root {
+				rootErr = err
+			}
+			return
+		}
+		for _, d := range dirs {
+			wg.Add(1)
+			go walk(d)
+		}
+		for _, e := range entries {
+			fn(e.path, e.info)
+		}
+	}
+	wg.Add(1)
+	go walk(root)
+	wg.Wait()
+	return rootErr
+}
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// walkVideos 遍历视频目录中的视频文件（与 ScanVideos 规则相同，但不探测时长、不关联字幕、不记录未完成的下载）
+func walkVideos(root string, fn func(rel string, info os.FileInfo)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 		if info.IsDir() {
-			if strings.HasPrefix(info.Name(), ".") && path != root {
+			if path != root && skipEntry(info.Name(), info) {
 				return filepath.SkipDir
 			}
 			return nil
 		}
-		if strings.HasPrefix(info.Name(), ".") {
+		if skipEntry(info.Name(), info) || !videoExts[strings.ToLower(filepath.Ext(info.Name()))] || partialVideo(path, info) {
 			return nil
 		}
-		ext := strings.ToLower(filepath.Ext(info.Name()))
-		if videoExts[ext] {
-			rel, _ := filepath.Rel(root, path)
-			name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
-			videos = append(videos, VideoFile{
-				Name:     name,
-				RelPath:  rel,
-				Size:     info.Size(),
-				SizeStr:  formatSize(info.Size()),
-				Duration: getDuration(path),
-			})
-		}
+		rel, _ := filepath.Rel(root, path)
+		fn(rel, info)
 		return nil
 	})
+}
 
-	sort.Slice(videos, func(i, j int) bool {
-		return videos[i].Name < videos[j].Name
-	})
+// newVideoFile 根据文件信息构造列表项，有自定义标题时显示自定义标题；没有缓存的时长留空
+func newVideoFile(root, path string, info os.FileInfo) VideoFile {
+	rel, _ := filepath.Rel(root, path)
+	name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	v := VideoFile{
+		Name:     name,
+		RelPath:  rel,
+		Size:     info.Size(),
+		SizeStr:  formatSize(info.Size()),
+		Duration: listDuration(path, rel),
+		Blocked:  playbackBlockReason(path),
+		ModTime:  info.ModTime(),
+	}
+	applyMeta(&v)
+	applyStreamInfo(&v, path)
+	applyStereoInfo(&v)
+	return v
+}
 
-	return videos, err
+// attachSidecars 关联外挂字幕，subsByDir 为 目录 -> 字幕相对路径
+func attachSidecars(videos []VideoFile, subsByDir map[string][]string) {
+	for i := range videos {
+		v := &videos[i]
+		for _, sub := range subsByDir[filepath.Dir(v.RelPath)] {
+			if isSidecarOf(filepath.Base(v.RelPath), filepath.Base(sub)) {
+				v.Subtitles = append(v.Subtitles, sub)
+			}
+		}
+	}
 }
 
 // getDuration 获取视频时长，优先读缓存
@@ -77,6 +238,62 @@ func getDuration(videoPath string) string {
 		return strings.TrimSpace(string(data))
 	}
 
+	v, _, _ := probeGroup.Do(cached, func() (any, error) {
+		return probeDuration(videoPath, cached), nil
+	})
+	return v.(string)
+}
+
+// listDuration 列表中显示的时长：只读缓存，不等待 ffprobe。没有缓存时在后台排队探测
+// （probeSlots 限制并发），探测完成后写入缓存并广播 video.duration 事件，页面收到后补上时长
+func listDuration(videoPath, rel string) string {
+	if data, err := os.ReadFile(durationCachePath(videoPath)); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	queueDurationProbe(videoPath, rel)
+	return ""
+}
+
+// queueDurationProbe 在后台探测视频时长，同一视频同时只排队一次；ffmpeg 未就绪时跳过
+func queueDurationProbe(videoPath, rel string) {
+	if !ffmpegReady() {
+		return
+	}
+	durationProbesMu.Lock()
+	defer durationProbesMu.Unlock()
+	if durationProbes[videoPath] {
+		return
+	}
+	durationProbes[videoPath] = true
+	go func() {
+		probeSlots <- struct{}{}
+		d := getDuration(videoPath)
+		<-probeSlots
+		durationProbesMu.Lock()
+		delete(durationProbes, videoPath)
+		durationProbesMu.Unlock()
+		if d != "" {
+			bus.Publish("video.duration", map[string]string{"file": filepath.ToSlash(rel), "duration": d})
+		}
+	}()
+}
+
+// handleDurations GET /api/durations?file=a.mp4&file=b.mkv 查询多个视频的时长（相对路径 -> 时长），
+// 还没有探测出来的为空并在后台探测。不支持 EventSource 的页面用它轮询列表中缺少的时长
+func (s *Server) handleDurations(w http.ResponseWriter, r *http.Request) {
+	v := viewerOf(r)
+	result := make(map[string]string)
+	for _, file := range r.URL.Query()["file"] {
+		if !s.isValidPath(file) || !v.canAccess(file) {
+			continue
+		}
+		result[file] = listDuration(filepath.Join(s.videoDir, file), file)
+	}
+	writeJSON(w, result)
+}
+
+// probeDuration 调用 ffprobe 获取时长并写入缓存
+func probeDuration(videoPath, cached string) string {
 	// 多种策略依次尝试
 	attempts := [][]string{
 		{"-v", "quiet", "-show_entries", "format=duration", "-print_format", "flat", videoPath},
@@ -92,7 +309,7 @@ func getDuration(videoPath string) string {
 		}
 		if dur := parseDuration(string(out)); dur != "" {
 			os.MkdirAll(filepath.Dir(cached), 0755)
-			os.WriteFile(cached, []byte(dur), 0644)
+			writeFileAtomic(cached, []byte(dur))
 			return dur
 		}
 	}
@@ -114,13 +331,7 @@ func parseDuration(s string) string {
 }
 
 func durationCachePath(videoPath string) string {
-	info, _ := os.Stat(videoPath)
-	var mtime int64
-	if info != nil {
-		mtime = info.ModTime().UnixNano()
-	}
-	h := md5.Sum([]byte(fmt.Sprintf("%s|%d", videoPath, mtime)))
-	return filepath.Join(thumbCacheDir, fmt.Sprintf("%x.dur", h[:8]))
+	return filepath.Join(thumbCacheDir, mediaCacheKey(videoPath)+".dur")
 }
 
 func formatDuration(secs float64) string {
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// transcodeScheduler 限制同时进行的重新编码任务数，超出的任务按先后顺序排队
+// （视频 copy 的封装转换开销很小，不受限制）
+type transcodeScheduler struct {
+	mu      sync.Mutex
+	max     int // 0 表示不限制
+	running int
+	waiting []*transcodeWaiter
+}
+
+type transcodeWaiter struct {
+	owner any // 排队的任务：*HLSJob 或 *ConvertJob
+	ready chan struct{}
+}
+
+var scheduler = &transcodeScheduler{max: 2}
+
+// acquire 等待空闲名额，name 用于日志；任务在排队期间被停止（stop 关闭）时返回 false
+func (s *transcodeScheduler) acquire(owner any, name string, stop <-chan struct{}) bool {
+	s.mu.Lock()
+	if s.max <= 0 || s.running < s.max {
+		s.running++
+		s.mu.Unlock()
+		return true
+	}
+	w := &transcodeWaiter{owner: owner, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	log.Printf("[转码] 已达并发上限 %d，%s 排队中（第 %d 位）", s.max, name, len(s.waiting))
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-stop:
+		s.mu.Lock()
+		for i, x := range s.waiting {
+			if x == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				s.mu.Unlock()
+				return false
+			}
+		}
+		s.mu.Unlock()
+		// 停止的同时恰好分到了名额，转交给下一个
+		s.release()
+		return false
+	}
+}
+
+// release 归还名额，有排队任务时直接转交给队首
+func (s *transcodeScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiting) > 0 {
+		w := s.waiting[0]
+		s.waiting = s.waiting[1:]
+		close(w.ready)
+		return
+	}
+	s.running--
+}
+
+// position 任务在队列中的位置（从 1 开始），不在排队时返回 0
+func (s *transcodeScheduler) position(owner any) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.waiting {
+		if w.owner == owner {
+			return i + 1
+		}
+	}
+	return 0
+}
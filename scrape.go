@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scrapedFile      = "scraped.json"
+	tmdbAPI          = "https://api.themoviedb.org/3"
+	tmdbPosterBase   = "https://image.tmdb.org/t/p/w342"
+	scrapeInterval   = 300 * time.Millisecond // 两次请求之间的间隔，避免触发 TMDB 限流
+	scrapeRetryAfter = 7 * 24 * time.Hour     // 没有找到匹配的视频多久后重新搜索
+	scrapeErrorRetry = time.Hour              // 网络错误等失败后多久再试
+)
+
+var (
+	// tmdbKey TMDB API 密钥（-tmdb-key），为空表示不启用在线刮削；v4 读取令牌（eyJ 开头）也可以
+	tmdbKey string
+	// tmdbLang 刮削结果的语言（-tmdb-lang）
+	tmdbLang = "zh-CN"
+)
+
+// ScrapedInfo 从 TMDB 获取的影片信息，用户编辑的标题、年份和简介优先
+type ScrapedInfo struct {
+	TMDBID   int      `json:"tmdb_id,omitempty"` // 0 表示没有找到匹配
+	Type     string   `json:"type,omitempty"`    // movie / tv
+	Title    string   `json:"title,omitempty"`
+	Original string   `json:"original_title,omitempty"`
+	Year     int      `json:"year,omitempty"`
+	Overview string   `json:"overview,omitempty"`
+	Poster   string   `json:"poster,omitempty"` // 海报地址（TMDB 图片服务）
+	Rating   float64  `json:"rating,omitempty"` // TMDB 评分 0-10
+	Genres   []string `json:"genres,omitempty"`
+	Query    string   `json:"query"`   // 从文件名解析出的搜索标题
+	Fetched  int64    `json:"fetched"` // unix 秒
+}
+
+var (
+	// scraped 视频相对路径 -> 刮削结果
+	scraped   = make(map[string]ScrapedInfo)
+	scrapedMu sync.Mutex
+
+	// scrapeQueue 等待刮削的视频相对路径，scrapePending 避免重复入队
+	scrapeQueue   = make(chan string, 4096)
+	scrapePending = make(map[string]bool)
+	// scrapeFailed 请求失败的时间（不保存），scrapeErrorRetry 内不再入队
+	scrapeFailed = make(map[string]time.Time)
+
+	// tmdbGenres 类型（movie / tv）-> 类型 ID -> 名称，首次刮削时加载
+	tmdbGenres   = make(map[string]map[int]string)
+	tmdbGenresMu sync.Mutex
+)
+
+// InitScraper 加载刮削结果；设置了 -tmdb-key 时启动后台刮削
+func InitScraper() error {
+	scrapedMu.Lock()
+	err := loadJSON(scrapedFile, &scraped)
+	scrapedMu.Unlock()
+	if tmdbKey != "" {
+		go scrapeWorker()
+	}
+	return err
+}
+
+// scrapedInfo 查询视频的刮削结果；启用刮削且还没有结果（或很久以前没找到）时加入后台队列
+func scrapedInfo(rel string) ScrapedInfo {
+	scrapedMu.Lock()
+	defer scrapedMu.Unlock()
+	info, ok := scraped[rel]
+	if tmdbKey != "" && !scrapePending[rel] && time.Since(scrapeFailed[rel]) > scrapeErrorRetry &&
+		(!ok || info.TMDBID == 0 && time.Since(time.Unix(info.Fetched, 0)) > scrapeRetryAfter) {
+		select {
+		case scrapeQueue <- rel:
+			scrapePending[rel] = true
+		default: // 队列已满，下次扫描时再加入
+		}
+	}
+	return info
+}
+
+// applyScraped 用刮削结果补充列表项：没有自定义标题时显示影片名称，年份和简介同理
+func applyScraped(v *VideoFile, m VideoMeta) {
+	info := scrapedInfo(v.RelPath)
+	if info.TMDBID == 0 {
+		return
+	}
+	if m.Title == "" && info.Title != "" {
+		v.Name = info.Title
+	}
+	if v.Year == 0 {
+		v.Year = info.Year
+	}
+	if v.Description == "" {
+		v.Description = info.Overview
+	}
+	v.Poster, v.Rating, v.Genres = info.Poster, info.Rating, info.Genres
+}
+
+// scrapeWorker 逐个处理刮削队列，队列清空时通知页面刷新
+func scrapeWorker() {
+	found := 0
+	for rel := range scrapeQueue {
+		info, err := scrapeVideo(rel, "", 0)
+		scrapedMu.Lock()
+		delete(scrapePending, rel)
+		if err != nil {
+			scrapeFailed[rel] = time.Now()
+		}
+		scrapedMu.Unlock()
+		if err != nil {
+			log.Printf("[刮削] %s: %v", rel, err)
+		} else if info.TMDBID != 0 {
+			found++
+		}
+		if len(scrapeQueue) == 0 && found > 0 {
+			log.Printf("[刮削] 完成一批，匹配到 %d 个视频", found)
+			bus.Publish("library.changed", nil)
+			found = 0
+		}
+		time.Sleep(scrapeInterval)
+	}
+}
+
+// scrapeVideo 搜索 TMDB 并保存结果；query 为空时从文件名解析标题和年份
+// 网络错误时不保存，稍后重试；没有找到匹配时也保存，scrapeRetryAfter 后再搜索
+func scrapeVideo(rel, query string, year int) (ScrapedInfo, error) {
+	title, fileYear, tv := parseTitleYear(strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)))
+	if title == "" {
+		// 文件名只有集数（如 S01E02.mkv）时用所在目录名作为剧名
+		if dirTitle, dirYear, _ := parseTitleYear(filepath.Base(filepath.Dir(rel))); dirTitle != "" && dirTitle != "." {
+			title, fileYear = dirTitle, max(fileYear, dirYear)
+		}
+	}
+	if query == "" {
+		query, year = title, fileYear
+	}
+	info := ScrapedInfo{Query: query, Fetched: time.Now().Unix()}
+	types := []string{"movie", "tv"}
+	if tv {
+		types = []string{"tv", "movie"}
+	}
+	if query == "" {
+		types = nil // 无法从文件名解析标题，记为没有找到
+	}
+	for _, typ := range types {
+		result, ok, err := tmdbSearch(typ, query, year)
+		if err != nil {
+			return info, err
+		}
+		if ok {
+			info = result
+			info.Query, info.Fetched = query, time.Now().Unix()
+			break
+		}
+	}
+
+	scrapedMu.Lock()
+	scraped[rel] = info
+	if err := saveJSON(scrapedFile, scraped); err != nil {
+		log.Printf("[刮削] 保存失败: %v", err)
+	}
+	scrapedMu.Unlock()
+	return info, nil
+}
+
+// tmdbResult TMDB 搜索结果（电影和剧集字段名不同）
+type tmdbResult struct {
+	ID            int     `json:"id"`
+	Title         string  `json:"title"`
+	Name          string  `json:"name"`
+	OriginalTitle string  `json:"original_title"`
+	OriginalName  string  `json:"original_name"`
+	ReleaseDate   string  `json:"release_date"`
+	FirstAirDate  string  `json:"first_air_date"`
+	Overview      string  `json:"overview"`
+	PosterPath    string  `json:"poster_path"`
+	VoteAverage   float64 `json:"vote_average"`
+	GenreIDs      []int   `json:"genre_ids"`
+}
+
+// tmdbSearch 按标题（和年份）搜索电影或剧集，取第一个结果
+func tmdbSearch(typ, query string, year int) (ScrapedInfo, bool, error) {
+	params := url.Values{"query": {query}}
+	if year > 0 {
+		if typ == "movie" {
+			params.Set("year", strconv.Itoa(year))
+		} else {
+			params.Set("first_air_date_year", strconv.Itoa(year))
+		}
+	}
+	var resp struct {
+		Results []tmdbResult `json:"results"`
+	}
+	if err := tmdbGet("/search/"+typ, params, &resp); err != nil {
+		return ScrapedInfo{}, false, err
+	}
+	if len(resp.Results) == 0 {
+		return ScrapedInfo{}, false, nil
+	}
+
+	r := resp.Results[0]
+	info := ScrapedInfo{
+		TMDBID:   r.ID,
+		Type:     typ,
+		Title:    r.Title + r.Name,
+		Original: r.OriginalTitle + r.OriginalName,
+		Overview: r.Overview,
+		Rating:   r.VoteAverage,
+	}
+	date := r.ReleaseDate + r.FirstAirDate
+	if len(date) >= 4 {
+		info.Year, _ = strconv.Atoi(date[:4])
+	}
+	if r.PosterPath != "" {
+		info.Poster = tmdbPosterBase + r.PosterPath
+	}
+	genres := tmdbGenreNames(typ)
+	for _, id := range r.GenreIDs {
+		if name := genres[id]; name != "" {
+			info.Genres = append(info.Genres, name)
+		}
+	}
+	return info, true, nil
+}
+
+// tmdbGenreNames 类型 ID 到名称的映射，加载失败时返回空（下次再试）
+func tmdbGenreNames(typ string) map[int]string {
+	tmdbGenresMu.Lock()
+	defer tmdbGenresMu.Unlock()
+	if m, ok := tmdbGenres[typ]; ok {
+		return m
+	}
+	var resp struct {
+		Genres []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"genres"`
+	}
+	if err := tmdbGet("/genre/"+typ+"/list", url.Values{}, &resp); err != nil {
+		log.Printf("[刮削] 加载类型列表失败: %v", err)
+		return nil
+	}
+	m := make(map[int]string)
+	for _, g := range resp.Genres {
+		m[g.ID] = g.Name
+	}
+	tmdbGenres[typ] = m
+	return m
+}
+
+var tmdbClient = &http.Client{Timeout: 15 * time.Second}
+
+// tmdbGet 请求 TMDB API 并解析 JSON；v4 读取令牌放在 Authorization 头，v3 密钥作为 api_key 参数
+func tmdbGet(path string, params url.Values, out any) error {
+	params.Set("language", tmdbLang)
+	bearer := strings.HasPrefix(tmdbKey, "eyJ")
+	if !bearer {
+		params.Set("api_key", tmdbKey)
+	}
+	req, err := http.NewRequest(http.MethodGet, tmdbAPI+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if bearer {
+		req.Header.Set("Authorization", "Bearer "+tmdbKey)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := tmdbClient.Do(req)
+	if err != nil {
+		// url.Error 中带有完整地址（含 api_key），不写入日志
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return fmt.Errorf("请求 TMDB 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TMDB 返回 HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+var (
+	groupTagPattern = regexp.MustCompile(`^\s*[\[【][^\]】]*[\]】]`)
+	yearPattern     = regexp.MustCompile(`\b(?:19|20)\d{2}\b`)
+	episodePattern  = regexp.MustCompile(`(?i)\bS\d{1,2}\s?E\d{1,3}\b|\bS\d{1,2}\b|\bE[Pp]?\d{1,3}\b|第\s*\d+\s*[集话季]`)
+	releasePattern  = regexp.MustCompile(`(?i)\b(2160p|1080[pi]|720p|480p|4k|uhd|hdr(10)?|bluray|blu-ray|bdrip|brrip|web-?dl|webrip|hdtv|dvdrip|remux|x26[45]|h\.?26[45]|hevc|avc|aac|ac3|dts|atmos|10bit|proper|repack|extended|unrated)\b`)
+)
+
+// parseTitleYear 从文件名解析影片标题和年份，如 "The.Matrix.1999.1080p.BluRay.x264" -> ("The Matrix", 1999)
+// tv 表示文件名带剧集编号（S01E02、第3集），优先按剧集搜索
+func parseTitleYear(name string) (title string, year int, tv bool) {
+	s := groupTagPattern.ReplaceAllString(name, "") // 开头的字幕组标签
+	s = strings.NewReplacer(".", " ", "_", " ", "[", " ", "]", " ", "(", " ", ")", " ",
+		"【", " ", "】", " ", "（", " ", "）", " ").Replace(s)
+
+	cut := len(s)
+	// 标题之后的年份（片名本身可能以年份开头，如「2012」）
+	for _, m := range yearPattern.FindAllStringIndex(s, -1) {
+		if strings.TrimSpace(s[:m[0]]) != "" {
+			year, _ = strconv.Atoi(s[m[0]:m[1]])
+			cut = m[0]
+			break
+		}
+	}
+	if loc := episodePattern.FindStringIndex(s); loc != nil {
+		tv = true
+		cut = min(cut, loc[0])
+	}
+	if loc := releasePattern.FindStringIndex(s); loc != nil {
+		cut = min(cut, loc[0])
+	}
+	title = strings.Join(strings.Fields(strings.Trim(s[:cut], " -")), " ")
+	return title, year, tv
+}
+
+// handleScrape GET 查询视频的刮削结果；POST 重新搜索（仅限管理员），
+// 可用 ?query=&year= 指定搜索的标题和年份，用于修正自动匹配错误的视频
+func (s *Server) handleScrape(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		scrapedMu.Lock()
+		info := scraped[file]
+		scrapedMu.Unlock()
+		writeJSON(w, info)
+	case http.MethodPost:
+		if !isAdminRequest(r) {
+			http.Error(w, "仅限管理员", http.StatusForbidden)
+			return
+		}
+		if tmdbKey == "" {
+			http.Error(w, "未设置 -tmdb-key", http.StatusServiceUnavailable)
+			return
+		}
+		year, _ := strconv.Atoi(r.URL.Query().Get("year"))
+		info, err := scrapeVideo(file, strings.TrimSpace(r.URL.Query().Get("query")), year)
+		if err != nil {
+			http.Error(w, "刮削失败: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, info)
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// gb2312Initials GB2312 一级汉字按拼音排序，各声母首字的区位码
+// 通过编码区间即可得到汉字的拼音首字母，无需内置完整拼音词库
+var gb2312Initials = []struct {
+	code   int
+	letter byte
+}{
+	{0xB0A1, 'a'}, {0xB0C5, 'b'}, {0xB2C1, 'c'}, {0xB4EE, 'd'}, {0xB6EA, 'e'},
+	{0xB7A2, 'f'}, {0xB8C1, 'g'}, {0xB9FE, 'h'}, {0xBBF7, 'j'}, {0xBFA6, 'k'},
+	{0xC0AC, 'l'}, {0xC2E8, 'm'}, {0xC4C3, 'n'}, {0xC5B6, 'o'}, {0xC5BE, 'p'},
+	{0xC6DA, 'q'}, {0xC8BB, 'r'}, {0xC8F6, 's'}, {0xCBFA, 't'}, {0xCDDA, 'w'},
+	{0xCEF4, 'x'}, {0xD1B9, 'y'}, {0xD4D1, 'z'},
+}
+
+const gb2312Level1End = 0xD7F9
+
+// pinyinInitial 返回汉字的拼音首字母，非一级常用字返回 0
+func pinyinInitial(r rune) byte {
+	b, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(string(r)))
+	if err != nil || len(b) != 2 {
+		return 0
+	}
+	code := int(b[0])<<8 | int(b[1])
+	if code < gb2312Initials[0].code || code > gb2312Level1End {
+		return 0
+	}
+	i := sort.Search(len(gb2312Initials), func(i int) bool { return gb2312Initials[i].code > code })
+	return gb2312Initials[i-1].letter
+}
+
+// pinyinInitials 将名称转为拼音首字母串，如 "射雕英雄传2" -> "sdyxc2"
+func pinyinInitials(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < unicode.MaxASCII:
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				b.WriteRune(unicode.ToLower(r))
+			}
+		case unicode.Is(unicode.Han, r):
+			if c := pinyinInitial(r); c != 0 {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// isSubsequence query 的字符是否按顺序出现在 s 中（模糊匹配，如 "hp7" 匹配 "harry potter 7"）
+func isSubsequence(query, s string) bool {
+	rs := []rune(s)
+	i := 0
+	for _, q := range query {
+		for i < len(rs) && rs[i] != q {
+			i++
+		}
+		if i == len(rs) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// matchScore 计算名称与单个搜索词的匹配度，0 表示不匹配
+func matchScore(name, initials, term string) int {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, term):
+		return 120
+	case strings.Contains(lower, term):
+		return 100
+	case strings.HasPrefix(initials, term):
+		return 90
+	case strings.Contains(initials, term):
+		return 80
+	case isSubsequence(term, lower):
+		return 40
+	case isSubsequence(term, initials):
+		return 30
+	}
+	return 0
+}
+
+// SearchVideos 按文件名搜索（不区分大小写，支持拼音首字母和模糊匹配），结果按匹配度排序
+// 查询按空格分词，所有词都匹配才算命中
+func SearchVideos(videos []VideoFile, query string) []VideoFile {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return videos
+	}
+
+	type hit struct {
+		video VideoFile
+		score int
+	}
+	var hits []hit
+	for _, v := range videos {
+		initials := pinyinInitials(v.Name)
+		total := 0
+		for _, t := range terms {
+			score := matchScore(v.Name, initials, t)
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total > 0 {
+			hits = append(hits, hit{v, total})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	result := make([]VideoFile, len(hits))
+	for i, h := range hits {
+		result[i] = h.video
+	}
+	return result
+}
+
+// paginate 计算分页范围，page 从 1 开始，超出范围时自动修正
+func paginate(total, page, size int) (start, end, curPage, totalPages int) {
+	if size <= 0 {
+		size = 20
+	}
+	totalPages = (total + size - 1) / size
+	if totalPages < 1 {
+		totalPages = 1
+	}
+	if page < 1 {
+		page = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+	start = (page - 1) * size
+	end = start + size
+	if end > total {
+		end = total
+	}
+	return start, end, page, totalPages
+}
+
+// handleSearch 搜索视频（JSON，分页）
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	videos, err := ScanVideos(s.videoDir)
+	if err != nil {
+		http.Error(w, "扫描视频目录失败", http.StatusInternalServerError)
+		return
+	}
+	user := requestUser(r)
+	videos = visibleVideos(r, videos)
+	markWatched(user, videos)
+	facets, err := facetExpr(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if facets != "" {
+		videos, _ = FilterVideos(videos, facets)
+	}
+	results, err := FilterVideos(videos, query)
+	if err != nil {
+		results = SearchVideos(videos, query)
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	if size <= 0 {
+		size = 20
+	}
+	start, end, page, totalPages := paginate(len(results), page, size)
+
+	writeJSON(w, struct {
+		Query      string
+		Results    []VideoFile
+		Page       int
+		PageSize   int
+		Total      int
+		TotalPages int
+	}{
+		Query:      query,
+		Results:    results[start:end],
+		Page:       page,
+		PageSize:   size,
+		Total:      len(results),
+		TotalPages: totalPages,
+	})
+}
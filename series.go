@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// seasonEpisodePattern 文件名中的季和集，如 S01E02、s1e2、S01.E02
+	seasonEpisodePattern = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,3})`)
+	// seasonDirPattern 按季分的子目录，如 Season 1、S01、第一季，剧名取上一级目录
+	seasonDirPattern = regexp.MustCompile(`(?i)^(season\s*\d+|s\d{1,2}|第.{1,3}季)$`)
+)
+
+// Episode 剧集中的一集
+type Episode struct {
+	Season  int
+	Episode int
+	Video   VideoFile
+}
+
+// Label 集数标签，如 S01E02
+func (e Episode) Label() string {
+	return fmt.Sprintf("S%02dE%02d", e.Season, e.Episode)
+}
+
+// Season 剧集的一季
+type Season struct {
+	Number   int
+	Episodes []Episode
+}
+
+// Series 按文件名中的 S01E02 归类的剧集
+type Series struct {
+	Name     string
+	Poster   string // 任意一集的 TMDB 海报，为空时用第一集的封面
+	First    string // 第一集的相对路径
+	Episodes int    // 总集数
+	Watched  int    // 已看完的集数
+	Seasons  []Season
+}
+
+// parseEpisode 从视频路径解析剧名、季和集；文件名中只有集数时剧名取所在目录名
+func parseEpisode(rel string) (name string, season, episode int, ok bool) {
+	base := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	m := seasonEpisodePattern.FindStringSubmatch(base)
+	if m == nil {
+		return "", 0, 0, false
+	}
+	season, _ = strconv.Atoi(m[1])
+	episode, _ = strconv.Atoi(m[2])
+
+	name, _, _ = parseTitleYear(base)
+	dir := filepath.Dir(rel)
+	for name == "" && dir != "." && dir != string(filepath.Separator) {
+		if d := filepath.Base(dir); !seasonDirPattern.MatchString(d) {
+			name, _, _ = parseTitleYear(d)
+			if name == "" {
+				name = d
+			}
+		}
+		dir = filepath.Dir(dir)
+	}
+	if name == "" {
+		name = "未命名剧集"
+	}
+	return name, season, episode, true
+}
+
+// groupSeries 把带 S01E02 的视频按剧名 → 季 → 集归类，剧名不区分大小写
+func groupSeries(videos []VideoFile) []Series {
+	byName := make(map[string]*Series)
+	seasons := make(map[string]map[int][]Episode)
+	var order []string
+	for _, v := range videos {
+		name, season, episode, ok := parseEpisode(v.RelPath)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(name)
+		s := byName[key]
+		if s == nil {
+			s = &Series{Name: name}
+			byName[key] = s
+			seasons[key] = make(map[int][]Episode)
+			order = append(order, key)
+		}
+		if s.Poster == "" {
+			s.Poster = v.Poster
+		}
+		s.Episodes++
+		if v.Watched {
+			s.Watched++
+		}
+		seasons[key][season] = append(seasons[key][season], Episode{Season: season, Episode: episode, Video: v})
+	}
+
+	list := make([]Series, 0, len(order))
+	for _, key := range order {
+		s := byName[key]
+		for number, episodes := range seasons[key] {
+			sort.SliceStable(episodes, func(i, j int) bool {
+				if episodes[i].Episode != episodes[j].Episode {
+					return episodes[i].Episode < episodes[j].Episode
+				}
+				return episodes[i].Video.RelPath < episodes[j].Video.RelPath
+			})
+			s.Seasons = append(s.Seasons, Season{Number: number, Episodes: episodes})
+		}
+		sort.Slice(s.Seasons, func(i, j int) bool { return s.Seasons[i].Number < s.Seasons[j].Number })
+		s.First = s.Seasons[0].Episodes[0].Video.RelPath
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name) })
+	return list
+}
+
+// nextEpisode 同一剧集中 rel 的下一集（跨季），不是剧集或已是最后一集时返回 nil
+func nextEpisode(series []Series, rel string) *Episode {
+	for _, s := range series {
+		var prev bool
+		for _, season := range s.Seasons {
+			for _, e := range season.Episodes {
+				if prev {
+					return &e
+				}
+				prev = e.Video.RelPath == rel
+			}
+		}
+		if prev {
+			return nil
+		}
+	}
+	return nil
+}
+
+// loadSeries 扫描视频目录并归类请求者能看到的剧集，已看状态来自账号的观看记录
+func (s *Server) loadSeries(r *http.Request) ([]Series, error) {
+	videos, err := ScanVideos(s.videoDir)
+	if err != nil {
+		return nil, err
+	}
+	videos = visibleVideos(r, videos)
+	markWatched(requestUser(r), videos)
+	return groupSeries(videos), nil
+}
+
+// handleSeries 剧集页面，?name= 只显示指定剧集
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	series, err := s.loadSeries(r)
+	if err != nil {
+		http.Error(w, "扫描视频失败", http.StatusInternalServerError)
+		return
+	}
+	if name := r.URL.Query().Get("name"); name != "" {
+		series = filterSeries(series, name)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "series.html", series); err != nil {
+		log.Printf("模板渲染错误: %v", err)
+	}
+}
+
+// handleSeriesAPI GET 剧集列表（JSON），?name= 只返回指定剧集
+func (s *Server) handleSeriesAPI(w http.ResponseWriter, r *http.Request) {
+	series, err := s.loadSeries(r)
+	if err != nil {
+		http.Error(w, "扫描视频失败", http.StatusInternalServerError)
+		return
+	}
+	if name := r.URL.Query().Get("name"); name != "" {
+		series = filterSeries(series, name)
+	}
+	writeJSON(w, series)
+}
+
+// filterSeries 按剧名筛选（不区分大小写）
+func filterSeries(series []Series, name string) []Series {
+	out := []Series{}
+	for _, s := range series {
+		if strings.EqualFold(s.Name, name) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
-	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,11 +17,51 @@ import (
 )
 
 type IndexData struct {
-	Videos     []VideoFile
-	Page       int
-	PageSize   int
-	Total      int
-	TotalPages int
+	Notice       string          // 转码策略说明
+	NoFFmpeg     bool            // ffmpeg 未就绪，显示安装入口
+	Logout       bool            // 已启用密码登录，显示退出按钮
+	User         string          // 登录的账号名，共用密码登录时为空
+	KidsMode     bool            // 可以开启儿童模式，显示切换按钮
+	Kids         bool            // 处于儿童模式
+	Device       string          // 本设备名称
+	Previews     bool            // 启用悬停预览短片
+	Smart        SmartFilter     // 当前打开的保存筛选，ID 为空表示没有
+	SmartFilters []SmartFilter   // 保存的筛选，显示为虚拟文件夹
+	Playlists    []Playlist      // 播放列表，显示为虚拟文件夹，点击后从第一项开始连续播放
+	Channels     []ChannelStatus // 还没播完的定时播放频道，显示为虚拟文件夹
+	Videos       []VideoFile
+	Recent       []VideoFile      // 最近观看（仅首页第一页展示）
+	Recommended  []Recommendation // 猜你想看（仅首页第一页展示）
+	Query        string           // 搜索关键词
+	Filter       string           // "" 全部 / "unwatched" 未看 / "favorites" 收藏
+	Res          string           // 清晰度筛选 ?res=
+	Codec        string           // 视频编码筛选 ?codec=
+	MinLen       string           // 时长筛选 ?minlen=
+	MaxLen       string           // ?maxlen=
+	MinRating    string           // 评分筛选 ?minrating=
+	Tag          string           // 标签筛选 ?tag=
+	Tags         []TagCount       // 所有标签，显示在标签页下方
+	Sort         string           // 排序字段：name / size / mtime / duration / rating
+	Order        string           // asc / desc
+	Browse       bool             // 目录浏览模式
+	Path         string           // 当前浏览的目录
+	Folders      []FolderEntry
+	Crumbs       []Crumb
+	Page         int
+	PageSize     int
+	Total        int
+	TotalPages   int
+	params       url.Values // 翻页时需要保留的查询参数
+}
+
+// PageURL 生成第 page 页的链接，保留筛选等参数
+func (d IndexData) PageURL(page int) string {
+	q := url.Values{}
+	for k, v := range d.params {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "/?" + q.Encode()
 }
 
 //go:embed templates/*.html
@@ -28,11 +72,19 @@ var staticFS embed.FS
 
 var templates = template.Must(
 	template.New("").Funcs(template.FuncMap{
-		"add":      func(a, b int) int { return a + b },
-		"subtract": func(a, b int) int { return a - b },
+		"add":       func(a, b int) int { return a + b },
+		"subtract":  func(a, b int) int { return a - b },
+		"asset":     assetURL,
+		"integrity": assetIntegrity,
+		"join":      strings.Join,
+		"stars":     func(n int) string { return strings.Repeat("★", n) },
+		"lower":     strings.ToLower,
 	}).ParseFS(templateFS, "templates/*.html"),
 )
 
+// errSourceGone 源文件在播放过程中被删除或移动时返回给播放器的提示
+const errSourceGone = "视频文件已被删除或移动，请返回列表刷新"
+
 type Server struct {
 	videoDir string
 }
@@ -41,15 +93,76 @@ func NewServer(videoDir string) *Server {
 	return &Server{videoDir: videoDir}
 }
 
-func (s *Server) ListenAndServe(addr string) error {
+// ListenAndServe 启动 HTTP 服务；certFile 非空时使用 HTTPS
+func (s *Server) ListenAndServe(addr, certFile, keyFile string) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleIndex)
 	mux.HandleFunc("/play", s.handlePlay)
-	mux.HandleFunc("/video", s.handleVideo)
-	mux.HandleFunc("/hls/", s.handleHLS)
+	mux.HandleFunc("/video", quotaMiddleware(bandwidthMiddleware(s.handleVideo)))
+	mux.HandleFunc("/download", quotaMiddleware(bandwidthMiddleware(s.handleDownload)))
+	mux.HandleFunc("/remux", quotaMiddleware(bandwidthMiddleware(s.handleRemux)))
+	mux.HandleFunc("/hls/", quotaMiddleware(bandwidthMiddleware(s.handleHLS)))
+	mux.HandleFunc("/dash/", quotaMiddleware(bandwidthMiddleware(s.handleDASH)))
 	mux.HandleFunc("/thumb", s.handleThumb)
+	mux.HandleFunc("/sprite", s.handleSprite)
+	mux.HandleFunc("/preview", s.handlePreview)
+	mux.HandleFunc("/api/frame", s.handleFrame)
+	mux.HandleFunc("/subs/", s.handleSubs)
+	mux.HandleFunc("/subtitle", s.handleSubtitle)
+	mux.HandleFunc("/api/tracks", s.handleTracks)
+	mux.HandleFunc("/api/videos/", s.handleVideoAPI)
+	mux.HandleFunc("/api/progress", s.handleProgress)
+	mux.HandleFunc("/api/history", s.handleHistory)
+	mux.HandleFunc("/api/recommendations", s.handleRecommendations)
+	mux.HandleFunc("/api/browse", s.handleBrowse)
+	mux.HandleFunc("/api/search", s.handleSearch)
+	mux.HandleFunc("/api/grid", s.handleGrid)
+	mux.HandleFunc("/api/ffmpeg", s.handleFFmpeg)
+	mux.HandleFunc("/api/cache", s.handleCache)
+	mux.HandleFunc("/api/jobs/", s.handleJobs)
+	mux.HandleFunc("/api/hls/", s.handleHLSStatus)
+	mux.HandleFunc("/api/errors", s.handleErrors)
+	mux.HandleFunc("/api/speedtest", s.handleSpeedtest)
+	mux.HandleFunc("/api/device", s.handleDevice)
+	mux.HandleFunc("/api/capabilities", s.handleCapabilities)
+	mux.HandleFunc("/api/kids", s.handleKids)
+	mux.HandleFunc("/api/metadata", s.handleMetadata)
+	mux.HandleFunc("/api/durations", s.handleDurations)
+	mux.HandleFunc("/api/scrape", s.handleScrape)
+	mux.HandleFunc("/api/filters", s.handleFilters)
+	mux.HandleFunc("/api/downloads", s.handleDownloads)
+	mux.HandleFunc("/api/quota", s.handleQuota)
+	mux.HandleFunc("/api/series", s.handleSeriesAPI)
+	mux.HandleFunc("/api/playlists", s.handlePlaylists)
+	mux.HandleFunc("/api/channels", s.handleChannels)
+	mux.HandleFunc("/live/", s.handleLive)
+	mux.HandleFunc("/api/queue/random", s.handleRandomQueue)
+	mux.HandleFunc("/api/sync", s.handleSync)
+	mux.HandleFunc("/api/tags", s.handleTags)
+	mux.HandleFunc("/api/trash", s.handleTrash)
+	mux.HandleFunc("/api/convert", s.handleConvert)
+	mux.HandleFunc("/api/upload", s.handleUpload)
+	mux.HandleFunc("/api/upload/", s.handleUpload)
+	mux.HandleFunc("/series", s.handleSeries)
+	mux.HandleFunc("/errors", s.handleErrorsPage)
+	mux.HandleFunc("/api/ffmpeg/events", s.handleFFmpegEvents)
+	mux.HandleFunc("/events", s.handleEvents)
 	mux.Handle("/static/", http.FileServer(http.FS(staticFS)))
-	return http.ListenAndServe(addr, logMiddleware(mux))
+	mux.HandleFunc("/assets/", handleAssets)
+	mux.HandleFunc("/api/version", handleVersion)
+	mux.HandleFunc("/logs", s.handleLogsPage)
+	mux.HandleFunc("/admin", s.handleAdminPage)
+	mux.HandleFunc("/api/admin", s.handleAdmin)
+	mux.HandleFunc("/api/logs", s.handleLogs)
+	mux.HandleFunc("/api/logs/events", s.handleLogEvents)
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/unlock", s.handleUnlock)
+	mux.HandleFunc("/logout", s.handleLogout)
+	srv := newHTTPServer(addr, logMiddleware(compressMiddleware(recoverMiddleware(authMiddleware(s.accessMiddleware(s.clientsMiddleware(mux)))))))
+	if certFile != "" {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	}
+	return srv.ListenAndServe()
 }
 
 // responseWriter 包装，用于捕获状态码和响应大小
@@ -76,6 +189,11 @@ func (w *loggingResponseWriter) Flush() {
 	}
 }
 
+// Unwrap 返回被包装的 ResponseWriter，http.ResponseController 通过它设置连接的读写期限
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -83,9 +201,16 @@ func logMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(lw, r)
 		elapsed := time.Since(start)
 
-		// 跳过高频请求的日志
+		// 高频请求（HLS/DASH 分片、封面）记为 debug，可用 -log-level http=debug 查看
 		path := r.URL.Path
-		if strings.HasSuffix(path, ".ts") || path == "/thumb" {
+		level := "info"
+		switch {
+		case lw.statusCode >= 500:
+			level = "error"
+		case strings.HasSuffix(path, ".ts") || strings.HasSuffix(path, ".m4s") || path == "/thumb":
+			level = "debug"
+		}
+		if !logEnabled(level, "HTTP") {
 			return
 		}
 
@@ -94,9 +219,9 @@ func logMiddleware(next http.Handler) http.Handler {
 			clientIP = ip
 		}
 
-		query := r.URL.RawQuery
-		if query != "" {
-			path = path + "?" + query
+		// 地址中的 ?token= 和签名不写入日志（日志会保存到 -log-file，也能在 /logs 查看）
+		if r.URL.RawQuery != "" {
+			path = path + "?" + redactQuery(r.URL.RawQuery)
 		}
 
 		var sizeStr string
@@ -104,8 +229,8 @@ func logMiddleware(next http.Handler) http.Handler {
 			sizeStr = " " + formatSize(lw.bytes)
 		}
 
-		log.Printf("[HTTP] %s %s %d %s%s <- %s",
-			r.Method, path, lw.statusCode, elapsed.Round(time.Millisecond), sizeStr, clientIP)
+		emitLog(level, fmt.Sprintf("[HTTP] %s %s %d %s%s <- %s",
+			r.Method, path, lw.statusCode, elapsed.Round(time.Millisecond), sizeStr, clientIP))
 	})
 }
 
@@ -115,50 +240,191 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	videos, err := ScanVideos(s.videoDir)
+	l, status, err := s.listVideos(r)
 	if err != nil {
-		http.Error(w, "扫描视频目录失败", http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
-
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
 	if size <= 0 {
 		size = 20
 	}
-	total := len(videos)
-	totalPages := (total + size - 1) / size
-	if totalPages < 1 {
-		totalPages = 1
-	}
-	if page < 1 {
-		page = 1
-	}
-	if page > totalPages {
-		page = totalPages
-	}
-
-	start := (page - 1) * size
-	end := start + size
-	if end > total {
-		end = total
-	}
+	total := len(l.videos)
+	start, end, page, totalPages := paginate(total, page, size)
 
 	data := IndexData{
-		Videos:     videos[start:end],
+		Notice:     policyNotice(),
+		NoFFmpeg:   !ffmpegReady(),
+		Logout:     authPassword != "" || len(accounts) > 0,
+		User:       requestUser(r),
+		KidsMode:   kidsAvailable(),
+		Kids:       kidsMode(r),
+		Device:     deviceName(deviceID(w, r)),
+		Previews:   previewsEnabled,
+		Videos:     l.videos[start:end],
+		Query:      l.query,
+		Filter:     l.filter,
+		Res:        r.URL.Query().Get("res"),
+		Codec:      r.URL.Query().Get("codec"),
+		MinLen:     r.URL.Query().Get("minlen"),
+		MaxLen:     r.URL.Query().Get("maxlen"),
+		MinRating:  r.URL.Query().Get("minrating"),
+		Tag:        r.URL.Query().Get("tag"),
+		Tags:       listTags(),
+		Sort:       l.sortKey,
+		Order:      l.order,
+		Browse:     l.browse,
+		Path:       filepath.ToSlash(l.dir),
 		Page:       page,
 		PageSize:   size,
 		Total:      total,
 		TotalPages: totalPages,
+		params:     l.params,
+	}
+	data.Smart = l.smart
+	if page == 1 {
+		data.Recent = l.recent
+		data.Recommended = l.recommended
+		data.Folders = l.folders
+		if l.query == "" && l.filter == "" && l.smart.ID == "" && l.dir == "" && l.facets == "" {
+			data.SmartFilters = listSmartFilters()
+			data.Playlists = visiblePlaylists(r)
+			data.Channels = visibleChannels(r)
+		}
+	}
+	if l.browse {
+		data.Crumbs = breadcrumbs(l.dir)
+	}
+	if data.Kids {
+		data.Tags = kidsTagCounts(data.Tags)
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
-		log.Printf("模板渲染错误: %v", err)
+		logErrorf("模板渲染错误: %v", err)
+	}
+}
+
+// videoListing 首页列表按请求参数筛选、搜索和排序后的结果（未分页）
+type videoListing struct {
+	browse         bool
+	dir            string
+	folders        []FolderEntry
+	videos         []VideoFile
+	recent         []VideoFile
+	recommended    []Recommendation
+	smart          SmartFilter
+	filter         string
+	facets         string
+	query          string
+	sortKey, order string
+	params         url.Values // 翻页时保留的参数
+}
+
+// listVideos 按首页的参数（path、smart、filter、筛选项、q、sort）列出视频，
+// 出错时返回对应的 HTTP 状态码
+func (s *Server) listVideos(r *http.Request) (*videoListing, int, error) {
+	// ?path= 进入目录浏览模式，只列出当前目录一层
+	browse := r.URL.Query().Has("path")
+	user := requestUser(r)
+	var dir string
+	var folders []FolderEntry
+	var videos []VideoFile
+	var err error
+	if browse {
+		var ok bool
+		if dir, ok = s.cleanDirParam(r.URL.Query().Get("path")); !ok {
+			return nil, http.StatusForbidden, errors.New("无效的目录")
+		}
+		folders, videos, err = ListDir(s.videoDir, dir)
+		folders = visibleFolders(r, folders)
+		attachFolderStats(user, folders)
+	} else {
+		videos, err = ScanVideos(s.videoDir)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("扫描视频目录失败")
+	}
+	videos = visibleVideos(r, videos)
+	markWatched(user, videos)
+
+	params := url.Values{}
+	filter := r.URL.Query().Get("filter")
+	var recent []VideoFile
+	var recommended []Recommendation
+	var smart SmartFilter
+	if id := r.URL.Query().Get("smart"); id != "" && !browse {
+		var ok bool
+		if smart, ok = findSmartFilter(id); !ok {
+			return nil, http.StatusNotFound, errors.New("筛选不存在或已删除")
+		}
+		if filtered, err := FilterVideos(videos, smart.Expr); err == nil {
+			videos = filtered
+		}
+		params.Set("smart", id)
+		filter = ""
+	} else if browse {
+		params.Set("path", filepath.ToSlash(dir))
+		filter = ""
+	} else if filter == "unwatched" {
+		videos = unwatchedVideos(user, videos)
+		params.Set("filter", filter)
+	} else if filter == "favorites" {
+		videos = favoriteVideos(videos)
+		params.Set("filter", filter)
+	} else {
+		filter = ""
+	}
+
+	// ?res=1080p&codec=hevc&minlen=90m 按清晰度、编码和时长筛选，可与以上各种模式组合
+	facets, err := facetExpr(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if facets != "" {
+		videos, _ = FilterVideos(videos, facets)
+		for _, p := range facetParams {
+			if v := r.URL.Query().Get(p.param); v != "" {
+				params.Set(p.param, v)
+			}
+		}
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query != "" {
+		// 搜索框也支持筛选表达式（如「课程 duration>1h unwatched」），无法解析时按普通搜索处理
+		if filtered, err := FilterVideos(videos, query); err == nil {
+			videos = filtered
+		} else {
+			videos = SearchVideos(videos, query)
+		}
+		params.Set("q", query)
+	} else if !browse && filter == "" && smart.ID == "" && facets == "" {
+		recent = recentlyWatched(user, videos, recentLimit)
+		recommended = recommendVideos(user, videos, recommendRowLimit)
+	}
+
+	// 搜索结果默认按相关度排列，只有显式指定 sort 时才重新排序
+	sortKey, order := normalizeSort(r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+	if r.URL.Query().Has("sort") || query == "" {
+		sortVideos(videos, sortKey, order)
 	}
+	if r.URL.Query().Has("sort") {
+		params.Set("sort", sortKey)
+		params.Set("order", order)
+	}
+	return &videoListing{
+		browse: browse, dir: dir, folders: folders, videos: videos, recent: recent, recommended: recommended,
+		smart: smart, filter: filter, facets: facets, query: query,
+		sortKey: sortKey, order: order, params: params,
+	}, 0, nil
 }
 
 func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
+	if s.handlePlaylistStart(w, r) {
+		return
+	}
 	file := r.URL.Query().Get("file")
 	if file == "" {
 		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
@@ -171,44 +437,214 @@ func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fullPath := filepath.Join(s.videoDir, file)
-	useHLS := needsTranscode(fullPath) || needsStreamingMp4(fullPath)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusNotFound)
+		return
+	}
+	if reason := quotaExceeded(quotaRulesFor(w, r)); reason != "" {
+		serveQuotaPage(w, reason)
+		return
+	}
+	audio := selectAudio(r, fullPath)
+	decision := decidePlayback(fullPath, audio, profileFor(r), acceptsHEVC(r))
+	// ?precise=1 精确定位模式：总是走重新编码的 HLS（短关键帧间隔），并提供逐帧查看
+	precise := r.URL.Query().Get("precise") == "1"
+	// ?burn=N 把第 N 条内嵌 ASS/SSA 字幕连同字体附件烧录到画面，保留特效字幕的样式
+	subtitles := probeSubtitles(fullPath, hlsJobKey(fullPath, HLSOptions{}))
+	burn := burnTrack(r, subtitles)
+	// ?view=2d 3D / 全景视频转为普通 2D 画面，供不支持 3D / VR 的屏幕观看
+	stereo, projection := videoStereo(fullPath)
+	flat := r.URL.Query().Get("view") == "2d" && (stereo != "" || projection != "")
+	if precise || burn > 0 || flat {
+		decision = hlsDecision(fullPath, acceptsHEVC(r))
+		if precise {
+			decision = preciseDecision(decision)
+		}
+		if burn > 0 {
+			decision = burnDecision(decision)
+		}
+		if flat {
+			decision = flatDecision(decision)
+		}
+	}
+	blocked := decision.Blocked
+	stream := blocked == "" && decision.Mode == PlayStream
+	useHLS := blocked == "" && decision.Mode != PlayDirect && !stream
+	faststart := blocked == "" && decision.Mode == PlayDirect && faststartReady(fullPath)
 
 	// 获取所有视频用于"相关视频"展示
+	user := requestUser(r)
 	allVideos, _ := ScanVideos(s.videoDir)
+	allVideos = visibleVideos(r, allVideos)
 	var related []VideoFile
+	var sidecars []string
 	for _, v := range allVideos {
 		if v.RelPath != file {
 			related = append(related, v)
+		} else {
+			sidecars = v.Subtitles
 		}
 	}
 
 	data := struct {
-		Name    string
-		File    string
-		UseHLS  bool
-		HLSKey  string
-		Related []VideoFile
+		Name      string
+		File      string
+		UseHLS    bool
+		Faststart bool            // 直接播放 faststart 重新封装后的缓存
+		Stream    bool            // 实时封装转换（/remux）
+		Mode      string          // 播放方式：direct / stream / hls / transcode
+		Duration  int             // 视频时长（秒），实时封装转换时用于跳转
+		Precise   bool            // 精确定位模式
+		Burn      int             // 烧录到画面的内嵌字幕轨序号加 1，0 表示不烧录
+		Burnable  []SubtitleTrack // 可以烧录的内嵌 ASS/SSA 字幕
+		Stereo    string          // 3D 排列：sbs / ou，2D 为空
+		VR        string          // 全景投影：360 / 180，普通画面为空
+		Flat      bool            // 3D / 全景视频转为 2D 播放
+		FrameRate float64         // 精确定位模式下的视频帧率，用于逐帧步进
+		HLSKey    string
+		HLSURL    string // 主播放列表地址，-cdn 时带短期签名
+		Blocked   string
+		Resume    float64 // 上次播放位置（秒），任意设备中最近的一次
+		ResumeOn  string  // 上次播放的设备名称，为本设备时为空
+		Watched   bool
+		Rating    VideoRating // 收藏和用户评分
+		Pinned    bool        // 固定了缓存
+		Tags      []string    // 用户添加的标签
+		Audio     int
+		Audios    []AudioTrack
+		Subtitles []SubtitleTrack
+		Related   []VideoFile
+		Bandwidth float64 // 该设备最近测得的带宽（bit/s），0 表示需要测速
+		Bitrate   int     // 播放码率估算（bit/s），0 表示未知
+		Meta      VideoMeta
+		Info      ScrapedInfo   // TMDB 刮削结果，TMDBID 为 0 表示没有
+		Episode   string        // 剧集的季和集，如 S01E02，不是剧集时为空
+		Series    string        // 剧名
+		Next      *Episode      // 同一剧集的下一集
+		Playlist  *PlaylistView // 播放列表模式，nil 表示不是从播放列表播放
+		Playlists []Playlist    // 所有播放列表，用于「加入播放列表」
+		NextURL   string        // 播放结束后自动播放的地址：播放列表的下一项，否则为剧集的下一集
+		NextLabel string        // 如「下一集 S01E03」「下一项」
+		NextTitle string
 	}{
-		Name:    strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
-		File:    file,
-		UseHLS:  useHLS,
-		Related: related,
+		Name:      strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		Meta:      videoMeta(file),
+		Info:      scrapedInfo(file),
+		File:      file,
+		UseHLS:    useHLS,
+		Faststart: faststart,
+		Stream:    stream,
+		Mode:      playModeName(decision.Mode),
+		Precise:   precise,
+		Burn:      burn,
+		Stereo:    stereo,
+		VR:        projection,
+		Flat:      flat,
+		Blocked:   blocked,
+		Audio:     audio,
+		Audios:    probeAudioTracks(fullPath, audio),
+		Subtitles: subtitles,
+		Related:   related,
 	}
 
+	if data.Meta.Title != "" {
+		data.Name = data.Meta.Title
+	} else if data.Info.Title != "" {
+		data.Name = data.Info.Title
+	}
+	device := deviceID(w, r)
+	data.Bandwidth = recentBandwidth(device)
+	if entry, ok := LatestProgress(user, file); ok {
+		data.Resume = entry.Position
+		if entry.Device != device {
+			data.ResumeOn = deviceName(entry.Device)
+		}
+	}
+	data.Watched = isWatched(user, file)
+	data.Rating = videoRating(user, file)
+	data.Pinned = videoPinned(file)
+	data.Tags = tagsOf(file)
+	if name, season, episode, ok := parseEpisode(file); ok {
+		data.Series = name
+		data.Episode = Episode{Season: season, Episode: episode}.Label()
+		data.Next = nextEpisode(groupSeries(allVideos), file)
+	}
+	data.Playlists = visiblePlaylists(r)
+	if p := playlistView(r, file, allVideos); p != nil {
+		data.Playlist = p
+		if next := p.Next(); next >= 0 {
+			data.NextURL, data.NextLabel, data.NextTitle = p.ItemURL(next), "下一项", p.Items[next].Name
+		}
+	} else if data.Next != nil {
+		data.NextURL = "/play?file=" + url.QueryEscape(data.Next.Video.RelPath)
+		data.NextLabel, data.NextTitle = "下一集 "+data.Next.Label(), data.Next.Video.Name
+	}
+	RecordPlay(user, file)
+
+	for _, sub := range sidecars {
+		data.Subtitles = append(data.Subtitles, sidecarTrack(file, sub))
+	}
+	for _, t := range subtitles {
+		if burnable(t) {
+			data.Burnable = append(data.Burnable, t)
+		}
+	}
+	// 烧录字幕时不再默认显示文本字幕，避免画面上出现两层字幕
+	if burn == 0 {
+		markDefaultSubtitle(r, data.Subtitles)
+	}
+	if precise {
+		data.FrameRate = videoFrameRate(fullPath)
+	}
+	if stream {
+		data.Duration = durationSeconds(getDuration(fullPath))
+	}
+
+	if !useHLS && blocked == "" {
+		data.Bitrate = streamBitrate(fullPath, false, false)
+	}
 	if useHLS {
-		data.HLSKey = hlsJobKey(fullPath)
+		opts := HLSOptions{Audio: audio, HEVC: outputHEVC(r, decision), FMP4: wantsFMP4(r), Precise: precise, Burn: burn, Flat: flat,
+			Passthrough: audioPassthrough(r, fullPath, audio)}
+		data.HLSKey = hlsJobKey(fullPath, opts)
+		data.HLSURL = hlsPlaylistURL(data.HLSKey)
+		data.Bitrate = streamBitrate(fullPath, decision.Mode == PlayTranscode, opts.HEVC)
 		// 预启动 HLS 转码
-		if _, err := getOrStartHLS(fullPath); err != nil {
-			log.Printf("[HLS] 启动失败: %v", err)
+		if _, err := getOrStartHLS(fullPath, opts, device); err != nil {
+			logErrorf("[HLS] 启动失败: %v", err)
 		}
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := templates.ExecuteTemplate(w, "player.html", data); err != nil {
-		log.Printf("模板渲染错误: %v", err)
+		logErrorf("模板渲染错误: %v", err)
 	}
 }
 
+// handleTracks 返回视频的音轨和字幕轨列表（JSON）
+func (s *Server) handleTracks(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+
+	fullPath := filepath.Join(s.videoDir, file)
+	writeJSON(w, struct {
+		Audios    []AudioTrack
+		Subtitles []SubtitleTrack
+	}{
+		Audios:    probeAudioTracks(fullPath, 0),
+		Subtitles: probeSubtitles(fullPath, hlsJobKey(fullPath, HLSOptions{})),
+	})
+}
+
 func (s *Server) handleVideo(w http.ResponseWriter, r *http.Request) {
 	file := r.URL.Query().Get("file")
 	if file == "" {
@@ -222,10 +658,59 @@ func (s *Server) handleVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fullPath := filepath.Join(s.videoDir, file)
-	// 只有原生 MP4（且 moov 在前面）才走直接提供
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusGone)
+		return
+	}
+	// ?vr=1 以带投影后缀的文件名提供原始视频，VR 播放器据此选择投影方式
+	if r.URL.Query().Get("vr") == "1" {
+		w.Header().Set("Content-Disposition", vrDisposition(fullPath))
+	}
+	// 播放页渲染时已就绪才会带 faststart=1，保证同一次播放的 Range 请求始终读同一个文件
+	if r.URL.Query().Get("faststart") == "1" {
+		cached := faststartPath(fullPath)
+		if _, err := os.Stat(cached); err == nil {
+			http.ServeFile(w, r, cached)
+			return
+		}
+	}
 	http.ServeFile(w, r, fullPath)
 }
 
+// handleDownload 以附件形式提供原始视频或外挂字幕，文件名为原文件名；
+// 支持 Range 和 If-Range（ETag 由大小和修改时间生成），下载工具可以断点续传
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) && !s.isValidSubtitlePath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+
+	fullPath := filepath.Join(s.videoDir, file)
+	f, err := os.Open(fullPath)
+	if err != nil {
+		if videoExts[strings.ToLower(filepath.Ext(file))] {
+			notifySourceMissing(fullPath)
+		}
+		http.Error(w, errSourceGone, http.StatusGone)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(w, errSourceGone, http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(fullPath)}))
+	w.Header().Set("ETag", fileETag(info))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
 // handleHLS 提供 HLS 分片文件（m3u8 和 ts）
 func (s *Server) handleHLS(w http.ResponseWriter, r *http.Request) {
 	// URL: /hls/{key}/{filename}
@@ -245,35 +730,27 @@ func (s *Server) handleHLS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查找对应的 HLS 任务并更新访问时间
-	TouchHLS(key)
-
-	hlsJobsMu.Lock()
-	job, ok := hlsJobs[key]
-	hlsJobsMu.Unlock()
-
-	// 任务不在内存中，但磁盘缓存可能存在
-	var hlsDir string
-	if ok {
-		hlsDir = job.Dir
-	} else {
-		cacheDir := filepath.Join(hlsCacheDir, key)
-		if isCacheComplete(cacheDir) {
-			hlsDir = cacheDir
-		} else {
-			http.Error(w, "转码任务不存在或已结束", http.StatusNotFound)
-			return
-		}
+	hlsDir, ok := jobDir(w, r, key)
+	if !ok {
+		return
 	}
 
+	// 只提供播放列表和分片，缓存目录中的其他文件（如 manifest.json）不对外
+	ct, ok := hlsContentTypes[filepath.Ext(fileName)]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 	filePath := filepath.Join(hlsDir, fileName)
+	w.Header().Set("Content-Type", ct)
 
-	// m3u8 可能还在生成中，等待文件出现且包含至少一个 .ts 引用
+	// m3u8 可能还在生成中，等待媒体播放列表出现且包含至少一个分片
 	if strings.HasSuffix(fileName, ".m3u8") {
+		streamPath := filepath.Join(hlsDir, "stream.m3u8")
 		ready := false
 		for i := 0; i < 150; i++ { // 最多等 15 秒
-			data, err := os.ReadFile(filePath)
-			if err == nil && strings.Contains(string(data), ".ts") {
+			data, err := os.ReadFile(streamPath)
+			if err == nil && strings.Contains(string(data), "#EXTINF") {
 				ready = true
 				break
 			}
@@ -283,45 +760,112 @@ func (s *Server) handleHLS(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "m3u8 not ready", http.StatusServiceUnavailable)
 			return
 		}
-		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-		w.Header().Set("Cache-Control", "no-cache")
-	} else if strings.HasSuffix(fileName, ".ts") {
-		// ts 分片可能还在写入，等待文件出现
-		ready := false
-		for i := 0; i < 300; i++ { // 最多等 30 秒
-			if _, err := os.Stat(filePath); err == nil {
-				ready = true
-				break
-			}
-			time.Sleep(100 * time.Millisecond)
+		// 旧版本缓存没有主播放列表，直接提供媒体播放列表
+		if _, err := os.Stat(filePath); err != nil && fileName == "master.m3u8" {
+			filePath = streamPath
 		}
-		if !ready {
-			http.Error(w, "ts segment not ready", http.StatusServiceUnavailable)
+		setHLSCacheHeaders(w, hlsDir, true)
+		servePlaylist(w, r, key, filePath)
+		return
+	}
+	if ext := filepath.Ext(fileName); ext == ".ts" || ext == ".m4s" {
+		// 分片可能还在写入，等待文件出现
+		if !waitForFile(filePath, 30*time.Second) {
+			http.Error(w, "segment not ready", http.StatusServiceUnavailable)
 			return
 		}
-		w.Header().Set("Content-Type", "video/mp2t")
 	}
+	setHLSCacheHeaders(w, hlsDir, false)
+
+	serveCachedFile(w, r, filePath)
+}
+
+// jobDir 查找转码任务的输出目录并更新访问时间；任务不在内存中时使用已完成的磁盘缓存
+// 源文件丢失、排队中或任务不存在时直接写出错误响应，返回 false
+func jobDir(w http.ResponseWriter, r *http.Request, key string) (string, bool) {
+	TouchHLS(key)
+
+	hlsJobsMu.Lock()
+	job, ok := hlsJobs[key]
+	hlsJobsMu.Unlock()
 
-	http.ServeFile(w, r, filePath)
+	if !ok {
+		cacheDir := filepath.Join(hlsCacheDir, key)
+		if isCacheComplete(cacheDir) {
+			return cacheDir, true
+		}
+		// 服务重启前未完成的转码：从中断处继续，已有分片照常提供
+		if job = resumeHLSJob(key, deviceID(w, r)); job == nil {
+			http.Error(w, "转码任务不存在或已结束", http.StatusNotFound)
+			return "", false
+		}
+	}
+	if job.gone.Load() {
+		http.Error(w, errSourceGone, http.StatusGone)
+		return "", false
+	}
+	// 排队中的任务还没有任何输出，告知播放器稍后重试
+	if pos := scheduler.position(job); pos > 0 {
+		w.Header().Set("X-Transcode-Status", "queued")
+		w.Header().Set("X-Queue-Position", strconv.Itoa(pos))
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, fmt.Sprintf("转码排队中（第 %d 位）", pos), http.StatusServiceUnavailable)
+		return "", false
+	}
+	return job.Dir, true
+}
+
+// waitForFile 等待转码输出的文件出现，超时返回 false
+func waitForFile(path string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+// writeJSON 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logWarnf("JSON 输出错误: %v", err)
+	}
 }
 
 // isValidPath 校验路径安全性，防止目录穿越
 func (s *Server) isValidPath(relPath string) bool {
-	if relPath == "" {
+	if !s.isSafeRelPath(relPath) {
 		return false
 	}
+	ext := strings.ToLower(filepath.Ext(relPath))
+	return videoExts[ext]
+}
 
-	cleaned := filepath.Clean(relPath)
+// isValidSubtitlePath 校验外挂字幕路径
+func (s *Server) isValidSubtitlePath(relPath string) bool {
+	if !s.isSafeRelPath(relPath) {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(relPath))
+	return subtitleExts[ext]
+}
 
-	if filepath.IsAbs(cleaned) || strings.HasPrefix(cleaned, "..") {
+// isSafeRelPath 相对路径必须落在视频目录内
+func (s *Server) isSafeRelPath(relPath string) bool {
+	if relPath == "" {
 		return false
 	}
 
-	full := filepath.Join(s.videoDir, cleaned)
-	if !strings.HasPrefix(full, s.videoDir+string(os.PathSeparator)) {
+	cleaned := filepath.Clean(relPath)
+
+	if filepath.IsAbs(cleaned) || strings.HasPrefix(cleaned, "..") || inTrash(cleaned) {
 		return false
 	}
 
-	ext := strings.ToLower(filepath.Ext(cleaned))
-	return videoExts[ext]
+	full := filepath.Join(s.videoDir, cleaned)
+	return strings.HasPrefix(full, s.videoDir+string(os.PathSeparator))
 }
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const filtersFile = "filters.json"
+
+// SmartFilter 保存的筛选条件，在首页显示为虚拟文件夹
+type SmartFilter struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Expr string `json:"expr"` // 筛选表达式，语法见 parseFilterExpr
+}
+
+var (
+	smartFilters   []SmartFilter
+	smartFiltersMu sync.Mutex
+)
+
+// InitSmartFilters 从数据目录加载保存的筛选
+func InitSmartFilters() error {
+	smartFiltersMu.Lock()
+	defer smartFiltersMu.Unlock()
+	return loadJSON(filtersFile, &smartFilters)
+}
+
+// listSmartFilters 返回所有保存的筛选
+func listSmartFilters() []SmartFilter {
+	smartFiltersMu.Lock()
+	defer smartFiltersMu.Unlock()
+	return append([]SmartFilter(nil), smartFilters...)
+}
+
+// findSmartFilter 按 ID 查找保存的筛选
+func findSmartFilter(id string) (SmartFilter, bool) {
+	for _, f := range listSmartFilters() {
+		if f.ID == id {
+			return f, true
+		}
+	}
+	return SmartFilter{}, false
+}
+
+// numRange 数值条件，min/max 为 -1 表示不限
+type numRange struct{ min, max int64 }
+
+func anyRange() numRange { return numRange{-1, -1} }
+
+func (r numRange) match(n int64) bool {
+	return (r.min < 0 || n >= r.min) && (r.max < 0 || n <= r.max)
+}
+
+// filterSpec 解析后的筛选表达式
+type filterSpec struct {
+	text     string   // 其余的词按名称搜索
+	watched  *bool    // 是否看完，nil 表示不限
+	favorite bool     // 只匹配收藏的视频
+	stars    numRange // 用户评分
+	duration numRange // 秒
+	size     numRange // 字节
+	year     numRange
+	res      numRange // 清晰度档位，见 qualityRank
+	dirs     []string
+	exts     []string
+	codecs   []string
+	tags     []string
+}
+
+// parseFilterExpr 解析筛选表达式，条件之间用空格（或 &）分隔，全部满足才命中：
+//
+//	unwatched / watched      未看 / 已看（也可写作「未看」「已看」）
+//	favorite                 已收藏（也可写作「收藏」）
+//	rating>=4                用户评分（星级），rating:0 为未评分
+//	duration>1h  duration<=45m   时长，单位 h/m/s，省略单位为分钟
+//	size>4G                  文件大小
+//	year>=2000  year:2010    自定义年份
+//	dir:课程                 所在目录（任一层目录名，或从根开始的路径前缀）
+//	ext:mkv                  扩展名
+//	res:4k  res>=1080p       清晰度（sd / 720p / 1080p / 4k），只匹配已探测过分辨率的视频
+//	codec:hevc               视频编码（h265 / x265 视为 hevc，avc / x264 视为 h264）
+//	tag:kids                 带有该标签（不区分大小写）
+//	其他词                   按名称搜索（同搜索框）
+func parseFilterExpr(expr string) (filterSpec, error) {
+	spec := filterSpec{duration: anyRange(), size: anyRange(), year: anyRange(), res: anyRange(), stars: anyRange()}
+	var text []string
+	for _, term := range strings.Fields(strings.ReplaceAll(expr, "&", " ")) {
+		lower := strings.ToLower(term)
+		switch {
+		case lower == "unwatched" || term == "未看":
+			spec.watched = new(bool)
+		case lower == "watched" || term == "已看":
+			spec.watched = new(bool)
+			*spec.watched = true
+		case lower == "favorite" || term == "收藏":
+			spec.favorite = true
+		case strings.HasPrefix(lower, "dir:"):
+			spec.dirs = append(spec.dirs, strings.Trim(filepath.ToSlash(term[4:]), "/"))
+		case strings.HasPrefix(lower, "ext:"):
+			spec.exts = append(spec.exts, "."+strings.TrimPrefix(lower[4:], "."))
+		case strings.HasPrefix(lower, "tag:"):
+			spec.tags = append(spec.tags, term[4:])
+		case strings.HasPrefix(lower, "codec:"):
+			spec.codecs = append(spec.codecs, codecAlias(lower[6:]))
+		case strings.HasPrefix(lower, "duration"), strings.HasPrefix(lower, "size"), strings.HasPrefix(lower, "year"), strings.HasPrefix(lower, "rating"),
+			len(lower) > 3 && strings.HasPrefix(lower, "res") && strings.ContainsRune("<>=:", rune(lower[3])):
+			if err := spec.parseCompare(lower); err != nil {
+				return spec, err
+			}
+		default:
+			text = append(text, term)
+		}
+	}
+	spec.text = strings.Join(text, " ")
+	return spec, nil
+}
+
+// parseCompare 解析 字段+比较符+值，如 duration>1h、size<=2G、year:2010
+func (spec *filterSpec) parseCompare(term string) error {
+	i := strings.IndexAny(term, "<>=:")
+	if i < 0 {
+		return fmt.Errorf("无效的条件 %q", term)
+	}
+	field, rest := term[:i], term[i:]
+	op := rest[:1]
+	if len(rest) > 1 && rest[1] == '=' {
+		op = rest[:2]
+	}
+	value := rest[len(op):]
+
+	var n int64
+	var err error
+	var target *numRange
+	switch field {
+	case "duration":
+		n, err = parseFilterDuration(value)
+		target = &spec.duration
+	case "size":
+		n, err = parseByteSize(value)
+		target = &spec.size
+	case "year":
+		n, err = strconv.ParseInt(value, 10, 64)
+		target = &spec.year
+	case "rating":
+		n, err = strconv.ParseInt(value, 10, 64)
+		target = &spec.stars
+	case "res":
+		if n = int64(qualityRank(value)); n == 0 {
+			err = fmt.Errorf("未知的清晰度 %q", value)
+		}
+		target = &spec.res
+	default:
+		return fmt.Errorf("未知的筛选字段 %q", field)
+	}
+	if err != nil || value == "" {
+		return fmt.Errorf("无效的条件 %q", term)
+	}
+	switch op {
+	case ">":
+		target.min = n + 1
+	case ">=":
+		target.min = n
+	case "<":
+		target.max = n - 1
+	case "<=":
+		target.max = n
+	default: // = 或 :
+		target.min, target.max = n, n
+	}
+	return nil
+}
+
+// parseFilterDuration 解析时长：1h、90m、1h30m、45s，纯数字为分钟
+func parseFilterDuration(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n * 60, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(d.Seconds()), nil
+}
+
+// match 视频是否满足除名称搜索外的所有条件
+func (spec filterSpec) match(v VideoFile) bool {
+	if spec.watched != nil && v.Watched != *spec.watched {
+		return false
+	}
+	if spec.favorite && !v.Favorite || !spec.stars.match(int64(v.Stars)) {
+		return false
+	}
+	if !spec.size.match(v.Size) || !spec.duration.match(int64(durationSeconds(v.Duration))) {
+		return false
+	}
+	if (spec.year.min >= 0 || spec.year.max >= 0) && (v.Year == 0 || !spec.year.match(int64(v.Year))) {
+		return false
+	}
+	if len(spec.exts) > 0 && !containsFold(spec.exts, filepath.Ext(v.RelPath)) {
+		return false
+	}
+	if (spec.res.min >= 0 || spec.res.max >= 0) && (v.Quality == "" || !spec.res.match(int64(qualityRank(v.Quality)))) {
+		return false
+	}
+	if len(spec.codecs) > 0 && !containsFold(spec.codecs, v.Codec) {
+		return false
+	}
+	for _, t := range spec.tags {
+		if !containsFold(v.Tags, t) {
+			return false
+		}
+	}
+	dir := filepath.ToSlash(filepath.Dir(v.RelPath))
+	for _, d := range spec.dirs {
+		if !inDir(dir, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// inDir 目录 dir（/ 分隔）是否以 want 为前缀，或任一层目录名为 want（不区分大小写）
+func inDir(dir, want string) bool {
+	dir, want = strings.ToLower(dir), strings.ToLower(want)
+	if dir == want || strings.HasPrefix(dir, want+"/") {
+		return true
+	}
+	for _, part := range strings.Split(dir, "/") {
+		if part == want {
+			return true
+		}
+	}
+	return false
+}
+
+// codecAlias 常见的编码别名统一为 ffprobe 的编码名称
+func codecAlias(s string) string {
+	switch s {
+	case "h265", "x265":
+		return "hevc"
+	case "avc", "x264":
+		return "h264"
+	}
+	return s
+}
+
+func containsFold(list []string, s string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterVideos 按筛选表达式过滤（视频需已填充观看状态），有名称搜索词时按匹配度排序
+func FilterVideos(videos []VideoFile, expr string) ([]VideoFile, error) {
+	spec, err := parseFilterExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	var result []VideoFile
+	for _, v := range videos {
+		if spec.match(v) {
+			result = append(result, v)
+		}
+	}
+	return SearchVideos(result, spec.text), nil
+}
+
+// facetParams 列表的筛选参数（首页、/api/search、/api/browse 通用）及对应的筛选表达式
+var facetParams = []struct{ param, term string }{
+	{"res", "res:"},           // res=1080p
+	{"codec", "codec:"},       // codec=hevc
+	{"minlen", "duration>="},  // minlen=90m
+	{"maxlen", "duration<="},  // maxlen=30m
+	{"minrating", "rating>="}, // minrating=4
+	{"tag", "tag:"},           // tag=kids
+}
+
+// facetExpr 把 ?res=1080p&codec=hevc&minlen=90m 转为筛选表达式，没有筛选参数时返回空
+func facetExpr(q url.Values) (string, error) {
+	var terms []string
+	for _, p := range facetParams {
+		v := strings.TrimSpace(q.Get(p.param))
+		if v == "" {
+			continue
+		}
+		if strings.ContainsAny(v, " &") {
+			return "", fmt.Errorf("无效的 %s 参数", p.param)
+		}
+		terms = append(terms, p.term+strings.ToLower(v))
+	}
+	expr := strings.Join(terms, " ")
+	if _, err := parseFilterExpr(expr); err != nil {
+		return "", err
+	}
+	return expr, nil
+}
+
+// handleFilters GET 列出保存的筛选；POST {"name":"未看的课程","expr":"dir:课程 unwatched"} 保存；DELETE ?id= 删除
+func (s *Server) handleFilters(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, listSmartFilters())
+	case http.MethodPost:
+		var f SmartFilter
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		f.Name, f.Expr = strings.TrimSpace(f.Name), strings.TrimSpace(f.Expr)
+		if f.Name == "" || f.Expr == "" || utf8.RuneCountInString(f.Name) > 64 {
+			http.Error(w, "名称和筛选条件不能为空", http.StatusBadRequest)
+			return
+		}
+		if _, err := parseFilterExpr(f.Expr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		buf := make([]byte, 4)
+		rand.Read(buf)
+		f.ID = hex.EncodeToString(buf)
+		smartFiltersMu.Lock()
+		smartFilters = append(smartFilters, f)
+		err := saveJSON(filtersFile, smartFilters)
+		smartFiltersMu.Unlock()
+		if err != nil {
+			log.Printf("[筛选] 保存失败: %v", err)
+		}
+		writeJSON(w, f)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		smartFiltersMu.Lock()
+		for i, f := range smartFilters {
+			if f.ID == id {
+				smartFilters = append(smartFilters[:i], smartFilters[i+1:]...)
+				break
+			}
+		}
+		err := saveJSON(filtersFile, smartFilters)
+		smartFiltersMu.Unlock()
+		if err != nil {
+			log.Printf("[筛选] 保存失败: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// smartThumbs 智能封面（-smart-thumbs）：裁掉黑边，并从片头之后的一段画面中挑选有代表性的一帧
+var smartThumbs bool
+
+const (
+	// smartThumbWindow thumbnail 滤镜从多少帧中挑选一帧（24fps 下约 5 秒）
+	smartThumbWindow = 120
+	// cropDetectSeconds 检测黑边时分析的时长
+	cropDetectSeconds = 8
+)
+
+// cropDetectPattern cropdetect 输出中的裁剪参数，如 crop=1920:800:0:140
+var cropDetectPattern = regexp.MustCompile(`crop=(\d+):(\d+):(\d+):(\d+)`)
+
+// smartThumbSeek 截取封面的位置：跳过片头（黑屏、片商标志），取时长的 1/10，在 5 秒到 10 分钟之间
+func smartThumbSeek(duration int) int {
+	if duration <= 0 {
+		return 5
+	}
+	if duration < 20 {
+		return 0
+	}
+	return min(max(duration/10, 5), 600)
+}
+
+// detectCrop 用 cropdetect 检测 pos 秒之后一段画面的黑边，返回 crop 滤镜参数；
+// 没有黑边或检测结果不可信（如整段都是暗场，裁掉超过一半画面）时返回空
+func detectCrop(videoPath string, pos int) string {
+	cmd := exec.Command(ffmpegPath(), "-hide_banner", "-nostats",
+		"-ss", strconv.Itoa(pos), "-i", videoPath, "-t", strconv.Itoa(cropDetectSeconds),
+		"-map", "0:v:0", "-vf", "cropdetect=limit=24:round=2:reset=0", "-an", "-sn", "-f", "null", "-")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	matches := cropDetectPattern.FindAllStringSubmatch(string(out), -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	m := matches[len(matches)-1] // reset=0 时最后一次的结果覆盖整段画面
+	w, _ := strconv.Atoi(m[1])
+	h, _ := strconv.Atoi(m[2])
+	x, _ := strconv.Atoi(m[3])
+	y, _ := strconv.Atoi(m[4])
+	if w <= 0 || h <= 0 || x == 0 && y == 0 {
+		return "" // 没有黑边
+	}
+	// 裁剪后的画面面积不足原画面（w+2x, h+2y）的一半时视为误判
+	if w*h*2 < (w+2*x)*(h+2*y) {
+		return ""
+	}
+	return fmt.Sprintf("crop=%d:%d:%d:%d", w, h, x, y)
+}
+
+// generateSmartThumb 智能封面：跳过片头，裁掉黑边，用 thumbnail 滤镜从一段画面中选出最有代表性的一帧
+// （直方图最接近平均值，会避开黑屏和转场）
+func generateSmartThumb(videoPath, outPath string) error {
+	pos := smartThumbSeek(durationSeconds(getDuration(videoPath)))
+	vf := fmt.Sprintf("thumbnail=%d,scale=320:-2", smartThumbWindow)
+	if crop := detectCrop(videoPath, pos); crop != "" {
+		vf = crop + "," + vf
+	}
+	cmd := exec.Command(ffmpegPath(), "-ss", strconv.Itoa(pos), "-i", videoPath,
+		"-map", "0:v:0", "-vf", vf, "-frames:v", "1", "-q:v", "6", "-y", outPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v\n%s", err, out)
+	}
+	if info, err := os.Stat(outPath); err != nil || info.Size() == 0 {
+		return fmt.Errorf("没有输出画面")
+	}
+	return nil
+}
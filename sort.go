@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortKeys 列表支持的排序字段及其默认顺序（名称升序，其余默认降序：最新、最大、最长在前）
+var sortKeys = map[string]string{
+	"name":     "asc",
+	"size":     "desc",
+	"mtime":    "desc",
+	"duration": "desc",
+	"rating":   "desc",
+}
+
+// normalizeSort 校验排序参数，未知字段回退到按名称排序，未指定顺序时使用该字段的默认顺序
+func normalizeSort(key, order string) (string, string) {
+	def, ok := sortKeys[key]
+	if !ok {
+		key, def = "name", sortKeys["name"]
+	}
+	if order != "asc" && order != "desc" {
+		order = def
+	}
+	return key, order
+}
+
+// sortVideos 按 key/order 原地排序，相同值按名称排序保证顺序稳定
+func sortVideos(videos []VideoFile, key, order string) {
+	less := func(a, b *VideoFile) int {
+		switch key {
+		case "size":
+			return compareInt64(a.Size, b.Size)
+		case "mtime":
+			return a.ModTime.Compare(b.ModTime)
+		case "duration":
+			return compareInt64(int64(durationSeconds(a.Duration)), int64(durationSeconds(b.Duration)))
+		case "rating":
+			// 用户评分优先，相同时按收藏和 TMDB 评分
+			if c := compareInt64(int64(a.Stars), int64(b.Stars)); c != 0 {
+				return c
+			}
+			if a.Favorite != b.Favorite {
+				if a.Favorite {
+					return 1
+				}
+				return -1
+			}
+			return cmp.Compare(a.Rating, b.Rating)
+		}
+		return 0
+	}
+	sort.SliceStable(videos, func(i, j int) bool {
+		c := less(&videos[i], &videos[j])
+		if c == 0 {
+			c = strings.Compare(videos[i].Name, videos[j].Name)
+			if key != "name" {
+				return c < 0
+			}
+		}
+		if order == "desc" {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// durationSeconds 将 "1:23:45" / "23:45" 格式的时长转为秒数，无法解析时返回 0
+func durationSeconds(d string) int {
+	if d == "" {
+		return 0
+	}
+	total := 0
+	for _, part := range strings.Split(d, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return total
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	speedtestDefaultSize = 2 << 20
+	speedtestMaxSize     = 16 << 20
+	// speedSampleTTL 测速结果的有效期，网络环境可能随时变化（如从 WiFi 切到移动网络）
+	speedSampleTTL = 10 * time.Minute
+)
+
+// speedtestBlock 测速数据块（随机数据，避免被压缩），按需重复发送
+var speedtestBlock = func() []byte {
+	b := make([]byte, 256<<10)
+	rand.Read(b)
+	return b
+}()
+
+// speedSample 一次测速结果
+type speedSample struct {
+	BPS  float64   `json:"bps"`
+	Time time.Time `json:"time"`
+}
+
+var (
+	// speedSamples 设备 ID -> 最近一次测速结果
+	speedSamples   = make(map[string]speedSample)
+	speedSamplesMu sync.Mutex
+)
+
+// recentBandwidth 设备最近测得的带宽（bit/s），没有有效结果时返回 0
+func recentBandwidth(device string) float64 {
+	speedSamplesMu.Lock()
+	defer speedSamplesMu.Unlock()
+	s, ok := speedSamples[device]
+	if !ok || time.Since(s.Time) > speedSampleTTL {
+		return 0
+	}
+	return s.BPS
+}
+
+// handleSpeedtest GET ?size=N 返回 N 字节测速数据；POST {"bytes":N,"ms":T} 上报客户端测得的下载耗时
+func (s *Server) handleSpeedtest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if size <= 0 {
+			size = speedtestDefaultSize
+		}
+		size = min(size, speedtestMaxSize)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Header().Set("Cache-Control", "no-store")
+		for size > 0 {
+			n := min(size, len(speedtestBlock))
+			if _, err := w.Write(speedtestBlock[:n]); err != nil {
+				return
+			}
+			size -= n
+		}
+	case http.MethodPost:
+		var req struct {
+			Bytes int64   `json:"bytes"`
+			MS    float64 `json:"ms"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Bytes <= 0 || req.MS <= 0 {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		sample := speedSample{BPS: float64(req.Bytes) * 8 / (req.MS / 1000), Time: time.Now()}
+		speedSamplesMu.Lock()
+		speedSamples[deviceID(w, r)] = sample
+		speedSamplesMu.Unlock()
+		writeJSON(w, sample)
+	default:
+		http.Error(w, "不支持的方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// streamBitrate 估算播放时的总码率（bit/s）：重新编码时为固定码率，否则按文件大小和时长估算，未知时返回 0
+func streamBitrate(filePath string, transcode, hevc bool) int {
+	if transcode {
+		if hevc {
+			return hevcVideoBitrate + hlsAudioBitrate
+		}
+		return transcodeVideoBitrate + hlsAudioBitrate
+	}
+	info, err := os.Stat(filePath)
+	secs := durationSeconds(getDuration(filePath))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return int(info.Size() * 8 / int64(secs))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 拖动进度条时的预览图（trick-play）：每隔 spriteInterval 秒截一帧，拼成一张 JPEG，
+// 再用 WebVTT 描述每段时间对应的图块（#xywh=）
+const (
+	spriteTileWidth  = 160
+	spriteTileHeight = 90
+	spriteColumns    = 10
+	spriteInterval   = 10
+	// spriteMaxFrames 长视频加大截帧间隔，控制图片尺寸
+	spriteMaxFrames = 600
+)
+
+var spriteCacheDir string
+
+// InitSpriteCache 初始化预览图缓存目录
+func InitSpriteCache() error {
+	spriteCacheDir = filepath.Join(cacheRoot, "sprites")
+	return os.MkdirAll(spriteCacheDir, 0755)
+}
+
+// spritePath 预览图缓存路径，与封面使用相同的缓存 key
+func spritePath(videoPath string) string {
+	return filepath.Join(spriteCacheDir, mediaCacheKey(videoPath)+".jpg")
+}
+
+// spriteLayout 按视频时长计算截帧间隔（秒）和帧数
+func spriteLayout(secs int) (interval, frames int) {
+	interval = max(spriteInterval, (secs+spriteMaxFrames-1)/spriteMaxFrames)
+	return interval, (secs + interval - 1) / interval
+}
+
+// ensureSprite 确保预览图已生成，同一视频的并发请求只生成一次
+func ensureSprite(videoPath string, secs int) (string, error) {
+	cached := spritePath(videoPath)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	_, err, _ := probeGroup.Do(cached, func() (any, error) {
+		if _, err := os.Stat(cached); err == nil {
+			return nil, nil
+		}
+		return nil, generateSprite(videoPath, cached, secs)
+	})
+	return cached, err
+}
+
+// generateSprite 只解码关键帧按间隔截图并平铺成一张 JPEG，先写临时文件再重命名
+func generateSprite(videoPath, cachePath string, secs int) error {
+	interval, frames := spriteLayout(secs)
+	rows := (frames + spriteColumns - 1) / spriteColumns
+	outPath := strings.TrimSuffix(cachePath, ".jpg") + ".tmp.jpg"
+	defer os.Remove(outPath)
+
+	filter := fmt.Sprintf("fps=1/%d,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		interval, spriteTileWidth, spriteTileHeight, spriteTileWidth, spriteTileHeight, spriteColumns, rows)
+	cmd := exec.Command(ffmpegPath(),
+		"-skip_frame", "nokey", "-i", videoPath,
+		"-an", "-sn", "-vf", filter, "-frames:v", "1", "-q:v", "5", "-y", outPath)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		if info, statErr := os.Stat(outPath); statErr == nil && info.Size() > 0 {
+			return os.Rename(outPath, cachePath)
+		}
+		err = fmt.Errorf("ffmpeg 未输出图片")
+	}
+	log.Printf("[预览图] 生成失败 %s: %v\n%s", filepath.Base(videoPath), err, string(out))
+	return err
+}
+
+// spriteVTT 生成 WebVTT 缩略图轨道，每个 cue 指向图片中的一个图块；
+// 图片地址带上缓存 key，视频文件替换后浏览器不会用到旧图
+func spriteVTT(file, key string, secs int) string {
+	interval, frames := spriteLayout(secs)
+	img := "/sprite?file=" + url.QueryEscape(file) + "&img=1&v=" + key
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := range frames {
+		start, end := i*interval, min((i+1)*interval, secs)
+		x := (i % spriteColumns) * spriteTileWidth
+		y := (i / spriteColumns) * spriteTileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), img, x, y, spriteTileWidth, spriteTileHeight)
+	}
+	return b.String()
+}
+
+// vttTimestamp 秒数格式化为 WebVTT 时间戳 HH:MM:SS.000
+func vttTimestamp(secs int) string {
+	return fmt.Sprintf("%02d:%02d:%02d.000", secs/3600, secs/60%60, secs%60)
+}
+
+// handleSprite 提供拖动预览：/sprite?file=xxx 返回 WebVTT，加 &img=1 返回拼接后的图片
+func (s *Server) handleSprite(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+	if !s.isValidPath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+	fullPath := filepath.Join(s.videoDir, file)
+	if sourceMissing(fullPath) {
+		notifySourceMissing(fullPath)
+		http.Error(w, errSourceGone, http.StatusNotFound)
+		return
+	}
+	if !ffmpegReady() {
+		http.Error(w, "ffmpeg 未就绪", http.StatusServiceUnavailable)
+		return
+	}
+	secs := durationSeconds(getDuration(fullPath))
+	if secs <= 0 {
+		http.Error(w, "无法获取视频时长", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("img") == "" {
+		vtt := spriteVTT(file, mediaCacheKey(fullPath), secs)
+		sum := sha256.Sum256([]byte(vtt))
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(vtt))
+		return
+	}
+	cached, err := ensureSprite(fullPath, secs)
+	if err != nil {
+		http.Error(w, "预览图生成失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	serveCachedFile(w, r, cached)
+}
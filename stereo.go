@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 3D 视频的画面排列
+const (
+	StereoSBS = "sbs" // 左右排列（Side-by-Side）
+	StereoOU  = "ou"  // 上下排列（Over-Under / Top-Bottom）
+)
+
+var (
+	// sbsNamePattern 文件名中的左右 3D 标记，如 3D.HSBS、Half-SBS、_LR、_3dh
+	sbsNamePattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:h(?:alf)?[ ._-]?sbs|f?sbs|lr|3dh)(?:$|[^a-z0-9])`)
+	// ouNamePattern 文件名中的上下 3D 标记，如 3D.HOU、Half-OU、TAB、_TB、_3dv
+	ouNamePattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:h(?:alf)?[ ._-]?(?:ou|tab)|ou|tab|tb|3dv)(?:$|[^a-z0-9])`)
+	// vrNamePattern 文件名中的全景标记，如 VR180、_180_LR、_360_TB、MONO_360，单独的 180/360 不视为全景
+	vrNamePattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:vr[ ._-]?(180|360)|(180|360)[ ._-]?(?:vr|lr|tb|sbs|ou|3dh|3dv|mono)|(?:lr|tb|sbs|ou|mono)[ ._-]?(180|360))(?:$|[^a-z0-9])`)
+)
+
+// stereoFromName 从文件名识别 3D 排列和全景投影（VR 播放器通用的命名约定）
+func stereoFromName(name string) (stereo, projection string) {
+	if m := vrNamePattern.FindStringSubmatch(name); m != nil {
+		projection = m[1] + m[2] + m[3]
+	}
+	switch {
+	case sbsNamePattern.MatchString(name):
+		stereo = StereoSBS
+	case ouNamePattern.MatchString(name):
+		stereo = StereoOU
+	}
+	return stereo, projection
+}
+
+// stereoFromSideData 从视频流的 Stereo 3D / Spherical Mapping 信息和 MKV 的 stereo_mode 标签识别
+func stereoFromSideData(stereoType, projectionType, stereoMode string) (stereo, projection string) {
+	switch {
+	case strings.Contains(stereoType, "side by side"), stereoMode == "left_right", stereoMode == "right_left":
+		stereo = StereoSBS
+	case strings.Contains(stereoType, "top and bottom"), stereoMode == "top_bottom", stereoMode == "bottom_top":
+		stereo = StereoOU
+	}
+	switch {
+	case strings.Contains(projectionType, "half"):
+		projection = "180"
+	case strings.Contains(projectionType, "equirectangular"):
+		projection = "360"
+	}
+	return stereo, projection
+}
+
+// applyStereoInfo 探测结果中没有 3D / 全景信息时按文件名识别
+func applyStereoInfo(v *VideoFile) {
+	stereo, projection := stereoFromName(v.Name)
+	if v.Stereo == "" {
+		v.Stereo = stereo
+	}
+	if v.Projection == "" {
+		v.Projection = projection
+	}
+}
+
+// videoStereo 视频的 3D 排列和全景投影，优先使用已缓存的探测结果
+func videoStereo(filePath string) (stereo, projection string) {
+	v := VideoFile{Name: strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))}
+	if p, ok := cachedMediaInfo(filePath); ok {
+		if video := buildMediaInfo("", p).Video; video != nil {
+			v.Stereo, v.Projection = video.Stereo, video.Projection
+		}
+	}
+	applyStereoInfo(&v)
+	return v.Stereo, v.Projection
+}
+
+// flatFilter 3D / 全景视频转为普通 2D 画面的滤镜，返回转换后的分辨率：
+// 3D 只保留左眼画面，半宽（Half-SBS）和半高（Half-OU）拉伸回原比例；全景画面投影为正前方 16:9 视野
+func flatFilter(stereo, projection string, width, height int) (string, int, int) {
+	var filters []string
+	switch stereo {
+	case StereoSBS:
+		filters = append(filters, "crop=iw/2:ih:0:0")
+		// 整幅宽高比小于 2.5 时为半宽 3D（如 1920x1080），拉伸回原宽度
+		if projection == "" && width*2 < height*5 {
+			filters = append(filters, "scale=iw*2:ih")
+		} else {
+			width /= 2
+		}
+	case StereoOU:
+		filters = append(filters, "crop=iw:ih/2:0:0")
+		// 整幅宽高比大于 1.2 时为半高 3D（如 1920x1080），拉伸回原高度
+		if projection == "" && width*5 > height*6 {
+			filters = append(filters, "scale=iw:ih*2")
+		} else {
+			height /= 2
+		}
+	}
+	switch projection {
+	case "360":
+		filters = append(filters, "v360=input=e:output=flat:h_fov=100:v_fov=56.25:w=1920:h=1080")
+		width, height = 1920, 1080
+	case "180":
+		filters = append(filters, "v360=input=hequirect:output=flat:h_fov=100:v_fov=56.25:w=1920:h=1080")
+		width, height = 1920, 1080
+	}
+	filters = append(filters, "setsar=1")
+	return strings.Join(filters, ","), width, height
+}
+
+// vrFileName VR 播放器（DeoVR、Skybox 等）按文件名后缀识别投影和 3D 排列，如 movie_180_LR.mp4
+func vrFileName(name, stereo, projection string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	// 文件名已经带有可识别的标记时保持原样
+	if s, p := stereoFromName(base); s == stereo && p == projection {
+		return name
+	}
+	var suffix []string
+	if projection != "" {
+		suffix = append(suffix, projection)
+	}
+	switch stereo {
+	case StereoSBS:
+		suffix = append(suffix, "LR")
+	case StereoOU:
+		suffix = append(suffix, "TB")
+	default:
+		suffix = append(suffix, "MONO")
+	}
+	return fmt.Sprintf("%s_%s%s", base, strings.Join(suffix, "_"), ext)
+}
+
+// vrDisposition /video?vr=1 的 Content-Disposition，用带投影后缀的文件名提供原始视频
+func vrDisposition(filePath string) string {
+	stereo, projection := videoStereo(filePath)
+	return mime.FormatMediaType("inline", map[string]string{"filename": vrFileName(filepath.Base(filePath), stereo, projection)})
+}
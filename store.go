@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// dataDir 用户数据目录（播放进度等），与缓存分开存放，不会被 -clear-cache 清除
+var dataDir string
+
+// defaultDataDir 用户数据目录 ~/.config/localcinema
+func defaultDataDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "localcinema")
+}
+
+// InitDataDir 初始化用户数据目录
+func InitDataDir() error {
+	if _, err := os.UserHomeDir(); err != nil {
+		return err
+	}
+	dataDir = defaultDataDir()
+	return os.MkdirAll(dataDir, 0755)
+}
+
+// loadJSON 读取数据目录下的 JSON 文件，文件不存在时保持 v 不变
+func loadJSON(name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(dataDir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON 写入数据目录下的 JSON 文件，先写临时文件再重命名，避免断电时留下半个文件
+func saveJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dataDir, name), data)
+}
+
+// writeFileAtomic 先写同目录下的临时文件再重命名，读者要么看到旧文件要么看到完整的新文件
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
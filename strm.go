@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamURL 视频原文件的播放地址；启用访问保护时附带签名，外部播放器无需登录即可播放
+func streamURL(baseURL, rel string) string {
+	file := filepath.ToSlash(rel)
+	u := strings.TrimRight(baseURL, "/") + "/video?file=" + url.QueryEscape(file)
+	if authEnabled() {
+		u += "&sig=" + signStream(file)
+	}
+	return u
+}
+
+// exportStrm 在 outDir 下按视频目录结构为每个视频生成同名 .strm 文件（Kodi 等播放器可直接导入），
+// 内容为指向 baseURL 的播放地址；媒体库中已不存在的视频对应的 .strm 文件会被删除
+func exportStrm(videoDir, outDir, baseURL string) (written, removed int, err error) {
+	want := make(map[string]bool)
+	err = walkVideos(videoDir, func(rel string, info os.FileInfo) {
+		if err != nil {
+			return
+		}
+		path := filepath.Join(outDir, strings.TrimSuffix(rel, filepath.Ext(rel))+".strm")
+		want[path] = true
+		content := streamURL(baseURL, rel) + "\n"
+		if old, e := os.ReadFile(path); e == nil && string(old) == content {
+			return
+		}
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			err = e
+			return
+		}
+		if e := writeFileAtomic(path, []byte(content)); e != nil {
+			err = e
+			return
+		}
+		written++
+	})
+	if err != nil {
+		return written, removed, fmt.Errorf("写入 .strm 失败: %w", err)
+	}
+
+	// 清理过期的 .strm 文件，其他文件（如 Kodi 生成的 .nfo）保持不动
+	filepath.Walk(outDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".strm" || want[path] {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	return written, removed, nil
+}
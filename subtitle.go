@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// textSubtitleCodecs 可转换为 WebVTT 的文本字幕编码（PGS/VobSub 等图形字幕无法转换）
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// SubtitleTrack 播放页可用的字幕轨
+type SubtitleTrack struct {
+	Index   int    // 字幕流序号（对应 -map 0:s:N 中的 N）
+	Lang    string // 语言代码，如 chi / eng
+	Label   string // 显示名称
+	Codec   string // 内嵌字幕的编码，如 ass / subrip；外挂字幕为空
+	URL     string
+	Default bool // 符合语言偏好，默认显示
+}
+
+var (
+	subsCacheDir string
+
+	// subsSources 记录 key -> 视频完整路径，供 /subs/ 按需提取
+	subsSources   = make(map[string]string)
+	subsSourcesMu sync.Mutex
+
+	// subsInflight 正在提取中的字幕，避免同一字幕重复启动 ffmpeg
+	subsInflight   = make(map[string]chan struct{})
+	subsInflightMu sync.Mutex
+)
+
+// InitSubtitleCache 初始化字幕缓存目录
+func InitSubtitleCache() error {
+	subsCacheDir = filepath.Join(cacheRoot, "subs")
+	return os.MkdirAll(subsCacheDir, 0755)
+}
+
+// probeSubtitles 探测视频内嵌的文本字幕轨，并登记 key 以便后续提取
+func probeSubtitles(filePath, key string) []SubtitleTrack {
+	streams, err := probeStreams(filePath, "s")
+	if err != nil {
+		return nil
+	}
+
+	var tracks []SubtitleTrack
+	for i, st := range streams {
+		if !textSubtitleCodecs[st.CodecName] {
+			continue
+		}
+		label := st.Tags.Title
+		if label == "" {
+			label = st.Tags.Language
+		}
+		if label == "" {
+			label = fmt.Sprintf("字幕 %d", i+1)
+		}
+		tracks = append(tracks, SubtitleTrack{
+			Index: i,
+			Lang:  st.Tags.Language,
+			Label: label,
+			Codec: st.CodecName,
+			URL:   fmt.Sprintf("/subs/%s/%d.vtt", key, i),
+		})
+	}
+
+	if len(tracks) > 0 {
+		subsSourcesMu.Lock()
+		subsSources[key] = filePath
+		subsSourcesMu.Unlock()
+	}
+	return tracks
+}
+
+// subtitleCachePath 字幕缓存路径
+func subtitleCachePath(key string, track int) string {
+	return filepath.Join(subsCacheDir, key, fmt.Sprintf("%d.vtt", track))
+}
+
+// extractSubtitle 使用 ffmpeg 将第 track 条字幕流转换为 WebVTT
+func extractSubtitle(filePath string, track int, outPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	tmp := outPath + ".tmp"
+	cmd := exec.Command(ffmpegPath(),
+		"-loglevel", "error",
+		"-i", filePath,
+		"-map", fmt.Sprintf("0:s:%d", track),
+		"-f", "webvtt",
+		"-y", tmp,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		log.Printf("[字幕] 提取失败 %s #%d: %v\n%s", filepath.Base(filePath), track, err, string(out))
+		return err
+	}
+	return os.Rename(tmp, outPath)
+}
+
+// ensureSubtitle 确保字幕已提取到缓存，同一字幕的并发请求只提取一次
+func ensureSubtitle(key string, track int) (string, error) {
+	outPath := subtitleCachePath(key, track)
+	if _, err := os.Stat(outPath); err == nil {
+		return outPath, nil
+	}
+
+	subsSourcesMu.Lock()
+	src, ok := subsSources[key]
+	subsSourcesMu.Unlock()
+	if !ok {
+		return "", os.ErrNotExist
+	}
+
+	id := fmt.Sprintf("%s/%d", key, track)
+	subsInflightMu.Lock()
+	if ch, ok := subsInflight[id]; ok {
+		subsInflightMu.Unlock()
+		<-ch
+		if _, err := os.Stat(outPath); err != nil {
+			return "", err
+		}
+		return outPath, nil
+	}
+	ch := make(chan struct{})
+	subsInflight[id] = ch
+	subsInflightMu.Unlock()
+
+	err := extractSubtitle(src, track, outPath)
+
+	subsInflightMu.Lock()
+	delete(subsInflight, id)
+	subsInflightMu.Unlock()
+	close(ch)
+
+	if err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+// handleSubs 提供内嵌字幕的 WebVTT 文件
+func (s *Server) handleSubs(w http.ResponseWriter, r *http.Request) {
+	// URL: /subs/{key}/{track}.vtt
+	path := strings.TrimPrefix(r.URL.Path, "/subs/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], ".vtt") {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := parts[0]
+	track, err := strconv.Atoi(strings.TrimSuffix(parts[1], ".vtt"))
+	if err != nil || track < 0 || !isHexKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 受限的请求者只能访问来源视频已知且能访问的字幕（见 requestedPaths），
+	// 重启后还没有打开过播放页的字幕缓存不提供给他们
+	if viewerOf(r).restricted() && s.subsSourceRel(key) == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	outPath, err := ensureSubtitle(key, track)
+	if err != nil {
+		http.Error(w, "字幕不存在或提取失败", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, outPath)
+}
+
+// isHexKey 校验缓存 key 只包含十六进制字符
+func isHexKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// subtitleExts 支持的外挂字幕格式
+var subtitleExts = map[string]bool{
+	".srt": true,
+	".ass": true,
+	".ssa": true,
+	".vtt": true,
+}
+
+// isSidecarOf 判断字幕文件是否属于视频（同名，或同名加语言后缀如 movie.zh.srt）
+func isSidecarOf(videoName, subName string) bool {
+	subBase := strings.TrimSuffix(subName, filepath.Ext(subName))
+	videoBase := strings.TrimSuffix(videoName, filepath.Ext(videoName))
+	return subBase == videoBase || strings.HasPrefix(subBase, videoBase+".")
+}
+
+// sidecarTrack 外挂字幕对应的播放页字幕轨
+func sidecarTrack(videoRel, subRel string) SubtitleTrack {
+	videoBase := strings.TrimSuffix(filepath.Base(videoRel), filepath.Ext(videoRel))
+	subBase := strings.TrimSuffix(filepath.Base(subRel), filepath.Ext(subRel))
+	lang := strings.TrimPrefix(strings.TrimPrefix(subBase, videoBase), ".")
+	label := lang
+	if label == "" {
+		label = "外挂字幕"
+	}
+	return SubtitleTrack{
+		Index: -1,
+		Lang:  lang,
+		Label: label,
+		URL:   "/subtitle?file=" + url.QueryEscape(subRel),
+	}
+}
+
+// handleSubtitle 将外挂 SRT/ASS 字幕实时转换为 WebVTT
+func (s *Server) handleSubtitle(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		http.Error(w, "缺少 file 参数", http.StatusBadRequest)
+		return
+	}
+
+	if !s.isValidSubtitlePath(file) {
+		http.Error(w, "无效的文件路径", http.StatusForbidden)
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.videoDir, file))
+	if err != nil {
+		http.Error(w, "字幕不存在", http.StatusNotFound)
+		return
+	}
+	text := decodeSubtitleText(data)
+
+	var vtt string
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".srt":
+		vtt = srtToVTT(text)
+	case ".ass", ".ssa":
+		vtt = assToVTT(text)
+	default:
+		vtt = text
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, vtt)
+}
+
+// decodeSubtitleText 处理 BOM 和 GBK 编码（国内字幕组常见），统一返回 UTF-8 文本
+func decodeSubtitleText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		if decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n")
+}
+
+// srtToVTT SRT 与 WebVTT 基本一致，只需加文件头并把时间戳中的逗号换成点
+func srtToVTT(text string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, "-->") {
+			line = strings.ReplaceAll(line, ",", ".")
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// assOverrideTags 匹配 ASS 特效标签，如 {\an8}{\fs20}
+var assOverrideTags = regexp.MustCompile(`\{[^}]*\}`)
+
+// assToVTT 提取 ASS [Events] 中的 Dialogue 行，丢弃样式只保留文字
+func assToVTT(text string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+
+	fields := []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+	inEvents := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+		if strings.HasPrefix(line, "Format:") {
+			fields = nil
+			for _, f := range strings.Split(strings.TrimPrefix(line, "Format:"), ",") {
+				fields = append(fields, strings.ToLower(strings.TrimSpace(f)))
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "Dialogue:") {
+			continue
+		}
+
+		// Text 为最后一个字段，可能包含逗号
+		values := strings.SplitN(strings.TrimPrefix(line, "Dialogue:"), ",", len(fields))
+		if len(values) != len(fields) {
+			continue
+		}
+		var start, end, content string
+		for i, f := range fields {
+			switch f {
+			case "start":
+				start = assTimeToVTT(values[i])
+			case "end":
+				end = assTimeToVTT(values[i])
+			case "text":
+				content = values[i]
+			}
+		}
+		if start == "" || end == "" {
+			continue
+		}
+		content = assOverrideTags.ReplaceAllString(content, "")
+		content = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(content)
+		content = strings.TrimSpace(content)
+		if content == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", start, end, content)
+	}
+	return b.String()
+}
+
+// assTimeToVTT 将 ASS 时间 "0:01:02.34"（百分之一秒）转为 "00:01:02.340"
+func assTimeToVTT(t string) string {
+	var h, m, s, cs int
+	if _, err := fmt.Sscanf(strings.TrimSpace(t), "%d:%d:%d.%d", &h, &m, &s, &cs); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, cs*10)
+}
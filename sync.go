@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// syncMaxBody 同步请求体的大小上限
+const syncMaxBody = 64 << 20
+
+var (
+	// syncPeers 同步观看状态的其他实例地址（-peers）
+	syncPeers []string
+	// syncToken 访问其他实例时使用的令牌（-peer-token），对应对方的 -token
+	syncToken string
+	// syncInterval 同步间隔（-sync-interval）
+	syncInterval = 5 * time.Minute
+	syncClient   = &http.Client{Timeout: 30 * time.Second}
+)
+
+// SyncState 在实例之间同步的观看状态：播放进度、观看记录、收藏和评分，按视频相对路径对应，
+// 各实例的视频目录需要有相同的目录结构。顶层为共享的观看状态，Users 为每个账号各自的状态
+// （账号名 -> 状态，其中不再嵌套 Users），只合并两边都定义了的账号
+type SyncState struct {
+	Progress map[string]map[string]ProgressEntry `json:"progress"`
+	History  map[string]HistoryEntry             `json:"history"`
+	Ratings  map[string]VideoRating              `json:"ratings"`
+	Users    map[string]SyncState                `json:"users,omitempty"`
+}
+
+// parsePeers 解析 -peers：逗号分隔的实例地址，如 http://home:8080,https://office.example.com
+func parsePeers(spec string) error {
+	var peers []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimRight(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		u, err := url.Parse(p)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("无效的实例地址 %q", p)
+		}
+		peers = append(peers, p)
+	}
+	syncPeers = peers
+	return nil
+}
+
+// localSyncState 当前实例的观看状态快照，包括每个账号的状态
+func localSyncState() SyncState {
+	state := userSyncState("")
+	state.Users = make(map[string]SyncState)
+	for _, name := range accountNames() {
+		state.Users[name] = userSyncState(name)
+	}
+	return state
+}
+
+// userSyncState 账号的观看状态快照，账号名为空时为共享的状态
+func userSyncState(user string) SyncState {
+	state := SyncState{
+		Progress: make(map[string]map[string]ProgressEntry),
+		History:  make(map[string]HistoryEntry),
+		Ratings:  make(map[string]VideoRating),
+	}
+	progressMu.Lock()
+	for file, devices := range progressOfLocked(user) {
+		m := make(map[string]ProgressEntry, len(devices))
+		for d, e := range devices {
+			m[d] = e
+		}
+		state.Progress[file] = m
+	}
+	progressMu.Unlock()
+	historyMu.Lock()
+	for file, e := range historyOfLocked(user) {
+		state.History[file] = e
+	}
+	historyMu.Unlock()
+	ratingsMu.Lock()
+	for file, r := range ratingsOfLocked(user) {
+		state.Ratings[file] = r
+	}
+	ratingsMu.Unlock()
+	return state
+}
+
+// mergeSyncState 合并其他实例的共享观看状态和本实例也定义了的账号的观看状态
+func mergeSyncState(remote SyncState) {
+	mergeUserState("", remote)
+	for name, state := range remote.Users {
+		if accounts[name] != nil {
+			mergeUserState(name, state)
+		}
+	}
+}
+
+// mergeUserState 合并账号的观看状态（只接受视频目录内的相对路径），各项以修改时间较新的为准：
+// 播放次数和最近播放时间取较大值；看完的时间晚于某个播放进度时丢弃该进度（已在另一处看完）
+func mergeUserState(user string, remote SyncState) {
+	historyMu.Lock()
+	history := historyOfLocked(user)
+	historyChanged := false
+	for file, re := range remote.History {
+		if !filepath.IsLocal(file) {
+			continue
+		}
+		e, ok := history[file]
+		merged := e
+		merged.LastPlayed = max(e.LastPlayed, re.LastPlayed)
+		merged.PlayCount = max(e.PlayCount, re.PlayCount)
+		if re.WatchedAt > e.WatchedAt {
+			merged.Watched, merged.WatchedAt = re.Watched, re.WatchedAt
+		}
+		if !ok || merged != e {
+			history[file] = merged
+			historyChanged = true
+		}
+	}
+	watchedAt := make(map[string]int64)
+	for file, e := range history {
+		if e.Watched {
+			watchedAt[file] = e.WatchedAt
+		}
+	}
+	if historyChanged {
+		saveHistoryLocked(user)
+	}
+	historyMu.Unlock()
+
+	progressMu.Lock()
+	progress := progressOfLocked(user)
+	progressChanged := false
+	for file, devices := range remote.Progress {
+		if !filepath.IsLocal(file) {
+			continue
+		}
+		for d, re := range devices {
+			if re.UpdatedAt <= watchedAt[file] {
+				continue
+			}
+			if e, ok := progress[file][d]; ok && e.UpdatedAt >= re.UpdatedAt {
+				continue
+			}
+			if progress[file] == nil {
+				progress[file] = make(map[string]ProgressEntry)
+			}
+			progress[file][d] = re
+			progressChanged = true
+		}
+	}
+	for file, at := range watchedAt {
+		for d, e := range progress[file] {
+			if e.UpdatedAt <= at {
+				delete(progress[file], d)
+				progressChanged = true
+			}
+		}
+		if len(progress[file]) == 0 {
+			delete(progress, file)
+		}
+	}
+	if progressChanged {
+		saveProgressLocked(user)
+	}
+	progressMu.Unlock()
+
+	ratingsMu.Lock()
+	ratings := ratingsOfLocked(user)
+	ratingsChanged := false
+	for file, rr := range remote.Ratings {
+		if !filepath.IsLocal(file) {
+			continue
+		}
+		if r, ok := ratings[file]; ok && r.UpdatedAt >= rr.UpdatedAt {
+			continue
+		}
+		ratings[file] = rr
+		ratingsChanged = true
+	}
+	if ratingsChanged {
+		saveRatingsLocked(user)
+	}
+	ratingsMu.Unlock()
+}
+
+// syncWithPeer 把本地状态发给对方，对方合并后返回其完整状态，再合并到本地
+func syncWithPeer(peer string) error {
+	body, err := json.Marshal(localSyncState())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, peer+"/api/sync", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if syncToken != "" {
+		req.Header.Set("Authorization", "Bearer "+syncToken)
+	}
+	resp, err := syncClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var remote SyncState
+	if err := json.NewDecoder(io.LimitReader(resp.Body, syncMaxBody)).Decode(&remote); err != nil {
+		return err
+	}
+	mergeSyncState(remote)
+	return nil
+}
+
+// StartSync 启动后及之后每隔 syncInterval 与各实例同步一次观看状态
+func StartSync() {
+	if len(syncPeers) == 0 || syncInterval <= 0 {
+		return
+	}
+	logInfof("[同步] 与 %s 同步观看状态，间隔 %s", strings.Join(syncPeers, "、"), syncInterval)
+	go func() {
+		failing := make(map[string]bool)
+		for {
+			for _, peer := range syncPeers {
+				err := syncWithPeer(peer)
+				// 对方离线时只在状态变化时记录日志，避免刷屏
+				if err != nil && !failing[peer] {
+					logWarnf("[同步] %s 同步失败: %v", peer, err)
+				} else if err == nil && failing[peer] {
+					logInfof("[同步] %s 已恢复", peer)
+				}
+				failing[peer] = err != nil
+			}
+			time.Sleep(syncInterval)
+		}
+	}()
+}
+
+// handleSync GET 返回本实例的观看状态；POST 合并请求体中其他实例的状态，返回合并后的状态。
+// 只允许管理员或携带访问令牌（-token）的请求，跨地点同步时两边都应设置访问令牌
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) && !tokenAuthorized(r) {
+		http.Error(w, "需要设置 -token 并使用访问令牌同步", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var remote SyncState
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, syncMaxBody)).Decode(&remote); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		mergeSyncState(remote)
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, localSyncState())
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	tagsFile     = "tags.json"
+	tagMaxLen    = 32
+	tagsPerVideo = 20
+)
+
+var (
+	// videoTags 视频相对路径 -> 标签
+	videoTags   = make(map[string][]string)
+	videoTagsMu sync.Mutex
+)
+
+// InitTags 从数据目录加载视频标签
+func InitTags() error {
+	videoTagsMu.Lock()
+	defer videoTagsMu.Unlock()
+	return loadJSON(tagsFile, &videoTags)
+}
+
+func saveTagsLocked() {
+	if err := saveJSON(tagsFile, videoTags); err != nil {
+		log.Printf("[标签] 保存失败: %v", err)
+	}
+}
+
+// normalizeTags 去掉首尾空白和重复的标签（不区分大小写，保留第一次出现的写法）；
+// 标签会用在筛选表达式 tag:xxx 中，不能包含空白、逗号和 &
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if utf8.RuneCountInString(t) > tagMaxLen || strings.ContainsAny(t, ",&") || strings.ContainsFunc(t, unicode.IsSpace) {
+			return nil, fmt.Errorf("无效的标签 %q：不能包含空格、逗号和 &，最长 %d 个字", t, tagMaxLen)
+		}
+		if !containsFold(result, t) {
+			result = append(result, t)
+		}
+	}
+	if len(result) > tagsPerVideo {
+		return nil, fmt.Errorf("每个视频最多 %d 个标签", tagsPerVideo)
+	}
+	return result, nil
+}
+
+// tagsOf 视频的标签
+func tagsOf(rel string) []string {
+	videoTagsMu.Lock()
+	defer videoTagsMu.Unlock()
+	return append([]string{}, videoTags[rel]...)
+}
+
+// setTags 替换视频的标签，为空时删除记录
+func setTags(rel string, tags []string) {
+	videoTagsMu.Lock()
+	defer videoTagsMu.Unlock()
+	if len(tags) == 0 {
+		delete(videoTags, rel)
+	} else {
+		videoTags[rel] = tags
+	}
+	saveTagsLocked()
+}
+
+// markTags 填充列表中每个视频的标签
+func markTags(videos []VideoFile) {
+	videoTagsMu.Lock()
+	defer videoTagsMu.Unlock()
+	for i := range videos {
+		videos[i].Tags = videoTags[videos[i].RelPath]
+	}
+}
+
+// TagCount 标签及使用该标签的视频数量
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// listTags 所有标签，按使用次数从多到少，不区分大小写合并
+func listTags() []TagCount {
+	videoTagsMu.Lock()
+	defer videoTagsMu.Unlock()
+	counts := make(map[string]*TagCount)
+	for _, tags := range videoTags {
+		for _, t := range tags {
+			key := strings.ToLower(t)
+			if counts[key] == nil {
+				counts[key] = &TagCount{Name: t}
+			}
+			counts[key].Count++
+		}
+	}
+	result := make([]TagCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// renameTag 把所有视频上的标签 from 改为 to（不区分大小写），to 为空时删除该标签，返回受影响的视频数量
+func renameTag(from, to string) int {
+	videoTagsMu.Lock()
+	defer videoTagsMu.Unlock()
+	n := 0
+	for rel, tags := range videoTags {
+		var updated []string
+		changed := false
+		for _, t := range tags {
+			if !strings.EqualFold(t, from) {
+				updated = append(updated, t)
+				continue
+			}
+			changed = true
+			if to != "" && !containsFold(updated, to) {
+				updated = append(updated, to)
+			}
+		}
+		if !changed {
+			continue
+		}
+		n++
+		if len(updated) == 0 {
+			delete(videoTags, rel)
+		} else {
+			videoTags[rel] = updated
+		}
+	}
+	if n > 0 {
+		saveTagsLocked()
+	}
+	return n
+}
+
+// handleTags 管理所有标签：
+//
+//	GET /api/tags                     所有标签及视频数量
+//	PUT /api/tags?name=旧  {"name":"新"}  重命名（与已有标签同名时合并）
+//	DELETE /api/tags?name=            从所有视频上移除该标签
+//
+// 重命名和删除影响所有视频（包括儿童模式允许的标签），仅管理员
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if r.Method != http.MethodGet && !isAdminRequest(r) {
+		http.Error(w, "仅限管理员访问", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, listTags())
+
+	case http.MethodPut:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		to, err := normalizeTags([]string{req.Name})
+		if err != nil || len(to) == 0 || name == "" {
+			http.Error(w, "无效的标签", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]int{"updated": renameTag(name, to[0])})
+
+	case http.MethodDelete:
+		if name == "" {
+			http.Error(w, "缺少 name 参数", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]int{"updated": renameTag(name, "")})
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleVideoTags 单个视频的标签：GET 查询；PUT {"tags":[...]} 整体替换；
+// POST {"tags":[...]} 追加；DELETE ?tag= 移除一个，不带参数时清空
+func (s *Server) handleVideoTags(w http.ResponseWriter, r *http.Request, file string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, tagsOf(file))
+		return
+
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求", http.StatusBadRequest)
+			return
+		}
+		tags := req.Tags
+		if r.Method == http.MethodPost {
+			tags = append(tagsOf(file), tags...)
+		}
+		tags, err := normalizeTags(tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setTags(file, tags)
+
+	case http.MethodDelete:
+		var tags []string
+		if tag := r.URL.Query().Get("tag"); tag != "" {
+			for _, t := range tagsOf(file) {
+				if !strings.EqualFold(t, tag) {
+					tags = append(tags, t)
+				}
+			}
+		}
+		setTags(file, tags)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, tagsOf(file))
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"crypto/md5"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sync"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
 )
 
 func servePlaceholder(w http.ResponseWriter, r *http.Request) {
@@ -22,34 +24,66 @@ func servePlaceholder(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-var (
-	thumbCacheDir string
-	thumbOnce     sync.Once
-)
+var thumbCacheDir string
 
 // InitThumbCache 初始化封面缓存目录
 func InitThumbCache() error {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-	thumbCacheDir = filepath.Join(home, ".cache", "localcinema", "thumbs")
+	thumbCacheDir = filepath.Join(cacheRoot, "thumbs")
 	return os.MkdirAll(thumbCacheDir, 0755)
 }
 
-// thumbPath 封面缓存路径（基于视频路径+修改时间）
-func thumbPath(videoPath string) string {
+// mediaCacheKey 封面、预览图等派生文件的缓存 key（基于视频路径+修改时间，文件替换后自动失效）
+func mediaCacheKey(videoPath string) string {
 	info, _ := os.Stat(videoPath)
 	var mtime int64
 	if info != nil {
 		mtime = info.ModTime().UnixNano()
 	}
 	h := md5.Sum([]byte(fmt.Sprintf("%s|%d", videoPath, mtime)))
-	return filepath.Join(thumbCacheDir, fmt.Sprintf("%x.jpg", h[:8]))
+	key := fmt.Sprintf("%x", h[:8])
+	if info != nil {
+		rememberMediaKey(videoPath, key)
+	}
+	return key
 }
 
-// generateThumb 使用 ffmpeg 截取视频封面
-func generateThumb(videoPath, outPath string) error {
+// thumbPath 封面缓存路径，智能封面单独缓存，开关 -smart-thumbs 后重新生成
+func thumbPath(videoPath string) string {
+	if smartThumbs {
+		return filepath.Join(thumbCacheDir, mediaCacheKey(videoPath)+"-smart.jpg")
+	}
+	return filepath.Join(thumbCacheDir, mediaCacheKey(videoPath)+".jpg")
+}
+
+// ensureThumb 确保封面已生成，返回缓存路径；同一视频的并发请求只生成一次
+func ensureThumb(videoPath string) (string, error) {
+	cached := thumbPath(videoPath)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	_, err, _ := probeGroup.Do(cached, func() (any, error) {
+		// 可能在上一轮合并结束后刚刚生成
+		if _, err := os.Stat(cached); err == nil {
+			return nil, nil
+		}
+		return nil, generateThumb(videoPath, cached)
+	})
+	return cached, err
+}
+
+// generateThumb 使用 ffmpeg 截取视频封面，先写临时文件再重命名，避免读到半个 JPEG
+func generateThumb(videoPath, cachePath string) error {
+	outPath := strings.TrimSuffix(cachePath, ".jpg") + ".tmp.jpg"
+	defer os.Remove(outPath)
+
+	if smartThumbs {
+		err := generateSmartThumb(videoPath, outPath)
+		if err == nil {
+			return os.Rename(outPath, cachePath)
+		}
+		logWarnf("[封面] 智能封面失败，改用普通截图 %s: %v", filepath.Base(videoPath), err)
+	}
+
 	// 多种策略依次尝试
 	attempts := [][]string{
 		// 1. 跳到第 5 秒截取
@@ -75,12 +109,12 @@ func generateThumb(videoPath, outPath string) error {
 		lastOutput, lastErr = cmd.CombinedOutput()
 		if lastErr == nil {
 			if info, err := os.Stat(outPath); err == nil && info.Size() > 0 {
-				return nil
+				return os.Rename(outPath, cachePath)
 			}
 		}
 	}
 
-	log.Printf("[封面] 生成失败 %s: %v\n%s", filepath.Base(videoPath), lastErr, string(lastOutput))
+	logErrorf("[封面] 生成失败 %s: %v\n%s", filepath.Base(videoPath), lastErr, string(lastOutput))
 	return lastErr
 }
 
@@ -98,17 +132,51 @@ func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fullPath := filepath.Join(s.videoDir, file)
-	cached := thumbPath(fullPath)
-
-	// 检查缓存
-	if _, err := os.Stat(cached); err != nil {
-		// 缓存不存在，生成
-		if err := generateThumb(fullPath, cached); err != nil {
-			servePlaceholder(w, r)
-			return
-		}
+	cached, err := ensureThumb(fullPath)
+	if err != nil {
+		servePlaceholder(w, r)
+		return
+	}
+	// 顺带探测分辨率和编码，之后的列表中显示清晰度标签
+	if _, ok := cachedMediaInfo(fullPath); !ok && ffmpegReady() {
+		go probeMediaInfo(fullPath)
 	}
 
 	w.Header().Set("Cache-Control", "public, max-age=86400")
-	http.ServeFile(w, r, cached)
+	serveCachedFile(w, r, cached)
+}
+
+// pregenerateWorkers 后台补全封面和时长的并发数（-pregenerate），0 表示启动时不预生成
+var pregenerateWorkers int
+
+// backfillMedia 遍历视频目录，并发补全缺少的时长缓存、探测结果和封面（启用 -previews 时还有预览短片），
+// 避免首次打开首页时逐个现场生成
+func backfillMedia(videoDir string) {
+	var files []string
+	walkVideos(videoDir, func(rel string, _ os.FileInfo) {
+		files = append(files, filepath.Join(videoDir, rel))
+	})
+
+	var g errgroup.Group
+	g.SetLimit(max(pregenerateWorkers, 1))
+	var generated atomic.Int64
+	for _, path := range files {
+		g.Go(func() error {
+			waitQuietHours("补全封面")
+			getDuration(path)
+			probeMediaInfo(path)
+			if previewsEnabled {
+				ensurePreview(path)
+			}
+			if _, err := os.Stat(thumbPath(path)); err == nil {
+				return nil
+			}
+			if _, err := ensureThumb(path); err == nil {
+				generated.Add(1)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	logInfof("[封面] 补全完成: %d 个视频，新生成 %d 个封面", len(files), generated.Load())
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// readHeaderTimeout 读取请求头的时限，防止慢速发送请求头（slowloris）占住连接
+	readHeaderTimeout = 10 * time.Second
+	// readTimeout 读取整个请求（含请求体）的时限，最大的请求体是多实例同步的状态
+	readTimeout = 2 * time.Minute
+	// idleTimeout keep-alive 连接空闲多久后关闭
+	idleTimeout = 2 * time.Minute
+	// maxHeaderBytes 请求头大小上限
+	maxHeaderBytes = 64 << 10
+
+	// writeTimeout 普通请求写完响应的时限（现场生成封面、雪碧图可能需要较长时间）
+	writeTimeout = 5 * time.Minute
+	// streamWriteIdle 视频流和事件流不限制总时长，但客户端超过该时间没有接收任何数据时断开
+	// （暂停播放时浏览器会停止读取，继续播放时重新请求）
+	streamWriteIdle = 5 * time.Minute
+	// deadlineRefreshEvery 流式响应延长写入期限的最小间隔，避免每次写入都重设定时器
+	deadlineRefreshEvery = 10 * time.Second
+	// uploadReadIdle 上传分块不受 readTimeout 限制，但超过该时间没有收到任何数据时断开
+	uploadReadIdle = time.Minute
+
+	// h2MaxConcurrentStreams 每个 HTTP/2 连接同时进行的请求数：首页的几十张封面，
+	// 播放时的分片、播放列表、进度上报和事件流都复用同一个连接
+	h2MaxConcurrentStreams = 250
+	// h2PingInterval HTTP/2 连接多久没有收到数据时发送 PING，h2PingTimeout 内没有回应就关闭，
+	// 手机锁屏、切换网络后留下的死连接及早回收
+	h2PingInterval = 30 * time.Second
+	h2PingTimeout  = 15 * time.Second
+)
+
+// maxBodySize 请求体大小上限（-max-body），0 表示不限制；bodyLimits 中的接口单独设置
+var maxBodySize int64 = 1 << 20
+
+// bodyLimits 需要更大请求体的接口，以 / 结尾的匹配该前缀下的所有路径
+var bodyLimits = map[string]int64{
+	"/api/sync":    syncMaxBody,
+	"/api/upload/": uploadChunkMax,
+}
+
+// streamRoutes 长时间持续输出的路径：视频流、下载、HLS/DASH 分片、服务器推送事件和测速下载
+var streamRoutes = []string{"/video", "/download", "/remux", "/hls/", "/dash/", "/events", "/api/ffmpeg/events", "/api/logs/events", "/api/speedtest"}
+
+// isStreamRoute 请求是否为流式响应
+func isStreamRoute(path string) bool {
+	for _, p := range streamRoutes {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// newHTTPServer 带超时和请求头大小限制的 http.Server；写入期限由 deadlineMiddleware 按路径设置，
+// 请求体大小由 bodyLimitMiddleware 限制。启用 HTTPS 时同时提供 HTTP/2：
+// 分片和封面等并发请求复用一个连接，不用为每个连接重新握手，也没有 HTTP/1.1 每个域名 6 个连接的限制
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           deadlineMiddleware(bodyLimitMiddleware(handler)),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+		ConnContext:       bandwidthConnContext,
+		Protocols:         &protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: h2MaxConcurrentStreams,
+			SendPingTimeout:      h2PingInterval,
+			PingTimeout:          h2PingTimeout,
+		},
+	}
+}
+
+// deadlineMiddleware 按路径设置写入期限：普通请求需在 writeTimeout 内写完，
+// 流式响应每次写入后把期限延长 streamWriteIdle，只断开长时间不接收数据的客户端
+func deadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if !isStreamRoute(r.URL.Path) {
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &streamDeadlineWriter{ResponseWriter: w, rc: rc}
+		sw.extend()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// streamDeadlineWriter 每次写入时延长写入期限
+type streamDeadlineWriter struct {
+	http.ResponseWriter
+	rc       *http.ResponseController
+	extended time.Time
+}
+
+func (w *streamDeadlineWriter) extend() {
+	now := time.Now()
+	if now.Sub(w.extended) < deadlineRefreshEvery {
+		return
+	}
+	w.extended = now
+	w.rc.SetWriteDeadline(now.Add(streamWriteIdle))
+}
+
+func (w *streamDeadlineWriter) Write(b []byte) (int, error) {
+	w.extend()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *streamDeadlineWriter) Flush() {
+	w.extend()
+	w.rc.Flush()
+}
+
+// Unwrap 返回被包装的 ResponseWriter，供 http.ResponseController 使用
+func (w *streamDeadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idleDeadlineReader 每次读到数据时把读取期限延长 idle（写入期限同时顺延 writeTimeout），
+// 用于上传分块这类请求体很大的请求：慢速链路上只要还在传输就不会因 readTimeout 中断
+type idleDeadlineReader struct {
+	io.Reader
+	rc       *http.ResponseController
+	idle     time.Duration
+	extended time.Time
+	failed   bool // 无法设置期限（如 ResponseWriter 的包装不支持），已记录过日志
+}
+
+func (r *idleDeadlineReader) Read(p []byte) (int, error) {
+	if now := time.Now(); !r.failed && now.Sub(r.extended) >= deadlineRefreshEvery {
+		r.extended = now
+		err := r.rc.SetReadDeadline(now.Add(r.idle))
+		if err == nil {
+			err = r.rc.SetWriteDeadline(now.Add(r.idle + writeTimeout))
+		}
+		if err != nil {
+			r.failed = true
+			logWarnf("[超时] 无法延长请求体的读取期限，较慢的上传可能在 %s 后中断: %v", readTimeout, err)
+		}
+	}
+	return r.Reader.Read(p)
+}
+
+// bodyLimitMiddleware 限制请求体大小：声明的 Content-Length 超出上限时直接返回 413，
+// 不读取请求体；未声明长度（分块传输）的请求在读到上限时中断
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBodySize
+		for p, l := range bodyLimits {
+			if r.URL.Path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p) {
+				limit = l
+			}
+		}
+		if limit > 0 {
+			if r.ContentLength > limit {
+				w.Header().Set("Connection", "close")
+				http.Error(w, "请求体过大", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
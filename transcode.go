@@ -3,12 +3,13 @@ package main
 import (
 	"crypto/md5"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -26,24 +27,29 @@ var (
 )
 
 type HLSJob struct {
-	Dir        string       // HLS 分片输出目录
-	Cmd        *exec.Cmd    // ffmpeg 进程（缓存命中时为 nil）
+	Dir        string        // HLS 分片输出目录
+	Cmd        *exec.Cmd     // ffmpeg 进程（缓存命中时为 nil）
 	Done       chan struct{} // 转码完成信号
-	Cached     bool         // 是否来自缓存
-	lastAccess int64        // 最后访问时间（unix 秒）
+	Cached     bool          // 是否来自缓存
+	Source     string        // 源视频完整路径
+	Owner      string        // 发起转码的设备 ID
+	stop       chan struct{} // StopHLS 时关闭，用于取消排队中的任务
+	lastAccess int64         // 最后访问时间（unix 秒）
+	gone       atomic.Bool   // 转码过程中源文件被删除或移动
+	keep       atomic.Bool   // 停止时保留已生成的分片
+	failed     atomic.Bool   // ffmpeg 异常退出或输出校验未通过
+	fallback   string        // 输出校验未通过后改用兼容模式重新转码的任务 key，Done 关闭前写入
+	progress   jobProgress   // 转码进度（解析 ffmpeg -progress 输出）
+	stderr     tailBuffer    // ffmpeg 最近的错误输出，随播放错误报告一起展示
 }
 
 // InitHLSCache 初始化 HLS 缓存目录
 func InitHLSCache() error {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-	hlsCacheDir = filepath.Join(home, ".cache", "localcinema", "hls")
+	hlsCacheDir = filepath.Join(cacheRoot, "hls")
 	if err := os.MkdirAll(hlsCacheDir, 0755); err != nil {
 		return err
 	}
-	log.Printf("[缓存] 目录: %s", hlsCacheDir)
+	logInfof("[缓存] 目录: %s", hlsCacheDir)
 	return nil
 }
 
@@ -52,14 +58,13 @@ func ClearHLSCache() error {
 	if hlsCacheDir == "" {
 		return nil
 	}
-	log.Printf("[缓存] 清空: %s", hlsCacheDir)
+	logInfof("[缓存] 清空: %s", hlsCacheDir)
 	return os.RemoveAll(hlsCacheDir)
 }
 
-// needsTranscode 判断非 MP4 格式
+// needsTranscode 判断文件是否不能被浏览器直接播放（按扩展名规则、探测到的容器和编码以及默认设备能力）
 func needsTranscode(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	return ext != ".mp4" && ext != ".m4v"
+	return !canDirectPlay(filePath, lookupProfile("default"))
 }
 
 // needsStreamingMp4 判断大 MP4 是否需要流式处理（moov 不在前面）
@@ -137,29 +142,178 @@ func probeVideoCodec(filePath string) string {
 	return ""
 }
 
-func canBrowserPlayCodec(codec string) bool {
-	switch codec {
-	case "h264", "avc1", "avc":
-		return true
+// hlsFMP4 默认使用 fMP4（CMAF）分片代替 MPEG-TS（-hls-segment fmp4）
+var hlsFMP4 bool
+
+// parseSegmentType 解析 -hls-segment 参数
+func parseSegmentType(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "ts":
+		hlsFMP4 = false
+	case "fmp4", "cmaf":
+		hlsFMP4 = true
 	default:
-		return false
+		return fmt.Errorf("未知的分片格式 %q（可选 ts / fmp4）", s)
 	}
+	return nil
+}
+
+// HLSOptions 同一视频的不同 HLS 输出，每种组合对应独立的任务和缓存
+type HLSOptions struct {
+	Audio int  // 音轨序号（0:a:N）
+	HEVC  bool // 输出 HEVC：HEVC 源直接 copy，需要重新编码时编码为 HEVC；总是使用 fMP4 分片
+	FMP4  bool // 使用 fMP4（CMAF）分片代替 MPEG-TS
+	DASH  bool // 输出 MPEG-DASH（manifest.mpd + fMP4 分片）代替 HLS
+	// Precise 精确定位：总是重新编码，关键帧间隔缩短到 0.5 秒、分片 2 秒，拖动和逐帧查看更准确
+	Precise bool
+	// Fallback 兼容模式：H.264 软编码、Main profile、yuv420p，播放器反复解码出错时自动改用
+	Fallback bool
+	// Burn 烧录到画面的内嵌字幕轨（0:s:N 中的 N 加 1），0 表示不烧录；总是重新编码
+	Burn int
+	// Flat 3D / 全景视频转为普通 2D 画面（见 flatFilter）；总是重新编码
+	Flat bool
+	// Passthrough AC-3 / E-AC-3 音轨原样输出，保留环绕声道（见 audioPassthrough）；总是使用 fMP4 分片
+	Passthrough bool
 }
 
-// hlsJobKey 基于文件路径+修改时间生成 key，文件变化后缓存自动失效
-func hlsJobKey(filePath string) string {
+// hlsJobKey 基于文件路径+修改时间+输出选项生成 key，文件变化后缓存自动失效
+// 默认选项不计入 key，保持与旧缓存兼容
+func hlsJobKey(filePath string, opts HLSOptions) string {
 	info, err := os.Stat(filePath)
 	var mtime int64
 	if err == nil {
 		mtime = info.ModTime().UnixNano()
 	}
 	data := fmt.Sprintf("%s|%d", filePath, mtime)
+	if opts.Audio > 0 {
+		data += fmt.Sprintf("|a%d", opts.Audio)
+	}
+	if opts.HEVC {
+		data += "|hevc"
+	} else if opts.FMP4 || opts.Passthrough {
+		data += "|fmp4"
+	}
+	if opts.DASH {
+		data += "|dash"
+	}
+	if opts.Precise {
+		data += "|precise"
+	}
+	if opts.Fallback {
+		data += "|fallback"
+	}
+	if opts.Burn > 0 {
+		data += fmt.Sprintf("|burn%d", opts.Burn-1)
+	}
+	if opts.Flat {
+		data += "|2d"
+	}
+	if opts.Passthrough {
+		data += "|passthrough"
+	}
 	h := md5.Sum([]byte(data))
 	return fmt.Sprintf("%x", h[:8])
 }
 
+// encoderSettings 描述视频的编码方式（编码器及码率等参数），写入缓存信息；
+// 设置变化时旧缓存失效
+func encoderSettings(transcode, hevc, fallback bool) string {
+	if !transcode {
+		return "copy"
+	}
+	enc := jobEncoder(hevc, fallback)
+	settings := strings.Join(append([]string{enc.Name}, enc.EncodeArgs...), " ")
+	if fallback {
+		settings += " main"
+	}
+	return settings
+}
+
+// cacheStale 检查已有缓存是否由当前版本和编码设置生成，过期时返回原因
+func cacheStale(cacheDir, filePath string, opts HLSOptions) string {
+	decision := jobDecision(filePath, opts)
+	if decision.Blocked != "" {
+		// 当前不允许转码（如 -no-transcode），保留已有缓存继续播放
+		return ""
+	}
+	m := readCacheManifest(cacheDir)
+	if m.Version != version {
+		return fmt.Sprintf("缓存版本 %q 与当前版本 %q 不一致", m.Version, version)
+	}
+	transcode := decision.Mode == PlayTranscode
+	if want := encoderSettings(transcode, opts.HEVC && transcode, opts.Fallback); m.Encoder != want {
+		return fmt.Sprintf("编码设置已变更 (%s -> %s)", m.Encoder, want)
+	}
+	return ""
+}
+
+// jobDecision 转码任务的视频处理方式，精确定位和兼容模式总是重新编码
+func jobDecision(filePath string, opts HLSOptions) PlaybackDecision {
+	decision := hlsDecision(filePath, opts.HEVC)
+	if opts.Precise || opts.Fallback {
+		decision = preciseDecision(decision)
+	}
+	if opts.Burn > 0 {
+		decision = burnDecision(decision)
+	}
+	if opts.Flat {
+		decision = flatDecision(decision)
+	}
+	return decision
+}
+
+// resumePoint 检查未完成的 HLS 缓存能否继续转码，返回已完成的分片数和它们的总时长（秒）
+// 缓存由旧版本或其他编码设置生成时删除重来；DASH 输出不支持续转，总是重新开始
+func resumePoint(cacheDir, filePath string, opts HLSOptions) (int, float64) {
+	data, err := os.ReadFile(filepath.Join(cacheDir, "stream.m3u8"))
+	if err != nil || opts.DASH {
+		return 0, 0
+	}
+	if reason := cacheStale(cacheDir, filePath, opts); reason != "" {
+		logInfof("[HLS] %s: %s，丢弃未完成的缓存", filepath.Base(filePath), reason)
+		os.RemoveAll(cacheDir)
+		return 0, 0
+	}
+	var segs int
+	var total float64
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			secs, _ := strconv.ParseFloat(strings.TrimSuffix(v, ","), 64)
+			segs++
+			total += secs
+		}
+	}
+	return segs, total
+}
+
+// resumeHLSJob 内存中没有记录的任务（如服务重启前未完成的转码）：按缓存记录的来源和选项继续转码，
+// 播放器无需重新打开页面；源文件已修改或删除时返回 nil
+func resumeHLSJob(key, owner string) *HLSJob {
+	if !isHexKey(key) {
+		return nil
+	}
+	m := readCacheManifest(filepath.Join(hlsCacheDir, key))
+	if m.Source == "" || sourceMissing(m.Source) {
+		return nil
+	}
+	opts := m.options()
+	if hlsJobKey(m.Source, opts) != key {
+		return nil
+	}
+	job, err := getOrStartHLS(m.Source, opts, owner)
+	if err != nil {
+		logErrorf("[HLS] 继续转码失败 (%s): %v", key, err)
+		return nil
+	}
+	return job
+}
+
 // isCacheComplete 检查缓存目录中是否有完整的 m3u8（包含 #EXT-X-ENDLIST）
+// DASH 缓存检查 manifest.mpd，转码结束时 ffmpeg 将其改写为 type="static"
 func isCacheComplete(dir string) bool {
+	if data, err := os.ReadFile(filepath.Join(dir, dashManifestName)); err == nil {
+		return strings.Contains(string(data), `type="static"`)
+	}
 	m3u8Path := filepath.Join(dir, "stream.m3u8")
 	data, err := os.ReadFile(m3u8Path)
 	if err != nil {
@@ -169,106 +323,311 @@ func isCacheComplete(dir string) bool {
 }
 
 // getOrStartHLS 获取已有任务、命中缓存、或启动新的 HLS 转码
-func getOrStartHLS(filePath string) (*HLSJob, error) {
-	key := hlsJobKey(filePath)
-	fileName := filepath.Base(filePath)
+// opts 不同（如切换音轨）对应不同的任务和缓存；owner 为发起的设备 ID
+func getOrStartHLS(filePath string, opts HLSOptions, owner string) (*HLSJob, error) {
+	key := hlsJobKey(filePath, opts)
+	if job := lookupHLSJob(key); job != nil {
+		return job, nil
+	}
+	// 探测和磁盘操作不持有 hlsJobsMu，不阻塞其他视频的播放和分片请求；同一任务的并发请求只准备一次
+	v, err, _ := probeGroup.Do("hls|"+key, func() (any, error) {
+		if job := lookupHLSJob(key); job != nil {
+			return job, nil
+		}
+		return startHLSJob(key, filePath, opts, owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*HLSJob), nil
+}
 
+// lookupHLSJob 返回进行中或已登记的任务，没有时返回 nil
+func lookupHLSJob(key string) *HLSJob {
 	hlsJobsMu.Lock()
-	if job, ok := hlsJobs[key]; ok {
-		hlsJobsMu.Unlock()
-		return job, nil
+	defer hlsJobsMu.Unlock()
+	return hlsJobs[key]
+}
+
+// addHLSJob 登记准备好的任务；准备期间已有同一 key 的任务时返回已有任务和 false
+func addHLSJob(key string, job *HLSJob) (*HLSJob, bool) {
+	hlsJobsMu.Lock()
+	defer hlsJobsMu.Unlock()
+	if existing, ok := hlsJobs[key]; ok {
+		return existing, false
 	}
+	hlsJobs[key] = job
+	return job, true
+}
+
+// startHLSJob 检查磁盘缓存，必要时探测源文件并启动 ffmpeg，最后在锁内登记任务
+func startHLSJob(key, filePath string, opts HLSOptions, owner string) (*HLSJob, error) {
+	audio := opts.Audio
+	fileName := filepath.Base(filePath)
 
 	// 检查磁盘缓存
 	cacheDir := filepath.Join(hlsCacheDir, key)
 	if isCacheComplete(cacheDir) {
-		log.Printf("[HLS] %s: 命中缓存 (%s)", fileName, key)
+		// 编码设置或程序版本变化后旧缓存作废，避免一直播放旧画质
+		if reason := cacheStale(cacheDir, filePath, opts); reason != "" {
+			logInfof("[HLS] %s: %s，重新转码 (%s)", fileName, reason, key)
+			os.RemoveAll(cacheDir)
+		}
+	}
+	if isCacheComplete(cacheDir) {
+		logInfof("[HLS] %s: 命中缓存 (%s)", fileName, key)
+		touchCacheDir(cacheDir)
 		job := &HLSJob{
 			Dir:        cacheDir,
 			Cached:     true,
+			Source:     filePath,
 			Done:       make(chan struct{}),
 			lastAccess: time.Now().Unix(),
 		}
 		close(job.Done) // 已完成
-		hlsJobs[key] = job
-		hlsJobsMu.Unlock()
+		job, _ = addHLSJob(key, job)
 		return job, nil
 	}
 
+	decision := jobDecision(filePath, opts)
+	if decision.Blocked != "" {
+		return nil, fmt.Errorf("%s", decision.Blocked)
+	}
+	// 上次转码被中断（如服务重启）留下的部分缓存：保留已完成的分片，从其后继续转码
+	resumeSegs, resumeAt := resumePoint(cacheDir, filePath, opts)
+	codec := decision.Codec
+	transcode := decision.Mode == PlayTranscode
+	hevc := opts.HEVC && (transcode || codec == "hevc")
+	// 原样输出的音频编码，为空时转为 AAC 立体声
+	var passthrough string
+	if opts.Passthrough {
+		passthrough = passthroughCodec(filePath, audio)
+	}
+	// HEVC 在 HLS 中需要 fMP4 分片（Safari 不支持 MPEG-TS 中的 HEVC），hls.js 不支持 MPEG-TS 中的 AC-3，
+	// DASH 总是 fMP4 分片
+	fmp4 := opts.FMP4 || hevc || opts.DASH || opts.Passthrough
+	logDebugf("[HLS] %s: 视频编码=%s 音轨=%d", fileName, codec, audio)
+
 	// 创建缓存目录
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		hlsJobsMu.Unlock()
 		return nil, fmt.Errorf("创建缓存目录失败: %w", err)
 	}
 
-	codec := probeVideoCodec(filePath)
-	log.Printf("[HLS] %s: 视频编码=%s", fileName, codec)
+	if resumeSegs > 0 {
+		logInfof("[HLS] %s: 从中断处继续转码，已有 %d 个分片 (%.1fs)", fileName, resumeSegs, resumeAt)
+	}
+	manifest := cacheManifest{
+		Source:      filePath,
+		Audio:       audio,
+		HEVC:        opts.HEVC,
+		FMP4:        fmp4,
+		DASH:        opts.DASH,
+		Precise:     opts.Precise,
+		Fallback:    opts.Fallback,
+		Burn:        opts.Burn,
+		Flat:        opts.Flat,
+		Passthrough: opts.Passthrough,
+		Encoder:     encoderSettings(transcode, hevc, opts.Fallback),
+		Version:     version,
+		Created:     time.Now(),
+	}
+	if resumeSegs > 0 {
+		manifest.Created = readCacheManifest(cacheDir).Created
+	}
+	if err := writeCacheManifest(cacheDir, manifest); err != nil {
+		logWarnf("[HLS] %s: 写入缓存信息失败: %v", fileName, err)
+	}
 
-	m3u8Path := filepath.Join(cacheDir, "stream.m3u8")
-	segPattern := filepath.Join(cacheDir, "seg%05d.ts")
+	// 重新编码为 H.264 时使用的 profile，兼容模式降为 Main
+	avcProfile := "High"
+	if opts.Fallback {
+		avcProfile = "Main"
+	}
+	video, _ := probeVideoStream(filePath)
+	var flat string
+	if opts.Flat {
+		stereo, projection := videoStereo(filePath)
+		flat, video.Width, video.Height = flatFilter(stereo, projection, video.Width, video.Height)
+	}
+	level := transcodeLevel(video.Width, video.Height, parseFrameRate(video.FrameRate))
 
-	// 公共参数：显式选第一条视频+第一条音频轨，音频统一转 AAC 立体声
+	// 公共参数：显式选第一条视频+指定音频轨，音频转 AAC 立体声，原样输出时 copy
 	commonArgs := []string{
 		"-map", "0:v:0",
-		"-map", "0:a:0?", // ? 表示没有音轨也不报错
-		"-c:a", "aac",
-		"-ac", "2",
-		"-b:a", "128k",
-		"-f", "hls",
-		"-hls_time", "6",
-		"-hls_list_size", "0",
-		"-hls_segment_filename", segPattern,
-		"-hls_flags", "independent_segments",
+		"-map", fmt.Sprintf("0:a:%d?", audio), // ? 表示没有音轨也不报错
+	}
+	if passthrough != "" {
+		logInfof("[HLS] %s: %s 音频原样输出", fileName, strings.ToUpper(passthrough))
+		commonArgs = append(commonArgs, "-c:a", "copy")
+	} else {
+		commonArgs = append(commonArgs, "-c:a", "aac", "-ac", "2", "-b:a", "128k")
+	}
+	// 精确定位模式缩短关键帧间隔和分片时长
+	keyInterval, segTime := "2", "6"
+	if opts.Precise {
+		keyInterval, segTime = "0.5", "2"
+	}
+	// 继续转码时从已完成分片的结束位置开始读取，保留原时间戳（-copyts），分片序号接在已有分片之后
+	inputArgs := []string{"-i", filePath}
+	keyFrames := "expr:gte(t,n_forced*" + keyInterval + ")"
+	hlsFlags := "independent_segments"
+	if resumeSegs > 0 {
+		at := strconv.FormatFloat(resumeAt, 'f', 3, 64)
+		inputArgs = []string{"-ss", at, "-i", filePath, "-copyts"}
+		keyFrames = "expr:gte(t," + at + "+n_forced*" + keyInterval + ")"
+		hlsFlags += "+append_list"
+	}
+	output := filepath.Join(cacheDir, "stream.m3u8")
+	if opts.DASH {
+		output = filepath.Join(cacheDir, dashManifestName)
+		commonArgs = append(commonArgs, dashArgs()...)
+	} else {
+		segPattern := filepath.Join(cacheDir, "seg%05d.ts")
+		if fmp4 {
+			segPattern = filepath.Join(cacheDir, "seg%05d.m4s")
+		}
+		commonArgs = append(commonArgs,
+			"-f", "hls",
+			"-hls_time", segTime,
+			"-hls_list_size", "0",
+			"-hls_segment_filename", segPattern,
+			"-hls_flags", hlsFlags,
+			"-start_number", strconv.Itoa(resumeSegs),
+		)
+		if fmp4 {
+			commonArgs = append(commonArgs, "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4")
+		}
 	}
 
 	var args []string
-	if canBrowserPlayCodec(codec) {
-		log.Printf("[HLS] %s: H.264 copy 模式", fileName)
-		args = append([]string{"-loglevel", "error", "-i", filePath,
-			"-c:v", "copy",
-			"-bsf:v", "h264_mp4toannexb", // H.264 -> Annex B 格式，ts 容器必须
-		}, commonArgs...)
+	if !transcode {
+		args = append([]string{"-loglevel", "error"}, inputArgs...)
+		args = append(args, "-c:v", "copy")
+		switch {
+		case hevc:
+			logInfof("[HLS] %s: HEVC copy 模式 (fMP4)", fileName)
+			args = append(args, "-tag:v", "hvc1")
+		case fmp4:
+			logInfof("[HLS] %s: H.264 copy 模式 (fMP4)", fileName)
+		default:
+			logInfof("[HLS] %s: H.264 copy 模式", fileName)
+			args = append(args, "-bsf:v", "h264_mp4toannexb") // H.264 -> Annex B 格式，ts 容器必须
+		}
+		args = append(args, commonArgs...)
 	} else {
-		var videoArgs []string
-		if runtime.GOOS == "darwin" {
-			log.Printf("[HLS] %s: %s -> H.264 转码 (硬件加速)", fileName, codec)
-			videoArgs = []string{"-c:v", "h264_videotoolbox", "-b:v", "4M"}
+		enc := jobEncoder(false, opts.Fallback)
+		// 固定 profile/level，与主播放列表中声明的 CODECS 保持一致
+		videoArgs := append(append([]string{}, enc.EncodeArgs...), "-profile:v", strings.ToLower(avcProfile), "-level:v", fmt.Sprintf("%.1f", float64(level)/10))
+		if opts.Fallback {
+			videoArgs = append(videoArgs, "-pix_fmt", "yuv420p")
+		}
+		if hevc {
+			enc = jobEncoder(true, false)
+			// hvc1 标签是 Apple 设备播放 HEVC 的必要条件
+			videoArgs = append(append([]string{}, enc.EncodeArgs...), "-profile:v", "main", "-tag:v", "hvc1")
+			logInfof("[HLS] %s: %s -> HEVC 转码 (%s)", fileName, codec, enc.Label)
 		} else {
-			log.Printf("[HLS] %s: %s -> H.264 转码 (软编码)", fileName, codec)
-			videoArgs = []string{"-c:v", "libx264", "-preset", "fast", "-b:v", "4M"}
+			logInfof("[HLS] %s: %s -> H.264 转码 (%s)", fileName, codec, enc.Label)
+		}
+		if opts.Burn > 0 {
+			videoArgs = withVideoFilter(videoArgs, burnSubtitleFilter(filePath, opts.Burn-1))
 		}
-		args = append([]string{"-loglevel", "error", "-i", filePath}, videoArgs...)
-		args = append(args, "-force_key_frames", "expr:gte(t,n_forced*2)")
+		// 先转为 2D 再叠加字幕
+		if flat != "" {
+			videoArgs = withVideoFilter(videoArgs, flat)
+		}
+		args = append([]string{"-loglevel", "error"}, enc.InputArgs...)
+		args = append(args, inputArgs...)
+		args = append(args, videoArgs...)
+		args = append(args, "-force_key_frames", keyFrames)
 		args = append(args, commonArgs...)
 	}
-	args = append(args, m3u8Path)
+	args = append(args, output)
+	// 进度以 key=value 形式输出到 stdout，供 /api/hls/{key}/status 使用
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+
+	if !opts.DASH {
+		if err := writeMasterPlaylist(cacheDir, video, transcode, hevc, fmp4, level, avcProfile, passthrough); err != nil {
+			logErrorf("[HLS] %s: 写入主播放列表失败: %v", fileName, err)
+		}
+	}
 
-	log.Printf("[HLS] %s: ffmpeg %s", fileName, strings.Join(args, " "))
+	logDebugf("[HLS] %s: ffmpeg %s", fileName, strings.Join(args, " "))
 
 	cmd := exec.Command(ffmpegPath(), args...)
 
 	job := &HLSJob{
 		Dir:        cacheDir,
 		Cmd:        cmd,
+		Source:     filePath,
+		Owner:      owner,
+		stop:       make(chan struct{}),
 		Done:       make(chan struct{}),
 		lastAccess: time.Now().Unix(),
+		stderr:     tailBuffer{max: 8 << 10},
+	}
+	job.progress.duration = float64(durationSeconds(getDuration(filePath)))
+	job.progress.offset = resumeAt
+	job.progress.outTime = resumeAt
+	if existing, added := addHLSJob(key, job); !added {
+		return existing, nil
 	}
-	hlsJobs[key] = job
-	hlsJobsMu.Unlock()
 
 	go func() {
-		defer close(job.Done)
-		// 丢弃 stdout/stderr，避免内存堆积（已通过 -loglevel error 限制输出）
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		err := cmd.Run()
-		if err != nil {
-			log.Printf("[HLS] %s: ffmpeg 退出: %v", fileName, err)
+		stopReport := reportProgress(job, key)
+		defer func() {
+			stopReport()
+			close(job.Done)
+			bus.Publish("transcode.progress", job.status(key))
+		}()
+		if transcode {
+			if !scheduler.acquire(job, filepath.Base(job.Source), job.stop) {
+				logInfof("[HLS] %s: 排队中的转码已取消", fileName)
+				os.RemoveAll(cacheDir)
+				return
+			}
+			defer scheduler.release()
+		}
+		// stdout 为进度输出，边读边解析；stderr 只保留最后一段（已通过 -loglevel error 限制输出）
+		cmd.Stderr = &job.stderr
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			if err = cmd.Start(); err == nil {
+				job.progress.start()
+				job.progress.parse(stdout)
+				err = cmd.Wait()
+			}
+		}
+		var invalid string
+		if err == nil && !opts.DASH {
+			invalid = validateHLSOutput(cacheDir, filePath)
+		}
+		switch {
+		case err != nil:
+			job.failed.Store(true)
+			if job.gone.Load() || sourceMissing(filePath) {
+				markSourceGone(job, key)
+			} else {
+				logErrorf("[HLS] %s: ffmpeg 退出: %v\n%s", fileName, err, job.stderr.String())
+			}
 			// 转码失败，清理不完整的缓存
-			os.RemoveAll(cacheDir)
-		} else {
-			log.Printf("[HLS] %s: 转码完成，已缓存 (%s)", fileName, key)
+			if !job.keep.Load() {
+				os.RemoveAll(cacheDir)
+			}
+		case invalid != "" && canReencodeSafer(opts):
+			// 输出有问题（如时长不完整、丢了音轨）：隔离后改用兼容模式重新转码，播放页按状态中的 fallback 切换
+			job.failed.Store(true)
+			logWarnf("[HLS] %s: 输出校验未通过（%s），隔离输出并改用兼容模式重新转码 (%s)", fileName, invalid, key)
+			quarantineHLSCache(key, filePath, invalid)
+			job.fallback = startSaferJob(filePath, opts, owner)
+		default:
+			if invalid != "" {
+				logWarnf("[HLS] %s: 输出校验未通过（%s），无法改用兼容模式，保留输出 (%s)", fileName, invalid, key)
+			}
+			logInfof("[HLS] %s: 转码完成，已缓存 (%s)", fileName, key)
 			job.Cached = true
+			touchCacheDir(cacheDir)
+			EvictHLSCache()
 		}
 
 		// 转码完成后不从 hlsJobs 删除（保留以便继续提供分片服务）
@@ -278,17 +637,51 @@ func getOrStartHLS(filePath string) (*HLSJob, error) {
 	return job, nil
 }
 
-// TouchHLS 更新任务的最后访问时间
+// sourceMissing 源文件是否已被删除或移动
+func sourceMissing(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// notifySourceMissing 广播源文件丢失事件，并通知客户端刷新媒体库
+func notifySourceMissing(filePath string) {
+	folderStatsRemove(filePath)
+	bus.Publish("video.missing", map[string]string{"file": filePath})
+	bus.Publish("library.changed", nil)
+}
+
+// markSourceGone 源文件在转码过程中消失：停止 ffmpeg，保留任务记录以便向播放器返回明确的错误
+func markSourceGone(job *HLSJob, key string) {
+	if job.gone.Swap(true) {
+		return
+	}
+	logWarnf("[HLS] %s: 源文件已被删除或移动，停止转码 (%s)", filepath.Base(job.Source), key)
+	if job.Cmd != nil && job.Cmd.Process != nil {
+		job.Cmd.Process.Kill()
+	}
+	notifySourceMissing(job.Source)
+}
+
+// TouchHLS 更新任务的最后访问时间，并记为最近播放的任务
 func TouchHLS(key string) {
 	hlsJobsMu.Lock()
-	if job, ok := hlsJobs[key]; ok {
+	job, ok := hlsJobs[key]
+	if ok {
 		atomic.StoreInt64(&job.lastAccess, time.Now().Unix())
 	}
 	hlsJobsMu.Unlock()
+	if ok {
+		markWarm(key)
+	}
 }
 
-// StopHLS 停止指定的 HLS 任务（不删除缓存文件）
+// StopHLS 停止指定的 HLS 任务（不删除已完成的缓存，未完成的分片会被清理）
 func StopHLS(key string) {
+	stopHLSJob(key, false)
+}
+
+// stopHLSJob 停止 HLS 任务，keepPartial 为 true 时保留已生成的分片
+func stopHLSJob(key string, keepPartial bool) {
 	hlsJobsMu.Lock()
 	job, ok := hlsJobs[key]
 	if ok {
@@ -296,18 +689,24 @@ func StopHLS(key string) {
 	}
 	hlsJobsMu.Unlock()
 
+	if ok && job.stop != nil {
+		close(job.stop)
+	}
 	if ok && job.Cmd != nil && job.Cmd.Process != nil && !job.Cached {
-		log.Printf("[HLS] 停止空闲转码任务: %s", key)
+		logInfof("[HLS] 停止转码任务: %s", key)
+		job.keep.Store(keepPartial)
 		job.Cmd.Process.Kill()
 		// 转码中断，删除不完整的缓存
-		os.RemoveAll(job.Dir)
+		if !keepPartial {
+			os.RemoveAll(job.Dir)
+		}
 	}
 	// 已完成的缓存保留在磁盘
 }
 
 const hlsIdleTimeout = 60 // 秒，无请求后清理内存记录
 
-// StartHLSReaper 定期清理空闲任务的内存记录
+// StartHLSReaper 定期清理空闲任务的内存记录，最近播放的任务见 reapIdleJob
 func StartHLSReaper() {
 	go func() {
 		ticker := time.NewTicker(10 * time.Second)
@@ -315,18 +714,29 @@ func StartHLSReaper() {
 		for range ticker.C {
 			now := time.Now().Unix()
 			hlsJobsMu.Lock()
-			var idleKeys []string
+			idle := make(map[string]*HLSJob)
+			running := make(map[string]*HLSJob)
 			for key, job := range hlsJobs {
 				last := atomic.LoadInt64(&job.lastAccess)
 				if last > 0 && now-last > hlsIdleTimeout {
-					idleKeys = append(idleKeys, key)
+					idle[key] = job
+				} else if !job.Cached && !job.gone.Load() {
+					running[key] = job
 				}
 			}
 			hlsJobsMu.Unlock()
 
-			for _, key := range idleKeys {
-				StopHLS(key)
+			// Linux 下文件删除后 ffmpeg 仍可读完已打开的句柄，需主动检查源文件
+			for key, job := range running {
+				if sourceMissing(job.Source) {
+					markSourceGone(job, key)
+				}
+			}
+
+			for key, job := range idle {
+				reapIdleJob(key, job)
 			}
+			expireWarm()
 		}
 	}()
 }
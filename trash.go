@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashDirName 视频目录下的回收站，网页上删除的视频先移到这里，扫描时总是跳过
+const trashDirName = ".localcinema-trash"
+
+// trashBatchLayout 每次删除在回收站中建一个以时间命名的目录，其中保持原来的相对路径，便于还原
+const trashBatchLayout = "20060102-150405"
+
+// trashRetention 回收站中的视频保留多久后彻底删除（-trash-retention），0 表示不自动清空
+var trashRetention = 30 * 24 * time.Hour
+
+// TrashItem 回收站中的一个文件
+type TrashItem struct {
+	ID        string    `json:"id"`   // 批次目录/原相对路径，用于还原和彻底删除
+	File      string    `json:"file"` // 原相对路径
+	Size      int64     `json:"size"`
+	SizeStr   string    `json:"size_str"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Expires   time.Time `json:"expires,omitzero"` // 自动彻底删除的时间，不自动清空时为空
+}
+
+// inTrash 相对路径是否位于回收站中
+func inTrash(rel string) bool {
+	first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return first == trashDirName
+}
+
+// videoSidecars 与视频同目录的外挂字幕（完整路径），随视频一起移动
+func videoSidecars(videoPath string) []string {
+	entries, _ := os.ReadDir(filepath.Dir(videoPath))
+	var subs []string
+	for _, e := range entries {
+		if !e.IsDir() && subtitleExts[strings.ToLower(filepath.Ext(e.Name()))] && isSidecarOf(filepath.Base(videoPath), e.Name()) {
+			subs = append(subs, filepath.Join(filepath.Dir(videoPath), e.Name()))
+		}
+	}
+	return subs
+}
+
+// forgetVideo 视频被移走后清理派生缓存、转码缓存和目录统计，并通知客户端刷新
+func forgetVideo(videoPath string) {
+	purgeMediaCache(videoPath)
+	folderStatsRemove(videoPath)
+	for _, e := range scanHLSCache() {
+		if e.Source == videoPath {
+			if err := removeHLSCache(e.Key); err != nil {
+				logWarnf("[回收站] 删除转码缓存失败: %v", err)
+			}
+		}
+	}
+	bus.Publish("library.changed", nil)
+}
+
+// moveToTrash 把视频和外挂字幕移到回收站，返回批次目录名。只在同一文件系统内重命名，
+// 视频所在目录挂载自其他磁盘时返回错误，不会复制大文件
+func moveToTrash(root, rel string) (string, error) {
+	src := filepath.Join(root, rel)
+	mediaCacheKey(src) // 记下当前的缓存 key，移走后据此清理
+	buf := make([]byte, 2)
+	rand.Read(buf)
+	batch := time.Now().Format(trashBatchLayout) + "-" + hex.EncodeToString(buf)
+	batchDir := filepath.Join(root, trashDirName, batch)
+
+	files := append([]string{src}, videoSidecars(src)...)
+	for i, f := range files {
+		r, _ := filepath.Rel(root, f)
+		dst := filepath.Join(batchDir, r)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(f, dst); err != nil {
+			if i == 0 {
+				os.RemoveAll(batchDir)
+				return "", err
+			}
+			logWarnf("[回收站] 移动字幕失败 %s: %v", r, err)
+		}
+	}
+	forgetVideo(src)
+	return batch, nil
+}
+
+// trashBatchTime 批次目录名中的删除时间
+func trashBatchTime(name string) (time.Time, bool) {
+	if len(name) < len(trashBatchLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(trashBatchLayout, name[:len(trashBatchLayout)], time.Local)
+	return t, err == nil
+}
+
+// listTrash 回收站中的视频，最近删除的在前
+func listTrash(root string) []TrashItem {
+	trash := filepath.Join(root, trashDirName)
+	batches, _ := os.ReadDir(trash)
+	var items []TrashItem
+	for _, b := range batches {
+		deleted, ok := trashBatchTime(b.Name())
+		if !b.IsDir() || !ok {
+			continue
+		}
+		batchDir := filepath.Join(trash, b.Name())
+		filepath.WalkDir(batchDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !videoExts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(batchDir, path)
+			item := TrashItem{
+				ID:        b.Name() + "/" + filepath.ToSlash(rel),
+				File:      filepath.ToSlash(rel),
+				Size:      info.Size(),
+				SizeStr:   formatSize(info.Size()),
+				DeletedAt: deleted,
+			}
+			if trashRetention > 0 {
+				item.Expires = deleted.Add(trashRetention)
+			}
+			items = append(items, item)
+			return nil
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items
+}
+
+// trashItemPath 解析回收站条目 ID（批次目录/原相对路径），返回批次目录和原相对路径
+func trashItemPath(root, id string) (batchDir, rel string, err error) {
+	batch, rel, ok := strings.Cut(id, "/")
+	rel = filepath.FromSlash(rel)
+	if !ok || batch == "" || strings.ContainsAny(batch, `/\`) || batch == "." || batch == ".." ||
+		!filepath.IsLocal(rel) || !videoExts[strings.ToLower(filepath.Ext(rel))] {
+		return "", "", fmt.Errorf("无效的回收站条目")
+	}
+	batchDir = filepath.Join(root, trashDirName, batch)
+	if _, err := os.Stat(filepath.Join(batchDir, rel)); err != nil {
+		return "", "", fmt.Errorf("回收站中没有该视频")
+	}
+	return batchDir, rel, nil
+}
+
+// restoreFromTrash 把视频和外挂字幕移回原位置，原位置已有同名文件时拒绝
+func restoreFromTrash(root, id string) (string, error) {
+	batchDir, rel, err := trashItemPath(root, id)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(root, rel)
+	if _, err := os.Stat(dst); err == nil {
+		return "", fmt.Errorf("原位置已有同名文件")
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	src := filepath.Join(batchDir, rel)
+	subs := videoSidecars(src)
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	for _, sub := range subs {
+		target := filepath.Join(filepath.Dir(dst), filepath.Base(sub))
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		os.Rename(sub, target)
+	}
+	removeEmptyDirs(filepath.Dir(src), filepath.Join(root, trashDirName))
+	if info, err := os.Stat(dst); err == nil {
+		folderStatsAdd(dst, info.Size())
+	}
+	bus.Publish("library.changed", nil)
+	return filepath.ToSlash(rel), nil
+}
+
+// purgeTrashItem 彻底删除回收站中的视频及其外挂字幕
+func purgeTrashItem(root, id string) error {
+	batchDir, rel, err := trashItemPath(root, id)
+	if err != nil {
+		return err
+	}
+	src := filepath.Join(batchDir, rel)
+	for _, f := range append(videoSidecars(src), src) {
+		if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	removeEmptyDirs(filepath.Dir(src), filepath.Join(root, trashDirName))
+	return nil
+}
+
+// removeEmptyDirs 从 dir 向上删除空目录，直到 stop（不含）
+func removeEmptyDirs(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop+string(os.PathSeparator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// emptyTrash 删除回收站中早于 before 的批次，before 为零值时全部删除，返回删除的批次数量
+func emptyTrash(root string, before time.Time) int {
+	trash := filepath.Join(root, trashDirName)
+	batches, _ := os.ReadDir(trash)
+	n := 0
+	for _, b := range batches {
+		if deleted, ok := trashBatchTime(b.Name()); !before.IsZero() && (!ok || !deleted.Before(before)) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(trash, b.Name())); err != nil {
+			logErrorf("[回收站] 清空 %s 失败: %v", b.Name(), err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// StartTrashPurge 每小时彻底删除回收站中超过 trashRetention 的视频
+func StartTrashPurge(root string) {
+	if trashRetention <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if n := emptyTrash(root, time.Now().Add(-trashRetention)); n > 0 {
+				logInfof("[回收站] 已彻底删除 %d 批过期的视频", n)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// handleDeleteVideo DELETE /api/videos/{id} 把视频移到回收站（仅限管理员）
+func (s *Server) handleDeleteVideo(w http.ResponseWriter, r *http.Request, file string) {
+	if !isAdminRequest(r) {
+		http.Error(w, "只有管理员可以删除视频", http.StatusForbidden)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(s.videoDir, file)); err != nil {
+		http.Error(w, "视频不存在", http.StatusNotFound)
+		return
+	}
+	batch, err := moveToTrash(s.videoDir, file)
+	if err != nil {
+		logErrorf("[回收站] 移动 %s 失败: %v", file, err)
+		http.Error(w, "移到回收站失败（视频所在目录可能位于其他磁盘）", http.StatusInternalServerError)
+		return
+	}
+	logInfof("[回收站] %s 已移到回收站", file)
+	writeJSON(w, map[string]string{"id": batch + "/" + filepath.ToSlash(file)})
+}
+
+// handleTrash 回收站（仅限管理员）：
+//
+//	GET /api/trash                  回收站中的视频
+//	POST /api/trash?id=             还原到原位置
+//	DELETE /api/trash?id=           彻底删除一个视频，不带 id 时清空回收站
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "只有管理员可以管理回收站", http.StatusForbidden)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		items := listTrash(s.videoDir)
+		if items == nil {
+			items = []TrashItem{}
+		}
+		writeJSON(w, items)
+
+	case http.MethodPost:
+		file, err := restoreFromTrash(s.videoDir, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logInfof("[回收站] %s 已还原", file)
+		writeJSON(w, map[string]string{"file": file})
+
+	case http.MethodDelete:
+		if id == "" {
+			writeJSON(w, map[string]int{"removed": emptyTrash(s.videoDir, time.Time{})})
+			return
+		}
+		if err := purgeTrashItem(s.videoDir, id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
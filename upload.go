@@ -0,0 +1,464 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadsFile = "uploads.json"
+	// uploadOwnersFile 上传完成的文件由哪个账号上传，用于计算上传空间配额
+	uploadOwnersFile = "upload_owners.json"
+	// uploadChunkMax 每个分块的大小上限，客户端按此切分文件
+	uploadChunkMax = 64 << 20
+	// uploadExpiry 上传超过该时间没有进展时删除临时文件
+	uploadExpiry = 24 * time.Hour
+)
+
+var (
+	// uploadDir 上传的视频默认放在视频目录下的哪个子目录（-upload-dir）
+	uploadDir = "Uploads"
+	// uploadMaxSize 单个文件的大小上限（-upload-max-size），0 表示不限制
+	uploadMaxSize int64
+	// uploadQuotas 每个账号上传文件占用空间的上限（-upload-quota），账号名 -> 字节数，
+	// "*" 为未单独设置的账号（包括共用密码和本机访问）的上限
+	uploadQuotas map[string]int64
+
+	uploadsMu sync.Mutex
+	// uploads 未完成的上传，ID -> 记录；保存在数据目录，重启后可以继续上传
+	uploads = make(map[string]*UploadSession)
+	// uploadOwners 上传完成的文件（相对路径）-> 上传者，改名和移动时跟着迁移
+	uploadOwners = make(map[string]*UploadOwner)
+)
+
+// UploadOwner 上传完成的文件的上传者和大小
+type UploadOwner struct {
+	User string `json:"user,omitempty"` // 账号名，共用密码或本机上传时为空
+	Size int64  `json:"size"`
+}
+
+// UploadSession 一次可续传的上传：文件先写入目标路径旁的 .part 临时文件，收齐 Size 字节后改名
+type UploadSession struct {
+	ID      string    `json:"id"`
+	File    string    `json:"file"`           // 目标相对路径
+	User    string    `json:"user,omitempty"` // 上传的账号，计入该账号的上传空间配额
+	Size    int64     `json:"size"`
+	Offset  int64     `json:"offset"` // 已收到的字节数，下一个分块从这里开始
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Done    bool      `json:"done,omitempty"`
+
+	writing bool // 正在写入分块，同一上传的分块不能并发
+}
+
+// InitUploads 从数据目录加载未完成的上传，已收到的字节数以磁盘上的临时文件为准
+func InitUploads(root string) error {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	if err := loadJSON(uploadsFile, &uploads); err != nil {
+		return err
+	}
+	if err := loadJSON(uploadOwnersFile, &uploadOwners); err != nil {
+		return err
+	}
+	for id, u := range uploads {
+		info, err := os.Stat(uploadPartPath(root, u))
+		if err != nil {
+			delete(uploads, id)
+			continue
+		}
+		u.Offset = min(info.Size(), u.Size)
+	}
+	return nil
+}
+
+func saveUploadsLocked() {
+	if err := saveJSON(uploadsFile, uploads); err != nil {
+		logErrorf("[上传] 保存失败: %v", err)
+	}
+}
+
+// parseUploadQuotas 解析 -upload-quota，如 "10G"（每个账号）或 "小明=20G,*=5G"
+func parseUploadQuotas(spec string) error {
+	quotas := make(map[string]int64)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		user, limit, ok := cutLast(item, "=")
+		if !ok {
+			user, limit = "*", item
+		}
+		n, err := parseByteSize(limit)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("无效的上传配额: %s", item)
+		}
+		quotas[strings.TrimSpace(user)] = n
+	}
+	uploadQuotas = quotas
+	return nil
+}
+
+// uploadQuotaFor 账号的上传空间上限，0 表示不限制
+func uploadQuotaFor(user string) int64 {
+	if n, ok := uploadQuotas[user]; ok && user != "" {
+		return n
+	}
+	return uploadQuotas["*"]
+}
+
+// uploadUsageLocked 账号上传的文件中仍在视频目录里的总大小，加上未完成的上传声明的大小（调用方持有 uploadsMu）
+func uploadUsageLocked(root, user string) int64 {
+	var used int64
+	for rel, o := range uploadOwners {
+		if o.User != user {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err == nil {
+			used += o.Size
+		}
+	}
+	for _, u := range uploads {
+		if u.User == user {
+			used += u.Size
+		}
+	}
+	return used
+}
+
+// pruneUploadOwnersLocked 清理已被删除的文件的上传记录（调用方持有 uploadsMu）
+func pruneUploadOwnersLocked(root string) {
+	changed := false
+	for rel := range uploadOwners {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); errors.Is(err, fs.ErrNotExist) {
+			delete(uploadOwners, rel)
+			changed = true
+		}
+	}
+	if changed {
+		saveUploadOwnersLocked()
+	}
+}
+
+func saveUploadOwnersLocked() {
+	if err := saveJSON(uploadOwnersFile, uploadOwners); err != nil {
+		logErrorf("[上传] 保存上传记录失败: %v", err)
+	}
+}
+
+// moveUploadOwner 视频改名或移动后，上传记录跟着迁移
+func moveUploadOwner(from, to string) {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	if renameKey(uploadOwners, from, to) {
+		saveUploadOwnersLocked()
+	}
+}
+
+// uploadPartPath 上传中的临时文件（浏览器下载同款 .part 后缀，扫描时跳过）
+func uploadPartPath(root string, u *UploadSession) string {
+	return filepath.Join(root, filepath.FromSlash(u.File)) + ".part"
+}
+
+// expireUploadsLocked 删除长时间没有进展的上传
+func expireUploadsLocked(root string) {
+	changed := false
+	for id, u := range uploads {
+		if !u.writing && time.Since(u.Updated) > uploadExpiry {
+			os.Remove(uploadPartPath(root, u))
+			delete(uploads, id)
+			changed = true
+			logInfof("[上传] %s 超过 %s 没有进展，已删除", u.File, uploadExpiry)
+		}
+	}
+	if changed {
+		saveUploadsLocked()
+	}
+}
+
+// uniquePath 路径（或加上 suffixes 中任一后缀的临时文件）已存在时在文件名后加序号，如 a (2).mp4
+func uniquePath(path string, suffixes ...string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	taken := func(p string) bool {
+		for _, s := range append([]string{""}, suffixes...) {
+			if _, err := os.Lstat(p + s); !errors.Is(err, fs.ErrNotExist) {
+				return true
+			}
+		}
+		return false
+	}
+	for n := 2; taken(path); n++ {
+		path = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	return path
+}
+
+// uploadTarget 校验上传的文件名和目录，只接受视频和字幕文件，返回目标相对路径
+func (s *Server) uploadTarget(name, dir string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || skipName(name) {
+		return "", fmt.Errorf("无效的文件名")
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if !videoExts[ext] && !subtitleExts[ext] {
+		return "", fmt.Errorf("只能上传视频和字幕文件")
+	}
+	if strings.TrimSpace(dir) == "" {
+		dir = uploadDir
+	}
+	rel, err := moveTarget(name, dir)
+	if err != nil {
+		return "", err
+	}
+	if !s.isSafeRelPath(rel) {
+		return "", fmt.Errorf("无效的目录")
+	}
+	return rel, nil
+}
+
+// createUpload 登记新的上传并创建空的临时文件，user 为上传的账号
+func (s *Server) createUpload(name, dir string, size int64, user string) (*UploadSession, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("无效的文件大小")
+	}
+	if uploadMaxSize > 0 && size > uploadMaxSize {
+		return nil, fmt.Errorf("文件超过上传大小上限 %s", formatSize(uploadMaxSize))
+	}
+	rel, err := s.uploadTarget(name, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	expireUploadsLocked(s.videoDir)
+	if limit := uploadQuotaFor(user); limit > 0 {
+		if used := uploadUsageLocked(s.videoDir, user); used+size > limit {
+			return nil, fmt.Errorf("%w（已用 %s / %s）", errUploadQuota, formatSize(used), formatSize(limit))
+		}
+	}
+	full := filepath.Join(s.videoDir, rel)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return nil, err
+	}
+	// 同名的上传还没完成时临时文件已存在，uniquePath 会避开
+	full = uniquePath(full, ".part")
+	rel, _ = filepath.Rel(s.videoDir, full)
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	now := time.Now()
+	u := &UploadSession{ID: hex.EncodeToString(buf), File: filepath.ToSlash(rel), User: user, Size: size, Created: now, Updated: now}
+	f, err := os.OpenFile(uploadPartPath(s.videoDir, u), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	uploads[u.ID] = u
+	saveUploadsLocked()
+	logInfof("[上传] 开始上传 %s（%s）", u.File, formatSize(size))
+	return u, nil
+}
+
+// writeUploadChunk 把请求体追加到临时文件，offset 必须等于已收到的字节数；收齐后改名为最终文件
+func writeUploadChunk(root string, u *UploadSession, offset int64, body io.Reader) error {
+	uploadsMu.Lock()
+	if u.writing {
+		uploadsMu.Unlock()
+		return errUploadBusy
+	}
+	if offset != u.Offset {
+		uploadsMu.Unlock()
+		return errUploadOffset
+	}
+	u.writing = true
+	uploadsMu.Unlock()
+
+	part := uploadPartPath(root, u)
+	n, err := appendChunk(part, offset, io.LimitReader(body, u.Size-offset+1))
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	u.writing = false
+	u.Updated = time.Now()
+	u.Offset = offset + n
+	if u.Offset > u.Size {
+		// 多出的数据丢弃，保留已收到的部分
+		os.Truncate(part, offset)
+		u.Offset = offset
+		saveUploadsLocked()
+		return fmt.Errorf("数据超出声明的文件大小")
+	}
+	if u.Offset < u.Size {
+		saveUploadsLocked()
+		return err
+	}
+
+	final := uniquePath(filepath.Join(root, filepath.FromSlash(u.File)))
+	if err := os.Rename(part, final); err != nil {
+		saveUploadsLocked()
+		return err
+	}
+	rel, _ := filepath.Rel(root, final)
+	u.File, u.Done = filepath.ToSlash(rel), true
+	delete(uploads, u.ID)
+	saveUploadsLocked()
+	if len(uploadQuotas) > 0 {
+		pruneUploadOwnersLocked(root)
+		uploadOwners[u.File] = &UploadOwner{User: u.User, Size: u.Size}
+		saveUploadOwnersLocked()
+	}
+	if videoExts[strings.ToLower(filepath.Ext(final))] {
+		folderStatsAdd(final, u.Size)
+	}
+	bus.Publish("library.changed", nil)
+	logInfof("[上传] %s 上传完成", u.File)
+	return nil
+}
+
+var (
+	errUploadBusy   = errors.New("该上传正在写入其他分块")
+	errUploadOffset = errors.New("分块位置与已上传的字节数不一致")
+	errUploadQuota  = errors.New("超出上传空间配额")
+)
+
+// appendChunk 从 offset 处写入数据，返回写入的字节数；写入后截掉之后的旧数据（之前中断的分块留下的）
+func appendChunk(path string, offset int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if terr := f.Truncate(offset + n); err == nil {
+		err = terr
+	}
+	return n, err
+}
+
+// handleUpload 可续传的分块上传（仅限管理员），文件放在视频目录下：
+//
+//	POST /api/upload              {"name":"a.mp4","size":123,"dir":"Uploads"} 开始上传，返回 id
+//	GET /api/upload               未完成的上传
+//	GET /api/upload/{id}          已收到的字节数（断线后从这里继续）
+//	PATCH /api/upload/{id}        请求头 Upload-Offset 为分块起始位置，请求体为分块数据
+//	DELETE /api/upload/{id}       取消上传并删除临时文件
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "只有管理员可以上传视频（请设置 -password 并登录）", http.StatusForbidden)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/upload"), "/")
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			uploadsMu.Lock()
+			list := make([]UploadSession, 0, len(uploads))
+			for _, u := range uploads {
+				list = append(list, *u)
+			}
+			uploadsMu.Unlock()
+			sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+			writeJSON(w, list)
+		case http.MethodPost:
+			var req struct {
+				Name string `json:"name"`
+				Size int64  `json:"size"`
+				Dir  string `json:"dir"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "无效的请求", http.StatusBadRequest)
+				return
+			}
+			u, err := s.createUpload(req.Name, req.Dir, req.Size, requestUser(r))
+			switch {
+			case errors.Is(err, errUploadQuota):
+				http.Error(w, err.Error(), http.StatusInsufficientStorage)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Upload-Offset", "0")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(u)
+		default:
+			http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	uploadsMu.Lock()
+	u, ok := uploads[id]
+	uploadsMu.Unlock()
+	if !ok {
+		http.Error(w, "上传不存在或已完成", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		uploadsMu.Lock()
+		view := *u
+		uploadsMu.Unlock()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(view.Offset, 10))
+		writeJSON(w, view)
+
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "缺少 Upload-Offset 请求头", http.StatusBadRequest)
+			return
+		}
+		// 慢速链路上传大分块可能超过服务的读取时限，只要还在收到数据就延长期限
+		body := &idleDeadlineReader{Reader: r.Body, rc: http.NewResponseController(w), idle: uploadReadIdle}
+		err = writeUploadChunk(s.videoDir, u, offset, body)
+		uploadsMu.Lock()
+		view := *u
+		uploadsMu.Unlock()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(view.Offset, 10))
+		switch {
+		case errors.Is(err, errUploadBusy), errors.Is(err, errUploadOffset):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			logWarnf("[上传] %s 写入失败: %v", view.File, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, view)
+		}
+
+	case http.MethodDelete:
+		uploadsMu.Lock()
+		if u.writing {
+			uploadsMu.Unlock()
+			http.Error(w, errUploadBusy.Error(), http.StatusConflict)
+			return
+		}
+		delete(uploads, id)
+		saveUploadsLocked()
+		uploadsMu.Unlock()
+		os.Remove(uploadPartPath(s.videoDir, u))
+		logInfof("[上传] 已取消 %s", u.File)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
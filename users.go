@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// usersDir 数据目录下保存各账号观看状态的子目录：users/<账号名>/history.json 等
+const usersDir = "users"
+
+// Account -users 定义的账号
+type Account struct {
+	Name     string
+	Password string
+	Folders  []string // 允许访问的文件夹（相对视频目录，/ 分隔），为空表示不限制
+	Admin    bool     // -admin-users 中的管理员账号，可以使用管理功能、访问私密文件夹
+}
+
+// accounts 账号名 -> 账号，启动时设置后只读
+var accounts = make(map[string]*Account)
+
+// parseUsers 解析 -users（如 alice=密码1,kids=密码2）、-user-folders（如 kids=动画|儿童电影）
+// 和 -admin-users（如 alice）
+func parseUsers(spec, folders, admins string) (map[string]*Account, error) {
+	list := make(map[string]*Account)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, password, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || password == "" {
+			return nil, fmt.Errorf("账号 %q 缺少密码（格式为 name=password）", name)
+		}
+		if name == "" || !filepath.IsLocal(name) || strings.ContainsAny(name, `/\.`) {
+			return nil, fmt.Errorf("无效的账号名: %q", name)
+		}
+		if list[name] != nil {
+			return nil, fmt.Errorf("重复的账号: %s", name)
+		}
+		list[name] = &Account{Name: name, Password: password}
+	}
+	for _, item := range strings.Split(folders, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, dirs, ok := strings.Cut(item, "=")
+		a := list[strings.TrimSpace(name)]
+		if !ok || a == nil {
+			return nil, fmt.Errorf("-user-folders 中的账号 %q 不在 -users 中", name)
+		}
+		for _, dir := range strings.Split(dirs, "|") {
+			dir = strings.Trim(filepath.ToSlash(filepath.Clean(strings.TrimSpace(dir))), "/")
+			if dir == "" || dir == "." || !filepath.IsLocal(dir) {
+				return nil, fmt.Errorf("账号 %s 的文件夹无效: %q", a.Name, dir)
+			}
+			a.Folders = append(a.Folders, dir)
+		}
+	}
+	for _, name := range strings.Split(admins, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		a := list[name]
+		if a == nil {
+			return nil, fmt.Errorf("-admin-users 中的账号 %q 不在 -users 中", name)
+		}
+		if len(a.Folders) > 0 {
+			return nil, fmt.Errorf("账号 %s 限制了文件夹，不能设为管理员", name)
+		}
+		a.Admin = true
+	}
+	return list, nil
+}
+
+// InitUsers 设置账号，加载每个账号的播放进度、观看记录和收藏评分
+func InitUsers(list map[string]*Account) error {
+	accounts = list
+	var errs []string
+	for name := range accounts {
+		if err := os.MkdirAll(filepath.Join(dataDir, usersDir, name), 0755); err != nil {
+			return err
+		}
+		p := make(map[string]map[string]ProgressEntry)
+		h := make(map[string]HistoryEntry)
+		r := make(map[string]VideoRating)
+		for file, v := range map[string]any{progressFile: &p, historyFile: &h, ratingsFile: &r} {
+			if err := loadJSON(userDataFile(name, file), v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		progressMu.Lock()
+		userProgress[name] = p
+		progressMu.Unlock()
+		historyMu.Lock()
+		userHistory[name] = h
+		historyMu.Unlock()
+		ratingsMu.Lock()
+		userRatings[name] = r
+		ratingsMu.Unlock()
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// accountNames 所有账号名（排序）
+func accountNames() []string {
+	names := make([]string, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userDataFile 账号的数据文件（相对数据目录），账号名为空时为共享的数据文件
+func userDataFile(user, name string) string {
+	if user == "" {
+		return name
+	}
+	return filepath.Join(usersDir, user, name)
+}
+
+// requestUser 请求登录的账号名；未启用账号、共用密码或令牌登录时为空
+func requestUser(r *http.Request) string {
+	if len(accounts) == 0 {
+		return ""
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	user, ok := validSession(c.Value)
+	if !ok {
+		return ""
+	}
+	return user
+}
+
+// userFolders 账号允许访问的文件夹，为空表示不限制
+func userFolders(user string) []string {
+	if a := accounts[user]; a != nil {
+		return a.Folders
+	}
+	return nil
+}
+
+// canAccess 账号能否访问视频目录中的文件或文件夹 rel
+func canAccess(user, rel string) bool {
+	folders := userFolders(user)
+	if len(folders) == 0 {
+		return true
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	for _, f := range folders {
+		if rel == f || strings.HasPrefix(rel, f+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// canBrowse 账号能否进入文件夹 dir：允许访问的文件夹及其子文件夹，以及通往它们的上级文件夹
+func canBrowse(user, dir string) bool {
+	if canAccess(user, dir) {
+		return true
+	}
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." || dir == "" {
+		return true
+	}
+	for _, f := range userFolders(user) {
+		if strings.HasPrefix(f, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleVideos 只保留请求者能访问的视频（账号限制的文件夹、私密文件夹）
+func visibleVideos(r *http.Request, videos []VideoFile) []VideoFile {
+	v := viewerOf(r)
+	if !v.restricted() {
+		return videos
+	}
+	var result []VideoFile
+	for _, video := range videos {
+		if v.canAccess(video.RelPath) {
+			result = append(result, video)
+		}
+	}
+	return result
+}
+
+// visibleFolders 只保留请求者能进入的子文件夹
+func visibleFolders(r *http.Request, folders []FolderEntry) []FolderEntry {
+	v := viewerOf(r)
+	if !v.restricted() {
+		return folders
+	}
+	var result []FolderEntry
+	for _, f := range folders {
+		if v.canBrowse(f.RelPath) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// requestedPaths 请求涉及的视频或文件夹：file、path 参数，/api/videos/{id}，
+// /hls/{key}/、/dash/{key}/ 转码任务的来源视频，以及 /subs/{key}/ 字幕的来源视频
+func (s *Server) requestedPaths(r *http.Request) (files, dirs []string) {
+	q := r.URL.Query()
+	if file := q.Get("file"); file != "" {
+		files = append(files, file)
+	}
+	if q.Has("path") {
+		dirs = append(dirs, q.Get("path"))
+	}
+	if rest, ok := strings.CutPrefix(r.URL.EscapedPath(), "/api/videos/"); ok {
+		// 与 handleVideoAPI 相同：DELETE /api/videos/{id}，其他为 /api/videos/{id}/{action}
+		id := rest
+		if head, _, ok := cutLast(rest, "/"); ok && r.Method != http.MethodDelete {
+			id = head
+		}
+		file, err := url.PathUnescape(id)
+		if err != nil {
+			file = id
+		}
+		files = append(files, file)
+	}
+	for _, prefix := range []string{"/hls/", "/dash/"} {
+		if key, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/"); ok && strings.HasPrefix(r.URL.Path, prefix) {
+			if rel := s.jobSourceRel(key); rel != "" {
+				files = append(files, rel)
+			}
+		}
+	}
+	if key, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/subs/"), "/"); ok && strings.HasPrefix(r.URL.Path, "/subs/") {
+		if rel := s.subsSourceRel(key); rel != "" {
+			files = append(files, rel)
+		}
+	}
+	return files, dirs
+}
+
+// jobSourceRel 转码任务或磁盘缓存的来源视频（相对视频目录），找不到时返回空
+func (s *Server) jobSourceRel(key string) string {
+	if !isHexKey(key) {
+		return ""
+	}
+	hlsJobsMu.Lock()
+	job := hlsJobs[key]
+	hlsJobsMu.Unlock()
+	source := ""
+	if job != nil {
+		source = job.Source
+	} else {
+		source = readCacheManifest(filepath.Join(hlsCacheDir, key)).Source
+	}
+	return s.videoRel(source)
+}
+
+// subsSourceRel /subs/{key}/ 内嵌字幕的来源视频（相对视频目录），找不到时返回空
+func (s *Server) subsSourceRel(key string) string {
+	if !isHexKey(key) {
+		return ""
+	}
+	subsSourcesMu.Lock()
+	source := subsSources[key]
+	subsSourcesMu.Unlock()
+	return s.videoRel(source)
+}
+
+// videoRel 视频目录中的文件相对视频目录的路径（/ 分隔），不在视频目录中时返回空
+func (s *Server) videoRel(source string) string {
+	if source == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(s.videoDir, source)
+	if err != nil || !filepath.IsLocal(rel) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// accessMiddleware 限制了文件夹的账号只能访问其中的视频，儿童模式只能访问允许的文件夹和标签，
+// 私密文件夹只有管理员或输入 PIN 后才能访问。
+// 浏览器打开可以用 PIN 解锁的私密文件夹时跳转到输入 PIN 的页面
+func (s *Server) accessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := viewerOf(r)
+		if !v.restricted() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		files, dirs := s.requestedPaths(r)
+		allowed := true
+		for _, file := range files {
+			allowed = allowed && canAccess(v.user, file)
+		}
+		for _, dir := range dirs {
+			allowed = allowed && canBrowse(v.user, dir)
+		}
+		if !allowed {
+			http.Error(w, "该账号无权访问此视频", http.StatusForbidden)
+			return
+		}
+		if v.kids {
+			for _, file := range files {
+				allowed = allowed && kidsAllowed(file)
+			}
+			for _, dir := range dirs {
+				allowed = allowed && kidsBrowsable(dir)
+			}
+			if !allowed {
+				http.Error(w, "儿童模式下不能观看此视频", http.StatusForbidden)
+				return
+			}
+		}
+		for _, p := range append(files, dirs...) {
+			locked := v.lockedFolder(p)
+			if locked == "" {
+				continue
+			}
+			if privateFolders[locked] != "" && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/unlock?"+url.Values{"folder": {locked}, "next": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+				return
+			}
+			http.Error(w, "私密文件夹，无权访问", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
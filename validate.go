@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// outputDurationTolerance 输出时长与源视频相差超过该比例（且超过 outputDurationSlack）时视为不完整
+	outputDurationTolerance = 0.02
+	outputDurationSlack     = 3.0 // 秒
+	// maxQuarantined 隔离区保留的输出数量，超出时删除最早的
+	maxQuarantined = 10
+)
+
+// quarantineDir 校验未通过的转码输出移到这里，便于排查
+func quarantineDir() string {
+	return filepath.Join(cacheRoot, "quarantine")
+}
+
+// validateHLSOutput 检查转码完成后的输出：分片不能为空，总时长与源视频一致，
+// 源视频有音轨时输出也要有音轨。返回未通过的原因，通过时返回空
+func validateHLSOutput(dir, source string) string {
+	f, err := os.Open(filepath.Join(dir, "stream.m3u8"))
+	if err != nil {
+		return "缺少播放列表"
+	}
+	defer f.Close()
+
+	var segments []string
+	var total float64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := strings.TrimPrefix(line, "#EXTINF:")
+			v, _, _ = strings.Cut(v, ",")
+			secs, _ := strconv.ParseFloat(v, 64)
+			total += secs
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if _, uri, ok := strings.Cut(line, `URI="`); ok {
+				segments = append(segments, strings.TrimSuffix(uri, `"`))
+			}
+		case line != "" && !strings.HasPrefix(line, "#"):
+			segments = append(segments, line)
+		}
+	}
+	if len(segments) == 0 {
+		return "播放列表中没有分片"
+	}
+	for _, seg := range segments {
+		info, err := os.Stat(filepath.Join(dir, filepath.Base(seg)))
+		if err != nil {
+			return fmt.Sprintf("分片 %s 不存在", seg)
+		}
+		if info.Size() == 0 {
+			return fmt.Sprintf("分片 %s 为空", seg)
+		}
+	}
+
+	if want := float64(durationSeconds(getDuration(source))); want > 0 {
+		if diff := math.Abs(total - want); diff > max(outputDurationSlack, want*outputDurationTolerance) {
+			return fmt.Sprintf("输出时长 %.1fs 与源视频 %.0fs 相差过大", total, want)
+		}
+	}
+
+	if srcAudio, err := probeStreams(source, "a"); err == nil && len(srcAudio) > 0 {
+		outAudio, err := runProbeStreams(filepath.Join(dir, "stream.m3u8"), "a")
+		if err != nil {
+			return fmt.Sprintf("无法读取输出: %v", err)
+		}
+		if len(outAudio) == 0 {
+			return "源视频有音轨，输出没有音频"
+		}
+	}
+	return ""
+}
+
+// quarantineHLSCache 把校验未通过的缓存目录移到隔离区，并记录原因
+func quarantineHLSCache(key, source, reason string) {
+	qdir := quarantineDir()
+	if err := os.MkdirAll(qdir, 0755); err != nil {
+		logErrorf("[HLS] 创建隔离目录失败: %v", err)
+		os.RemoveAll(filepath.Join(hlsCacheDir, key))
+		return
+	}
+	dst := filepath.Join(qdir, fmt.Sprintf("%s-%d", key, time.Now().Unix()))
+	if err := os.Rename(filepath.Join(hlsCacheDir, key), dst); err != nil {
+		logErrorf("[HLS] 隔离 %s 失败: %v", key, err)
+		os.RemoveAll(filepath.Join(hlsCacheDir, key))
+		return
+	}
+	note := fmt.Sprintf("source: %s\nreason: %s\ntime: %s\n", source, reason, time.Now().Format(time.RFC3339))
+	os.WriteFile(filepath.Join(dst, "reason.txt"), []byte(note), 0644)
+	pruneQuarantine(qdir)
+}
+
+// pruneQuarantine 隔离区只保留最近的 maxQuarantined 份
+func pruneQuarantine(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) <= maxQuarantined {
+		return
+	}
+	type item struct {
+		name string
+		mod  time.Time
+	}
+	var items []item
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			items = append(items, item{e.Name(), info.ModTime()})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].mod.Before(items[j].mod) })
+	for _, it := range items[:max(0, len(items)-maxQuarantined)] {
+		os.RemoveAll(filepath.Join(dir, it.name))
+	}
+}
+
+// startSaferJob 用兼容模式（H.264 软编码、Main profile）重新转码，返回新任务的 key，启动失败时返回空
+func startSaferJob(filePath string, opts HLSOptions, owner string) string {
+	opts.Fallback, opts.HEVC = true, false
+	if _, err := getOrStartHLS(filePath, opts, owner); err != nil {
+		logErrorf("[HLS] %s: 兼容模式转码启动失败: %v", filepath.Base(filePath), err)
+		return ""
+	}
+	return hlsJobKey(filePath, opts)
+}
+
+// canReencodeSafer 输出校验未通过时能否改用兼容模式重新转码：已经是兼容模式、DASH 输出，
+// 或 -remux-only / -no-transcode 禁止重新编码时不能
+func canReencodeSafer(opts HLSOptions) bool {
+	return !opts.Fallback && !opts.DASH && transcodePolicy == PolicyFull
+}
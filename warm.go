@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// warmTTL 最近播放的视频保持预热多久：暂停去吃饭、第二天早上继续看都能立即开始
+const warmTTL = 12 * time.Hour
+
+var (
+	// warmKey 最近播放的转码任务，空闲时不按 hlsIdleTimeout 清理：已完成的任务记录留在内存中，
+	// 未完成的转码只停止 ffmpeg，保留已生成的分片，继续播放时从中断处接着转码
+	warmKey string
+	warmAt  time.Time
+	warmMu  sync.Mutex
+)
+
+// markWarm 记录最近播放的转码任务，换成其他视频时清理上一个视频保留的未完成分片
+func markWarm(key string) {
+	warmMu.Lock()
+	prev := warmKey
+	warmKey, warmAt = key, time.Now()
+	warmMu.Unlock()
+	if prev != "" && prev != key {
+		dropWarmPartial(prev)
+	}
+}
+
+// isWarm 任务是否为预热中的最近播放
+func isWarm(key string) bool {
+	warmMu.Lock()
+	defer warmMu.Unlock()
+	return key == warmKey && time.Since(warmAt) < warmTTL
+}
+
+// expireWarm 预热超过 warmTTL 后清理保留的未完成分片
+func expireWarm() {
+	warmMu.Lock()
+	key := warmKey
+	expired := key != "" && time.Since(warmAt) >= warmTTL
+	if expired {
+		warmKey = ""
+	}
+	warmMu.Unlock()
+	if expired {
+		dropWarmPartial(key)
+	}
+}
+
+// dropWarmPartial 删除不再预热的任务留下的未完成分片；任务仍在进行（其他设备在看）或缓存已完成时保留
+func dropWarmPartial(key string) {
+	hlsJobsMu.Lock()
+	_, running := hlsJobs[key]
+	hlsJobsMu.Unlock()
+	dir := filepath.Join(hlsCacheDir, key)
+	if running || isCacheComplete(dir) {
+		return
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+	logInfof("[HLS] 清理不再预热的未完成分片 (%s)", key)
+	os.RemoveAll(dir)
+}
+
+// reapIdleJob 清理空闲任务：最近播放的视频保持预热，其他任务停止并删除未完成的分片
+func reapIdleJob(key string, job *HLSJob) {
+	if !isWarm(key) {
+		StopHLS(key)
+		return
+	}
+	if job.Cached {
+		return
+	}
+	logInfof("[HLS] %s: 空闲，暂停转码并保留已生成的分片 (%s)", filepath.Base(job.Source), key)
+	stopHLSJob(key, true)
+}
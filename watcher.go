@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce 文件变化后等待的时间，合并复制大文件时的连续写入事件
+const watchDebounce = 2 * time.Second
+
+var (
+	// libraryWatch 是否监听视频目录变化（-watch）
+	libraryWatch = true
+
+	mediaKeysMu sync.Mutex
+	// mediaKeys 视频完整路径 -> 最近使用的派生文件缓存 key，文件删除或替换后据此清理旧缓存
+	mediaKeys = make(map[string]string)
+)
+
+// StartWatcher 监听视频目录（含子目录）的变化：新增、重命名、删除视频后立即更新目录统计、
+// 清理旧的封面等缓存，并通过 library.changed 事件通知客户端刷新
+func StartWatcher(root string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	watchTree(w, root, root)
+	go runWatcher(w, root)
+	return nil
+}
+
+// watchTree 为目录及其所有子目录添加监听（跳过隐藏目录和系统目录）
+func watchTree(w *fsnotify.Watcher, root, dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != root && skipName(d.Name()) {
+			return filepath.SkipDir
+		}
+		if err := w.Add(path); err != nil {
+			// 常见原因是超出 inotify 监听数量上限（fs.inotify.max_user_watches）
+			log.Printf("[监听] 无法监听 %s: %v", path, err)
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+// runWatcher 收集文件事件，静默 watchDebounce 后批量处理
+func runWatcher(w *fsnotify.Watcher, root string) {
+	pending := make(map[string]fsnotify.Op)
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if skipName(filepath.Base(ev.Name)) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					watchTree(w, root, ev.Name)
+				}
+			}
+			pending[ev.Name] |= ev.Op
+			timer.Reset(watchDebounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[监听] %v", err)
+		case <-timer.C:
+			applyLibraryChanges(root, pending)
+			pending = make(map[string]fsnotify.Op)
+		}
+	}
+}
+
+// applyLibraryChanges 处理一批文件变化，媒体库有变化时通知客户端
+func applyLibraryChanges(root string, changes map[string]fsnotify.Op) {
+	changed, rescan := false, false
+	for path, op := range changes {
+		info, err := os.Stat(path)
+		exists := err == nil
+		isVideo := videoExts[strings.ToLower(filepath.Ext(path))]
+
+		switch {
+		case exists && info.IsDir():
+			// 新建或移入的目录，其中的视频没有单独的事件
+			rescan, changed = true, true
+		case isVideo && exists:
+			purgeMediaCache(path)
+			if partialVideo(path, info) {
+				continue
+			}
+			folderStatsAdd(path, info.Size())
+			changed = true
+		case isVideo && errors.Is(err, fs.ErrNotExist):
+			purgeMediaCache(path)
+			folderStatsRemove(path)
+			changed = true
+		case !exists && op.Has(fsnotify.Remove|fsnotify.Rename) && filepath.Ext(path) == "":
+			// 可能是目录被删除或移走，无法知道其中有哪些视频
+			rescan, changed = true, true
+		}
+	}
+	if rescan {
+		InitFolderStats(root)
+	}
+	checkPartialDownloads()
+	if changed {
+		log.Printf("[监听] 视频目录有 %d 处变化，已刷新媒体库", len(changes))
+		bus.Publish("library.changed", nil)
+	}
+}
+
+// rememberMediaKey 记录视频当前的缓存 key
+func rememberMediaKey(path, key string) {
+	mediaKeysMu.Lock()
+	mediaKeys[path] = key
+	mediaKeysMu.Unlock()
+}
+
+// purgeMediaCache 视频被删除或替换后，删除按旧 key 缓存的封面、时长、预览图和预览短片
+func purgeMediaCache(path string) {
+	mediaKeysMu.Lock()
+	old, ok := mediaKeys[path]
+	mediaKeysMu.Unlock()
+	if !ok {
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		if mediaCacheKey(path) == old {
+			return
+		}
+	} else {
+		mediaKeysMu.Lock()
+		delete(mediaKeys, path)
+		mediaKeysMu.Unlock()
+	}
+	for _, f := range mediaCacheFiles(old) {
+		os.RemoveAll(f)
+	}
+}
+
+// mediaCacheFiles 按 key 缓存的派生文件：封面、时长、编码和探测结果、预览图、预览短片、
+// faststart 副本和字体附件目录
+func mediaCacheFiles(key string) []string {
+	return []string{
+		filepath.Join(thumbCacheDir, key+".jpg"),
+		filepath.Join(thumbCacheDir, key+"-smart.jpg"),
+		filepath.Join(thumbCacheDir, key+".dur"),
+		filepath.Join(thumbCacheDir, key+".codec"),
+		filepath.Join(thumbCacheDir, key+".probe.json"),
+		filepath.Join(spriteCacheDir, key+".jpg"),
+		filepath.Join(previewCacheDir, key+".mp4"),
+		filepath.Join(faststartCacheDir, key+".mp4"),
+		filepath.Join(subsCacheDir, "fonts", key),
+	}
+}